@@ -5,7 +5,15 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"cloud.google.com/go/bigquery"
 	"cloud.google.com/go/storage"
@@ -20,16 +28,175 @@ const (
 	DatasetName = "ci_operator_metrics"
 )
 
+// insertLimiter is shared by the events an instance serves concurrently, so that their inserts into the same
+// table wait for each other once MAX_CONCURRENT_INSERTS_PER_TABLE is set
+var insertLimiter struct {
+	once    sync.Once
+	limiter *metrics.TableInsertLimiter
+}
+
+// quotaCooldown is shared by the events an instance serves concurrently, so that a quota error seen by one pauses
+// the inserts of all of them once QUOTA_COOLDOWN is set
+var quotaCooldown struct {
+	once     sync.Once
+	cooldown *metrics.QuotaCooldown
+}
+
 // LoadMetricsFromGCS is the Cloud Function entry point
 func LoadMetricsFromGCS(ctx context.Context, e storage.Event) error {
-	logger := logrus.WithField("bucket", e.Bucket).WithField("name", e.Name)
+	fields := logFields(nil)
+	logger := logrus.WithFields(fields).WithField("bucket", e.Bucket).WithField("name", e.Name)
+
+	if !bucketAllowed(e.Bucket) {
+		logger.Warn("Ignoring event from a bucket missing from ALLOWED_BUCKETS")
+		return nil
+	}
 
 	if !metrics.IsMetricsFile(e.Name) {
-		logger.Error("Received non-metrics file")
-		return fmt.Errorf("unexpected file received: %s (expected ci-operator-metrics.json)", e.Name)
+		// Finalize events fire for every object of the bucket, and failing them would retry them until they expire
+		logger.Info("Ignoring non-metrics file")
+		return nil
 	}
 
-	logger.Infof("Processing metrics file: gs://%s/%s", e.Bucket, e.Name)
+	return loadObject(ctx, logger, fields, e.Bucket, e.Name)
+}
+
+// pubSubPush is the body of a Pub/Sub push request
+type pubSubPush struct {
+	Message struct {
+		Attributes map[string]string `json:"attributes"`
+		Data       []byte            `json:"data"`
+		MessageID  string            `json:"messageId"`
+	} `json:"message"`
+	Subscription string `json:"subscription"`
+}
+
+// HandlePubSubPush is the HTTP entry point for Pub/Sub push subscriptions to GCS notifications. The object is
+// taken from the bucketId and objectId attributes of the message, or from the object resource in its data.
+// Responding with a 2xx status acks the message and any other status nacks it so that Pub/Sub redelivers it:
+// requests that cannot succeed on redelivery are answered with 400, failed loads with 500.
+func HandlePubSubPush(w http.ResponseWriter, r *http.Request) {
+	fields := logFields(r)
+	var push pubSubPush
+	if err := json.NewDecoder(r.Body).Decode(&push); err != nil {
+		logrus.WithFields(fields).WithError(err).Error("Failed to decode Pub/Sub push request")
+		http.Error(w, "invalid Pub/Sub push request", http.StatusBadRequest)
+		return
+	}
+	logger := logrus.WithFields(fields).WithField("message_id", push.Message.MessageID).WithField("subscription", push.Subscription)
+
+	if eventType := push.Message.Attributes["eventType"]; eventType != "" && eventType != "OBJECT_FINALIZE" {
+		logger.Infof("Ignoring %s notification", eventType)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	bucket, object := push.Message.Attributes["bucketId"], push.Message.Attributes["objectId"]
+	if bucket == "" || object == "" {
+		var resource struct {
+			Bucket string `json:"bucket"`
+			Name   string `json:"name"`
+		}
+		if err := json.Unmarshal(push.Message.Data, &resource); err != nil {
+			logger.WithError(err).Error("Failed to decode the message data")
+			http.Error(w, "message data is not a GCS object resource", http.StatusBadRequest)
+			return
+		}
+		bucket, object = resource.Bucket, resource.Name
+	}
+	if bucket == "" || object == "" {
+		logger.Error("Message references no GCS object")
+		http.Error(w, "message references no GCS object", http.StatusBadRequest)
+		return
+	}
+
+	logger = logger.WithField("bucket", bucket).WithField("name", object)
+	if !bucketAllowed(bucket) {
+		logger.Warn("Ignoring notification from a bucket missing from ALLOWED_BUCKETS")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if !metrics.IsMetricsFile(object) {
+		// Notifications fan out for every object of the bucket, so other files are expected and acked
+		logger.Info("Ignoring non-metrics file")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if err := loadObject(r.Context(), logger, fields, bucket, object); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// logFields returns the fields configured by the comma-separated LOG_FIELDS to tag every log line of an invocation
+// with, which adds none when unset. Each entry is name=source, or a bare source naming a field after itself, and the
+// source is looked up as a header of the request, such as Function-Execution-Id or X-Cloud-Trace-Context, then as an
+// environment variable. Sources without a value are left out. r is nil for events not delivered over HTTP.
+func logFields(r *http.Request) logrus.Fields {
+	fields := logrus.Fields{}
+	for _, entry := range strings.Split(os.Getenv("LOG_FIELDS"), ",") {
+		name, source, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok {
+			source = name
+		}
+		if name == "" || source == "" {
+			continue
+		}
+		var value string
+		if r != nil {
+			value = r.Header.Get(source)
+		}
+		if value == "" {
+			value = os.Getenv(source)
+		}
+		if value != "" {
+			fields[name] = value
+		}
+	}
+	return fields
+}
+
+// bucketAllowed reports whether bucket is listed in the comma-separated ALLOWED_BUCKETS, which accepts every
+// bucket when unset
+func bucketAllowed(bucket string) bool {
+	allowed := os.Getenv("ALLOWED_BUCKETS")
+	if allowed == "" {
+		return true
+	}
+	for _, candidate := range strings.Split(allowed, ",") {
+		if strings.TrimSpace(candidate) == bucket {
+			return true
+		}
+	}
+	return false
+}
+
+// deadLetterObject records an object dropped after a permanent error under DEAD_LETTER_PREFIX, when it is set
+func deadLetterObject(ctx context.Context, logger *logrus.Entry, bucket, object string, cause error) {
+	prefix := os.Getenv("DEAD_LETTER_PREFIX")
+	if prefix == "" {
+		return
+	}
+	writer, err := metrics.NewDeadLetterWriter(ctx, prefix)
+	if err != nil {
+		logger.WithError(err).Error("Failed to set up the dead-letter location")
+		return
+	}
+	defer writer.Close()
+	path, err := writer.WriteFailedObject(ctx, bucket, object, cause)
+	if err != nil {
+		logger.WithError(err).Error("Failed to record the dropped object in the dead-letter location")
+		return
+	}
+	logger.Warnf("Recorded the dropped object in %s", path)
+}
+
+// loadObject loads a single metrics file into the dataset, configured from the environment, tagging the lines the
+// loader logs with fields
+func loadObject(ctx context.Context, logger *logrus.Entry, fields logrus.Fields, bucket, object string) error {
+	logger.Infof("Processing metrics file: gs://%s/%s", bucket, object)
 
 	bqClient, err := bigquery.NewClient(ctx, ProjectName)
 	if err != nil {
@@ -38,9 +205,119 @@ func LoadMetricsFromGCS(ctx context.Context, e storage.Event) error {
 	}
 	defer bqClient.Close()
 
-	if err := metrics.NewBigQueryLoader(ctx, bqClient, ProjectName, DatasetName).LoadFromGCS(e.Bucket, e.Name); err != nil {
-		logger.WithError(err).Error("Failed to load metrics from GCS")
+	var decodeOpts metrics.DecodeOptions
+	if limit := os.Getenv("MAX_ROWS_PER_TABLE"); limit != "" {
+		decodeOpts.MaxRowsPerTable, err = strconv.Atoi(limit)
+		if err != nil {
+			return fmt.Errorf("invalid MAX_ROWS_PER_TABLE: %w", err)
+		}
+	}
+
+	decodeOpts.EnvelopePath = os.Getenv("ENVELOPE_PATH")
+	var envelopeColumns []metrics.EnvelopeColumn
+	if columns := os.Getenv("ENVELOPE_COLUMNS"); columns != "" {
+		for _, column := range strings.Split(columns, ",") {
+			name, field, ok := strings.Cut(column, "=")
+			if !ok || name == "" || field == "" {
+				return fmt.Errorf("invalid ENVELOPE_COLUMNS: expected column=field, got %q", column)
+			}
+			envelopeColumns = append(envelopeColumns, metrics.EnvelopeColumn{Name: name, Field: field})
+		}
+	}
+
+	var decodeRetries int
+	decodeRetryDelay := 10 * time.Second
+	if retries := os.Getenv("DECODE_RETRIES"); retries != "" {
+		decodeRetries, err = strconv.Atoi(retries)
+		if err != nil {
+			return fmt.Errorf("invalid DECODE_RETRIES: %w", err)
+		}
+	}
+	if delay := os.Getenv("DECODE_RETRY_DELAY"); delay != "" {
+		decodeRetryDelay, err = time.ParseDuration(delay)
+		if err != nil {
+			return fmt.Errorf("invalid DECODE_RETRY_DELAY: %w", err)
+		}
+	}
+
+	loaderOpts := []metrics.Option{metrics.WithDecodeOptions(decodeOpts), metrics.WithDecodeRetries(decodeRetries, decodeRetryDelay)}
+	if len(envelopeColumns) > 0 {
+		loaderOpts = append(loaderOpts, metrics.WithEnvelopeColumns(envelopeColumns))
+	}
+	if limit := os.Getenv("MAX_CONCURRENT_INSERTS_PER_TABLE"); limit != "" {
+		perTable, err := strconv.Atoi(limit)
+		if err != nil || perTable < 1 {
+			return fmt.Errorf("invalid MAX_CONCURRENT_INSERTS_PER_TABLE: %q", limit)
+		}
+		insertLimiter.once.Do(func() {
+			insertLimiter.limiter = metrics.NewTableInsertLimiter(perTable)
+		})
+		loaderOpts = append(loaderOpts, metrics.WithTableInsertLimiter(insertLimiter.limiter))
+	}
+
+	if value := os.Getenv("QUOTA_COOLDOWN"); value != "" {
+		duration, err := time.ParseDuration(value)
+		if err != nil || duration <= 0 {
+			return fmt.Errorf("invalid QUOTA_COOLDOWN: %q", value)
+		}
+		quotaCooldown.once.Do(func() {
+			quotaCooldown.cooldown = metrics.NewQuotaCooldown(duration)
+		})
+		loaderOpts = append(loaderOpts, metrics.WithQuotaCooldown(quotaCooldown.cooldown))
+	}
+
+	if value := os.Getenv("WAIT_FOR_PERMISSIONS"); value != "" {
+		duration, err := time.ParseDuration(value)
+		if err != nil || duration <= 0 {
+			return fmt.Errorf("invalid WAIT_FOR_PERMISSIONS: %q", value)
+		}
+		loaderOpts = append(loaderOpts, metrics.WithPermissionWait(duration))
+	}
+
+	if tables := os.Getenv("REQUIRED_TABLES"); tables != "" {
+		loaderOpts = append(loaderOpts, metrics.WithRequiredTables(strings.Split(tables, ",")))
+	}
+
+	if len(fields) > 0 {
+		loaderOpts = append(loaderOpts, metrics.WithLogFields(fields))
+	}
+
+	loader := metrics.NewBigQueryLoader(ctx, bqClient, ProjectName, DatasetName, loaderOpts...)
+	start := time.Now()
+	result, err := loader.LoadFromGCSContext(ctx, bucket, object)
+	if path := os.Getenv("OPENMETRICS_OBJECT"); path != "" {
+		run := metrics.RunMetrics{Dataset: DatasetName, Start: start, Duration: time.Since(start), Success: err == nil, Result: result}
+		if err := metrics.AppendOpenMetrics(ctx, path, run); err != nil {
+			logger.WithError(err).Error("Failed to append the load to the OpenMetrics object")
+		}
+	}
+	if os.Getenv("AUDIT") == "true" {
+		table := os.Getenv("AUDIT_TABLE")
+		if table == "" {
+			table = metrics.DefaultAuditTable
+		}
+		record := &metrics.AuditRecord{Source: fmt.Sprintf("gs://%s/%s", bucket, object), StartedAt: start, Duration: time.Since(start), Result: result, Err: err}
+		// a redelivered event of the same generation shares the load_id of the first delivery
+		if generation, err := metrics.GCSObjectGeneration(ctx, bucket, object); err != nil {
+			logger.WithError(err).Warn("Failed to get the generation of the object for its audit record")
+		} else {
+			record.Generation = generation
+		}
+		if err := loader.WriteAuditRecord(ctx, table, record); err != nil {
+			logger.WithError(err).Error("Failed to audit the load")
+		}
+	}
+	if errors.Is(err, metrics.ErrEmptySource) && os.Getenv("SKIP_EMPTY_OBJECTS") == "true" {
+		logger.Warn("Skipping empty metrics file")
+		return nil
+	} else if metrics.IsTransientError(err) {
+		logger.WithError(err).Error("Failed to load metrics from GCS, the event will be retried")
 		return fmt.Errorf("failed to load metrics: %w", err)
+	} else if err != nil {
+		// Retrying an event that fails the same way every time only delays the next ones, so it is dropped
+		logger.WithError(err).Error("Failed to load metrics from GCS with a permanent error, dropping the event")
+		deadLetterObject(ctx, logger, bucket, object, err)
+		return nil
 	}
 
 	logger.Info("Successfully loaded metrics into BigQuery")
@@ -2,56 +2,918 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
-	"net/url"
+	"io"
+	"io/fs"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
+	"unicode/utf8"
 
 	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/storage"
 
 	"github.com/sirupsen/logrus"
+	"google.golang.org/api/option"
 
 	"github.com/droslean/ci-metrics-bigquery/pkg/metrics"
 )
 
+// Version is the release of the tool, set at build time with -ldflags "-X main.Version=<version>"
+var Version string
+
+// version returns Version, or "dev" for builds that did not set it
+func version() string {
+	if Version == "" {
+		return "dev"
+	}
+	return Version
+}
+
+// Exit codes of a load run
+const (
+	// exitSuccess means every table loaded
+	exitSuccess = 0
+	// exitFailure means nothing was loaded or the run aborted
+	exitFailure = 1
+	// exitPartial means some tables loaded and others failed with --continue-on-error or --stop-after-errors
+	exitPartial = 2
+	// exitBudgetExhausted means --max-runtime stopped a --paths-file run that can be resumed with --resume-after
+	exitBudgetExhausted = 3
+)
+
+// tableSuffixPattern matches the characters BigQuery allows in table names
+var tableSuffixPattern = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// columnPattern matches the names BigQuery allows for columns
+var columnPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// kmsKeyPattern matches Cloud KMS crypto key resource names
+var kmsKeyPattern = regexp.MustCompile(`^projects/[^/]+/locations/[^/]+/keyRings/[^/]+/cryptoKeys/[^/]+$`)
+
+// BigQuery limits on dataset metadata, in characters
+const (
+	maxDatasetFriendlyName = 1024
+	maxDatasetDescription  = 16384
+)
+
+// locationPattern matches BigQuery regions (europe-west1) and multi-regions (US, EU), which --location upper-cases
+var locationPattern = regexp.MustCompile(`^([a-z]+(-[a-z]+[0-9]+)|US|EU)$`)
+
+// emitFlag is the value of --emit-stdout: a table, or every table when the flag is given without a value
+type emitFlag struct {
+	set   bool
+	table string
+}
+
+func (f *emitFlag) String() string {
+	return f.table
+}
+
+func (f *emitFlag) Set(value string) error {
+	switch value {
+	case "true":
+		*f = emitFlag{set: true}
+	case "false":
+		*f = emitFlag{}
+	default:
+		*f = emitFlag{set: true, table: value}
+	}
+	return nil
+}
+
+// IsBoolFlag lets --emit-stdout be given without a value
+func (f *emitFlag) IsBoolFlag() bool {
+	return true
+}
+
 type options struct {
-	projectID string
-	datasetID string
-	gcsPath   string
-	bucket    string
-	object    string
-	exportDir string
+	projectID     string
+	datasetID     string
+	location      string
+	gcsPath       string
+	sourceURL     string
+	bucket        string
+	object        string
+	filePath      string
+	fromExportDir string
+	pathsFile     string
+	exportDir     string
+	preview       int
+	output        string
+
+	inputFormat string
+
+	useWriteAPI              bool
+	skipEmptyTablesInSummary bool
+	shards                   int
+	shardBy                  string
+	stopAfterErrors          int
+	continueOnError          bool
+	columnCase               string
+	generateSample           string
+	writeSchemas             string
+	skipEmptyObjects         bool
+	diffAgainst              string
+	datasetFromMetadata      string
+	maxRowsPerTable          int
+	mergeMapContext          bool
+	defaultTimestampToNow    bool
+	exportManifest           bool
+	exportIncludeEmpty       bool
+	exportBundle             string
+	exportPerSource          bool
+	exportFormat             string
+	requiredTables           []string
+	truncateStrings          int
+	normalizeNamespaces      bool
+	nonFiniteFloats          string
+	leaseMetrics             bool
+	createDataset            bool
+	deadLetter               string
+	validateOnly             bool
+	describe                 bool
+	benchmark                bool
+	emitStdout               emitFlag
+	schemaBaseline           string
+	preflightSchemaCheck     bool
+	compare                  string
+	compareKey               []string
+	skipInvalidRows          bool
+	dropTablesFirst          string
+	confirmDropTables        bool
+	tableSuffix              string
+	unifiedTable             string
+	upsertKeys               map[string][]string
+	alsoWriteTo              []metrics.DatasetRef
+	logFields                logrus.Fields
+	strictFanOut             bool
+	columns                  map[string][]string
+	dedupKeys                map[string][]string
+	insertIDColumns          map[string]string
+	fieldTypes               map[string]map[string]bigquery.FieldType
+	fieldDescriptions        bool
+	rangePartitions          map[string]metrics.RangePartition
+	preserveRaw              bool
+	envelopePath             string
+	envelopeColumns          []metrics.EnvelopeColumn
+	lenientDecode            bool
+	sampleRate               float64
+	sampleSeed               uint64
+	createViews              string
+	postLoadSQL              string
+	successMarker            string
+	openMetricsObject        string
+	audit                    bool
+	auditTable               string
+	successMarkerOnPartial   bool
+	checkTimestamps          bool
+	minValidDate             string
+	maxFutureSkew            time.Duration
+	strict                   bool
+	kmsKey                   string
+	count                    bool
+	ingestionID              bool
+	tagVersion               bool
+	tagSource                bool
+	splitUnions              bool
+	decodeWorkers            int
+	loadWorkers              int
+	maxTableInserts          int
+	quotaCooldown            time.Duration
+	waitForPermissions       time.Duration
+	maxRuntime               time.Duration
+	resumeAfter              string
+	objectRegex              string
+	checkpointFile           string
+	// deadline is when the --max-runtime budget runs out, zero without a budget
+	deadline            time.Time
+	decodeRetries       int
+	incrementalBatch    int
+	decodeRetryDelay    time.Duration
+	downloadConcurrency int
+	verifyCRC32C        bool
+	partitionExpiration time.Duration
+	datasetFriendlyName string
+	datasetDescription  string
+	httpProxy           string
+	caCert              string
+	bqEmulatorHost      string
+	gcsEmulatorHost     string
+
+	// bqClientOpts and gcsClientOpts carry the custom transport, if any, to every client created
+	bqClientOpts  []option.ClientOption
+	gcsClientOpts []option.ClientOption
+	// writeAPIClientOpts carry the proxy and CA bundle, if any, to the Storage Write API clients
+	writeAPIClientOpts []option.ClientOption
+	// httpClient fetches --url through the custom transport, if any, and is nil for the default client
+	httpClient *http.Client
 }
 
 func gatherOptions() *options {
 	opts := &options{}
 	flag.StringVar(&opts.projectID, "google-project-id", "", "GCP project ID")
 	flag.StringVar(&opts.datasetID, "bigquery-dataset", "", "BigQuery dataset ID")
+	flag.Func("location", "BigQuery location (region such as europe-west1 or multi-region US/EU) used for jobs and queries", func(value string) error {
+		// BigQuery takes multi-regions in any case, but reports them upper-cased
+		if strings.EqualFold(value, "US") || strings.EqualFold(value, "EU") {
+			value = strings.ToUpper(value)
+		}
+		opts.location = value
+		return nil
+	})
 	flag.StringVar(&opts.gcsPath, "gcs-path", "", "Full GCS path to a specific metrics.json file")
+	flag.StringVar(&opts.sourceURL, "url", "", "HTTP(S) URL of a metrics file, such as a time-limited signed GCS URL, fetched with a plain GET without Google credentials")
+	flag.StringVar(&opts.filePath, "file", "", "Path to a local metrics.json file, or - to read it from stdin (alternative to --gcs-path)")
+	flag.StringVar(&opts.fromExportDir, "from-export-dir", "", "Directory of <table>.json NDJSON files written by --export (optionally gzipped as <table>.json.gz) to load instead of a metrics file")
+	flag.StringVar(&opts.pathsFile, "paths-file", "", "Local file listing gs:// metrics paths, one per line (# starts a comment), to load one after the other")
+	flag.DurationVar(&opts.maxRuntime, "max-runtime", 0, "Stop starting new files of --paths-file once this much time has passed, finish the ones in flight and exit with code 3 and the path to resume after")
+	flag.StringVar(&opts.resumeAfter, "resume-after", "", "Skip the paths of --paths-file up to and including this one, as reported by a run stopped by --max-runtime")
+	flag.StringVar(&opts.objectRegex, "object-regex", "", "Only load the paths of --paths-file whose object name matches this regular expression and names a metrics file")
+	flag.StringVar(&opts.checkpointFile, "checkpoint-file", "", "File the path to resume after is written to when --max-runtime stops the run")
+	flag.IntVar(&opts.decodeWorkers, "decode-workers", 1, "Download and decode up to N files of --paths-file concurrently while earlier ones are loaded")
+	flag.IntVar(&opts.loadWorkers, "load-workers", 1, "Load up to N decoded files of --paths-file into BigQuery concurrently")
+	flag.IntVar(&opts.maxTableInserts, "max-concurrent-files-per-table-insert", 0, "Let at most N concurrently loaded files insert into the same table at once, to stay below its update rate limit (0 means no limit)")
+	flag.DurationVar(&opts.quotaCooldown, "quota-cooldown", 0, "Pause every load for this long once an insert hits a BigQuery quota or rate limit, then retry the rejected streaming insert (0 disables the pause)")
+	flag.DurationVar(&opts.waitForPermissions, "wait-for-permissions", 0, "Retry GCS reads and BigQuery requests failing with 403 permission denied, with backoff, for up to this long while new IAM bindings propagate (0 fails at once)")
 	flag.StringVar(&opts.exportDir, "export", "", "Export data to directory as JSON files for manual BigQuery import (instead of writing to BigQuery)")
+	flag.BoolVar(&opts.exportPerSource, "export-per-source", false, "Export every source into its own subdirectory of --export named after its path, e.g. <export>/<bucket>/<object path>/, so that the exports of --paths-file do not overwrite each other")
+	flag.StringVar(&opts.exportFormat, "export-format", metrics.ExportFormatJSON, "Format of --export: json (NDJSON files) or avro-bq (Avro files with the exact inferred BigQuery schema, each with a <table>.schema.json beside it)")
+	flag.BoolVar(&opts.exportManifest, "export-manifest", false, "Also write a manifest.json to the export directory listing each file with its table, row count and inferred schema")
+	flag.BoolVar(&opts.exportIncludeEmpty, "export-include-empty", false, "Write an empty file for every table without rows so that the export always holds one file per table")
+	flag.StringVar(&opts.exportBundle, "export-bundle", "", "Export data as a single gzipped tarball holding the per-table NDJSON files and their manifest.json (instead of writing to BigQuery)")
+	flag.StringVar(&opts.inputFormat, "input-format", metrics.InputFormatJSON, "Input format: json (keyed metrics object) or ndjson (one typed event per line)")
+	flag.IntVar(&opts.preview, "preview", 0, "Print the first N records of each non-empty table and exit without loading")
+	flag.Var(&opts.emitStdout, "emit-stdout", "Write the rows of this table to stdout as NDJSON and exit without loading; without a table (--emit-stdout), write every table with each row wrapped in {\"table\": ..., \"row\": ...}")
+	flag.StringVar(&opts.output, "output", metrics.OutputText, "Output format for reports printed to stdout: text or json")
+	flag.BoolVar(&opts.useWriteAPI, "use-write-api", false, "Append rows through the BigQuery Storage Write API instead of legacy streaming inserts (falls back to streaming inserts if setup fails)")
+	flag.BoolVar(&opts.skipEmptyTablesInSummary, "skip-empty-tables-in-summary", false, "Omit tables that had no rows from the per-table load summary")
+	flag.IntVar(&opts.shards, "shards", 0, "Shard the events table across N physical tables named events_<shard> (currently rejected: the events schema cannot be inferred)")
+	flag.StringVar(&opts.shardBy, "shard-by", "", "Events column whose hash selects the shard table (requires --shards)")
+	flag.IntVar(&opts.stopAfterErrors, "stop-after-errors", 0, "Keep loading past failed tables and abort once N errors have occurred in total (0 aborts on the first error)")
+	flag.BoolVar(&opts.continueOnError, "continue-on-error", false, "Keep loading past failed tables and exit with code 2 if only some tables failed")
+	flag.StringVar(&opts.columnCase, "column-case", metrics.ColumnCasePreserve, "Column naming: preserve (inferred names as-is) or snake (rewrite to snake_case)")
+	flag.StringVar(&opts.generateSample, "generate-sample", "", "Write a small sample metrics.json with one record of every loadable event type (all but pods and events) to this path and exit")
+	flag.BoolVar(&opts.skipEmptyObjects, "skip-empty-objects", false, "Skip a zero-byte metrics file with a warning and exit successfully instead of failing (json input only)")
+	flag.StringVar(&opts.writeSchemas, "write-schemas", "", "Write the schema of every table to <dir>/<table>.schema.json (for bq mk --schema) and exit")
+	flag.StringVar(&opts.diffAgainst, "diff-against", "", "Previous version of the metrics file (gs:// path or local path); only tables whose contents differ from it are loaded")
+	flag.StringVar(&opts.datasetFromMetadata, "dataset-from-metadata", "", "GCS object metadata key whose value overrides --bigquery-dataset (falls back to the flag when the key is absent)")
+	flag.IntVar(&opts.maxRowsPerTable, "max-rows-per-table", 0, "Abort decoding once a single table of the metrics file holds more than N rows (0 is unlimited, json input only)")
+	flag.BoolVar(&opts.mergeMapContext, "merge-map-context", false, "Consolidate image events sharing a full_name into one row, merging their additional_context and image_stream_details (json input only)")
+	flag.BoolVar(&opts.defaultTimestampToNow, "default-timestamp-to-now", false, "Set the timestamp of rows that have none to the load time instead of the epoch")
+	flag.StringVar(&opts.httpProxy, "http-proxy", "", "HTTP(S) proxy URL that GCS and BigQuery requests are sent through")
+	flag.StringVar(&opts.caCert, "ca-cert", "", "PEM bundle of additional CA certificates to trust for GCS and BigQuery requests")
+	// Integration tests run against local emulators instead of Google endpoints:
+	//   docker run -p 9050:9050 ghcr.io/goccy/bigquery-emulator --project=<project> --dataset=<dataset>
+	//   docker run -p 4443:4443 fsouza/fake-gcs-server -scheme http
+	// and pass --bq-emulator-host=localhost:9050 --gcs-emulator-host=localhost:4443 (or set the environment
+	// variables). Requests to the emulators are not authenticated.
+	flag.StringVar(&opts.bqEmulatorHost, "bq-emulator-host", os.Getenv("BIGQUERY_EMULATOR_HOST"), "host:port of a bigquery-emulator to send BigQuery requests to, without authentication (defaults to $BIGQUERY_EMULATOR_HOST)")
+	flag.StringVar(&opts.gcsEmulatorHost, "gcs-emulator-host", os.Getenv("STORAGE_EMULATOR_HOST"), "host:port of a fake-gcs-server to send GCS requests to, without authentication (defaults to $STORAGE_EMULATOR_HOST)")
+	flag.IntVar(&opts.truncateStrings, "truncate-strings", 0, "Truncate string fields longer than N bytes, ending them with an ellipsis, before insert (0 is unlimited)")
+	flag.BoolVar(&opts.leaseMetrics, "lease-metrics", false, "Add acquisition_efficiency (acquisition duration per lease of the pool) and utilization (fraction of the pool in use) columns to the lease tables")
+	flag.StringVar(&opts.nonFiniteFloats, "non-finite-floats", "", "What to do with rows holding a NaN or infinite float, which BigQuery rejects: drop the row or null the field (drop, null; empty inserts them as they are)")
+	flag.BoolVar(&opts.normalizeNamespaces, "normalize-namespaces", false, "Trim and lower-case every namespace value before insert, so that differently cased namespaces group as one")
+	flag.BoolVar(&opts.createDataset, "create-dataset", false, "Create the dataset if it does not exist (in --location when set); an existing dataset is left unchanged")
+	flag.DurationVar(&opts.partitionExpiration, "default-partition-expiration", 0, "Default partition expiration (e.g. 2160h) set on the dataset when --create-dataset creates it")
+	flag.StringVar(&opts.datasetFriendlyName, "dataset-friendly-name", "", "Friendly name shown in the BigQuery UI, set on the dataset when --create-dataset creates it")
+	flag.StringVar(&opts.datasetDescription, "dataset-description", "", "Description set on the dataset when --create-dataset creates it")
+	flag.StringVar(&opts.deadLetter, "dead-letter", "", "GCS prefix (gs://bucket/dlq/) where rows rejected by BigQuery are written as NDJSON together with the rejection reason")
+	flag.BoolVar(&opts.validateOnly, "validate-only", false, "Decode a local metrics file (--file, or - for stdin) and check the schema of every non-empty table without any GCP calls, exiting non-zero on problems")
+	flag.StringVar(&opts.dropTablesFirst, "drop-tables-first", "", "Development only: delete every table loaded into before recreating it, refusing tables whose dataset.table name does not start with this prefix (requires --i-know-this-deletes-data)")
+	flag.BoolVar(&opts.confirmDropTables, "i-know-this-deletes-data", false, "Confirm that --drop-tables-first deletes the existing rows of every table loaded into")
+	flag.BoolVar(&opts.skipInvalidRows, "skip-invalid-rows", false, "Insert the valid rows of each batch and skip the rows BigQuery rejects instead of failing the table (combine with --dead-letter to keep them)")
+	flag.StringVar(&opts.tableSuffix, "table-suffix", "", "Suffix appended to every table name for this run (e.g. _reprocess), leaving the production tables untouched")
+	flag.BoolVar(&opts.splitUnions, "split-unions", false, "Load lease and image events into lease_acquisitions/lease_releases and image_streams/tag_imports instead of the leases and images union tables")
+	flag.StringVar(&opts.unifiedTable, "unified-table", "", "Load every event into this single table with type, timestamp and JSON payload columns instead of one table per type")
+	flag.Func("columns", "table=col1,col2: store only these columns of table and drop the others (repeatable)", func(value string) error {
+		table, columns, ok := strings.Cut(value, "=")
+		if !ok || table == "" || columns == "" {
+			return fmt.Errorf("expected table=col1,col2, got %q", value)
+		}
+		if opts.columns == nil {
+			opts.columns = map[string][]string{}
+		}
+		opts.columns[table] = strings.Split(columns, ",")
+		return nil
+	})
+	flag.StringVar(&opts.postLoadSQL, "post-load-sql", "", "File of SQL run as a query job after a successful load, e.g. a rollup; may use {{.Project}}, {{.Dataset}}, {{.TableSuffix}} and {{table \"nodes\"}}")
+	flag.StringVar(&opts.successMarker, "success-marker", "", "gs://bucket/path/_SUCCESS object written with the run summary as JSON once every table loaded, for downstream jobs to wait for")
+	flag.BoolVar(&opts.successMarkerOnPartial, "success-marker-on-partial", false, "Also write --success-marker when only some tables failed, marking it as partial")
+	flag.StringVar(&opts.openMetricsObject, "openmetrics-object", "", "gs://bucket/path object to append the rows loaded per table, duration and outcome of the run to, in the OpenMetrics text format")
+	flag.BoolVar(&opts.audit, "audit", false, "Append the source, row counts, duration, status and error of every file loaded as a row of --audit-table, through the Storage Write API")
+	flag.StringVar(&opts.auditTable, "audit-table", metrics.DefaultAuditTable, "Table of the dataset --audit records loads in, created when missing")
+	flag.StringVar(&opts.createViews, "create-views", "", "Directory of <view>.sql files; after loading, each is created (or updated) as a view named after the file")
+	flag.Func("dedup-key", "table=field1,field2: derive the insert ID of every row of table from a hash of these fields so BigQuery de-duplicates retried inserts (repeatable)", func(value string) error {
+		table, fields, ok := strings.Cut(value, "=")
+		if !ok || table == "" || fields == "" {
+			return fmt.Errorf("expected table=field1,field2, got %q", value)
+		}
+		if opts.dedupKeys == nil {
+			opts.dedupKeys = map[string][]string{}
+		}
+		opts.dedupKeys[table] = strings.Split(fields, ",")
+		return nil
+	})
+	flag.BoolVar(&opts.fieldDescriptions, "field-descriptions", false, "Set the description of every column of the tables created to the comment of the struct field it comes from")
+	flag.Func("insert-id-column", "table=field: use this string field of every row of table as its insert ID, for producers that already emit a unique ID (repeatable)", func(value string) error {
+		table, field, ok := strings.Cut(value, "=")
+		if !ok || table == "" || field == "" {
+			return fmt.Errorf("expected table=field, got %q", value)
+		}
+		if opts.insertIDColumns == nil {
+			opts.insertIDColumns = map[string]string{}
+		}
+		opts.insertIDColumns[table] = field
+		return nil
+	})
+	flag.Func("field-type", "table.field=TYPE: store this column of table, a dot-separated path into nested records, as TYPE instead of the inferred type, e.g. NUMERIC for exact decimals (repeatable)", func(value string) error {
+		column, typeName, ok := strings.Cut(value, "=")
+		table, path, hasPath := strings.Cut(column, ".")
+		if !ok || !hasPath || table == "" || path == "" || typeName == "" {
+			return fmt.Errorf("expected table.field=TYPE, got %q", value)
+		}
+		fieldType, err := metrics.ParseFieldType(typeName)
+		if err != nil {
+			return err
+		}
+		if opts.fieldTypes == nil {
+			opts.fieldTypes = map[string]map[string]bigquery.FieldType{}
+		}
+		if opts.fieldTypes[table] == nil {
+			opts.fieldTypes[table] = map[string]bigquery.FieldType{}
+		}
+		opts.fieldTypes[table][path] = fieldType
+		return nil
+	})
+	flag.Func("range-partition", "table:column:start:end:interval: create table partitioned by ranges of an integer column (repeatable)", func(value string) error {
+		parts := strings.Split(value, ":")
+		if len(parts) != 5 || parts[0] == "" || parts[1] == "" {
+			return fmt.Errorf("expected table:column:start:end:interval, got %q", value)
+		}
+		var bounds [3]int64
+		for i, part := range parts[2:] {
+			bound, err := strconv.ParseInt(part, 10, 64)
+			if err != nil {
+				return fmt.Errorf("expected table:column:start:end:interval with integer bounds, got %q", value)
+			}
+			bounds[i] = bound
+		}
+		if opts.rangePartitions == nil {
+			opts.rangePartitions = map[string]metrics.RangePartition{}
+		}
+		opts.rangePartitions[parts[0]] = metrics.RangePartition{Column: parts[1], Start: bounds[0], End: bounds[1], Interval: bounds[2]}
+		return nil
+	})
+	flag.Func("upsert-key", "table=col1,col2: merge the rows of table into it on these key columns, updating matches and inserting the rest, instead of appending (repeatable)", func(value string) error {
+		table, columns, ok := strings.Cut(value, "=")
+		if !ok || table == "" || columns == "" {
+			return fmt.Errorf("expected table=col1,col2, got %q", value)
+		}
+		if opts.upsertKeys == nil {
+			opts.upsertKeys = map[string][]string{}
+		}
+		opts.upsertKeys[table] = strings.Split(columns, ",")
+		return nil
+	})
+	flag.Func("also-write-to", "project:dataset: after loading, write the same data to this dataset too, which must exist (repeatable)", func(value string) error {
+		target, err := metrics.ParseDatasetRef(value)
+		if err != nil {
+			return err
+		}
+		opts.alsoWriteTo = append(opts.alsoWriteTo, target)
+		return nil
+	})
+	flag.Func("log-field", "key=value: add this field to every log line, to correlate the logs of a run with the system that started it (repeatable)", func(value string) error {
+		key, fieldValue, ok := strings.Cut(value, "=")
+		if !ok || strings.TrimSpace(key) == "" {
+			return fmt.Errorf("expected key=value, got %q", value)
+		}
+		if opts.logFields == nil {
+			opts.logFields = logrus.Fields{}
+		}
+		opts.logFields[strings.TrimSpace(key)] = fieldValue
+		return nil
+	})
+	flag.BoolVar(&opts.strictFanOut, "strict-fanout", false, "Fail the load when writing to an --also-write-to dataset fails instead of only logging it")
+	flag.Func("require-tables", "table1,table2: fail the load of a metrics file that has no rows for any of these tables, before inserting anything", func(value string) error {
+		opts.requiredTables = strings.Split(value, ",")
+		return nil
+	})
+	flag.BoolVar(&opts.lenientDecode, "lenient-decode", false, "Skip and log the records that fail to decode (writing them to --dead-letter when set) instead of failing the whole file")
+	flag.StringVar(&opts.envelopePath, "envelope-path", "", "Dotted path of the object holding the metrics sections, such as data, for files wrapped in an envelope like {\"metadata\": {...}, \"data\": {...}} (json input only)")
+	flag.Func("envelope-column", "column=field: add this column to every table holding the envelope field at this dotted path, such as job_name=metadata.job.name (repeatable, requires --envelope-path)", func(value string) error {
+		name, field, ok := strings.Cut(value, "=")
+		if !ok || name == "" || field == "" {
+			return fmt.Errorf("expected column=field, got %q", value)
+		}
+		opts.envelopeColumns = append(opts.envelopeColumns, metrics.EnvelopeColumn{Name: name, Field: field})
+		return nil
+	})
+	flag.BoolVar(&opts.preserveRaw, "preserve-raw", false, "Store the original JSON record of every row in a raw_json column next to the typed columns (json input only)")
+	flag.Float64Var(&opts.sampleRate, "sample-rate", 1, "Load a random subset of the rows of every table, keeping each row with this probability, for quick test loads")
+	flag.Uint64Var(&opts.sampleSeed, "sample-seed", 0, "Seed of --sample-rate; the same seed keeps the same rows of the same file")
+	flag.BoolVar(&opts.checkTimestamps, "check-timestamps", false, "Flag rows with a timestamp before --min-valid-date or more than --max-future-skew after the load time")
+	flag.StringVar(&opts.minValidDate, "min-valid-date", "", "Earliest plausible timestamp date (YYYY-MM-DD) for --check-timestamps")
+	flag.DurationVar(&opts.maxFutureSkew, "max-future-skew", 24*time.Hour, "How far past the load time a timestamp may be for --check-timestamps")
+	flag.BoolVar(&opts.strict, "strict", false, "Drop the rows flagged by --check-timestamps instead of loading them")
+	flag.StringVar(&opts.kmsKey, "kms-key", "", "Cloud KMS key (projects/<p>/locations/<l>/keyRings/<r>/cryptoKeys/<k>) used to encrypt created tables and datasets")
+	flag.BoolVar(&opts.benchmark, "benchmark", false, "Load normally and print the insert throughput: rows/sec per table and overall, p50/p95 batch latency and peak heap")
+	flag.BoolVar(&opts.preflightSchemaCheck, "preflight-schema-check", false, "Before inserting anything, check the rows against the schema of the existing tables and fail with a report of every incompatible column")
+	flag.StringVar(&opts.schemaBaseline, "warn-on-schema-widening", "", "Record the schema of every table loaded in this file the first time, and warn when a later load changes the type of a column; a --write-schemas directory pins the baseline instead")
+	flag.StringVar(&opts.compare, "compare", "", "table_a,table_b: compare two tables, each [project:]dataset.table or a table of --bigquery-dataset, with read-only queries and exit")
+	flag.Func("compare-key", "col1,col2: match the rows of the --compare tables on these columns and report the added, removed and changed rows", func(value string) error {
+		opts.compareKey = strings.Split(value, ",")
+		return nil
+	})
+	flag.BoolVar(&opts.describe, "describe", false, "Print the row count, columns with their types and timestamp range of every non-empty table of the metrics file, and exit without writing anything")
+	flag.BoolVar(&opts.count, "count", false, "Print the number of rows of every table by scanning the metrics file, without decoding it into memory, and exit")
+	flag.BoolVar(&opts.tagVersion, "tag-version", false, "Add a loader_version column holding the version of this tool to every row")
+	flag.BoolVar(&opts.tagSource, "tag-source", false, "Add source_bucket and source_object columns naming the metrics file every row was loaded from")
+	flag.BoolVar(&opts.ingestionID, "ingestion-id", false, "Add an ingestion_id column identifying the source object generation (or local file version) to every row")
+	flag.IntVar(&opts.decodeRetries, "retry-file-on-decode-error", 0, "Download and decode the metrics file again, up to N times, when it is not valid JSON, e.g. because it was read mid-upload (json input only)")
+	flag.IntVar(&opts.incrementalBatch, "incremental-batch-size", 0, "Insert every N records of a section as soon as they are decoded instead of after the whole file, overlapping the download with the inserts (json input only, 0 loads the whole file at once)")
+	flag.DurationVar(&opts.decodeRetryDelay, "decode-retry-delay", 10*time.Second, "How long to wait before each --retry-file-on-decode-error attempt")
+	flag.IntVar(&opts.downloadConcurrency, "download-concurrency", 1, "Download GCS objects of at least 64MiB with up to N parallel range reads of 16MiB stitched back in order, instead of a single stream (1 reads every object with a single reader)")
+	flag.BoolVar(&opts.verifyCRC32C, "download-verify-crc32c", false, "Check the CRC32C of objects downloaded with --download-concurrency against the checksum GCS stores")
 	flag.Parse()
 	return opts
 }
 
-func validate(opts *options) error {
-	if opts.gcsPath == "" {
-		return fmt.Errorf("--gcs-path is required")
+// validate returns every problem with the given options as diagnostics
+func validate(opts *options) []metrics.Diagnostic {
+	if opts.generateSample != "" || opts.writeSchemas != "" {
+		return nil
 	}
+	if opts.compare != "" {
+		return validateCompare(opts)
+	}
+
+	diagnostics := metrics.ValidateInput(metrics.InputOptions{
+		GCSPath:            opts.gcsPath,
+		URL:                opts.sourceURL,
+		FilePath:           opts.filePath,
+		ExportDir:          opts.fromExportDir,
+		PathsFile:          opts.pathsFile,
+		ProjectID:          opts.projectID,
+		DatasetID:          opts.datasetID,
+		DatasetMetadataKey: opts.datasetFromMetadata,
+		RequireDestination: opts.exportDir == "" && opts.exportBundle == "" && opts.preview == 0 && !opts.validateOnly && !opts.count && !opts.describe && !opts.emitStdout.set,
+	})
 
-	if opts.exportDir == "" {
-		if opts.projectID == "" {
-			return fmt.Errorf("--google-project-id is required")
+	if opts.inputFormat != metrics.InputFormatJSON && opts.inputFormat != metrics.InputFormatNDJSON {
+		diagnostics = append(diagnostics, invalidFlag("input-format", fmt.Sprintf("--input-format must be %q or %q", metrics.InputFormatJSON, metrics.InputFormatNDJSON)))
+	}
+	if opts.count && (opts.inputFormat != metrics.InputFormatJSON || opts.fromExportDir != "" || metrics.IsArchive(opts.source())) {
+		diagnostics = append(diagnostics, invalidFlag("count", "--count only supports a single json metrics file"))
+	}
+	if opts.ingestionID && (opts.filePath == metrics.StdinPath || opts.fromExportDir != "" || opts.sourceURL != "") {
+		diagnostics = append(diagnostics, invalidFlag("ingestion-id", "--ingestion-id needs a GCS object or a local file to identify"))
+	}
+	if opts.pathsFile != "" && (opts.preview > 0 || (opts.exportDir != "" && !opts.exportPerSource) || opts.exportBundle != "" || opts.count || opts.describe || opts.validateOnly || opts.datasetFromMetadata != "" || opts.ingestionID || opts.diffAgainst != "") {
+		diagnostics = append(diagnostics, invalidFlag("paths-file", "--paths-file only loads into BigQuery and cannot be combined with --preview, --export (without --export-per-source), --export-bundle, --count, --describe, --validate-only, --dataset-from-metadata, --ingestion-id or --diff-against"))
+	}
+	if opts.tagSource && (opts.filePath == metrics.StdinPath || opts.fromExportDir != "") {
+		diagnostics = append(diagnostics, invalidFlag("tag-source", "--tag-source needs a GCS object or a local file to name"))
+	}
+	if (opts.maxRuntime != 0 || opts.resumeAfter != "" || opts.checkpointFile != "") && opts.pathsFile == "" {
+		diagnostics = append(diagnostics, invalidFlag("max-runtime", "--max-runtime, --resume-after and --checkpoint-file require --paths-file"))
+	}
+	if opts.objectRegex != "" {
+		if opts.pathsFile == "" {
+			diagnostics = append(diagnostics, invalidFlag("object-regex", "--object-regex requires --paths-file"))
 		}
-		if opts.datasetID == "" {
-			return fmt.Errorf("--bigquery-dataset is required")
+		if _, err := regexp.Compile(opts.objectRegex); err != nil {
+			diagnostics = append(diagnostics, invalidFlag("object-regex", fmt.Sprintf("invalid --object-regex: %v", err)))
 		}
 	}
-	return nil
+	if opts.maxRuntime < 0 {
+		diagnostics = append(diagnostics, invalidFlag("max-runtime", "--max-runtime must not be negative"))
+	}
+	if opts.decodeWorkers < 1 {
+		diagnostics = append(diagnostics, invalidFlag("decode-workers", "--decode-workers must be at least 1"))
+	}
+	if opts.decodeWorkers > 1 && opts.pathsFile == "" {
+		diagnostics = append(diagnostics, invalidFlag("decode-workers", "--decode-workers requires --paths-file"))
+	}
+	if opts.loadWorkers < 1 {
+		diagnostics = append(diagnostics, invalidFlag("load-workers", "--load-workers must be at least 1"))
+	}
+	if opts.loadWorkers > 1 && opts.pathsFile == "" {
+		diagnostics = append(diagnostics, invalidFlag("load-workers", "--load-workers requires --paths-file"))
+	}
+	if opts.maxTableInserts < 0 {
+		diagnostics = append(diagnostics, invalidFlag("max-concurrent-files-per-table-insert", "--max-concurrent-files-per-table-insert must not be negative"))
+	}
+	if opts.quotaCooldown < 0 {
+		diagnostics = append(diagnostics, invalidFlag("quota-cooldown", "--quota-cooldown must not be negative"))
+	}
+	if opts.waitForPermissions < 0 {
+		diagnostics = append(diagnostics, invalidFlag("wait-for-permissions", "--wait-for-permissions must not be negative"))
+	}
+	if opts.validateOnly && (opts.gcsPath != "" || opts.sourceURL != "") {
+		diagnostics = append(diagnostics, invalidFlag("validate-only", "--validate-only reads local files only; use --file"))
+	}
+	if opts.lenientDecode && opts.fromExportDir != "" {
+		diagnostics = append(diagnostics, invalidFlag("lenient-decode", "--lenient-decode does not apply to --from-export-dir"))
+	}
+	if opts.fromExportDir != "" && opts.inputFormat != metrics.InputFormatJSON {
+		diagnostics = append(diagnostics, invalidFlag("from-export-dir", "--from-export-dir cannot be combined with --input-format"))
+	}
+	if opts.location != "" && !locationPattern.MatchString(opts.location) {
+		diagnostics = append(diagnostics, invalidFlag("location", fmt.Sprintf("--location %q is not a BigQuery region or multi-region", opts.location)))
+	}
+	if opts.deadLetter != "" {
+		if _, _, err := metrics.ParseGCSPath(opts.deadLetter); err != nil {
+			diagnostics = append(diagnostics, invalidFlag("dead-letter", fmt.Sprintf("invalid --dead-letter location: %v", err)))
+		}
+	}
+	if opts.successMarker != "" {
+		if _, _, err := metrics.ParseGCSPath(opts.successMarker); err != nil {
+			diagnostics = append(diagnostics, invalidFlag("success-marker", fmt.Sprintf("invalid --success-marker path: %v", err)))
+		}
+	}
+	if opts.openMetricsObject != "" {
+		if _, _, err := metrics.ParseGCSPath(opts.openMetricsObject); err != nil {
+			diagnostics = append(diagnostics, invalidFlag("openmetrics-object", fmt.Sprintf("invalid --openmetrics-object path: %v", err)))
+		}
+	}
+	if opts.audit && opts.bqEmulatorHost != "" {
+		diagnostics = append(diagnostics, invalidFlag("audit", "--audit writes through the Storage Write API, which is not supported with --bq-emulator-host"))
+	}
+	if opts.audit && opts.auditTable == "" {
+		diagnostics = append(diagnostics, invalidFlag("audit-table", "--audit-table must not be empty"))
+	}
+	if opts.successMarkerOnPartial && opts.successMarker == "" {
+		diagnostics = append(diagnostics, invalidFlag("success-marker-on-partial", "--success-marker-on-partial requires --success-marker"))
+	}
+	if opts.httpProxy != "" {
+		if _, err := baseTransport(opts.httpProxy, ""); err != nil {
+			diagnostics = append(diagnostics, invalidFlag("http-proxy", err.Error()))
+		}
+	}
+	if opts.caCert != "" {
+		if _, err := baseTransport("", opts.caCert); err != nil {
+			diagnostics = append(diagnostics, invalidFlag("ca-cert", err.Error()))
+		}
+	}
+	if (opts.bqEmulatorHost != "" || opts.gcsEmulatorHost != "") && (opts.httpProxy != "" || opts.caCert != "") {
+		diagnostics = append(diagnostics, invalidFlag("bq-emulator-host", "emulator hosts cannot be combined with --http-proxy or --ca-cert"))
+	}
+	if opts.bqEmulatorHost != "" && opts.useWriteAPI {
+		diagnostics = append(diagnostics, invalidFlag("use-write-api", "--use-write-api is not supported with --bq-emulator-host"))
+	}
+	for _, table := range opts.requiredTables {
+		if !slices.Contains(metrics.Tables(), table) {
+			diagnostics = append(diagnostics, invalidFlag("require-tables", fmt.Sprintf("--require-tables names unknown table %q, expected one of %s", table, strings.Join(metrics.Tables(), ", "))))
+		}
+	}
+	if len(opts.requiredTables) > 0 && opts.inputFormat == metrics.InputFormatNDJSON {
+		diagnostics = append(diagnostics, invalidFlag("require-tables", "--require-tables cannot check --input-format ndjson, which is inserted while it is read"))
+	}
+	if opts.exportPerSource && opts.exportDir == "" {
+		diagnostics = append(diagnostics, invalidFlag("export-per-source", "--export-per-source requires --export"))
+	}
+	if opts.exportPerSource && (opts.maxRuntime != 0 || opts.resumeAfter != "" || opts.checkpointFile != "") {
+		diagnostics = append(diagnostics, invalidFlag("export-per-source", "--export-per-source exports every path and cannot be combined with --max-runtime, --resume-after or --checkpoint-file"))
+	}
+	if opts.exportFormat != metrics.ExportFormatJSON && opts.exportFormat != metrics.ExportFormatAvroBQ {
+		diagnostics = append(diagnostics, invalidFlag("export-format", fmt.Sprintf("--export-format must be %q or %q", metrics.ExportFormatJSON, metrics.ExportFormatAvroBQ)))
+	}
+	if opts.exportFormat == metrics.ExportFormatAvroBQ && opts.exportDir == "" {
+		diagnostics = append(diagnostics, invalidFlag("export-format", "--export-format=avro-bq requires --export"))
+	}
+	if opts.exportFormat == metrics.ExportFormatAvroBQ && opts.exportManifest {
+		diagnostics = append(diagnostics, invalidFlag("export-format", "--export-format=avro-bq writes the schema of every table beside its file and cannot be combined with --export-manifest"))
+	}
+	if opts.exportManifest && opts.exportDir == "" {
+		diagnostics = append(diagnostics, invalidFlag("export-manifest", "--export-manifest requires --export"))
+	}
+	if opts.exportIncludeEmpty && opts.exportDir == "" && opts.exportBundle == "" {
+		diagnostics = append(diagnostics, invalidFlag("export-include-empty", "--export-include-empty requires --export or --export-bundle"))
+	}
+	if opts.exportBundle != "" && opts.exportDir != "" {
+		diagnostics = append(diagnostics, invalidFlag("export-bundle", "--export-bundle cannot be combined with --export"))
+	}
+	if opts.emitStdout.set && opts.emitStdout.table != "" && !slices.Contains(metrics.Tables(), opts.emitStdout.table) {
+		diagnostics = append(diagnostics, invalidFlag("emit-stdout", fmt.Sprintf("--emit-stdout table %q must be one of %s", opts.emitStdout.table, strings.Join(metrics.Tables(), ", "))))
+	}
+	if opts.emitStdout.set && (opts.preview > 0 || opts.describe || opts.exportDir != "" || opts.exportBundle != "" || opts.count || opts.validateOnly || opts.pathsFile != "" || opts.benchmark) {
+		diagnostics = append(diagnostics, invalidFlag("emit-stdout", "--emit-stdout cannot be combined with --preview, --describe, --export, --export-bundle, --count, --validate-only, --paths-file or --benchmark"))
+	}
+	if opts.preview < 0 {
+		diagnostics = append(diagnostics, invalidFlag("preview", "--preview must not be negative"))
+	}
+	if opts.output != metrics.OutputText && opts.output != metrics.OutputJSON {
+		diagnostics = append(diagnostics, invalidFlag("output", fmt.Sprintf("--output must be %q or %q", metrics.OutputText, metrics.OutputJSON)))
+	}
+	if opts.shards < 0 {
+		diagnostics = append(diagnostics, invalidFlag("shards", "--shards must not be negative"))
+	}
+	if (opts.shards > 0) != (opts.shardBy != "") {
+		diagnostics = append(diagnostics, invalidFlag("shard-by", "--shards and --shard-by must be set together"))
+	}
+	if opts.shards > 0 {
+		// the schema is inferred before rows are routed to shards, and it cannot be inferred from the events rows
+		diagnostics = append(diagnostics, invalidFlag("shards", "--shards cannot be used: it shards the events table, whose schema cannot be inferred (pointer fields such as locator.container are not supported), so no events row would load"))
+	}
+	if opts.stopAfterErrors < 0 {
+		diagnostics = append(diagnostics, invalidFlag("stop-after-errors", "--stop-after-errors must not be negative"))
+	}
+	if opts.columnCase != metrics.ColumnCasePreserve && opts.columnCase != metrics.ColumnCaseSnake {
+		diagnostics = append(diagnostics, invalidFlag("column-case", fmt.Sprintf("--column-case must be %q or %q", metrics.ColumnCasePreserve, metrics.ColumnCaseSnake)))
+	}
+	if opts.partitionExpiration < 0 {
+		diagnostics = append(diagnostics, invalidFlag("default-partition-expiration", "--default-partition-expiration must not be negative"))
+	}
+	if opts.partitionExpiration > 0 && !opts.createDataset {
+		diagnostics = append(diagnostics, invalidFlag("default-partition-expiration", "--default-partition-expiration requires --create-dataset"))
+	}
+	if (opts.datasetFriendlyName != "" || opts.datasetDescription != "") && !opts.createDataset {
+		diagnostics = append(diagnostics, invalidFlag("create-dataset", "--dataset-friendly-name and --dataset-description require --create-dataset"))
+	}
+	if utf8.RuneCountInString(opts.datasetFriendlyName) > maxDatasetFriendlyName {
+		diagnostics = append(diagnostics, invalidFlag("dataset-friendly-name", fmt.Sprintf("--dataset-friendly-name must be at most %d characters", maxDatasetFriendlyName)))
+	}
+	if utf8.RuneCountInString(opts.datasetDescription) > maxDatasetDescription {
+		diagnostics = append(diagnostics, invalidFlag("dataset-description", fmt.Sprintf("--dataset-description must be at most %d characters", maxDatasetDescription)))
+	}
+	if opts.tableSuffix != "" && !tableSuffixPattern.MatchString(opts.tableSuffix) {
+		diagnostics = append(diagnostics, invalidFlag("table-suffix", "--table-suffix may only contain letters, digits and underscores"))
+	}
+	if opts.unifiedTable != "" && !tableSuffixPattern.MatchString(opts.unifiedTable) {
+		diagnostics = append(diagnostics, invalidFlag("unified-table", "--unified-table may only contain letters, digits and underscores"))
+	}
+	if opts.unifiedTable != "" && opts.shards > 0 {
+		diagnostics = append(diagnostics, invalidFlag("unified-table", "--unified-table cannot be combined with --shards"))
+	}
+	for table := range opts.columns {
+		if !opts.knownTable(table) {
+			diagnostics = append(diagnostics, invalidFlag("columns", fmt.Sprintf("--columns names unknown table %q", table)))
+		}
+	}
+	for table := range opts.dedupKeys {
+		if !opts.knownTable(table) {
+			diagnostics = append(diagnostics, invalidFlag("dedup-key", fmt.Sprintf("--dedup-key names unknown table %q", table)))
+		}
+	}
+	if len(opts.dedupKeys) > 0 && opts.useWriteAPI {
+		diagnostics = append(diagnostics, invalidFlag("dedup-key", "--dedup-key relies on streaming insert IDs, which the Storage Write API ignores"))
+	}
+	for table := range opts.insertIDColumns {
+		if !opts.knownTable(table) {
+			diagnostics = append(diagnostics, invalidFlag("insert-id-column", fmt.Sprintf("--insert-id-column names unknown table %q", table)))
+		}
+		if _, ok := opts.dedupKeys[table]; ok {
+			diagnostics = append(diagnostics, invalidFlag("insert-id-column", fmt.Sprintf("--insert-id-column and --dedup-key both set the insert ID of table %q", table)))
+		}
+	}
+	if len(opts.insertIDColumns) > 0 && opts.useWriteAPI {
+		diagnostics = append(diagnostics, invalidFlag("insert-id-column", "--insert-id-column relies on streaming insert IDs, which the Storage Write API ignores"))
+	}
+	for table := range opts.fieldTypes {
+		if !opts.knownTable(table) {
+			diagnostics = append(diagnostics, invalidFlag("field-type", fmt.Sprintf("--field-type names unknown table %q", table)))
+		}
+	}
+	if len(opts.fieldTypes) > 0 {
+		for _, failure := range metrics.CheckFieldTypes(opts.schemaOptions()...) {
+			diagnostics = append(diagnostics, invalidFlag("field-type", fmt.Sprintf("--field-type %v", failure)))
+		}
+	}
+	if opts.dropTablesFirst != "" && !opts.confirmDropTables {
+		diagnostics = append(diagnostics, invalidFlag("drop-tables-first", "--drop-tables-first deletes data and requires --i-know-this-deletes-data"))
+	}
+	if opts.confirmDropTables && opts.dropTablesFirst == "" {
+		diagnostics = append(diagnostics, invalidFlag("i-know-this-deletes-data", "--i-know-this-deletes-data requires --drop-tables-first"))
+	}
+	if dataset := opts.datasetID + "."; opts.dropTablesFirst != "" && opts.datasetID != "" && opts.datasetFromMetadata == "" &&
+		!strings.HasPrefix(dataset, opts.dropTablesFirst) && !strings.HasPrefix(opts.dropTablesFirst, dataset) {
+		diagnostics = append(diagnostics, invalidFlag("drop-tables-first", fmt.Sprintf("no table of dataset %s starts with the --drop-tables-first prefix %q", opts.datasetID, opts.dropTablesFirst)))
+	}
+	routingOpts := append(opts.schemaOptions(), metrics.WithTableSuffix(opts.tableSuffix))
+	if opts.unifiedTable != "" {
+		routingOpts = append(routingOpts, metrics.WithUnifiedTable(opts.unifiedTable))
+	}
+	if opts.shards > 0 {
+		routingOpts = append(routingOpts, metrics.WithSharding(metrics.EventsTable, opts.shards, opts.shardBy))
+	}
+	for _, conflict := range metrics.CheckTableRouting(routingOpts...) {
+		field := "table-suffix"
+		switch {
+		case opts.unifiedTable != "":
+			field = "unified-table"
+		case opts.shards > 0:
+			field = "shards"
+		}
+		diagnostics = append(diagnostics, invalidFlag(field, conflict.Error()))
+	}
+	for table := range opts.upsertKeys {
+		if !opts.knownTable(table) {
+			diagnostics = append(diagnostics, invalidFlag("upsert-key", fmt.Sprintf("--upsert-key names unknown table %q", table)))
+		}
+	}
+	for _, target := range opts.alsoWriteTo {
+		if target.ProjectID == opts.projectID && target.DatasetID == opts.datasetID {
+			diagnostics = append(diagnostics, invalidFlag("also-write-to", fmt.Sprintf("--also-write-to names the --bigquery-dataset %s itself", target)))
+		}
+	}
+	if opts.schemaBaseline != "" && (opts.preview > 0 || opts.describe || opts.exportDir != "" || opts.exportBundle != "" || opts.count || opts.validateOnly) {
+		diagnostics = append(diagnostics, invalidFlag("warn-on-schema-widening", "--warn-on-schema-widening checks the tables loaded and cannot be combined with --preview, --describe, --export, --export-bundle, --count or --validate-only"))
+	}
+	if opts.preflightSchemaCheck && (opts.preview > 0 || opts.describe || opts.exportDir != "" || opts.exportBundle != "" || opts.count || opts.validateOnly) {
+		diagnostics = append(diagnostics, invalidFlag("preflight-schema-check", "--preflight-schema-check checks the tables loaded and cannot be combined with --preview, --describe, --export, --export-bundle, --count or --validate-only"))
+	}
+	if opts.preflightSchemaCheck && opts.dropTablesFirst != "" {
+		diagnostics = append(diagnostics, invalidFlag("preflight-schema-check", "--preflight-schema-check cannot be combined with --drop-tables-first, which recreates every table with the schema of the rows"))
+	}
+	if opts.benchmark && (opts.preview > 0 || opts.describe || opts.exportDir != "" || opts.exportBundle != "" || opts.count || opts.validateOnly) {
+		diagnostics = append(diagnostics, invalidFlag("benchmark", "--benchmark measures a load and cannot be combined with --preview, --describe, --export, --export-bundle, --count or --validate-only"))
+	}
+	if len(opts.compareKey) > 0 {
+		diagnostics = append(diagnostics, invalidFlag("compare-key", "--compare-key requires --compare"))
+	}
+	if opts.strictFanOut && len(opts.alsoWriteTo) == 0 {
+		diagnostics = append(diagnostics, invalidFlag("strict-fanout", "--strict-fanout requires --also-write-to"))
+	}
+	if opts.sampleRate <= 0 || opts.sampleRate > 1 {
+		diagnostics = append(diagnostics, invalidFlag("sample-rate", "--sample-rate must be greater than 0 and at most 1"))
+	}
+	for table, partition := range opts.rangePartitions {
+		if !opts.knownTable(table) {
+			diagnostics = append(diagnostics, invalidFlag("range-partition", fmt.Sprintf("--range-partition names unknown table %q", table)))
+		}
+		if err := partition.Validate(); err != nil {
+			diagnostics = append(diagnostics, invalidFlag("range-partition", fmt.Sprintf("--range-partition for table %q: %v", table, err)))
+		}
+	}
+	if opts.minValidDate != "" {
+		if _, err := time.Parse(time.DateOnly, opts.minValidDate); err != nil {
+			diagnostics = append(diagnostics, invalidFlag("min-valid-date", "--min-valid-date must be a YYYY-MM-DD date"))
+		}
+	}
+	if opts.maxFutureSkew < 0 {
+		diagnostics = append(diagnostics, invalidFlag("max-future-skew", "--max-future-skew must not be negative"))
+	}
+	if (opts.minValidDate != "" || opts.strict) && !opts.checkTimestamps {
+		diagnostics = append(diagnostics, invalidFlag("check-timestamps", "--min-valid-date and --strict require --check-timestamps"))
+	}
+	if opts.kmsKey != "" && !kmsKeyPattern.MatchString(opts.kmsKey) {
+		diagnostics = append(diagnostics, invalidFlag("kms-key", "--kms-key must look like projects/<p>/locations/<l>/keyRings/<r>/cryptoKeys/<k>"))
+	}
+	if opts.nonFiniteFloats != "" && opts.nonFiniteFloats != metrics.DropNonFiniteRows && opts.nonFiniteFloats != metrics.NullNonFiniteFields {
+		diagnostics = append(diagnostics, invalidFlag("non-finite-floats", fmt.Sprintf("--non-finite-floats must be %s or %s, got %q", metrics.DropNonFiniteRows, metrics.NullNonFiniteFields, opts.nonFiniteFloats)))
+	}
+	if opts.truncateStrings < 0 {
+		diagnostics = append(diagnostics, invalidFlag("truncate-strings", "--truncate-strings must not be negative"))
+	}
+	if opts.decodeRetries < 0 {
+		diagnostics = append(diagnostics, invalidFlag("retry-file-on-decode-error", "--retry-file-on-decode-error must not be negative"))
+	}
+	if opts.decodeRetries > 0 && (opts.filePath == metrics.StdinPath || opts.inputFormat != metrics.InputFormatJSON) {
+		diagnostics = append(diagnostics, invalidFlag("retry-file-on-decode-error", "--retry-file-on-decode-error needs a json metrics file that can be read again"))
+	}
+	if opts.decodeRetryDelay < 0 {
+		diagnostics = append(diagnostics, invalidFlag("decode-retry-delay", "--decode-retry-delay must not be negative"))
+	}
+	if opts.downloadConcurrency < 1 {
+		diagnostics = append(diagnostics, invalidFlag("download-concurrency", "--download-concurrency must be at least 1"))
+	}
+	if opts.downloadConcurrency > 1 && (opts.filePath != "" || opts.fromExportDir != "" || opts.sourceURL != "") {
+		diagnostics = append(diagnostics, invalidFlag("download-concurrency", "--download-concurrency only applies to GCS sources"))
+	}
+	if opts.verifyCRC32C && opts.downloadConcurrency <= 1 {
+		diagnostics = append(diagnostics, invalidFlag("download-verify-crc32c", "--download-verify-crc32c requires --download-concurrency, as a single reader always checks the CRC32C"))
+	}
+	if opts.maxRowsPerTable < 0 {
+		diagnostics = append(diagnostics, invalidFlag("max-rows-per-table", "--max-rows-per-table must not be negative"))
+	}
+	if metrics.IsArchive(opts.gcsPath) || metrics.IsArchive(opts.filePath) || metrics.IsArchive(metrics.RedactURL(opts.sourceURL)) {
+		if opts.inputFormat != metrics.InputFormatJSON {
+			diagnostics = append(diagnostics, invalidFlag("input-format", "archives can only hold json metrics files"))
+		}
+		if opts.diffAgainst != "" {
+			diagnostics = append(diagnostics, invalidFlag("diff-against", "--diff-against is not supported for .tar.gz sources"))
+		}
+	}
+	if opts.skipEmptyObjects && opts.inputFormat != metrics.InputFormatJSON {
+		diagnostics = append(diagnostics, invalidFlag("skip-empty-objects", "--skip-empty-objects requires --input-format json"))
+	}
+	if opts.preserveRaw && opts.inputFormat != metrics.InputFormatJSON {
+		diagnostics = append(diagnostics, invalidFlag("preserve-raw", "--preserve-raw requires --input-format json"))
+	}
+	if opts.envelopePath != "" && (opts.inputFormat != metrics.InputFormatJSON || opts.fromExportDir != "") {
+		diagnostics = append(diagnostics, invalidFlag("envelope-path", "--envelope-path requires --input-format json"))
+	}
+	if opts.envelopePath != "" && opts.count {
+		diagnostics = append(diagnostics, invalidFlag("envelope-path", "--count only scans the sections at the root of the file and cannot be combined with --envelope-path"))
+	}
+	if len(opts.envelopeColumns) > 0 && opts.envelopePath == "" {
+		diagnostics = append(diagnostics, invalidFlag("envelope-column", "--envelope-column requires --envelope-path"))
+	}
+	for _, column := range opts.envelopeColumns {
+		if column.Field == opts.envelopePath || strings.HasPrefix(column.Field, opts.envelopePath+".") {
+			diagnostics = append(diagnostics, invalidFlag("envelope-column", fmt.Sprintf("--envelope-column %s reads %s, which is inside --envelope-path", column.Name, column.Field)))
+		}
+	}
+	if len(opts.envelopeColumns) > 0 && opts.incrementalBatch > 0 {
+		diagnostics = append(diagnostics, invalidFlag("envelope-column", "--envelope-column cannot be combined with --incremental-batch-size, which inserts rows before the envelope is read"))
+	}
+	if opts.incrementalBatch < 0 {
+		diagnostics = append(diagnostics, invalidFlag("incremental-batch-size", "--incremental-batch-size must not be negative"))
+	}
+	if opts.incrementalBatch > 0 && (opts.inputFormat != metrics.InputFormatJSON || opts.fromExportDir != "" || opts.isArchive()) {
+		diagnostics = append(diagnostics, invalidFlag("incremental-batch-size", "--incremental-batch-size requires a json metrics file; --input-format ndjson is always loaded in batches"))
+	}
+	if opts.incrementalBatch > 0 && opts.pathsFile != "" {
+		diagnostics = append(diagnostics, invalidFlag("incremental-batch-size", "--incremental-batch-size loads a single file; --paths-file overlaps decoding and loading with --decode-workers instead"))
+	}
+	if opts.incrementalBatch > 0 && (opts.mergeMapContext || opts.diffAgainst != "" || len(opts.requiredTables) > 0 || opts.decodeRetries > 0) {
+		diagnostics = append(diagnostics, invalidFlag("incremental-batch-size", "--incremental-batch-size inserts tables before they were read whole and cannot be combined with --merge-map-context, --diff-against, --require-tables or --retry-file-on-decode-error"))
+	}
+	if opts.mergeMapContext && opts.inputFormat != metrics.InputFormatJSON {
+		diagnostics = append(diagnostics, invalidFlag("merge-map-context", "--merge-map-context requires --input-format json"))
+	}
+	if opts.diffAgainst != "" && opts.inputFormat != metrics.InputFormatJSON {
+		diagnostics = append(diagnostics, invalidFlag("diff-against", "--diff-against requires --input-format json"))
+	}
+	if strings.HasPrefix(opts.diffAgainst, "gs://") {
+		if _, _, err := metrics.ParseGCSPath(opts.diffAgainst); err != nil {
+			diagnostics = append(diagnostics, invalidFlag("diff-against", fmt.Sprintf("invalid --diff-against path: %v", err)))
+		}
+	}
+	return diagnostics
+}
+
+func invalidFlag(field, message string) metrics.Diagnostic {
+	return metrics.Diagnostic{Code: metrics.DiagnosticInvalidValue, Field: field, Message: message}
+}
+
+// validateCompare returns the problems with the options of --compare, which reads no metrics file
+func validateCompare(opts *options) []metrics.Diagnostic {
+	var diagnostics []metrics.Diagnostic
+	if opts.projectID == "" {
+		diagnostics = append(diagnostics, metrics.Diagnostic{Code: metrics.DiagnosticMissingProject, Field: "google-project-id", Message: "--compare requires --google-project-id to run its queries in"})
+	}
+	if _, _, err := opts.compareTables(); err != nil {
+		diagnostics = append(diagnostics, invalidFlag("compare", fmt.Sprintf("--compare: %v", err)))
+	}
+	for _, column := range opts.compareKey {
+		if !columnPattern.MatchString(column) {
+			diagnostics = append(diagnostics, invalidFlag("compare-key", fmt.Sprintf("--compare-key column %q is not a column name", column)))
+		}
+	}
+	if opts.gcsPath != "" || opts.sourceURL != "" || opts.filePath != "" || opts.pathsFile != "" || opts.fromExportDir != "" {
+		diagnostics = append(diagnostics, invalidFlag("compare", "--compare compares existing tables and reads no metrics file"))
+	}
+	return diagnostics
+}
+
+// compareTables returns the two tables of --compare, completing bare names with the project and dataset flags
+func (o *options) compareTables() (metrics.TableRef, metrics.TableRef, error) {
+	a, b, ok := strings.Cut(o.compare, ",")
+	if !ok {
+		return metrics.TableRef{}, metrics.TableRef{}, fmt.Errorf("expected table_a,table_b, got %q", o.compare)
+	}
+	refA, err := metrics.ParseTableRef(a, o.projectID, o.datasetID)
+	if err != nil {
+		return metrics.TableRef{}, metrics.TableRef{}, err
+	}
+	refB, err := metrics.ParseTableRef(b, o.projectID, o.datasetID)
+	if err != nil {
+		return metrics.TableRef{}, metrics.TableRef{}, err
+	}
+	return refA, refB, nil
+}
+
+// reportDiagnostics writes the diagnostics to stderr, as JSON when requested so wrappers can parse them
+func reportDiagnostics(output string, diagnostics []metrics.Diagnostic) {
+	if output == metrics.OutputJSON {
+		if err := json.NewEncoder(os.Stderr).Encode(map[string][]metrics.Diagnostic{"diagnostics": diagnostics}); err != nil {
+			logrus.WithError(err).Error("Failed to encode diagnostics")
+		}
+		return
+	}
+	for _, d := range diagnostics {
+		logrus.WithField("field", d.Field).WithField("code", d.Code).Error(d.Message)
+	}
 }
 
 func (o *options) complete() error {
+	if o.gcsPath == "" {
+		return nil
+	}
 	var err error
-	o.bucket, o.object, err = parseGCSPath(o.gcsPath)
+	o.bucket, o.object, err = metrics.ParseGCSPath(o.gcsPath)
 	if err != nil {
 		return fmt.Errorf("invalid GCS path: %w", err)
 	}
@@ -60,53 +922,994 @@ func (o *options) complete() error {
 
 func main() {
 	opts := gatherOptions()
+	if len(opts.logFields) > 0 {
+		logrus.AddHook(metrics.NewLogFieldsHook(opts.logFields))
+	}
 
-	if err := validate(opts); err != nil {
-		logrus.Fatal(err)
+	if diagnostics := validate(opts); len(diagnostics) > 0 {
+		reportDiagnostics(opts.output, diagnostics)
+		os.Exit(1)
+	}
+
+	if opts.generateSample != "" {
+		if err := metrics.WriteSampleFile(opts.generateSample, time.Now()); err != nil {
+			logrus.WithError(err).Fatal("Failed to generate sample")
+		}
+		logrus.Infof("Wrote sample metrics to %s", opts.generateSample)
+		return
+	}
+
+	if opts.writeSchemas != "" {
+		written, err := metrics.WriteSchemas(opts.writeSchemas, opts.schemaOptions()...)
+		for _, path := range written {
+			logrus.Infof("Wrote %s", path)
+		}
+		if err != nil {
+			logrus.WithError(err).Fatal("Failed to write every table schema")
+		}
+		return
 	}
 
 	if err := opts.complete(); err != nil {
 		logrus.Fatal(err)
 	}
+	if opts.maxRuntime > 0 {
+		opts.deadline = time.Now().Add(opts.maxRuntime)
+	}
 
 	ctx := context.Background()
 
-	if opts.exportDir != "" {
-		if err := metrics.ExportMetricsFromGCS(ctx, opts.bucket, opts.object, opts.exportDir); err != nil {
-			logrus.WithError(err).Fatal("Failed to export metrics from GCS")
+	if opts.count {
+		reader, err := openSource(ctx, opts)
+		if err != nil {
+			logrus.WithError(err).Fatal("Failed to open the metrics file")
+		}
+		counts, err := metrics.CountRows(ctx, reader)
+		reader.Close()
+		if err != nil {
+			logrus.WithError(err).Fatal("Failed to count rows")
+		}
+		if err := reportCounts(os.Stdout, opts.output, counts); err != nil {
+			logrus.WithError(err).Fatal("Failed to report the counts")
+		}
+		return
+	}
+
+	if opts.validateOnly {
+		data, err := readMetrics(ctx, opts)
+		if err != nil {
+			logrus.WithError(err).Fatal("Failed to read metrics")
+		}
+		if bad := len(data.BadRecords()); bad > 0 {
+			logrus.Warnf("Skipped %d records that failed to decode", bad)
+		}
+		checks := metrics.CheckMetricsData(data, opts.schemaOptions()...)
+		if err := reportChecks(os.Stdout, opts.output, checks); err != nil {
+			logrus.WithError(err).Fatal("Failed to report the checks")
+		}
+		if missing := metrics.MissingTables(data, opts.requiredTables); len(missing) > 0 {
+			logrus.WithError(&metrics.MissingTablesError{Tables: missing}).Error("The metrics file is missing required tables")
+			os.Exit(exitFailure)
+		}
+		for _, check := range checks {
+			if check.Error != "" {
+				os.Exit(exitFailure)
+			}
+		}
+		return
+	}
+
+	transport, err := baseTransport(opts.httpProxy, opts.caCert)
+	if err != nil {
+		logrus.WithError(err).Fatal("Failed to configure the HTTP transport")
+	}
+	if transport != nil {
+		if opts.bqClientOpts, err = clientOptions(ctx, transport, bigquery.Scope); err != nil {
+			logrus.WithError(err).Fatal("Failed to configure the BigQuery client")
+		}
+		if opts.gcsClientOpts, err = clientOptions(ctx, transport, storage.ScopeReadWrite); err != nil {
+			logrus.WithError(err).Fatal("Failed to configure the GCS client")
+		}
+		opts.writeAPIClientOpts = grpcClientOptions(transport)
+		opts.httpClient = &http.Client{Transport: transport}
+	}
+	if opts.bqEmulatorHost != "" {
+		logrus.Infof("Sending BigQuery requests to the emulator at %s", opts.bqEmulatorHost)
+		opts.bqClientOpts = emulatorOptions(opts.bqEmulatorHost, "/")
+	}
+	if opts.gcsEmulatorHost != "" {
+		logrus.Infof("Sending GCS requests to the emulator at %s", opts.gcsEmulatorHost)
+		opts.gcsClientOpts = emulatorOptions(opts.gcsEmulatorHost, "/storage/v1/")
+	}
+
+	if opts.compare != "" {
+		tableA, tableB, _ := opts.compareTables()
+		bqClient, err := bigquery.NewClient(ctx, opts.projectID, opts.bqClientOpts...)
+		if err != nil {
+			logrus.WithError(err).Fatal("Failed to create BigQuery client")
+		}
+		defer bqClient.Close()
+		bqClient.Location = opts.location
+		comparison, err := metrics.CompareTables(ctx, bqClient, tableA, tableB, opts.compareKey)
+		if err != nil {
+			logrus.WithError(err).Fatal("Failed to compare the tables")
+		}
+		if err := reportComparison(os.Stdout, opts.output, comparison); err != nil {
+			logrus.WithError(err).Fatal("Failed to report the comparison")
+		}
+		return
+	}
+
+	if opts.exportPerSource && opts.pathsFile != "" {
+		paths, err := metrics.ReadPathsFile(opts.pathsFile)
+		if err != nil {
+			logrus.WithError(err).Fatal("Failed to read the paths file")
+		}
+		paths = applyObjectRegex(opts, paths)
+		failed := 0
+		for i, subdir := range metrics.ExportSubdirs(paths) {
+			dir := filepath.Join(opts.exportDir, subdir)
+			logrus.Infof("Exporting %d/%d: %s to %s", i+1, len(paths), paths[i], dir)
+			if err := exportPath(ctx, opts, paths[i], dir); err != nil {
+				logrus.WithError(err).Errorf("Failed to export %s, continuing with the remaining paths", paths[i])
+				failed++
+			}
+		}
+		if failed > 0 {
+			logrus.Errorf("Failed to export %d of %d paths", failed, len(paths))
+			os.Exit(exitFailure)
+		}
+		return
+	}
+
+	if opts.preview > 0 || opts.describe || opts.exportDir != "" || opts.exportBundle != "" || opts.emitStdout.set {
+		data, err := readMetrics(ctx, opts)
+		if err != nil {
+			logrus.WithError(err).Fatal("Failed to read metrics")
+		}
+		if bad := len(data.BadRecords()); bad > 0 {
+			logrus.Warnf("Skipped %d records that failed to decode", bad)
+		}
+
+		if opts.emitStdout.set {
+			if err := metrics.EmitNDJSON(os.Stdout, data, opts.emitStdout.table); err != nil {
+				logrus.WithError(err).Fatal("Failed to write rows to stdout")
+			}
+			return
+		}
+
+		if opts.describe {
+			if err := metrics.DescribeMetrics(os.Stdout, data, opts.output, opts.schemaOptions()...); err != nil {
+				logrus.WithError(err).Fatal("Failed to describe metrics")
+			}
+			return
+		}
+
+		if opts.preview > 0 {
+			if err := metrics.PreviewMetrics(os.Stdout, data, opts.preview, opts.output); err != nil {
+				logrus.WithError(err).Fatal("Failed to preview metrics")
+			}
+			return
+		}
+
+		if opts.exportBundle != "" {
+			if err := metrics.WriteExportBundle(data, opts.exportBundle, opts.source(), time.Now(), opts.exportIncludeEmpty); err != nil {
+				logrus.WithError(err).Fatal("Failed to write the export bundle")
+			}
+			logrus.Infof("Wrote export bundle %s", opts.exportBundle)
+			return
+		}
+
+		dir := opts.exportDir
+		if opts.exportPerSource {
+			dir = filepath.Join(opts.exportDir, metrics.ExportSubdirs([]string{opts.source()})[0])
+		}
+		if err := exportMetrics(data, dir, opts.source(), opts); err != nil {
+			logrus.WithError(err).Fatal("Failed to export metrics")
 		}
 		return
 	}
 
-	bqClient, err := bigquery.NewClient(ctx, opts.projectID)
+	if opts.datasetFromMetadata != "" {
+		dataset, err := metrics.DatasetFromMetadata(ctx, opts.bucket, opts.object, opts.datasetFromMetadata, opts.datasetID, opts.gcsClientOpts...)
+		if err != nil {
+			logrus.WithError(err).Fatal("Failed to resolve the dataset from object metadata")
+		}
+		if dataset == "" {
+			logrus.Fatalf("Object metadata key %s is absent and --bigquery-dataset is not set", opts.datasetFromMetadata)
+		}
+		logrus.Infof("Resolved target dataset %s", dataset)
+		opts.datasetID = dataset
+	}
+
+	var views []metrics.View
+	if opts.createViews != "" {
+		if views, err = metrics.ReadViewDir(opts.createViews); err != nil {
+			logrus.WithError(err).Fatal("Failed to read the view definitions")
+		}
+	}
+
+	var postLoadSQL string
+	if opts.postLoadSQL != "" {
+		query, err := metrics.ReadPostLoadQuery(opts.postLoadSQL)
+		if err != nil {
+			logrus.WithError(err).Fatal("Failed to read the post-load SQL")
+		}
+		if postLoadSQL, err = query.Render(opts.projectID, opts.datasetID, opts.tableSuffix); err != nil {
+			logrus.WithError(err).Fatal("Failed to read the post-load SQL")
+		}
+	}
+
+	var paths []string
+	if opts.pathsFile != "" {
+		if paths, err = metrics.ReadPathsFile(opts.pathsFile); err != nil {
+			logrus.WithError(err).Fatal("Failed to read the paths file")
+		}
+		if len(paths) == 0 {
+			logrus.Fatalf("%s lists no paths", opts.pathsFile)
+		}
+		listed := len(paths)
+		if paths, err = opts.resumePaths(paths); err != nil {
+			logrus.Fatal(err)
+		}
+		if skipped := listed - len(paths); skipped > 0 {
+			logrus.Infof("Resuming after %s, skipping %d of %d paths", opts.resumeAfter, skipped, listed)
+		}
+		paths = applyObjectRegex(opts, paths)
+	}
+
+	bqClient, err := bigquery.NewClient(ctx, opts.projectID, opts.bqClientOpts...)
 	if err != nil {
 		logrus.WithError(err).Fatal("Failed to create BigQuery client")
 	}
 	defer bqClient.Close()
+	bqClient.Location = opts.location
+
+	loaderOpts := []metrics.Option{
+		metrics.WithWriteAPI(opts.useWriteAPI),
+		metrics.WithWriteAPIClientOptions(opts.writeAPIClientOpts...),
+		metrics.WithColumnCase(opts.columnCase),
+		metrics.WithTruncateStrings(opts.truncateStrings),
+		metrics.WithNormalizeNamespaces(opts.normalizeNamespaces),
+		metrics.WithNonFiniteFloats(opts.nonFiniteFloats),
+		metrics.WithLeaseMetrics(opts.leaseMetrics),
+		metrics.WithSkipInvalidRows(opts.skipInvalidRows),
+		metrics.WithTableSuffix(opts.tableSuffix),
+		metrics.WithSampleRate(opts.sampleRate, opts.sampleSeed),
+		metrics.WithKMSKey(opts.kmsKey),
+	}
+	if len(opts.alsoWriteTo) > 0 {
+		loaderOpts = append(loaderOpts, metrics.WithAlsoWriteTo(opts.alsoWriteTo, opts.strictFanOut))
+	}
+	loaderOpts = append(loaderOpts, opts.projections()...)
+	if opts.fieldDescriptions {
+		loaderOpts = append(loaderOpts, metrics.WithFieldDescriptions())
+	}
+	if opts.preserveRaw {
+		loaderOpts = append(loaderOpts, metrics.WithPreserveRaw())
+	}
+	if len(opts.envelopeColumns) > 0 {
+		loaderOpts = append(loaderOpts, metrics.WithEnvelopeColumns(opts.envelopeColumns))
+	}
+	for table, partition := range opts.rangePartitions {
+		loaderOpts = append(loaderOpts, metrics.WithRangePartitioning(table, partition))
+	}
+	for table, fields := range opts.dedupKeys {
+		loaderOpts = append(loaderOpts, metrics.WithDedupKey(table, fields))
+	}
+	for table, field := range opts.insertIDColumns {
+		loaderOpts = append(loaderOpts, metrics.WithInsertIDColumn(table, field))
+	}
+	if opts.unifiedTable != "" {
+		loaderOpts = append(loaderOpts, metrics.WithUnifiedTable(opts.unifiedTable))
+	}
+	if opts.splitUnions {
+		loaderOpts = append(loaderOpts, metrics.WithSplitUnions())
+	}
+	for table, columns := range opts.upsertKeys {
+		loaderOpts = append(loaderOpts, metrics.WithUpsertKey(table, columns))
+	}
+	if opts.shards > 0 {
+		loaderOpts = append(loaderOpts, metrics.WithSharding(metrics.EventsTable, opts.shards, opts.shardBy))
+	}
+	if opts.createDataset {
+		metadata := &bigquery.DatasetMetadata{
+			Name:                       opts.datasetFriendlyName,
+			Description:                opts.datasetDescription,
+			Location:                   opts.location,
+			DefaultPartitionExpiration: opts.partitionExpiration,
+		}
+		if opts.kmsKey != "" {
+			metadata.DefaultEncryptionConfig = &bigquery.EncryptionConfig{KMSKeyName: opts.kmsKey}
+		}
+		loaderOpts = append(loaderOpts, metrics.WithDatasetCreation(metadata))
+	}
+	if opts.deadLetter != "" {
+		deadLetter, err := metrics.NewDeadLetterWriter(ctx, opts.deadLetter, opts.gcsClientOpts...)
+		if err != nil {
+			logrus.WithError(err).Fatal("Failed to set up the dead-letter location")
+		}
+		defer deadLetter.Close()
+		loaderOpts = append(loaderOpts, metrics.WithDeadLetter(deadLetter))
+	}
+	if opts.ingestionID {
+		id, err := ingestionID(ctx, opts)
+		if err != nil {
+			logrus.WithError(err).Fatal("Failed to derive the ingestion id")
+		}
+		logrus.Infof("Tagging rows with ingestion id %s", id)
+		loaderOpts = append(loaderOpts, metrics.WithIngestionID(id))
+	}
+	if opts.tagVersion {
+		loaderOpts = append(loaderOpts, metrics.WithLoaderVersion(version()))
+	}
+	if opts.checkTimestamps {
+		var minValid time.Time
+		if opts.minValidDate != "" {
+			minValid, _ = time.Parse(time.DateOnly, opts.minValidDate)
+		}
+		loaderOpts = append(loaderOpts, metrics.WithTimestampWindow(minValid, opts.maxFutureSkew, opts.strict))
+	}
+	if opts.defaultTimestampToNow {
+		loaderOpts = append(loaderOpts, metrics.WithDefaultTimestampToNow())
+	}
+	switch {
+	case opts.stopAfterErrors > 0:
+		loaderOpts = append(loaderOpts, metrics.WithCircuitBreaker(metrics.NewCircuitBreaker(opts.stopAfterErrors)))
+	case opts.continueOnError:
+		loaderOpts = append(loaderOpts, metrics.WithCircuitBreaker(metrics.NewCircuitBreaker(math.MaxInt)))
+	}
+	if opts.diffAgainst != "" {
+		previous, err := readPrevious(ctx, opts.diffAgainst, opts.gcsClientOpts...)
+		if err != nil {
+			logrus.WithError(err).Fatal("Failed to read the previous metrics file")
+		}
+		loaderOpts = append(loaderOpts, metrics.WithPrevious(previous))
+	}
+	var bench *metrics.Benchmark
+	if opts.benchmark {
+		bench = metrics.NewBenchmark()
+		loaderOpts = append(loaderOpts, metrics.WithBenchmark(bench))
+	}
+	if opts.schemaBaseline != "" {
+		baseline, err := metrics.LoadSchemaBaseline(opts.schemaBaseline)
+		if err != nil {
+			logrus.WithError(err).Fatal("Failed to load the schema baseline")
+		}
+		loaderOpts = append(loaderOpts, metrics.WithSchemaBaseline(baseline))
+	}
+	if len(opts.requiredTables) > 0 {
+		loaderOpts = append(loaderOpts, metrics.WithRequiredTables(opts.requiredTables))
+	}
+	if opts.preflightSchemaCheck {
+		loaderOpts = append(loaderOpts, metrics.WithPreflightSchemaCheck(true))
+	}
+	if opts.dropTablesFirst != "" {
+		loaderOpts = append(loaderOpts, metrics.WithDropTablesFirst(opts.dropTablesFirst))
+	}
+	if opts.maxTableInserts > 0 {
+		loaderOpts = append(loaderOpts, metrics.WithTableInsertLimiter(metrics.NewTableInsertLimiter(opts.maxTableInserts)))
+	}
+	if opts.quotaCooldown > 0 {
+		loaderOpts = append(loaderOpts, metrics.WithQuotaCooldown(metrics.NewQuotaCooldown(opts.quotaCooldown)))
+	}
+	if opts.waitForPermissions > 0 {
+		loaderOpts = append(loaderOpts, metrics.WithPermissionWait(opts.waitForPermissions))
+	}
+	var auditStream *metrics.AuditStream
+	if opts.audit {
+		auditStream = metrics.NewAuditStream()
+		loaderOpts = append(loaderOpts, metrics.WithAuditStream(auditStream))
+	}
+	loaderOpts = append(loaderOpts,
+		metrics.WithDecodeOptions(opts.decodeOptions()),
+		metrics.WithTableCreationGuard(metrics.NewTableCreationGuard()),
+	)
+	// newLoader is called once per source so that the source columns follow the path being loaded
+	newLoader := func(opts *options) *metrics.BigQueryLoader {
+		sourceOpts := slices.Clip(loaderOpts)
+		if opts.tagSource {
+			bucket, object := opts.sourceLocation()
+			sourceOpts = append(sourceOpts, metrics.WithSourceColumns(bucket, object))
+		}
+		return metrics.NewBigQueryLoader(ctx, bqClient, opts.projectID, opts.datasetID, sourceOpts...)
+	}
+	var result *metrics.LoadResult
+	dispatched := allPathsDispatched
+	loadStart := time.Now()
+	if opts.pathsFile != "" {
+		backfill := &metrics.BackfillResult{Skipped: []metrics.SkippedObject{}}
+		result, dispatched, err = loadPaths(ctx, opts, newLoader, paths, backfill)
+		if err := reportBackfill(os.Stdout, opts.output, backfill, len(paths)); err != nil {
+			logrus.WithError(err).Error("Failed to report the backfill outcome")
+		}
+	} else {
+		logrus.Infof("Loading metrics from %s into BigQuery dataset %s.%s", opts.source(), opts.projectID, opts.datasetID)
+		loader := newLoader(opts)
+		result, err = loadMetrics(ctx, opts, loader)
+		auditLoad(ctx, opts, loader, loadStart, result, err)
+	}
+	if auditStream != nil {
+		if err := auditStream.Close(); err != nil {
+			logrus.WithError(err).Error("Failed to close the audit stream")
+		}
+	}
+	if result != nil {
+		logSummary(result, opts.skipEmptyTablesInSummary)
+	}
+	if bench != nil {
+		if err := reportBenchmark(os.Stdout, opts.output, bench.Report()); err != nil {
+			logrus.WithError(err).Error("Failed to report the benchmark")
+		}
+	}
+	code := exitCode(result, err)
+	if opts.openMetricsObject != "" {
+		run := metrics.RunMetrics{Dataset: opts.datasetID, Start: loadStart, Duration: time.Since(loadStart), Success: code == exitSuccess, Result: result}
+		if err := metrics.AppendOpenMetrics(ctx, opts.openMetricsObject, run, opts.gcsClientOpts...); err != nil {
+			logrus.WithError(err).Error("Failed to append the run to the OpenMetrics object")
+		}
+	}
+	if code != exitFailure && len(views) > 0 {
+		if err := metrics.CreateViews(ctx, bqClient, opts.datasetID, views); err != nil {
+			logrus.WithError(err).Error("Failed to create views")
+			os.Exit(exitFailure)
+		}
+		logrus.Infof("Created or updated %d views", len(views))
+	}
+	if code == exitSuccess && dispatched == allPathsDispatched && postLoadSQL != "" {
+		result, err := metrics.RunPostLoadQuery(ctx, bqClient, opts.datasetID, postLoadSQL)
+		if err != nil {
+			logrus.WithError(err).Error("Failed to run the post-load SQL")
+			os.Exit(exitFailure)
+		}
+		logrus.WithField("job", result.JobID).Infof("Ran the post-load SQL, processing %d bytes", result.BytesProcessed)
+	}
+	if opts.successMarker != "" && dispatched == allPathsDispatched && (code == exitSuccess || code == exitPartial && opts.successMarkerOnPartial) {
+		source := opts.source()
+		if opts.pathsFile != "" {
+			source = opts.pathsFile
+		}
+		marker := &metrics.SuccessMarker{Source: source, Project: opts.projectID, Dataset: opts.datasetID, Partial: code == exitPartial, CompletedAt: time.Now().UTC(), Result: result}
+		if err := metrics.WriteSuccessMarker(ctx, opts.successMarker, marker, opts.gcsClientOpts...); err != nil {
+			logrus.WithError(err).Error("Failed to write the success marker")
+			os.Exit(exitFailure)
+		}
+		logrus.Infof("Wrote the success marker %s", opts.successMarker)
+	}
+	if code != exitFailure && dispatched != allPathsDispatched {
+		checkpoint := opts.resumeAfter
+		if dispatched > 0 {
+			checkpoint = paths[dispatched-1]
+		}
+		if opts.checkpointFile != "" {
+			if err := os.WriteFile(opts.checkpointFile, []byte(checkpoint+"\n"), 0644); err != nil {
+				logrus.WithError(err).Error("Failed to write the checkpoint file")
+			}
+		}
+		if checkpoint == "" {
+			logrus.Warnf("Time budget exhausted before any path was loaded, rerun to start over")
+		} else {
+			logrus.Warnf("Time budget exhausted after %d of %d paths, resume with --resume-after=%s", dispatched, len(paths), checkpoint)
+		}
+		os.Exit(exitBudgetExhausted)
+	}
+	switch code {
+	case exitSuccess:
+		logrus.Info("Successfully loaded metrics into BigQuery")
+	case exitPartial:
+		failed := result.FailedTables()
+		logrus.WithError(err).Errorf("Partially loaded metrics: %d of %d tables failed: %s", len(failed), len(result.Tables), strings.Join(failed, ", "))
+	default:
+		logrus.WithError(err).Error("Failed to load metrics")
+	}
+	os.Exit(code)
+}
+
+// applyObjectRegex applies --object-regex to the listed paths, reporting how many it kept, and exits when it
+// matched none of them
+func applyObjectRegex(opts *options, paths []string) []string {
+	if opts.objectRegex == "" || len(paths) == 0 {
+		return paths
+	}
+	matched, skipped := opts.filterPaths(paths)
+	logrus.Infof("--object-regex matched %d paths, skipping %d", len(matched), skipped)
+	if len(matched) == 0 {
+		logrus.Fatalf("No path of %s matches --object-regex %s", opts.pathsFile, opts.objectRegex)
+	}
+	return matched
+}
+
+// exitCode maps the outcome of a load to the process exit code. A run is only a partial success when it
+// went through every table, at least one table loaded, and the failures were confined to individual tables.
+func exitCode(result *metrics.LoadResult, err error) int {
+	if err == nil {
+		return exitSuccess
+	}
+	if result == nil || errors.Is(err, metrics.ErrCircuitBreakerTripped) {
+		return exitFailure
+	}
+	if len(result.FailedTables()) == 0 || result.TablesWithData() == 0 {
+		return exitFailure
+	}
+	return exitPartial
+}
 
-	loader := metrics.NewBigQueryLoader(ctx, bqClient, opts.projectID, opts.datasetID)
-	logrus.Infof("Loading metrics from %s into BigQuery dataset %s.%s", opts.gcsPath, opts.projectID, opts.datasetID)
-	if err := loader.LoadFromGCS(opts.bucket, opts.object); err != nil {
-		logrus.WithError(err).Fatal("Failed to load metrics from GCS")
+// auditLoad appends the outcome of the load of the source of opts to the audit table with --audit. Failing to write
+// it is logged, but does not fail the load.
+func auditLoad(ctx context.Context, opts *options, loader *metrics.BigQueryLoader, start time.Time, result *metrics.LoadResult, err error) {
+	if !opts.audit {
+		return
+	}
+	record := &metrics.AuditRecord{Source: opts.source(), StartedAt: start, Duration: time.Since(start), Result: result, Err: err}
+	// without the version of the source, the load_id of the record only identifies the source
+	generation, genErr := sourceGeneration(ctx, opts)
+	if genErr != nil {
+		logrus.WithError(genErr).Warnf("Failed to get the version of %s for its audit record", opts.source())
+	}
+	record.Generation = generation
+	if err := loader.WriteAuditRecord(ctx, opts.auditTable, record); err != nil {
+		logrus.WithError(err).Errorf("Failed to audit the load of %s", opts.source())
 	}
-	logrus.Info("Successfully loaded metrics into BigQuery")
 }
 
-func parseGCSPath(gcsPath string) (bucket, object string, err error) {
-	u, err := url.Parse(gcsPath)
+// logSummary logs the outcome of every table, optionally omitting the ones that had no rows
+func logSummary(result *metrics.LoadResult, skipEmpty bool) {
+	if result.BadRecords > 0 {
+		logrus.Warnf("%d records failed to decode and were skipped", result.BadRecords)
+	}
+	for _, t := range result.Tables {
+		if t.Error != "" {
+			logrus.Errorf("Table %s: failed: %s", t.Table, t.Error)
+			continue
+		}
+		if t.Unchanged {
+			logrus.Infof("Table %s: unchanged since the previous file, skipped", t.Table)
+			continue
+		}
+		if t.SuspectTimestamps > 0 {
+			logrus.Warnf("Table %s: %d rows with timestamps outside the valid window", t.Table, t.SuspectTimestamps)
+		}
+		if t.SampledFrom > 0 {
+			logrus.Infof("Table %s: sampled %d of %d rows", t.Table, t.RowsInserted+t.InvalidRows+t.DroppedRows, t.SampledFrom)
+		}
+		if t.Skipped {
+			if !skipEmpty && t.SuspectTimestamps == 0 {
+				logrus.Infof("Table %s: no rows", t.Table)
+			}
+			continue
+		}
+		if t.DroppedRows > 0 {
+			logrus.Warnf("Table %s: %d rows with NaN or infinite floats dropped", t.Table, t.DroppedRows)
+		}
+		if t.InvalidRows > 0 {
+			logrus.Warnf("Table %s: %d rows inserted, %d invalid rows skipped", t.Table, t.RowsInserted, t.InvalidRows)
+			continue
+		}
+		logrus.Infof("Table %s: %d rows inserted", t.Table, t.RowsInserted)
+	}
+}
+
+// ingestionID identifies the version of the source being loaded: the generation of a GCS object,
+// or the modification time of a local file
+func ingestionID(ctx context.Context, opts *options) (string, error) {
+	generation, err := sourceGeneration(ctx, opts)
 	if err != nil {
-		return "", "", fmt.Errorf("invalid GCS path: %w", err)
+		return "", err
+	}
+	if opts.filePath != "" {
+		path, err := filepath.Abs(opts.filePath)
+		if err != nil {
+			return "", err
+		}
+		return metrics.IngestionID(path, generation), nil
 	}
-	if u.Scheme != "gs" {
-		return "", "", fmt.Errorf("path must use gs:// scheme, got %s://", u.Scheme)
+	return metrics.IngestionID(opts.gcsPath, generation), nil
+}
+
+// sourceGeneration returns the version of the source being loaded: the generation of a GCS object, or the
+// modification time of a local file. Sources without one, such as stdin or a URL, return zero.
+func sourceGeneration(ctx context.Context, opts *options) (int64, error) {
+	switch {
+	case opts.filePath == "-":
+		return 0, nil
+	case opts.filePath != "":
+		info, err := os.Stat(opts.filePath)
+		if err != nil {
+			return 0, err
+		}
+		return info.ModTime().UnixNano(), nil
+	case opts.gcsPath != "":
+		return metrics.GCSObjectGeneration(ctx, opts.bucket, opts.object, opts.gcsClientOpts...)
+	default:
+		return 0, nil
 	}
-	if u.Host == "" {
-		return "", "", fmt.Errorf("bucket name is required")
+}
+
+// reportCounts writes the outcome of --count to w, in load order for the text format
+func reportCounts(w io.Writer, output string, counts map[string]int) error {
+	if output == metrics.OutputJSON {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(counts)
+	}
+	for _, table := range metrics.Tables() {
+		if _, err := fmt.Fprintf(w, "%s: %d\n", table, counts[table]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reportBackfill writes the outcome of a --paths-file run over total paths to w
+func reportBackfill(w io.Writer, output string, backfill *metrics.BackfillResult, total int) error {
+	if output == metrics.OutputJSON {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(backfill)
 	}
-	bucket = u.Host
-	object = strings.TrimPrefix(u.Path, "/")
-	if object == "" {
-		return "", "", fmt.Errorf("object path is required")
+	if _, err := fmt.Fprintf(w, "loaded %d of %d files (%d rows), skipped %d\n", backfill.Loaded, total, backfill.RowsInserted, len(backfill.Skipped)); err != nil {
+		return err
+	}
+	counts := backfill.SkipCounts()
+	for _, reason := range []metrics.SkipReason{metrics.SkipEmpty, metrics.SkipDecodeError, metrics.SkipLoadError} {
+		if counts[reason] == 0 {
+			continue
+		}
+		if _, err := fmt.Fprintf(w, "%s: %d\n", reason, counts[reason]); err != nil {
+			return err
+		}
+	}
+	for _, skipped := range backfill.Skipped {
+		if _, err := fmt.Fprintf(w, "  %s: %s: %s\n", skipped.Path, skipped.Reason, skipped.Message); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reportBenchmark writes the throughput measured by --benchmark to w
+func reportBenchmark(w io.Writer, output string, report metrics.BenchmarkReport) error {
+	if output == metrics.OutputJSON {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	}
+	if _, err := fmt.Fprintf(w, "%d rows in %s (%.1f rows/s), %d batches, batch p50 %s p95 %s, peak heap %.1f MiB\n",
+		report.Rows, report.Duration.Round(time.Millisecond), report.RowsPerSecond, report.Batches,
+		report.BatchP50.Round(time.Millisecond), report.BatchP95.Round(time.Millisecond), float64(report.PeakHeapBytes)/(1<<20)); err != nil {
+		return err
+	}
+	for _, t := range report.Tables {
+		if _, err := fmt.Fprintf(w, "  %s: %d rows in %d batches, %.1f rows/s, batch p50 %s p95 %s\n",
+			t.Table, t.Rows, t.Batches, t.RowsPerSecond, t.BatchP50.Round(time.Millisecond), t.BatchP95.Round(time.Millisecond)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reportComparison writes the outcome of --compare to w
+func reportComparison(w io.Writer, output string, c *metrics.TableComparison) error {
+	if output == metrics.OutputJSON {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(c)
+	}
+	if _, err := fmt.Fprintf(w, "%s: %d rows\n%s: %d rows (%+d)\n", c.TableA, c.RowsA, c.TableB, c.RowsB, c.RowDelta); err != nil {
+		return err
+	}
+	if len(c.OnlyInA) > 0 {
+		if _, err := fmt.Fprintf(w, "columns only in %s: %s\n", c.TableA, strings.Join(c.OnlyInA, ", ")); err != nil {
+			return err
+		}
+	}
+	if len(c.OnlyInB) > 0 {
+		if _, err := fmt.Fprintf(w, "columns only in %s: %s\n", c.TableB, strings.Join(c.OnlyInB, ", ")); err != nil {
+			return err
+		}
+	}
+	if c.Rows == nil {
+		return nil
+	}
+	if _, err := fmt.Fprintf(w, "on %s: %d added, %d removed, %d changed, %d unchanged\n",
+		strings.Join(c.Rows.Key, ", "), c.Rows.Added, c.Rows.Removed, c.Rows.Changed, c.Rows.Unchanged); err != nil {
+		return err
+	}
+	for _, samples := range []struct {
+		kind string
+		keys []string
+	}{{"added", c.Rows.AddedSamples}, {"removed", c.Rows.RemovedSamples}, {"changed", c.Rows.ChangedSamples}} {
+		for _, key := range samples.keys {
+			if _, err := fmt.Fprintf(w, "  %s: %s\n", samples.kind, key); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// reportChecks writes the outcome of --validate-only to w
+func reportChecks(w io.Writer, output string, checks []metrics.TableCheck) error {
+	if output == metrics.OutputJSON {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(map[string][]metrics.TableCheck{"tables": checks})
+	}
+	for _, check := range checks {
+		status := "ok"
+		if check.Error != "" {
+			status = "error: " + check.Error
+		}
+		if _, err := fmt.Fprintf(w, "%s: %d rows, %s\n", check.Table, check.Rows, status); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// source returns a human readable description of where metrics are read from
+func (o *options) source() string {
+	if o.filePath != "" {
+		return o.filePath
+	}
+	if o.fromExportDir != "" {
+		return o.fromExportDir
+	}
+	if o.sourceURL != "" {
+		return metrics.RedactURL(o.sourceURL)
+	}
+	return o.gcsPath
+}
+
+// knownTable reports whether table is one of the tables this run writes to
+func (o *options) knownTable(table string) bool {
+	if o.unifiedTable != "" {
+		return table == o.unifiedTable
+	}
+	if o.splitUnions {
+		switch table {
+		case metrics.LeaseAcquisitionsTable, metrics.LeaseReleasesTable, metrics.ImageStreamsTable, metrics.TagImportsTable:
+			return true
+		case metrics.LeasesTable, metrics.ImagesTable:
+			return false
+		}
+	}
+	return slices.Contains(metrics.Tables(), table)
+}
+
+// sourceLocation returns the bucket and object the metrics are read from. Local files have no bucket and are
+// named by their path.
+func (o *options) sourceLocation() (bucket, object string) {
+	if o.filePath != "" {
+		return "", o.filePath
+	}
+	if o.sourceURL != "" {
+		return "", metrics.RedactURL(o.sourceURL)
+	}
+	return o.bucket, o.object
+}
+
+// isArchive reports whether the source is a .tar.gz archive of metrics files
+func (o *options) isArchive() bool {
+	return metrics.IsArchive(o.source())
+}
+
+// projections returns the --columns projections and --field-type overrides as loader options. They must come
+// after WithColumnCase so that the requested names are matched against the final column names.
+func (o *options) projections() []metrics.Option {
+	var projections []metrics.Option
+	for table, columns := range o.columns {
+		projections = append(projections, metrics.WithColumns(table, columns))
+	}
+	for table, paths := range o.fieldTypes {
+		for path, fieldType := range paths {
+			projections = append(projections, metrics.WithFieldType(table, path, fieldType))
+		}
+	}
+	return projections
+}
+
+// schemaOptions returns the loader options that shape table schemas, for the modes that check or write schemas
+// without loading
+func (o *options) schemaOptions() []metrics.Option {
+	schemaOpts := append([]metrics.Option{metrics.WithColumnCase(o.columnCase)}, o.projections()...)
+	if o.fieldDescriptions {
+		schemaOpts = append(schemaOpts, metrics.WithFieldDescriptions())
+	}
+	if o.preserveRaw {
+		schemaOpts = append(schemaOpts, metrics.WithPreserveRaw())
+	}
+	if len(o.envelopeColumns) > 0 {
+		schemaOpts = append(schemaOpts, metrics.WithEnvelopeColumns(o.envelopeColumns))
+	}
+	schemaOpts = append(schemaOpts, metrics.WithNonFiniteFloats(o.nonFiniteFloats), metrics.WithLeaseMetrics(o.leaseMetrics))
+	for table, partition := range o.rangePartitions {
+		schemaOpts = append(schemaOpts, metrics.WithRangePartitioning(table, partition))
+	}
+	if o.splitUnions {
+		schemaOpts = append(schemaOpts, metrics.WithSplitUnions())
+	}
+	for table, fields := range o.dedupKeys {
+		schemaOpts = append(schemaOpts, metrics.WithDedupKey(table, fields))
+	}
+	for table, field := range o.insertIDColumns {
+		schemaOpts = append(schemaOpts, metrics.WithInsertIDColumn(table, field))
+	}
+	return schemaOpts
+}
+
+func (o *options) decodeOptions() metrics.DecodeOptions {
+	decodeOpts := metrics.DecodeOptions{MaxRowsPerTable: o.maxRowsPerTable, PreserveRaw: o.preserveRaw, LenientDecode: o.lenientDecode, EnvelopePath: o.envelopePath, AllowNonFiniteFloats: o.nonFiniteFloats != ""}
+	for _, column := range o.envelopeColumns {
+		decodeOpts.EnvelopeFields = append(decodeOpts.EnvelopeFields, column.Field)
+	}
+	return decodeOpts
+}
+
+// openSource opens the raw metrics stream from whichever source was configured
+func openSource(ctx context.Context, opts *options) (io.ReadCloser, error) {
+	if opts.filePath != "" {
+		return metrics.OpenFile(opts.filePath)
+	}
+	if opts.sourceURL != "" {
+		return metrics.OpenURL(ctx, opts.httpClient, opts.sourceURL)
+	}
+	download := metrics.DownloadOptions{Concurrency: opts.downloadConcurrency, VerifyCRC32C: opts.verifyCRC32C}
+	return metrics.OpenGCSObjectWithOptions(ctx, opts.bucket, opts.object, download, opts.gcsClientOpts...)
+}
+
+// exportMetrics writes data to dir as --export does, with the empty tables and the manifest when requested
+func exportMetrics(data *metrics.MetricsData, dir, source string, opts *options) error {
+	if opts.exportFormat == metrics.ExportFormatAvroBQ {
+		return metrics.ExportAvro(data, dir, opts.exportIncludeEmpty)
+	}
+	if err := metrics.ExportMetricsData(data, dir); err != nil {
+		return err
+	}
+	if opts.exportIncludeEmpty {
+		if err := metrics.ExportEmptyTables(data, dir); err != nil {
+			return fmt.Errorf("failed to export empty tables: %w", err)
+		}
+	}
+	if opts.exportManifest {
+		if err := metrics.WriteExportManifest(data, dir, source, time.Now(), opts.exportIncludeEmpty); err != nil {
+			return fmt.Errorf("failed to write the export manifest: %w", err)
+		}
+	}
+	return nil
+}
+
+// exportPath reads a single path of --paths-file and exports it to dir
+func exportPath(ctx context.Context, opts *options, path, dir string) error {
+	pathOpts, err := opts.forPath(path)
+	if err != nil {
+		return err
+	}
+	data, err := readMetrics(ctx, pathOpts)
+	if err != nil {
+		return fmt.Errorf("failed to read metrics: %w", err)
+	}
+	if bad := len(data.BadRecords()); bad > 0 {
+		logrus.Warnf("Skipped %d records of %s that failed to decode", bad, path)
+	}
+	return exportMetrics(data, dir, path, opts)
+}
+
+// readMetrics decodes the whole metrics source and applies the requested pre-load rewrites
+func readMetrics(ctx context.Context, opts *options) (*metrics.MetricsData, error) {
+	logger := logrus.WithField("source", opts.source())
+	data, err := metrics.RetryDecode(ctx, opts.decodeRetries, opts.decodeRetryDelay, logger, func() (data *metrics.MetricsData, err error) {
+		err = metrics.RetryPermissionDenied(ctx, opts.waitForPermissions, logger, func() (err error) {
+			data, err = decodeSource(ctx, opts)
+			return err
+		})
+		return data, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if opts.mergeMapContext {
+		before := len(data.Images)
+		metrics.MergeImageContext(data)
+		logrus.Infof("Merged %d image events into %d rows by full_name", before, len(data.Images))
+	}
+	return data, nil
+}
+
+// decodeSource decodes the whole metrics source using the configured input format
+func decodeSource(ctx context.Context, opts *options) (*metrics.MetricsData, error) {
+	if opts.fromExportDir != "" {
+		return metrics.ReadExportDir(opts.fromExportDir)
+	}
+
+	reader, err := openSource(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	decode := func(r io.Reader) (*metrics.MetricsData, error) {
+		return metrics.DecodeMetricsDataWithOptions(r, opts.decodeOptions())
+	}
+	switch {
+	case opts.isArchive():
+		decode = func(r io.Reader) (*metrics.MetricsData, error) {
+			return metrics.DecodeArchive(r, opts.decodeOptions())
+		}
+	case opts.inputFormat == metrics.InputFormatNDJSON:
+		decode = func(r io.Reader) (*metrics.MetricsData, error) {
+			return metrics.DecodeNDJSONWithOptions(r, opts.decodeOptions())
+		}
+	}
+	data, err := decode(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", opts.source(), err)
+	}
+	return data, nil
+}
+
+// readPrevious decodes the file passed to --diff-against. A missing previous file is not an error:
+// it returns nil so that every table is loaded.
+func readPrevious(ctx context.Context, path string, clientOpts ...option.ClientOption) (*metrics.MetricsData, error) {
+	var previous *metrics.MetricsData
+	var err error
+	if strings.HasPrefix(path, "gs://") {
+		bucket, object, parseErr := metrics.ParseGCSPath(path)
+		if parseErr != nil {
+			return nil, parseErr
+		}
+		previous, err = metrics.ReadMetricsFromGCS(ctx, bucket, object, clientOpts...)
+	} else {
+		previous, err = metrics.ReadMetricsFromFile(path)
+	}
+	if errors.Is(err, storage.ErrObjectNotExist) || errors.Is(err, fs.ErrNotExist) {
+		logrus.Infof("Previous metrics file %s does not exist, loading every table", path)
+		return nil, nil
+	}
+	return previous, err
+}
+
+// loadMetrics loads the configured source into BigQuery, streaming NDJSON input in bounded batches and
+// archives one member file at a time (unless image events have to be merged across the whole archive)
+func loadMetrics(ctx context.Context, opts *options, loader *metrics.BigQueryLoader) (*metrics.LoadResult, error) {
+	// Archives are loaded member by member while streaming; a retry would load the earlier members twice
+	if opts.isArchive() && !opts.mergeMapContext && opts.decodeRetries == 0 {
+		reader, err := openSource(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+		return loader.LoadArchive(reader)
+	}
+	if opts.incrementalBatch > 0 {
+		reader, err := openSource(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+		result, err := loader.LoadIncrementalContext(ctx, reader, opts.incrementalBatch)
+		if opts.skipEmptyObjects && errors.Is(err, metrics.ErrEmptySource) {
+			logrus.Warnf("Skipping %s: the metrics file is empty", opts.source())
+			return &metrics.LoadResult{}, nil
+		}
+		return result, err
+	}
+	if opts.inputFormat != metrics.InputFormatNDJSON {
+		data, err := readMetrics(ctx, opts)
+		if opts.skipEmptyObjects && errors.Is(err, metrics.ErrEmptySource) {
+			logrus.Warnf("Skipping %s: the metrics file is empty", opts.source())
+			return &metrics.LoadResult{}, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		return loader.LoadMetricsDataWithResult(data)
+	}
+
+	reader, err := openSource(ctx, opts)
+	if err != nil {
+		return nil, err
 	}
-	return bucket, object, nil
+	defer reader.Close()
+	return loader.LoadNDJSON(reader)
 }
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/droslean/ci-metrics-bigquery/pkg/metrics"
+)
+
+// decodedSource is one path of --paths-file after the decode stage
+type decodedSource struct {
+	path    string
+	opts    *options
+	data    *metrics.MetricsData
+	err     error
+	elapsed time.Duration
+}
+
+// allPathsDispatched is returned by loadPaths when the run was not cut short by --max-runtime
+const allPathsDispatched = -1
+
+// loadPaths loads every path listed in the paths file, merging their outcomes. Without --continue-on-error or
+// --stop-after-errors it stops at the first path that fails. With --decode-workers above one the paths are
+// downloaded and decoded concurrently while earlier ones are loaded, and with --load-workers above one several
+// decoded paths are loaded at once.
+// Once the --max-runtime budget is used up no further path is started; the ones in flight are still loaded and
+// loadPaths returns how many paths were dispatched, which are always the first ones of the list.
+// The outcome of every path is recorded in backfill.
+func loadPaths(ctx context.Context, opts *options, newLoader func(*options) *metrics.BigQueryLoader, paths []string, backfill *metrics.BackfillResult) (*metrics.LoadResult, int, error) {
+	if opts.decodeWorkers > 1 || opts.loadWorkers > 1 {
+		return loadPathsPipelined(ctx, opts, newLoader, paths, backfill)
+	}
+
+	result := &metrics.LoadResult{}
+	var failures []error
+	for i, path := range paths {
+		if opts.budgetExhausted() {
+			return result, i, errors.Join(failures...)
+		}
+		pathOpts, err := opts.forPath(path)
+		if err != nil {
+			return result, allPathsDispatched, err
+		}
+		// empty objects are skipped here rather than by loadMetrics so that they are recorded in backfill
+		pathOpts.skipEmptyObjects = false
+		logrus.Infof("Loading %d/%d: %s into BigQuery dataset %s.%s", i+1, len(paths), path, opts.projectID, opts.datasetID)
+		loader, loadStart := newLoader(pathOpts), time.Now()
+		pathResult, err := loadMetrics(ctx, pathOpts, loader)
+		auditLoad(ctx, pathOpts, loader, loadStart, pathResult, err)
+		result.Merge(pathResult)
+		if err = opts.recordPath(backfill, path, pathResult, err); err != nil {
+			err = fmt.Errorf("%s: %w", path, err)
+			if opts.abortsOn(err) {
+				return result, allPathsDispatched, errors.Join(append(failures, err)...)
+			}
+			logrus.WithError(err).Errorf("Failed to load %d/%d, continuing with the remaining paths", i+1, len(paths))
+			failures = append(failures, err)
+		}
+	}
+	return result, allPathsDispatched, errors.Join(failures...)
+}
+
+// loadPathsPipelined decodes paths on --decode-workers goroutines and loads them on --load-workers goroutines in
+// the order they finish. The decoded files wait in a channel of the decode workers' capacity, so at most twice the
+// number of decode workers plus the ones being loaded are held in memory. A failure that aborts the run cancels
+// the remaining downloads and loads.
+func loadPathsPipelined(ctx context.Context, opts *options, newLoader func(*options) *metrics.BigQueryLoader, paths []string, backfill *metrics.BackfillResult) (*metrics.LoadResult, int, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// dispatched is only read once pending is closed and every decoded file was consumed
+	dispatched := allPathsDispatched
+	pending := make(chan string)
+	go func() {
+		defer close(pending)
+		for i, path := range paths {
+			if opts.budgetExhausted() {
+				dispatched = i
+				return
+			}
+			select {
+			case pending <- path:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	decoded := make(chan decodedSource, opts.decodeWorkers)
+	var workers sync.WaitGroup
+	for range opts.decodeWorkers {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for path := range pending {
+				select {
+				case decoded <- decodePath(ctx, opts, path):
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		workers.Wait()
+		close(decoded)
+	}()
+
+	started := time.Now()
+	result := &metrics.LoadResult{}
+	// lock guards the outcome of the run, which every load worker updates
+	var lock sync.Mutex
+	var failures []error
+	var abort error
+	var decodeTime, loadTime time.Duration
+	done := 0
+	var loaders sync.WaitGroup
+	for range opts.loadWorkers {
+		loaders.Add(1)
+		go func() {
+			defer loaders.Done()
+			for source := range decoded {
+				lock.Lock()
+				if abort != nil {
+					lock.Unlock()
+					continue
+				}
+				done++
+				current := done
+				decodeTime += source.elapsed
+				lock.Unlock()
+
+				err := source.err
+				var pathResult *metrics.LoadResult
+				var elapsed time.Duration
+				if err == nil {
+					logrus.Infof("Loading %d/%d: %s into BigQuery dataset %s.%s", current, len(paths), source.path, opts.projectID, opts.datasetID)
+					loader, loadStart := newLoader(source.opts), time.Now()
+					pathResult, err = loader.LoadMetricsDataContext(ctx, source.data)
+					elapsed = time.Since(loadStart)
+					auditLoad(ctx, source.opts, loader, loadStart, pathResult, err)
+				}
+
+				lock.Lock()
+				loadTime += elapsed
+				result.Merge(pathResult)
+				if err = opts.recordPath(backfill, source.path, pathResult, err); err != nil {
+					err = fmt.Errorf("%s: %w", source.path, err)
+					if opts.abortsOn(err) {
+						if abort == nil {
+							abort = err
+						}
+						cancel()
+					} else {
+						logrus.WithError(err).Errorf("Failed to load %d/%d, continuing with the remaining paths", current, len(paths))
+						failures = append(failures, err)
+					}
+				}
+				lock.Unlock()
+			}
+		}()
+	}
+	loaders.Wait()
+	if abort != nil {
+		return result, allPathsDispatched, errors.Join(append(failures, abort)...)
+	}
+	logrus.Infof("Loaded %d files in %s: %s spent downloading and decoding across %d workers, %s inserting across %d workers",
+		done, time.Since(started).Round(time.Millisecond), decodeTime.Round(time.Millisecond), opts.decodeWorkers, loadTime.Round(time.Millisecond), opts.loadWorkers)
+	return result, dispatched, errors.Join(failures...)
+}
+
+// decodePath downloads and decodes a single path of the paths file
+func decodePath(ctx context.Context, opts *options, path string) decodedSource {
+	start := time.Now()
+	source := decodedSource{path: path}
+	source.opts, source.err = opts.forPath(path)
+	if source.err == nil {
+		source.data, source.err = readMetrics(ctx, source.opts)
+	}
+	source.elapsed = time.Since(start)
+	return source
+}
+
+// recordPath records the outcome of one path in backfill and returns the error it failed with, if any. Empty
+// objects skipped with --skip-empty-objects are recorded as skipped but do not count as failures.
+func (o *options) recordPath(backfill *metrics.BackfillResult, path string, result *metrics.LoadResult, err error) error {
+	switch {
+	case err == nil:
+		backfill.AddLoaded(result)
+		return nil
+	case o.skipEmptyObjects && errors.Is(err, metrics.ErrEmptySource):
+		logrus.Warnf("Skipping %s: the metrics file is empty", path)
+		backfill.AddSkipped(path, metrics.SkipEmpty, err)
+		return nil
+	default:
+		backfill.AddSkipped(path, metrics.SkipReasonOf(err), err)
+		return err
+	}
+}
+
+// forPath returns a copy of the options that reads from one path of the paths file
+func (o *options) forPath(path string) (*options, error) {
+	pathOpts := *o
+	pathOpts.gcsPath = path
+	if err := pathOpts.complete(); err != nil {
+		return nil, err
+	}
+	return &pathOpts, nil
+}
+
+// budgetExhausted reports whether the --max-runtime budget is used up
+func (o *options) budgetExhausted() bool {
+	return !o.deadline.IsZero() && !time.Now().Before(o.deadline)
+}
+
+// resumePaths drops the paths up to and including --resume-after
+func (o *options) resumePaths(paths []string) ([]string, error) {
+	if o.resumeAfter == "" {
+		return paths, nil
+	}
+	for i, path := range paths {
+		if path == o.resumeAfter {
+			return paths[i+1:], nil
+		}
+	}
+	return nil, fmt.Errorf("--resume-after path %s is not listed in %s", o.resumeAfter, o.pathsFile)
+}
+
+// filterPaths keeps the paths whose object name matches --object-regex and that name a metrics file, and returns
+// how many it dropped
+func (o *options) filterPaths(paths []string) ([]string, int) {
+	if o.objectRegex == "" {
+		return paths, 0
+	}
+	pattern := regexp.MustCompile(o.objectRegex)
+	var matched []string
+	for _, path := range paths {
+		// listed paths were validated by ReadPathsFile
+		_, object, _ := metrics.ParseGCSPath(path)
+		if pattern.MatchString(object) && metrics.IsMetricsFile(object) {
+			matched = append(matched, path)
+		}
+	}
+	return matched, len(paths) - len(matched)
+}
+
+// abortsOn reports whether a failed path ends a --paths-file run instead of moving on to the next path
+func (o *options) abortsOn(err error) bool {
+	return errors.Is(err, metrics.ErrCircuitBreakerTripped) || (o.stopAfterErrors == 0 && !o.continueOnError)
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"google.golang.org/api/option"
+	htransport "google.golang.org/api/transport/http"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// baseTransport returns the transport GCS and BigQuery requests are sent through when --http-proxy or --ca-cert
+// is set, and nil to keep the client libraries' defaults (which already honor HTTPS_PROXY)
+func baseTransport(proxy, caCert string) (*http.Transport, error) {
+	if proxy == "" && caCert == "" {
+		return nil, nil
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if proxy != "" {
+		proxyURL, err := url.Parse(proxy)
+		if err != nil || proxyURL.Scheme == "" || proxyURL.Host == "" {
+			return nil, fmt.Errorf("invalid proxy URL %q", proxy)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	if caCert != "" {
+		pem, err := os.ReadFile(caCert)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no PEM certificates found in %s", caCert)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	}
+	return transport, nil
+}
+
+// clientOptions authenticates requests sent through base for the given OAuth scopes. A nil base yields no options.
+func clientOptions(ctx context.Context, base http.RoundTripper, scopes ...string) ([]option.ClientOption, error) {
+	if base == nil {
+		return nil, nil
+	}
+	authenticated, err := htransport.NewTransport(ctx, base, option.WithScopes(scopes...))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create an authenticated transport: %w", err)
+	}
+	return []option.ClientOption{option.WithHTTPClient(&http.Client{Transport: authenticated})}, nil
+}
+
+// emulatorOptions points a client at a local emulator listening on host (host:port) and disables authentication,
+// which emulators do not check. path is the API root the emulator serves, e.g. "/" for bigquery-emulator and
+// "/storage/v1/" for fake-gcs-server.
+func emulatorOptions(host, path string) []option.ClientOption {
+	return []option.ClientOption{
+		option.WithEndpoint("http://" + host + path),
+		option.WithoutAuthentication(),
+	}
+}
+
+// grpcClientOptions carries the proxy and CA bundle of base to the Storage Write API client, which talks gRPC and
+// so cannot be given an http.Client: the proxy is reached through an HTTP CONNECT tunnel, and the CA bundle is
+// trusted by the TLS credentials of the connection. A nil base yields no options.
+func grpcClientOptions(base *http.Transport) []option.ClientOption {
+	if base == nil {
+		return nil
+	}
+	var clientOpts []option.ClientOption
+	if base.Proxy != nil {
+		clientOpts = append(clientOpts, option.WithGRPCDialOption(grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+			return dialThroughProxy(ctx, base, addr)
+		})))
+	}
+	if base.TLSClientConfig != nil {
+		clientOpts = append(clientOpts, option.WithGRPCDialOption(grpc.WithTransportCredentials(credentials.NewTLS(base.TLSClientConfig.Clone()))))
+	}
+	return clientOpts
+}
+
+// dialThroughProxy opens a connection to addr (host:port) through the proxy base sends requests for it to, or
+// directly when base sends them to none
+func dialThroughProxy(ctx context.Context, base *http.Transport, addr string) (net.Conn, error) {
+	proxyURL, err := base.Proxy(&http.Request{URL: &url.URL{Scheme: "https", Host: addr}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve the proxy for %s: %w", addr, err)
+	}
+	var dialer net.Dialer
+	if proxyURL == nil {
+		return dialer.DialContext(ctx, "tcp", addr)
+	}
+	proxyAddr := proxyURL.Host
+	if proxyURL.Port() == "" {
+		port := "80"
+		if proxyURL.Scheme == "https" {
+			port = "443"
+		}
+		proxyAddr = net.JoinHostPort(proxyURL.Hostname(), port)
+	}
+	conn, err := dialer.DialContext(ctx, "tcp", proxyAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to the proxy: %w", err)
+	}
+	if proxyURL.Scheme == "https" {
+		conn = tls.Client(conn, &tls.Config{ServerName: proxyURL.Hostname(), MinVersion: tls.VersionTLS12})
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+
+	connect := &http.Request{Method: http.MethodConnect, URL: &url.URL{Opaque: addr}, Host: addr, Header: http.Header{}}
+	if user := proxyURL.User; user != nil {
+		password, _ := user.Password()
+		connect.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(user.Username()+":"+password)))
+	}
+	if err := connect.Write(conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to send CONNECT to the proxy: %w", err)
+	}
+	reader := bufio.NewReader(conn)
+	response, err := http.ReadResponse(reader, connect)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to read the CONNECT response of the proxy: %w", err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("proxy refused to CONNECT to %s: %s", addr, response.Status)
+	}
+	return &tunnelConn{Conn: conn, reader: reader}, nil
+}
+
+// tunnelConn reads the bytes the proxy sent after its CONNECT response before those still on the connection
+type tunnelConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *tunnelConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
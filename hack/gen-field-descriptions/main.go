@@ -0,0 +1,197 @@
+// gen-field-descriptions extracts the doc and line comments of struct fields from Go packages into a map of
+// BigQuery column descriptions, keyed by the import path and name of the struct type and then by field name.
+// Only the root types and the struct types their fields reach within the given packages are included.
+//
+// Usage: gen-field-descriptions -output <file> -package <name> -roots <import path>.<type>,... <import path>...
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"os"
+	"os/exec"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+func main() {
+	output := flag.String("output", "", "File to write the generated map to")
+	pkgName := flag.String("package", "", "Package clause of the generated file")
+	roots := flag.String("roots", "", "Comma-separated struct types, as <import path>.<type>, to describe along with the types they reach")
+	flag.Parse()
+	if *output == "" || *pkgName == "" || *roots == "" || flag.NArg() == 0 {
+		logrus.Fatal("Usage: gen-field-descriptions -output <file> -package <name> -roots <import path>.<type>,... <import path>...")
+	}
+
+	structs := map[string]parsedStruct{}
+	for _, importPath := range flag.Args() {
+		if err := collect(importPath, structs); err != nil {
+			logrus.WithError(err).Fatalf("Failed to parse %s", importPath)
+		}
+	}
+	descriptions, err := describe(structs, strings.Split(*roots, ","))
+	if err != nil {
+		logrus.WithError(err).Fatal("Failed to collect field descriptions")
+	}
+
+	source, err := render(*pkgName, descriptions)
+	if err != nil {
+		logrus.WithError(err).Fatal("Failed to render field descriptions")
+	}
+	if err := os.WriteFile(*output, source, 0644); err != nil {
+		logrus.WithError(err).Fatal("Failed to write field descriptions")
+	}
+}
+
+// parsedStruct is a struct type declaration along with the imports of its file
+type parsedStruct struct {
+	importPath string
+	fields     *ast.FieldList
+	// imports maps the names a file refers to imported packages by to their import paths
+	imports map[string]string
+}
+
+// collect adds every struct type declared in importPath to structs, keyed by <import path>.<type>
+func collect(importPath string, structs map[string]parsedStruct) error {
+	resolved, err := exec.Command("go", "list", "-f", "{{.ImportPath}} {{.Dir}}", importPath).Output()
+	if err != nil {
+		return fmt.Errorf("failed to resolve package: %w", err)
+	}
+	importPath, dir, _ := strings.Cut(strings.TrimSpace(string(resolved)), " ")
+
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, dir, func(info os.FileInfo) bool {
+		return !strings.HasSuffix(info.Name(), "_test.go")
+	}, parser.ParseComments)
+	if err != nil {
+		return fmt.Errorf("failed to parse package: %w", err)
+	}
+
+	for _, pkg := range pkgs {
+		for _, file := range pkg.Files {
+			imports := map[string]string{}
+			for _, spec := range file.Imports {
+				path := strings.Trim(spec.Path.Value, `"`)
+				name := path[strings.LastIndex(path, "/")+1:]
+				if spec.Name != nil {
+					name = spec.Name.Name
+				}
+				imports[name] = path
+			}
+			for _, decl := range file.Decls {
+				gen, ok := decl.(*ast.GenDecl)
+				if !ok || gen.Tok != token.TYPE {
+					continue
+				}
+				for _, spec := range gen.Specs {
+					typeSpec := spec.(*ast.TypeSpec)
+					if structType, ok := typeSpec.Type.(*ast.StructType); ok {
+						structs[importPath+"."+typeSpec.Name.Name] = parsedStruct{importPath: importPath, fields: structType.Fields, imports: imports}
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// describe returns the comments of the exported fields of the root types and of every struct type they reach
+func describe(structs map[string]parsedStruct, roots []string) (map[string]map[string]string, error) {
+	descriptions := map[string]map[string]string{}
+	seen := map[string]bool{}
+	queue := slices.Clone(roots)
+	for len(queue) > 0 {
+		key := queue[0]
+		queue = queue[1:]
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		parsed, ok := structs[key]
+		if !ok {
+			if slices.Contains(roots, key) {
+				return nil, fmt.Errorf("root type %s is not a struct of the given packages", key)
+			}
+			continue
+		}
+
+		for _, field := range parsed.fields.List {
+			queue = append(queue, referencedTypes(parsed, field.Type)...)
+			text := commentText(field.Doc)
+			if text == "" {
+				text = commentText(field.Comment)
+			}
+			if text == "" {
+				continue
+			}
+			for _, name := range field.Names {
+				if !name.IsExported() {
+					continue
+				}
+				if descriptions[key] == nil {
+					descriptions[key] = map[string]string{}
+				}
+				descriptions[key][name.Name] = text
+			}
+		}
+	}
+	return descriptions, nil
+}
+
+// referencedTypes returns the keys of the named types a field type expression refers to
+func referencedTypes(parsed parsedStruct, expr ast.Expr) []string {
+	switch e := expr.(type) {
+	case *ast.Ident:
+		return []string{parsed.importPath + "." + e.Name}
+	case *ast.SelectorExpr:
+		if pkg, ok := e.X.(*ast.Ident); ok && parsed.imports[pkg.Name] != "" {
+			return []string{parsed.imports[pkg.Name] + "." + e.Sel.Name}
+		}
+	case *ast.StarExpr:
+		return referencedTypes(parsed, e.X)
+	case *ast.ArrayType:
+		return referencedTypes(parsed, e.Elt)
+	case *ast.MapType:
+		return referencedTypes(parsed, e.Value)
+	}
+	return nil
+}
+
+// commentText joins the lines of a comment into a single line
+func commentText(group *ast.CommentGroup) string {
+	return strings.Join(strings.Fields(group.Text()), " ")
+}
+
+func render(pkgName string, descriptions map[string]map[string]string) ([]byte, error) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by gen-field-descriptions. DO NOT EDIT.\n\npackage %s\n\n", pkgName)
+	buf.WriteString("// fieldDescriptions holds the comments of struct fields, keyed by the import path and name of the struct\n")
+	buf.WriteString("// type and then by field name\n")
+	buf.WriteString("var fieldDescriptions = map[string]map[string]string{\n")
+	for _, typeName := range sortedKeys(descriptions) {
+		fmt.Fprintf(&buf, "\t%q: {\n", typeName)
+		for _, field := range sortedKeys(descriptions[typeName]) {
+			fmt.Fprintf(&buf, "\t\t%q: %q,\n", field, descriptions[typeName][field])
+		}
+		buf.WriteString("\t},\n")
+	}
+	buf.WriteString("}\n")
+	return format.Source(buf.Bytes())
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,76 @@
+package metrics
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/googleapis/gax-go/v2/apierror"
+	"google.golang.org/api/googleapi"
+)
+
+// activationURLPattern matches the console link Google APIs include in the message of a disabled API error
+var activationURLPattern = regexp.MustCompile(`https://console\.(developers\.google|cloud\.google)\.com/apis/api/[^\s"]+`)
+
+// APIDisabledError is returned when a Google API the loader calls is not enabled in the project it runs requests
+// in, as happens in freshly provisioned projects
+type APIDisabledError struct {
+	// API names the disabled API, such as BigQuery or Cloud Storage
+	API string
+	// Service is the name of the service to enable, such as bigquery.googleapis.com, when the error named it
+	Service string
+	// ActivationURL is the console page enabling the API, when the error included it
+	ActivationURL string
+	Err           error
+}
+
+func (e *APIDisabledError) Error() string {
+	message := fmt.Sprintf("the %s API is not enabled for this project", e.API)
+	switch {
+	case e.ActivationURL != "":
+		message += fmt.Sprintf(": enable it at %s, wait a few minutes and retry", e.ActivationURL)
+	case e.Service != "":
+		message += fmt.Sprintf(": enable it with `gcloud services enable %s`, wait a few minutes and retry", e.Service)
+	default:
+		message += ": enable it in the APIs & Services page of the Cloud console and retry"
+	}
+	return message
+}
+
+func (e *APIDisabledError) Unwrap() error {
+	return e.Err
+}
+
+// explainAPIDisabled returns an APIDisabledError for err when it reports that api is disabled, either through the
+// accessNotConfigured reason of a JSON API error or the SERVICE_DISABLED reason of its error details, and err
+// otherwise
+func explainAPIDisabled(api string, err error) error {
+	var disabled *APIDisabledError
+	if err == nil || errors.As(err, &disabled) {
+		return err
+	}
+	explained := &APIDisabledError{API: api, Err: err}
+	var apiErr *apierror.APIError
+	if errors.As(err, &apiErr) && apiErr.Reason() == "SERVICE_DISABLED" {
+		explained.Service = apiErr.Metadata()["service"]
+		explained.ActivationURL = apiErr.Metadata()["activationUrl"]
+		return explained
+	}
+	var httpErr *googleapi.Error
+	if !errors.As(err, &httpErr) || httpErr.Code != http.StatusForbidden {
+		return err
+	}
+	for _, item := range httpErr.Errors {
+		if item.Reason == "accessNotConfigured" {
+			explained.ActivationURL = activationURLPattern.FindString(item.Message)
+			return explained
+		}
+	}
+	if strings.Contains(httpErr.Message, "has not been used in project") {
+		explained.ActivationURL = activationURLPattern.FindString(httpErr.Message)
+		return explained
+	}
+	return err
+}
@@ -0,0 +1,98 @@
+package metrics
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// IsArchive reports whether name is a gzipped tarball of metrics files
+func IsArchive(name string) bool {
+	return strings.HasSuffix(name, ".tar.gz") || strings.HasSuffix(name, ".tgz")
+}
+
+// forEachArchivedFile streams through a .tar.gz archive and decodes every regular member matching IsMetricsFile,
+// passing it to fn in archive order. Other members are skipped without being buffered.
+func forEachArchivedFile(r io.Reader, opts DecodeOptions, fn func(name string, data *MetricsData) error) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("failed to open gzip stream: %w", err)
+	}
+	defer gz.Close()
+
+	archive := tar.NewReader(gz)
+	for {
+		header, err := archive.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read archive: %w", err)
+		}
+		if header.Typeflag != tar.TypeReg || !IsMetricsFile(header.Name) {
+			continue
+		}
+
+		member, err := decompress(header.Name, "", io.NopCloser(archive))
+		if err != nil {
+			return fmt.Errorf("failed to open %s: %w", header.Name, err)
+		}
+		data, err := DecodeMetricsDataWithOptions(member, opts)
+		member.Close()
+		if err != nil {
+			return fmt.Errorf("failed to decode %s: %w", header.Name, err)
+		}
+		if err := fn(header.Name, data); err != nil {
+			return err
+		}
+	}
+}
+
+// DecodeArchive decodes every metrics file of a .tar.gz archive and merges them into a single MetricsData
+func DecodeArchive(r io.Reader, opts DecodeOptions) (*MetricsData, error) {
+	merged := &MetricsData{}
+	err := forEachArchivedFile(r, opts, func(_ string, data *MetricsData) error {
+		merged.append(data)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return merged, nil
+}
+
+// LoadArchive loads every metrics file of a .tar.gz archive in sequence, without unpacking it to disk.
+// With a circuit breaker, files that fail to load are counted and the remaining files are still loaded.
+func (b *BigQueryLoader) LoadArchive(r io.Reader) (*LoadResult, error) {
+	result := &LoadResult{}
+	var failures []error
+	err := forEachArchivedFile(r, b.decodeOpts, func(name string, data *MetricsData) error {
+		b.logger.Infof("Loading %s from archive", name)
+		var fileResult *LoadResult
+		err := b.checkRequiredTables(data)
+		if err == nil {
+			fileResult, err = b.loadData(b.ctx, data)
+		}
+		result.Merge(fileResult)
+		if err == nil {
+			return nil
+		}
+		err = fmt.Errorf("%s: %w", name, err)
+		if b.breaker == nil || errors.Is(err, ErrCircuitBreakerTripped) {
+			return err
+		}
+		failures = append(failures, err)
+		return nil
+	})
+	if err != nil {
+		return result, err
+	}
+	if len(failures) > 0 {
+		return result, errors.Join(failures...)
+	}
+	b.logSummary(result)
+	return result, nil
+}
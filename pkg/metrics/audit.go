@@ -0,0 +1,233 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/bigquery/storage/managedwriter"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// DefaultAuditTable is the table loads are audited in unless another one is named
+const DefaultAuditTable = "ingestion_audit"
+
+// Statuses of an AuditRecord, matching the exit codes of the CLI
+const (
+	AuditSuccess = "success"
+	AuditPartial = "partial"
+	AuditFailure = "failure"
+)
+
+// auditSchema is the schema of the audit table, partitioned by day of started_at
+var auditSchema = bigquery.Schema{
+	{Name: "load_id", Type: bigquery.StringFieldType, Required: true, Description: "Identifies the version of the source loaded, shared by the retries and reruns of its load"},
+	{Name: "source", Type: bigquery.StringFieldType, Required: true, Description: "The metrics file loaded"},
+	{Name: "dataset", Type: bigquery.StringFieldType, Required: true},
+	{Name: "started_at", Type: bigquery.TimestampFieldType, Required: true},
+	{Name: "duration_seconds", Type: bigquery.FloatFieldType, Required: true},
+	{Name: "status", Type: bigquery.StringFieldType, Required: true, Description: "success, partial or failure"},
+	{Name: "error", Type: bigquery.StringFieldType, Description: "The error the load failed with, NULL when it succeeded"},
+	{Name: "tables_loaded", Type: bigquery.IntegerFieldType, Required: true},
+	{Name: "rows_inserted", Type: bigquery.IntegerFieldType, Required: true},
+	{Name: "invalid_rows", Type: bigquery.IntegerFieldType, Required: true},
+	{Name: "bad_records", Type: bigquery.IntegerFieldType, Required: true},
+	{Name: "failed_tables", Type: bigquery.StringFieldType, Repeated: true},
+}
+
+// AuditRecord is the outcome of one load, appended to the audit table by WriteAuditRecord
+type AuditRecord struct {
+	Source string
+	// Generation identifies the version of Source loaded, such as the generation of its GCS object or the
+	// modification time of a local file. Zero when it is not known, load_id then identifies Source alone.
+	Generation int64
+	StartedAt  time.Time
+	Duration   time.Duration
+	// Result is nil when the load failed before any table was attempted
+	Result *LoadResult
+	// Err is the error the load failed with, nil when it succeeded
+	Err error
+}
+
+// Status returns whether the load succeeded, failed for some tables only or failed
+func (r *AuditRecord) Status() string {
+	switch {
+	case r.Err == nil:
+		return AuditSuccess
+	case r.Result != nil && len(r.Result.FailedTables()) > 0 && r.Result.TablesWithData() > 0:
+		return AuditPartial
+	default:
+		return AuditFailure
+	}
+}
+
+// LoadID identifies the version of the source loaded. The loads of the same version, such as a retried event or a
+// rerun, share it, so that the latest row of a load_id is the outcome of that version.
+func (r *AuditRecord) LoadID() string {
+	return IngestionID(r.Source, r.Generation)
+}
+
+// auditRow is the row an AuditRecord is written as
+type auditRow struct {
+	record  *AuditRecord
+	dataset string
+}
+
+func (r auditRow) Save() (map[string]bigquery.Value, string, error) {
+	row := map[string]bigquery.Value{
+		"load_id":          r.record.LoadID(),
+		"source":           r.record.Source,
+		"dataset":          r.dataset,
+		"started_at":       r.record.StartedAt,
+		"duration_seconds": r.record.Duration.Seconds(),
+		"status":           r.record.Status(),
+		"tables_loaded":    0,
+		"rows_inserted":    0,
+		"invalid_rows":     0,
+		"bad_records":      0,
+	}
+	if r.record.Err != nil {
+		row["error"] = r.record.Err.Error()
+	}
+	if result := r.record.Result; result != nil {
+		rows, invalid := 0, 0
+		for _, t := range result.Tables {
+			rows += t.RowsInserted
+			invalid += t.InvalidRows
+		}
+		row["tables_loaded"], row["rows_inserted"], row["invalid_rows"], row["bad_records"] = result.TablesWithData(), rows, invalid, result.BadRecords
+		row["failed_tables"] = result.FailedTables()
+	}
+	return row, "", nil
+}
+
+// AuditStream appends the audit records of every loader sharing it to one committed Storage Write API stream per
+// audit table, instead of opening a stream for every record. It is safe for concurrent use. Close it once the run
+// is over.
+type AuditStream struct {
+	lock    sync.Mutex
+	streams map[string]*auditTableStream
+}
+
+// auditTableStream is the stream of one audit table
+type auditTableStream struct {
+	writer *writeAPIClient
+	stream *writeAPIStream
+	// offset is the offset the next record is appended at
+	offset int64
+}
+
+// NewAuditStream creates an audit stream opening the stream of each audit table on its first record
+func NewAuditStream() *AuditStream {
+	return &AuditStream{streams: map[string]*auditTableStream{}}
+}
+
+// WithAuditStream shares stream between loaders so that WriteAuditRecord appends the records of all of them to the
+// same stream. Without it every call opens a stream of its own.
+func WithAuditStream(stream *AuditStream) Option {
+	return func(b *BigQueryLoader) {
+		b.auditStream = stream
+	}
+}
+
+// Close closes the stream of every audit table
+func (s *AuditStream) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var errs []error
+	for key, stream := range s.streams {
+		errs = append(errs, stream.close())
+		delete(s.streams, key)
+	}
+	return errors.Join(errs...)
+}
+
+func (s *auditTableStream) close() error {
+	err := s.stream.close()
+	// a managed stream closed without error reports io.EOF
+	if errors.Is(err, io.EOF) {
+		err = nil
+	}
+	return errors.Join(err, s.writer.close())
+}
+
+// WriteAuditRecord appends record to table in the dataset of the loader, creating the table when it does not exist.
+// The row is appended through the Storage Write API to a committed stream at the next offset of the stream, so that
+// the appends the client retries after a lost acknowledgement are rejected as already written instead of
+// duplicating the row: every call writes exactly one row. The stream is the one of WithAuditStream, or one opened
+// for this record alone.
+func (b *BigQueryLoader) WriteAuditRecord(ctx context.Context, table string, record *AuditRecord) error {
+	streams := b.auditStream
+	if streams == nil {
+		streams = NewAuditStream()
+		defer streams.Close()
+	}
+	return streams.append(ctx, b, table, record)
+}
+
+// append writes record to the stream of table in the dataset of b, opening it on the first record. Records are
+// appended one at a time so that their offsets follow each other.
+func (s *AuditStream) append(ctx context.Context, b *BigQueryLoader, table string, record *AuditRecord) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	key := fmt.Sprintf("%s.%s.%s", b.projectID, b.datasetID, table)
+	stream, ok := s.streams[key]
+	if !ok {
+		var err error
+		if stream, err = b.openAuditStream(ctx, table); err != nil {
+			return err
+		}
+		s.streams[key] = stream
+	}
+
+	encoded, err := stream.stream.encodeRow(auditRow{record: record, dataset: b.datasetID})
+	if err != nil {
+		return fmt.Errorf("failed to encode audit record: %w", err)
+	}
+	appended, err := stream.stream.stream.AppendRows(ctx, [][]byte{encoded}, managedwriter.WithOffset(stream.offset))
+	if err == nil {
+		_, err = appended.GetResult(ctx)
+	}
+	if err != nil && status.Code(err) != codes.AlreadyExists {
+		// whether the row was written is unknown, so the next record starts over on a new stream
+		_ = stream.close()
+		delete(s.streams, key)
+		return fmt.Errorf("failed to append to audit table %s: %w", table, err)
+	}
+	stream.offset++
+	return nil
+}
+
+// openAuditStream opens a committed stream to table in the dataset of the loader, creating the table when it does
+// not exist
+func (b *BigQueryLoader) openAuditStream(ctx context.Context, table string) (*auditTableStream, error) {
+	auditTable := b.bqClient.DatasetInProject(b.projectID, b.datasetID).Table(table)
+	if _, err := auditTable.Metadata(ctx); isNotFoundError(err) {
+		err = auditTable.Create(ctx, &bigquery.TableMetadata{
+			Schema:           auditSchema,
+			TimePartitioning: &bigquery.TimePartitioning{Field: "started_at"},
+			EncryptionConfig: b.encryption,
+		})
+		if err != nil && !isAlreadyExistsError(err) {
+			return nil, fmt.Errorf("failed to create audit table %s: %w", table, err)
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get metadata of audit table %s: %w", table, err)
+	}
+
+	writer, err := newWriteAPIClient(ctx, b.projectID, b.writeAPIClientOpts...)
+	if err != nil {
+		return nil, err
+	}
+	stream, err := writer.newStream(ctx, b.datasetID, table, auditSchema, managedwriter.WithType(managedwriter.CommittedStream), managedwriter.EnableWriteRetries(true))
+	if err != nil {
+		writer.close()
+		return nil, fmt.Errorf("failed to open a stream to audit table %s: %w", table, err)
+	}
+	return &auditTableStream{writer: writer, stream: stream}, nil
+}
@@ -0,0 +1,59 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestAuditRecordLoadID(t *testing.T) {
+	start := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	first := &AuditRecord{Source: "gs://bucket/metrics.json", Generation: 42, StartedAt: start}
+	retry := &AuditRecord{Source: "gs://bucket/metrics.json", Generation: 42, StartedAt: start.Add(time.Minute), Err: errors.New("failed")}
+	if first.LoadID() != retry.LoadID() {
+		t.Errorf("a retry of the same generation got load_id %s, expected %s", retry.LoadID(), first.LoadID())
+	}
+	next := &AuditRecord{Source: "gs://bucket/metrics.json", Generation: 43, StartedAt: start}
+	if first.LoadID() == next.LoadID() {
+		t.Errorf("a new generation got the load_id %s of the previous one", next.LoadID())
+	}
+	other := &AuditRecord{Source: "gs://bucket/other.json", Generation: 42, StartedAt: start}
+	if first.LoadID() == other.LoadID() {
+		t.Errorf("another source got the load_id %s", other.LoadID())
+	}
+}
+
+func TestWriteAuditRecordSharesStream(t *testing.T) {
+	ctx := context.Background()
+	fake, client := newFakeBigQuery(t)
+	server := &fakeWriteServer{committed: map[string]int{}}
+	clientOpts := startFakeWriteServer(t, server)
+
+	stream := NewAuditStream()
+	for i, source := range []string{"a.json", "b.json", "c.json"} {
+		// a loader per source, like the CLI creates for every path
+		loader := NewBigQueryLoader(ctx, client, "project", "dataset", WithWriteAPIClientOptions(clientOpts...), WithAuditStream(stream))
+		record := &AuditRecord{Source: source, Generation: int64(i), StartedAt: time.Now(), Result: &LoadResult{}}
+		if err := loader.WriteAuditRecord(ctx, DefaultAuditTable, record); err != nil {
+			t.Fatalf("failed to write the audit record of %s: %v", source, err)
+		}
+	}
+	if err := stream.Close(); err != nil {
+		t.Fatalf("failed to close the audit stream: %v", err)
+	}
+
+	if server.streams != 1 {
+		t.Errorf("expected one stream for the run, got %d", server.streams)
+	}
+	if expected := []int64{0, 1, 2}; !slices.Equal(server.offsets, expected) {
+		t.Errorf("expected appends at offsets %v, got %v", expected, server.offsets)
+	}
+	if len(server.committed) != 3 {
+		t.Errorf("expected 3 committed rows, got %d", len(server.committed))
+	}
+	if expected := []string{"dataset." + DefaultAuditTable}; !slices.Equal(fake.created, expected) {
+		t.Errorf("expected the audit table to be created once, got %v", fake.created)
+	}
+}
@@ -0,0 +1,389 @@
+package metrics
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// ExportFormatJSON exports every table as the NDJSON files of ExportMetricsData
+	ExportFormatJSON = "json"
+	// ExportFormatAvroBQ exports every table as the Avro files and schemas of ExportAvro
+	ExportFormatAvroBQ = "avro-bq"
+)
+
+// AvroFileSuffix is appended to the table name to form the file ExportAvro writes for it
+const AvroFileSuffix = ".avro"
+
+// avroBlockRows is the number of rows written to every block of an Avro file
+const avroBlockRows = 1000
+
+// avroMagic starts every Avro object container file
+var avroMagic = []byte{'O', 'b', 'j', 1}
+
+// ExportAvro writes every table of data with rows to <exportDir>/<table>.avro, a deflate-compressed Avro object
+// container file whose schema is derived from the BigQuery schema inferred for the table, and that BigQuery schema
+// to <exportDir>/<table>.schema.json, so that `bq load --source_format=AVRO --use_avro_logical_types` creates the
+// columns a load creates. includeEmpty also writes the tables without rows, as files holding only the schema.
+// Tables whose row type cannot be inferred, pods and events, are exported as NDJSON like ExportMetricsData does.
+func ExportAvro(data *MetricsData, exportDir string, includeEmpty bool) error {
+	if err := os.MkdirAll(exportDir, 0755); err != nil {
+		return fmt.Errorf("failed to create export directory: %w", err)
+	}
+
+	logger := logrus.WithField("component", "exportMetrics")
+	logger.Infof("Exporting metrics to %s as Avro", exportDir)
+	for _, t := range data.tables() {
+		if len(t.rows) == 0 && !includeEmpty {
+			continue
+		}
+		inferred, err := inferSchema(t.row)
+		if err != nil {
+			logger.WithError(err).Warnf("Exporting %s as NDJSON: its schema cannot be inferred", t.name)
+			if err := exportNDJSON(filepath.Join(exportDir, t.name+".json"), data, t.name); err != nil {
+				return fmt.Errorf("failed to export %s: %w", t.name, err)
+			}
+			continue
+		}
+		schema := nullableJSONColumns(inferred)
+		encoded, err := schema.ToJSONFields()
+		if err != nil {
+			return fmt.Errorf("failed to encode the schema of %s: %w", t.name, err)
+		}
+		if err := os.WriteFile(filepath.Join(exportDir, t.name+SchemaFileSuffix), append(encoded, '\n'), 0644); err != nil {
+			return fmt.Errorf("failed to write the schema of %s: %w", t.name, err)
+		}
+		if err := writeAvroFile(filepath.Join(exportDir, t.name+AvroFileSuffix), t.name, schema, t.rows); err != nil {
+			return fmt.Errorf("failed to export %s: %w", t.name, err)
+		}
+		logger.Infof("Exported %d %s to %s%s", len(t.rows), t.name, t.name, AvroFileSuffix)
+	}
+	return nil
+}
+
+// exportNDJSON writes the rows of table to path as EmitNDJSON does
+func exportNDJSON(path string, data *MetricsData, table string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Base(path), err)
+	}
+	defer file.Close()
+	if err := EmitNDJSON(file, data, table); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// AvroSchema returns the Avro schema of a record named name whose fields load into the columns of schema:
+//
+//   - STRING, BYTES and BOOLEAN map to string, bytes and boolean
+//   - INTEGER maps to long and FLOAT to double, which keeps NaN and the infinities BigQuery accepts
+//   - TIMESTAMP maps to a long of the timestamp-micros logical type, the precision BigQuery stores
+//   - JSON maps to a string annotated with the JSON SQL type, which BigQuery parses into the column
+//   - RECORD maps to a nested record named after the record and the path of the column, as Avro names must be
+//     unique within a schema
+//
+// NULLABLE columns are a union of null and their type defaulting to null, and REPEATED columns an array of it.
+func AvroSchema(name string, schema bigquery.Schema) (json.RawMessage, error) {
+	record, err := avroRecordSchema(name, schema)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(record)
+}
+
+type avroRecord struct {
+	Type   string      `json:"type"`
+	Name   string      `json:"name"`
+	Fields []avroField `json:"fields"`
+}
+
+type avroField struct {
+	Name    string          `json:"name"`
+	Doc     string          `json:"doc,omitempty"`
+	Type    any             `json:"type"`
+	Default json.RawMessage `json:"default,omitempty"`
+}
+
+type avroPrimitive struct {
+	Type        string `json:"type"`
+	LogicalType string `json:"logicalType,omitempty"`
+	SQLType     string `json:"sqlType,omitempty"`
+}
+
+type avroArray struct {
+	Type  string `json:"type"`
+	Items any    `json:"items"`
+}
+
+func avroRecordSchema(name string, schema bigquery.Schema) (avroRecord, error) {
+	record := avroRecord{Type: "record", Name: name, Fields: []avroField{}}
+	for _, field := range schema {
+		fieldType, err := avroFieldType(name+"_"+field.Name, field)
+		if err != nil {
+			return avroRecord{}, err
+		}
+		avro := avroField{Name: field.Name, Doc: field.Description, Type: fieldType}
+		switch {
+		case field.Repeated:
+			avro.Type = avroArray{Type: "array", Items: fieldType}
+		case !field.Required:
+			avro.Type, avro.Default = []any{"null", fieldType}, json.RawMessage("null")
+		}
+		record.Fields = append(record.Fields, avro)
+	}
+	return record, nil
+}
+
+// avroFieldType returns the Avro type of a single value of field, named name when it is a record
+func avroFieldType(name string, field *bigquery.FieldSchema) (any, error) {
+	switch field.Type {
+	case bigquery.StringFieldType:
+		return "string", nil
+	case bigquery.BytesFieldType:
+		return "bytes", nil
+	case bigquery.IntegerFieldType:
+		return "long", nil
+	case bigquery.FloatFieldType:
+		return "double", nil
+	case bigquery.BooleanFieldType:
+		return "boolean", nil
+	case bigquery.TimestampFieldType:
+		return avroPrimitive{Type: "long", LogicalType: "timestamp-micros"}, nil
+	case bigquery.JSONFieldType:
+		return avroPrimitive{Type: "string", SQLType: "JSON"}, nil
+	case bigquery.RecordFieldType:
+		return avroRecordSchema(name, field.Schema)
+	default:
+		return nil, fmt.Errorf("column %s of type %s has no Avro mapping", field.Name, field.Type)
+	}
+}
+
+// writeAvroFile writes rows saved against schema to path as an Avro object container file
+func writeAvroFile(path, name string, schema bigquery.Schema, rows []any) error {
+	avroSchema, err := AvroSchema(name, schema)
+	if err != nil {
+		return err
+	}
+	var sync [16]byte
+	if _, err := rand.Read(sync[:]); err != nil {
+		return fmt.Errorf("failed to generate sync marker: %w", err)
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Base(path), err)
+	}
+	defer file.Close()
+	w := bufio.NewWriter(file)
+
+	header := append([]byte{}, avroMagic...)
+	header = appendAvroLong(header, 2)
+	header = appendAvroBytes(header, []byte("avro.schema"))
+	header = appendAvroBytes(header, avroSchema)
+	header = appendAvroBytes(header, []byte("avro.codec"))
+	header = appendAvroBytes(header, []byte("deflate"))
+	header = appendAvroLong(header, 0)
+	if _, err := w.Write(append(header, sync[:]...)); err != nil {
+		return fmt.Errorf("failed to write %s: %w", filepath.Base(path), err)
+	}
+
+	var block, compressed bytes.Buffer
+	deflate, err := flate.NewWriter(&compressed, flate.DefaultCompression)
+	if err != nil {
+		return err
+	}
+	for start := 0; start < len(rows); start += avroBlockRows {
+		end := min(start+avroBlockRows, len(rows))
+		block.Reset()
+		var encoded []byte
+		for i, row := range rows[start:end] {
+			values, _, err := saveRow(row, schema)
+			if err != nil {
+				return fmt.Errorf("row %d: %w", start+i, err)
+			}
+			if encoded, err = appendAvroRecord(encoded[:0], schema, values); err != nil {
+				return fmt.Errorf("row %d: %w", start+i, err)
+			}
+			block.Write(encoded)
+		}
+
+		compressed.Reset()
+		deflate.Reset(&compressed)
+		if _, err := deflate.Write(block.Bytes()); err != nil {
+			return fmt.Errorf("failed to compress block: %w", err)
+		}
+		if err := deflate.Close(); err != nil {
+			return fmt.Errorf("failed to compress block: %w", err)
+		}
+		prefix := appendAvroLong(appendAvroLong(nil, int64(end-start)), int64(compressed.Len()))
+		for _, part := range [][]byte{prefix, compressed.Bytes(), sync[:]} {
+			if _, err := w.Write(part); err != nil {
+				return fmt.Errorf("failed to write %s: %w", filepath.Base(path), err)
+			}
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", filepath.Base(path), err)
+	}
+	return file.Close()
+}
+
+// appendAvroRecord appends the Avro encoding of the values of a row or a nested record saved against schema
+func appendAvroRecord(buf []byte, schema bigquery.Schema, values map[string]bigquery.Value) ([]byte, error) {
+	for _, field := range schema {
+		var err error
+		if buf, err = appendAvroField(buf, field, values[field.Name]); err != nil {
+			return nil, err
+		}
+	}
+	return buf, nil
+}
+
+func appendAvroField(buf []byte, field *bigquery.FieldSchema, value bigquery.Value) ([]byte, error) {
+	switch {
+	case field.Repeated:
+		// a missing repeated value is an empty array, as BigQuery stores it
+		items := reflect.ValueOf(value)
+		if value == nil || (items.Kind() == reflect.Slice && items.IsNil()) {
+			return appendAvroLong(buf, 0), nil
+		}
+		if items.Kind() != reflect.Slice && items.Kind() != reflect.Array {
+			return nil, fmt.Errorf("column %s: expected a list, got %T", field.Name, value)
+		}
+		if items.Len() > 0 {
+			buf = appendAvroLong(buf, int64(items.Len()))
+			for i := range items.Len() {
+				item := items.Index(i).Interface()
+				if isNullValue(item) {
+					return nil, fmt.Errorf("column %s: a repeated column cannot hold NULL", field.Name)
+				}
+				var err error
+				if buf, err = appendAvroValue(buf, field, item); err != nil {
+					return nil, err
+				}
+			}
+		}
+		return appendAvroLong(buf, 0), nil
+	case isNullValue(value):
+		if field.Required {
+			return nil, fmt.Errorf("column %s is REQUIRED but has no value", field.Name)
+		}
+		return appendAvroLong(buf, 0), nil
+	case !field.Required:
+		buf = appendAvroLong(buf, 1)
+	}
+	return appendAvroValue(buf, field, value)
+}
+
+// appendAvroValue appends a single non-null value of field, accepting the Go types values are saved with
+func appendAvroValue(buf []byte, field *bigquery.FieldSchema, value bigquery.Value) ([]byte, error) {
+	v := reflect.ValueOf(value)
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		v = v.Elem()
+	}
+	mismatch := fmt.Errorf("column %s: cannot encode %T as %s", field.Name, value, field.Type)
+	switch field.Type {
+	case bigquery.StringFieldType:
+		if v.Kind() != reflect.String {
+			return nil, mismatch
+		}
+		return appendAvroBytes(buf, []byte(v.String())), nil
+	case bigquery.BytesFieldType:
+		if v.Kind() != reflect.Slice || v.Type().Elem().Kind() != reflect.Uint8 {
+			return nil, mismatch
+		}
+		return appendAvroBytes(buf, v.Bytes()), nil
+	case bigquery.IntegerFieldType:
+		switch {
+		case v.CanInt():
+			return appendAvroLong(buf, v.Int()), nil
+		case v.CanUint() && v.Uint() <= math.MaxInt64:
+			return appendAvroLong(buf, int64(v.Uint())), nil
+		case v.CanUint():
+			return nil, fmt.Errorf("column %s: %d overflows INTEGER", field.Name, v.Uint())
+		}
+		return nil, mismatch
+	case bigquery.FloatFieldType:
+		var f float64
+		switch {
+		case v.CanFloat():
+			f = v.Float()
+		case v.CanInt():
+			f = float64(v.Int())
+		case v.CanUint():
+			f = float64(v.Uint())
+		default:
+			return nil, mismatch
+		}
+		// the IEEE 754 bits are written as is, so NaN and the infinities survive the export
+		return binary.LittleEndian.AppendUint64(buf, math.Float64bits(f)), nil
+	case bigquery.BooleanFieldType:
+		if v.Kind() != reflect.Bool {
+			return nil, mismatch
+		}
+		if v.Bool() {
+			return append(buf, 1), nil
+		}
+		return append(buf, 0), nil
+	case bigquery.TimestampFieldType:
+		timestamp, ok := v.Interface().(time.Time)
+		if !ok {
+			return nil, mismatch
+		}
+		// BigQuery stores microseconds, so the nanoseconds it would drop on insert are truncated here too
+		return appendAvroLong(buf, timestamp.UnixMicro()), nil
+	case bigquery.JSONFieldType:
+		if v.Kind() == reflect.String {
+			return appendAvroBytes(buf, []byte(v.String())), nil
+		}
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return nil, fmt.Errorf("column %s: failed to encode JSON: %w", field.Name, err)
+		}
+		return appendAvroBytes(buf, encoded), nil
+	case bigquery.RecordFieldType:
+		values, ok := v.Interface().(map[string]bigquery.Value)
+		if !ok {
+			return nil, mismatch
+		}
+		return appendAvroRecord(buf, field.Schema, values)
+	default:
+		return nil, fmt.Errorf("column %s of type %s has no Avro mapping", field.Name, field.Type)
+	}
+}
+
+// isNullValue reports whether a saved value is NULL: nil, or a nil pointer, map or slice
+func isNullValue(value bigquery.Value) bool {
+	if value == nil {
+		return true
+	}
+	switch v := reflect.ValueOf(value); v.Kind() {
+	case reflect.Pointer, reflect.Interface, reflect.Map, reflect.Slice:
+		return v.IsNil()
+	}
+	return false
+}
+
+// appendAvroLong appends the zig-zag variable-length encoding Avro uses for int and long
+func appendAvroLong(buf []byte, n int64) []byte {
+	return binary.AppendUvarint(buf, uint64(n<<1)^uint64(n>>63))
+}
+
+// appendAvroBytes appends a length-prefixed bytes or string value
+func appendAvroBytes(buf, value []byte) []byte {
+	return append(appendAvroLong(buf, int64(len(value))), value...)
+}
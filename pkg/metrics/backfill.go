@@ -0,0 +1,70 @@
+package metrics
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+// SkipReason classifies why an object of a multi-file load was not loaded
+type SkipReason string
+
+// Reasons an object of a multi-file load is skipped
+const (
+	// SkipEmpty is a zero-byte metrics file skipped with --skip-empty-objects
+	SkipEmpty SkipReason = "empty"
+	// SkipDecodeError is a file that is not valid metrics JSON
+	SkipDecodeError SkipReason = "decode_error"
+	// SkipLoadError is a file that was read but failed to load into BigQuery
+	SkipLoadError SkipReason = "load_error"
+)
+
+// SkippedObject records an object of a multi-file load that was not loaded
+type SkippedObject struct {
+	Path    string     `json:"path"`
+	Reason  SkipReason `json:"reason"`
+	Message string     `json:"message"`
+}
+
+// BackfillResult aggregates the outcome of loading many objects, one LoadResult per object
+type BackfillResult struct {
+	Loaded       int             `json:"loaded"`
+	RowsInserted int             `json:"rows_inserted"`
+	Skipped      []SkippedObject `json:"skipped"`
+}
+
+// AddLoaded records an object that loaded successfully
+func (r *BackfillResult) AddLoaded(result *LoadResult) {
+	r.Loaded++
+	if result == nil {
+		return
+	}
+	for _, t := range result.Tables {
+		r.RowsInserted += t.RowsInserted
+	}
+}
+
+// AddSkipped records an object that was not loaded because of err
+func (r *BackfillResult) AddSkipped(path string, reason SkipReason, err error) {
+	r.Skipped = append(r.Skipped, SkippedObject{Path: path, Reason: reason, Message: err.Error()})
+}
+
+// SkipCounts returns how many objects were skipped for every reason that occurred
+func (r *BackfillResult) SkipCounts() map[SkipReason]int {
+	counts := map[SkipReason]int{}
+	for _, skipped := range r.Skipped {
+		counts[skipped.Reason]++
+	}
+	return counts
+}
+
+// SkipReasonOf classifies the error an object failed with
+func SkipReasonOf(err error) SkipReason {
+	switch {
+	case errors.Is(err, ErrEmptySource):
+		return SkipEmpty
+	case IsDecodeError(err), errors.As(err, new(*json.UnmarshalTypeError)):
+		return SkipDecodeError
+	default:
+		return SkipLoadError
+	}
+}
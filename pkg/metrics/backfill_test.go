@@ -0,0 +1,25 @@
+package metrics
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSkipReasonOf(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		err      error
+		expected SkipReason
+	}{
+		{name: "empty", err: ErrEmptySource, expected: SkipEmpty},
+		{name: "truncated", err: decodeTestFile(`{"nodes": [`), expected: SkipDecodeError},
+		{name: "wrong type", err: decodeTestFile(`{"nodes": [{"node": 1}]}`), expected: SkipDecodeError},
+		{name: "insert", err: errors.New("failed to insert"), expected: SkipLoadError},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if actual := SkipReasonOf(tc.err); actual != tc.expected {
+				t.Errorf("expected %s for %v, got %s", tc.expected, tc.err, actual)
+			}
+		})
+	}
+}
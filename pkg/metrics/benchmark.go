@@ -0,0 +1,155 @@
+package metrics
+
+import (
+	"runtime"
+	"slices"
+	"sync"
+	"time"
+)
+
+// benchmarkMemoryInterval is how often a Benchmark samples the heap to find its high-water mark
+const benchmarkMemoryInterval = 50 * time.Millisecond
+
+// Benchmark times every batch of rows a loader writes to BigQuery and tracks the memory used while loading.
+// It is safe for concurrent use, so loaders sharing it report a single run. A nil Benchmark records nothing.
+type Benchmark struct {
+	start time.Time
+	stop  chan struct{}
+	done  chan struct{}
+
+	lock     sync.Mutex
+	batches  []benchmarkBatch
+	peakHeap uint64
+}
+
+// benchmarkBatch is a single successful streaming insert, upsert or Storage Write API append
+type benchmarkBatch struct {
+	table   string
+	rows    int
+	elapsed time.Duration
+}
+
+// BenchmarkReport is the throughput measured by a Benchmark
+type BenchmarkReport struct {
+	Duration      time.Duration     `json:"duration_ns"`
+	Rows          int               `json:"rows"`
+	RowsPerSecond float64           `json:"rows_per_second"`
+	Batches       int               `json:"batches"`
+	BatchP50      time.Duration     `json:"batch_p50_ns"`
+	BatchP95      time.Duration     `json:"batch_p95_ns"`
+	PeakHeapBytes uint64            `json:"peak_heap_bytes"`
+	Tables        []TableThroughput `json:"tables"`
+}
+
+// TableThroughput is the throughput of a single table, measured over the time spent writing its batches
+type TableThroughput struct {
+	Table         string        `json:"table"`
+	Rows          int           `json:"rows"`
+	Batches       int           `json:"batches"`
+	WriteTime     time.Duration `json:"write_time_ns"`
+	RowsPerSecond float64       `json:"rows_per_second"`
+	BatchP50      time.Duration `json:"batch_p50_ns"`
+	BatchP95      time.Duration `json:"batch_p95_ns"`
+}
+
+// NewBenchmark starts a benchmark, sampling the heap until Report is called
+func NewBenchmark() *Benchmark {
+	bench := &Benchmark{start: time.Now(), stop: make(chan struct{}), done: make(chan struct{})}
+	go bench.sampleMemory()
+	return bench
+}
+
+// WithBenchmark records the batches written by the loader in bench
+func WithBenchmark(bench *Benchmark) Option {
+	return func(b *BigQueryLoader) {
+		b.benchmark = bench
+	}
+}
+
+func (bench *Benchmark) sampleMemory() {
+	defer close(bench.done)
+	ticker := time.NewTicker(benchmarkMemoryInterval)
+	defer ticker.Stop()
+	for {
+		bench.recordHeap()
+		select {
+		case <-bench.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (bench *Benchmark) recordHeap() {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	bench.lock.Lock()
+	defer bench.lock.Unlock()
+	bench.peakHeap = max(bench.peakHeap, stats.HeapAlloc)
+}
+
+// recordBatch records that rows rows were written to table in elapsed
+func (bench *Benchmark) recordBatch(table string, rows int, elapsed time.Duration) {
+	if bench == nil {
+		return
+	}
+	bench.lock.Lock()
+	defer bench.lock.Unlock()
+	bench.batches = append(bench.batches, benchmarkBatch{table: table, rows: rows, elapsed: elapsed})
+}
+
+// Report stops the benchmark and returns its throughput, with tables in the order they were first written to
+func (bench *Benchmark) Report() BenchmarkReport {
+	close(bench.stop)
+	<-bench.done
+	bench.recordHeap()
+
+	bench.lock.Lock()
+	defer bench.lock.Unlock()
+	report := BenchmarkReport{
+		Duration:      time.Since(bench.start),
+		Batches:       len(bench.batches),
+		PeakHeapBytes: bench.peakHeap,
+		Tables:        []TableThroughput{},
+	}
+	var all []time.Duration
+	latencies := map[string][]time.Duration{}
+	for _, batch := range bench.batches {
+		i := slices.IndexFunc(report.Tables, func(t TableThroughput) bool { return t.Table == batch.table })
+		if i < 0 {
+			report.Tables = append(report.Tables, TableThroughput{Table: batch.table})
+			i = len(report.Tables) - 1
+		}
+		report.Tables[i].Rows += batch.rows
+		report.Tables[i].Batches++
+		report.Tables[i].WriteTime += batch.elapsed
+		latencies[batch.table] = append(latencies[batch.table], batch.elapsed)
+		all = append(all, batch.elapsed)
+		report.Rows += batch.rows
+	}
+	for i := range report.Tables {
+		t := &report.Tables[i]
+		t.RowsPerSecond = perSecond(t.Rows, t.WriteTime)
+		t.BatchP50, t.BatchP95 = percentile(latencies[t.Table], 50), percentile(latencies[t.Table], 95)
+	}
+	report.RowsPerSecond = perSecond(report.Rows, report.Duration)
+	report.BatchP50, report.BatchP95 = percentile(all, 50), percentile(all, 95)
+	return report
+}
+
+func perSecond(rows int, elapsed time.Duration) float64 {
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(rows) / elapsed.Seconds()
+}
+
+// percentile returns the nearest-rank p-th percentile of latencies
+func percentile(latencies []time.Duration, p int) time.Duration {
+	if len(latencies) == 0 {
+		return 0
+	}
+	sorted := slices.Sorted(slices.Values(latencies))
+	rank := (p*len(sorted) + 99) / 100
+	return sorted[max(rank, 1)-1]
+}
@@ -6,14 +6,15 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"reflect"
 	"strings"
 	"time"
 
 	"cloud.google.com/go/bigquery"
-	"cloud.google.com/go/storage"
 
 	"github.com/sirupsen/logrus"
 	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
 
 	citoolsmetrics "github.com/openshift/ci-tools/pkg/metrics"
 )
@@ -24,38 +25,38 @@ const (
 
 // LeaseEventUnion holds all fields from both LeaseAcquisitionMetricEvent and LeaseReleaseMetricEvent
 type LeaseEventUnion struct {
-	LeaseName                    string    `json:"name,omitempty"`
-	Slice                        string    `json:"slice,omitempty"`
-	Region                       string    `json:"region,omitempty"`
-	RawLeaseName                 string    `json:"raw_lease_name,omitempty"`
-	AcquisitionDurationSeconds   float64   `json:"acquisition_duration_seconds,omitempty"`
-	ReleaseDurationSeconds       float64   `json:"release_duration_seconds,omitempty"`
-	LeasesRemainingAtAcquisition int       `json:"leases_remaining_at_acquisition,omitempty"`
-	LeasesAvailableAtRelease     int       `json:"leases_available_at_release,omitempty"`
-	LeasesTotal                  int       `json:"leases_total,omitempty"`
-	Released                     bool      `json:"released,omitempty"`
-	Error                        string    `json:"error,omitempty"`
-	Timestamp                    time.Time `json:"timestamp"`
+	LeaseName                    string    `json:"name,omitempty"`                            // Lease name parsed from RawLeaseName
+	Slice                        string    `json:"slice,omitempty"`                           // Quota slice parsed from RawLeaseName
+	Region                       string    `json:"region,omitempty"`                          // Region parsed from RawLeaseName
+	RawLeaseName                 string    `json:"raw_lease_name,omitempty"`                  // Lease name as reported by the lease server
+	AcquisitionDurationSeconds   float64   `json:"acquisition_duration_seconds,omitempty"`    // Time spent waiting to acquire the lease
+	ReleaseDurationSeconds       float64   `json:"release_duration_seconds,omitempty"`        // Time spent releasing the lease
+	LeasesRemainingAtAcquisition int       `json:"leases_remaining_at_acquisition,omitempty"` // Free leases of the pool when the lease was acquired
+	LeasesAvailableAtRelease     int       `json:"leases_available_at_release,omitempty"`     // Free leases of the pool when the lease was released
+	LeasesTotal                  int       `json:"leases_total,omitempty"`                    // Total leases of the pool
+	Released                     bool      `json:"released,omitempty"`                        // Set on release events, unset on acquisition events
+	Error                        string    `json:"error,omitempty"`                           // Error acquiring or releasing the lease, if any
+	Timestamp                    time.Time `json:"timestamp"`                                 // When the event was recorded
 }
 
 // ImageEventUnion holds all fields from both ImageStreamEvent and TagImportEvent
 type ImageEventUnion struct {
-	Namespace          string         `json:"namespace,omitempty"`
-	ImageStreamName    string         `json:"image_stream_name,omitempty"`
-	FullName           string         `json:"full_name,omitempty"`
-	TagName            string         `json:"tag_name,omitempty"`
-	FullTagName        string         `json:"full_tag_name,omitempty"`
-	SourceImage        string         `json:"source_image,omitempty"`
-	SourceImageKind    string         `json:"source_image_kind,omitempty"`
-	StartTime          time.Time      `json:"start_time,omitempty"`
-	CompletionTime     time.Time      `json:"completion_time,omitempty"`
-	DurationSeconds    float64        `json:"duration_seconds,omitempty"`
-	RetryCount         int            `json:"retry_count,omitempty"`
-	Success            bool           `json:"success,omitempty"`
-	Error              string         `json:"error,omitempty"`
-	ImageStreamDetails map[string]any `json:"image_stream_details,omitempty"`
-	AdditionalContext  map[string]any `json:"additional_context,omitempty"`
-	Timestamp          time.Time      `json:"timestamp"`
+	Namespace          string         `json:"namespace,omitempty"`            // Namespace of the image stream
+	ImageStreamName    string         `json:"image_stream_name,omitempty"`    // Name of the image stream
+	FullName           string         `json:"full_name,omitempty"`            // Namespaced name of the image stream
+	TagName            string         `json:"tag_name,omitempty"`             // Tag imported, on tag import events
+	FullTagName        string         `json:"full_tag_name,omitempty"`        // Image stream tag imported, as name:tag
+	SourceImage        string         `json:"source_image,omitempty"`         // Pull spec the tag was imported from
+	SourceImageKind    string         `json:"source_image_kind,omitempty"`    // Kind of the import source, such as DockerImage
+	StartTime          time.Time      `json:"start_time,omitempty"`           // When the import or image stream operation started
+	CompletionTime     time.Time      `json:"completion_time,omitempty"`      // When the import or image stream operation finished
+	DurationSeconds    float64        `json:"duration_seconds,omitempty"`     // Time between StartTime and CompletionTime
+	RetryCount         int            `json:"retry_count,omitempty"`          // Number of retries before the operation finished
+	Success            bool           `json:"success,omitempty"`              // Whether the operation succeeded
+	Error              string         `json:"error,omitempty"`                // Error of a failed operation
+	ImageStreamDetails map[string]any `json:"image_stream_details,omitempty"` // Status of the image stream, as reported by the API server
+	AdditionalContext  map[string]any `json:"additional_context,omitempty"`   // Free-form producer context
+	Timestamp          time.Time      `json:"timestamp"`                      // When the event was recorded
 }
 
 // MetricsData represents the complete metrics JSON structure
@@ -67,278 +68,621 @@ type MetricsData struct {
 	OpenshiftBuilds      []*citoolsmetrics.BuildEvent               `json:"openshift_builds"`
 	Pods                 []*citoolsmetrics.PodLifecycleMetricsEvent `json:"pods"`
 	TestPlatformInsights []*citoolsmetrics.InsightsEvent            `json:"test_platform_insights"`
+
+	// raw holds the record every row was decoded from, keyed by the row, with DecodeOptions.PreserveRaw
+	raw map[any]json.RawMessage
+	// badRecords holds the records skipped with DecodeOptions.LenientDecode
+	badRecords []BadRecord
+	// envelope holds the DecodeOptions.EnvelopeFields of the file, keyed by field
+	envelope map[string]string
+	// allowNonFinite decodes NaN and infinite floats, with DecodeOptions.AllowNonFiniteFloats
+	allowNonFinite bool
 }
 
 // BigQueryLoader handles loading metrics data into BigQuery
 type BigQueryLoader struct {
-	ctx       context.Context
-	bqClient  *bigquery.Client
-	projectID string
-	datasetID string
-	logger    *logrus.Entry
+	ctx              context.Context
+	bqClient         *bigquery.Client
+	projectID        string
+	datasetID        string
+	logger           *logrus.Entry
+	useWriteAPI      bool
+	shards           map[string]shardConfig
+	breaker          *CircuitBreaker
+	transforms       []rowTransform
+	previous         *MetricsData
+	decodeOpts       DecodeOptions
+	decodeRetries    int
+	decodeRetryDelay time.Duration
+	// datasetMetadata is set when the dataset should be created if missing
+	datasetMetadata *bigquery.DatasetMetadata
+	datasetEnsured  bool
+	deadLetter      *DeadLetterWriter
+	// skipInvalidRows inserts the valid rows of a batch and skips the ones BigQuery rejects
+	skipInvalidRows bool
+	timestampWindow *timestampWindow
+	// preserveRaw stores the record every row was decoded from in the raw_json column
+	preserveRaw bool
+	// fanOut lists the datasets written to after the dataset, with strictFanOut failing the load when one fails
+	fanOut       []DatasetRef
+	strictFanOut bool
+	// benchmark, when set, times every batch written
+	benchmark *Benchmark
+	// sampler, when set, keeps a random subset of the rows of every table
+	sampler *rowSampler
+	// encryption is set on every table the loader creates
+	encryption *bigquery.EncryptionConfig
+	// upsertKeys holds the key columns of the tables that are merged into instead of appended to
+	upsertKeys map[string][]string
+	// rangePartitions holds the integer range partitioning of the tables created with it
+	rangePartitions map[string]RangePartition
+	// splitUnions loads the lease and image union tables as one table per original event type
+	splitUnions bool
+	// unifiedTable, when set, receives the rows of every table instead of the per-type tables
+	unifiedTable string
+	// tableSuffix is appended to the name of every table written to
+	tableSuffix string
+	// requiredTables lists the tables every metrics file must hold rows for
+	requiredTables []string
+	// dropTablesPrefix, when set, drops every table written to before it is created, refusing the tables whose
+	// dataset.table name does not start with it
+	dropTablesPrefix string
+	// creationGuard remembers which tables were already created during this run
+	creationGuard *TableCreationGuard
+	// preflightSchemaCheck compares the rows with the schema of the existing tables before inserting anything
+	preflightSchemaCheck bool
+	// schemaBaseline, when set, is compared with the schema of every table loaded
+	schemaBaseline  *SchemaBaseline
+	envelopeColumns []EnvelopeColumn
+	tableResolver   TableResolver
+	// permissionWait bounds how long requests failing with a permission error are retried
+	permissionWait time.Duration
+	// quotaCooldown, when set, pauses the inserts of every loader sharing it after a quota error
+	quotaCooldown *QuotaCooldown
+	// insertLimiter, when set, bounds the concurrent inserts into each table across loaders
+	insertLimiter *TableInsertLimiter
+	// auditStream, when set, is the stream WriteAuditRecord appends to, shared across loaders
+	auditStream *AuditStream
+	// defaultTimestamp fills in zero row timestamps with the load time
+	defaultTimestamp bool
+	// fieldDescriptions sets column descriptions from the comments of the struct fields
+	fieldDescriptions bool
+	// writeAPIClientOpts configure every Storage Write API client the loader creates
+	writeAPIClientOpts []option.ClientOption
+	// clock is consulted by all time-dependent logic so tests can pin the current time
+	clock func() time.Time
 }
 
-// NewBigQueryLoader creates a new BigQuery loader
-func NewBigQueryLoader(ctx context.Context, bqClient *bigquery.Client, projectID, datasetID string) *BigQueryLoader {
-	return &BigQueryLoader{
-		ctx:       ctx,
-		bqClient:  bqClient,
-		projectID: projectID,
-		datasetID: datasetID,
-		logger:    logrus.WithField("component", "bigqueryLoader"),
+// Option configures optional BigQueryLoader behavior
+type Option func(*BigQueryLoader)
+
+// WithWriteAPI makes the loader append rows through the BigQuery Storage Write API
+// instead of legacy streaming inserts
+func WithWriteAPI(enabled bool) Option {
+	return func(b *BigQueryLoader) {
+		b.useWriteAPI = enabled
 	}
 }
 
-// LoadMetricsData loads the metrics file into BigQuery
-func (b *BigQueryLoader) LoadMetricsData(data *MetricsData) error {
-	dataset := b.bqClient.Dataset(b.datasetID)
-
-	if err := b.loadImages(dataset, data.Images); err != nil {
-		return fmt.Errorf("failed to load images: %w", err)
-	}
-	if err := b.loadNodes(dataset, data.Nodes); err != nil {
-		return fmt.Errorf("failed to load nodes: %w", err)
-	}
-	if err := b.loadInsights(dataset, data.TestPlatformInsights); err != nil {
-		return fmt.Errorf("failed to load insights: %w", err)
-	}
-	if err := b.loadLeases(dataset, data.Leases); err != nil {
-		return fmt.Errorf("failed to load leases: %w", err)
-	}
-	if err := b.loadBuilds(dataset, data.OpenshiftBuilds); err != nil {
-		return fmt.Errorf("failed to load builds: %w", err)
-	}
-	if err := b.loadPods(dataset, data.Pods); err != nil {
-		return fmt.Errorf("failed to load pods: %w", err)
-	}
-	if err := b.loadEvents(dataset, data.Events); err != nil {
-		return fmt.Errorf("failed to load events: %w", err)
+// WithTableSuffix appends suffix to every table the loader creates or inserts into, so that a file can be
+// reprocessed into fresh tables (events_reprocess) while the production tables stay untouched.
+// Shard tables keep their shard index last (events_reprocess_3).
+func WithTableSuffix(suffix string) Option {
+	return func(b *BigQueryLoader) {
+		b.tableSuffix = suffix
 	}
+}
 
-	return nil
+// WithKMSKey encrypts every table the loader creates with the given Cloud KMS key
+// (projects/<p>/locations/<l>/keyRings/<r>/cryptoKeys/<k>). Tables that already exist keep their encryption.
+func WithKMSKey(keyName string) Option {
+	return func(b *BigQueryLoader) {
+		if keyName != "" {
+			b.encryption = &bigquery.EncryptionConfig{KMSKeyName: keyName}
+		}
+	}
 }
 
-// LoadFromGCS loads metrics from a GCS file
-func (b *BigQueryLoader) LoadFromGCS(bucket, object string) error {
-	gcsClient, err := storage.NewClient(b.ctx)
-	if err != nil {
-		return fmt.Errorf("failed to create GCS client: %w", err)
+// WithClock replaces time.Now as the source of the current time
+func WithClock(clock func() time.Time) Option {
+	return func(b *BigQueryLoader) {
+		b.clock = clock
 	}
-	defer gcsClient.Close()
+}
 
-	obj := gcsClient.Bucket(bucket).Object(object)
-	reader, err := obj.NewReader(b.ctx)
-	if err != nil {
-		return fmt.Errorf("failed to open GCS object: %w", err)
+// WithCircuitBreaker keeps loading the remaining tables after a table fails, counting every failure in breaker
+// and aborting once it trips. Without a breaker the first failed table aborts the load.
+func WithCircuitBreaker(breaker *CircuitBreaker) Option {
+	return func(b *BigQueryLoader) {
+		b.breaker = breaker
 	}
-	defer reader.Close()
+}
 
-	var data MetricsData
-	if err := json.NewDecoder(reader).Decode(&data); err != nil {
-		return fmt.Errorf("failed to decode JSON from GCS: %w", err)
+// WithDecodeOptions applies opts when the loader reads a metrics file itself, as LoadFromGCS does
+func WithDecodeOptions(opts DecodeOptions) Option {
+	return func(b *BigQueryLoader) {
+		b.decodeOpts = opts
 	}
+}
 
-	return b.LoadMetricsData(&data)
+// NewBigQueryLoader creates a new BigQuery loader. ctx is only used by the methods that do not take a
+// context of their own; new code should call the Context variants instead.
+func NewBigQueryLoader(ctx context.Context, bqClient *bigquery.Client, projectID, datasetID string, opts ...Option) *BigQueryLoader {
+	b := &BigQueryLoader{
+		ctx:           ctx,
+		bqClient:      bqClient,
+		projectID:     projectID,
+		datasetID:     datasetID,
+		logger:        logrus.WithField("component", "bigqueryLoader"),
+		clock:         time.Now,
+		creationGuard: NewTableCreationGuard(),
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
 }
 
-func (b *BigQueryLoader) loadImages(dataset *bigquery.Dataset, images []*ImageEventUnion) error {
-	if len(images) == 0 {
-		return nil
-	}
+// TableResult describes the outcome of loading a single table
+type TableResult struct {
+	Table        string `json:"table"`
+	RowsInserted int    `json:"rows_inserted"`
+	// Skipped is set when nothing was created or inserted for the table, either because the file
+	// held no rows for it or because it was unchanged since the previous file
+	Skipped bool `json:"skipped,omitempty"`
+	// Unchanged is set when the table was skipped because its rows match the previous file
+	Unchanged bool `json:"unchanged,omitempty"`
+	// InvalidRows counts the rows BigQuery rejected and that were skipped with WithSkipInvalidRows
+	InvalidRows int `json:"invalid_rows,omitempty"`
+	// DroppedRows counts the rows dropped before insert by WithNonFiniteFloats
+	DroppedRows int `json:"dropped_rows,omitempty"`
+	// SuspectTimestamps counts the rows with a timestamp outside the window set by WithTimestampWindow
+	SuspectTimestamps int `json:"suspect_timestamps,omitempty"`
+	// SampledFrom is the number of rows the table held before WithSampleRate kept a subset of them
+	SampledFrom int `json:"sampled_from,omitempty"`
+	// Error is set when loading the table failed and the load carried on with the remaining tables
+	Error string `json:"error,omitempty"`
+}
 
-	table := dataset.Table("images")
-	inserter := table.Inserter()
+// LoadResult summarizes a load across every table, including the ones that had no rows
+type LoadResult struct {
+	Tables []TableResult `json:"tables"`
+	// BadRecords counts the records that failed to decode and were skipped with DecodeOptions.LenientDecode
+	BadRecords int `json:"bad_records,omitempty"`
+}
 
-	schema, err := bigquery.InferSchema(ImageEventUnion{})
-	if err != nil {
-		return fmt.Errorf("failed to infer schema: %w", err)
+// TablesWithData returns how many tables had rows inserted
+func (r *LoadResult) TablesWithData() int {
+	count := 0
+	for _, t := range r.Tables {
+		if !t.Skipped && t.Error == "" {
+			count++
+		}
 	}
+	return count
+}
 
-	if err := table.Create(b.ctx, &bigquery.TableMetadata{Schema: schema}); err != nil {
-		if !isAlreadyExistsError(err) {
-			return fmt.Errorf("failed to create table: %w", err)
+// FailedTables returns the names of the tables that failed to load
+func (r *LoadResult) FailedTables() []string {
+	var failed []string
+	for _, t := range r.Tables {
+		if t.Error != "" {
+			failed = append(failed, t.Table)
 		}
-		b.logger.Debug("Table already exists")
 	}
+	return failed
+}
 
-	if err := inserter.Put(b.ctx, images); err != nil {
-		return fmt.Errorf("failed to insert images: %w", err)
+// Merge folds the per-table outcome of another load of the same run into r, summing the counts of tables present in both
+func (r *LoadResult) Merge(other *LoadResult) {
+	if other == nil {
+		return
+	}
+	r.BadRecords += other.BadRecords
+	for _, t := range other.Tables {
+		found := false
+		for i := range r.Tables {
+			if r.Tables[i].Table != t.Table {
+				continue
+			}
+			found = true
+			r.Tables[i].RowsInserted += t.RowsInserted
+			r.Tables[i].InvalidRows += t.InvalidRows
+			r.Tables[i].DroppedRows += t.DroppedRows
+			r.Tables[i].SuspectTimestamps += t.SuspectTimestamps
+			r.Tables[i].Skipped = r.Tables[i].Skipped && t.Skipped
+			r.Tables[i].Unchanged = r.Tables[i].Unchanged && t.Unchanged
+			if t.Error != "" {
+				r.Tables[i].Error = t.Error
+			}
+		}
+		if !found {
+			r.Tables = append(r.Tables, t)
+		}
 	}
-
-	b.logger.Infof("Loaded %d images into BigQuery", len(images))
-	return nil
 }
 
-func (b *BigQueryLoader) loadNodes(dataset *bigquery.Dataset, nodes []*citoolsmetrics.NodeEvent) error {
-	if len(nodes) == 0 {
-		return nil
-	}
+// LoadMetricsData loads the metrics file into BigQuery using the context the loader was created with.
+// Use LoadMetricsDataWithResult for the outcome of every table, and LoadMetricsDataContext for loaders that serve
+// many requests.
+func (b *BigQueryLoader) LoadMetricsData(data *MetricsData) error {
+	_, err := b.LoadMetricsDataWithResult(data)
+	return err
+}
 
-	table := dataset.Table("nodes")
-	inserter := table.Inserter()
+// LoadMetricsDataWithResult is LoadMetricsData returning the outcome of every table
+func (b *BigQueryLoader) LoadMetricsDataWithResult(data *MetricsData) (*LoadResult, error) {
+	return b.LoadMetricsDataContext(b.ctx, data)
+}
 
-	schema, err := bigquery.InferSchema(citoolsmetrics.NodeEvent{})
+// LoadMetricsDataContext loads the metrics file into BigQuery, bounding every API call by ctx
+func (b *BigQueryLoader) LoadMetricsDataContext(ctx context.Context, data *MetricsData) (*LoadResult, error) {
+	if err := b.checkRequiredTables(data); err != nil {
+		return nil, err
+	}
+	result, err := b.loadData(ctx, data)
 	if err != nil {
-		return fmt.Errorf("failed to infer schema: %w", err)
+		return result, err
 	}
+	b.logSummary(result)
+	return result, nil
+}
 
-	if err := table.Create(b.ctx, &bigquery.TableMetadata{Schema: schema}); err != nil {
-		if !isAlreadyExistsError(err) {
-			return fmt.Errorf("failed to create table: %w", err)
-		}
-		b.logger.Debug("Table already exists")
-	}
+func (b *BigQueryLoader) logSummary(result *LoadResult) {
+	b.logger.Infof("%d tables had data, %d had none", result.TablesWithData(), len(result.Tables)-result.TablesWithData())
+}
 
-	if err := inserter.Put(b.ctx, nodes); err != nil {
-		return fmt.Errorf("failed to insert nodes: %w", err)
+// loadData loads data into the dataset and, once that succeeded, into the datasets set with WithAlsoWriteTo.
+// Failures because the BigQuery API is not enabled are returned as an APIDisabledError.
+func (b *BigQueryLoader) loadData(ctx context.Context, data *MetricsData) (*LoadResult, error) {
+	badRecords := b.reportBadRecords(ctx, data)
+	result, err := b.loadDataset(ctx, data)
+	if result != nil {
+		result.BadRecords = badRecords
 	}
-
-	b.logger.Infof("Loaded %d nodes into BigQuery", len(nodes))
-	return nil
+	if err != nil || len(b.fanOut) == 0 {
+		return result, explainAPIDisabled("BigQuery", err)
+	}
+	return result, explainAPIDisabled("BigQuery", b.fanOutData(ctx, data))
 }
 
-func (b *BigQueryLoader) loadInsights(dataset *bigquery.Dataset, insights []*citoolsmetrics.InsightsEvent) error {
-	if len(insights) == 0 {
-		return nil
+// loadDataset loads every table of data into the dataset, recording the outcome of each one
+func (b *BigQueryLoader) loadDataset(ctx context.Context, data *MetricsData) (*LoadResult, error) {
+	dataset := b.bqClient.DatasetInProject(b.projectID, b.datasetID)
+	if err := b.ensureDataset(ctx, dataset); err != nil {
+		return nil, err
+	}
+	if b.preflightSchemaCheck {
+		if err := b.preflightSchemas(ctx, dataset, data); err != nil {
+			return nil, err
+		}
 	}
 
-	table := dataset.Table("test_platform_insights")
-	inserter := table.Inserter()
+	var writer *writeAPIClient
+	if b.useWriteAPI {
+		w, err := newWriteAPIClient(ctx, b.projectID, b.writeAPIClientOpts...)
+		if err != nil {
+			b.logger.WithError(err).Warn("Failed to set up the Storage Write API, falling back to streaming inserts")
+		} else {
+			defer w.close()
+			writer = w
+		}
+	}
 
-	schema, err := bigquery.InferSchema(citoolsmetrics.InsightsEvent{})
+	unchanged, err := unchangedTables(data, b.previous)
 	if err != nil {
-		return fmt.Errorf("failed to infer schema: %w", err)
+		return nil, fmt.Errorf("failed to compare against the previous file: %w", err)
 	}
 
-	if err := table.Create(b.ctx, &bigquery.TableMetadata{Schema: schema}); err != nil {
-		if !isAlreadyExistsError(err) {
-			return fmt.Errorf("failed to create table: %w", err)
+	loadTime := b.clock()
+	result := &LoadResult{}
+	unified := tableData{name: b.unifiedTable, schema: unifiedSchema}
+	unifiedSuspect := 0
+	var failures []error
+	for _, t := range b.tablesOf(data) {
+		if b.breaker != nil && b.breaker.Tripped() {
+			return result, fmt.Errorf("%w after %d errors", ErrCircuitBreakerTripped, b.breaker.Errors())
+		}
+		if len(t.rows) == 0 {
+			b.logger.Debugf("No rows for table %s", t.name)
+			result.Tables = append(result.Tables, TableResult{Table: t.name, Skipped: true})
+			continue
+		}
+		if unchanged[t.name] || unchanged[t.splitFrom] {
+			b.logger.Infof("Table %s is unchanged since the previous file, skipping", t.name)
+			result.Tables = append(result.Tables, TableResult{Table: t.name, Skipped: true, Unchanged: true})
+			continue
 		}
-		b.logger.Debug("Table already exists")
+		if b.defaultTimestamp {
+			if defaulted := defaultTimestamps(t.rows, loadTime); defaulted > 0 {
+				b.logger.Infof("Defaulted the timestamp of %d %s rows to the load time", defaulted, t.name)
+			}
+		}
+		suspect := 0
+		if b.timestampWindow != nil {
+			if t.rows, suspect = b.timestampWindow.filter(t.rows, loadTime); suspect > 0 {
+				action := "loading them anyway"
+				if b.timestampWindow.strict {
+					action = "dropping them"
+				}
+				b.logger.Warnf("Found %d %s rows with timestamps outside the valid window, %s", suspect, t.name, action)
+			}
+			unifiedSuspect += suspect
+			if len(t.rows) == 0 {
+				result.Tables = append(result.Tables, TableResult{Table: t.name, Skipped: true, SuspectTimestamps: suspect})
+				continue
+			}
+		}
+		sampledFrom := 0
+		if b.sampler != nil {
+			sampledFrom = len(t.rows)
+			t.rows = b.sampler.sample(t.name, t.rows)
+			b.logger.Infof("Sampled %d of %d %s rows", len(t.rows), sampledFrom, t.name)
+			if len(t.rows) == 0 {
+				result.Tables = append(result.Tables, TableResult{Table: t.name, Skipped: true, SuspectTimestamps: suspect, SampledFrom: sampledFrom})
+				continue
+			}
+		}
+		if b.unifiedTable != "" {
+			rows, err := unifiedRows(t)
+			if err != nil {
+				return result, err
+			}
+			unified.rows = append(unified.rows, rows...)
+			continue
+		}
+		invalidRows, droppedRows, err := b.loadTable(ctx, dataset, t, writer)
+		if err != nil {
+			err = fmt.Errorf("failed to load %s: %w", t.name, err)
+			if b.breaker == nil {
+				return result, err
+			}
+			result.Tables = append(result.Tables, TableResult{Table: t.name, SuspectTimestamps: suspect, SampledFrom: sampledFrom, Error: err.Error()})
+			if tripped := b.breaker.Record(err); tripped != nil {
+				return result, tripped
+			}
+			b.logger.WithError(err).Error("Failed to load table, continuing with the remaining tables")
+			failures = append(failures, err)
+			continue
+		}
+		result.Tables = append(result.Tables, TableResult{Table: t.name, RowsInserted: len(t.rows) - droppedRows - invalidRows, InvalidRows: invalidRows, DroppedRows: droppedRows, SuspectTimestamps: suspect, SampledFrom: sampledFrom})
 	}
-
-	if err := inserter.Put(b.ctx, insights); err != nil {
-		return fmt.Errorf("failed to insert insights: %w", err)
+	if b.unifiedTable != "" {
+		return b.loadUnified(ctx, dataset, unified, unifiedSuspect, writer)
 	}
-
-	b.logger.Infof("Loaded %d insights into BigQuery", len(insights))
-	return nil
+	if len(failures) > 0 {
+		return result, errors.Join(failures...)
+	}
+	return result, nil
 }
 
-func (b *BigQueryLoader) loadLeases(dataset *bigquery.Dataset, leases []*LeaseEventUnion) error {
-	if len(leases) == 0 {
-		return nil
+// loadUnified loads the rows gathered from every table into the unified table, reporting it as the only table
+func (b *BigQueryLoader) loadUnified(ctx context.Context, dataset *bigquery.Dataset, unified tableData, suspect int, writer *writeAPIClient) (*LoadResult, error) {
+	if len(unified.rows) == 0 {
+		return &LoadResult{Tables: []TableResult{{Table: unified.name, Skipped: true, SuspectTimestamps: suspect}}}, nil
 	}
-
-	table := dataset.Table("leases")
-	inserter := table.Inserter()
-
-	schema, err := bigquery.InferSchema(LeaseEventUnion{})
+	invalidRows, droppedRows, err := b.loadTable(ctx, dataset, unified, writer)
 	if err != nil {
-		return fmt.Errorf("failed to infer schema: %w", err)
-	}
-
-	if err := table.Create(b.ctx, &bigquery.TableMetadata{Schema: schema}); err != nil {
-		if !isAlreadyExistsError(err) {
-			return fmt.Errorf("failed to create table: %w", err)
-		}
-		b.logger.Debug("Table already exists")
+		err = fmt.Errorf("failed to load %s: %w", unified.name, err)
+		return &LoadResult{Tables: []TableResult{{Table: unified.name, SuspectTimestamps: suspect, Error: err.Error()}}}, err
 	}
+	return &LoadResult{Tables: []TableResult{{Table: unified.name, RowsInserted: len(unified.rows) - droppedRows - invalidRows, InvalidRows: invalidRows, DroppedRows: droppedRows, SuspectTimestamps: suspect}}}, nil
+}
 
-	if err := inserter.Put(b.ctx, leases); err != nil {
-		return fmt.Errorf("failed to insert leases: %w", err)
-	}
+// LoadFromGCS loads metrics from a GCS file using the context the loader was created with.
+// Use LoadFromGCSWithResult for the outcome of every table, and LoadFromGCSContext for loaders that serve many
+// requests.
+func (b *BigQueryLoader) LoadFromGCS(bucket, object string) error {
+	_, err := b.LoadFromGCSWithResult(bucket, object)
+	return err
+}
 
-	b.logger.Infof("Loaded %d leases into BigQuery", len(leases))
-	return nil
+// LoadFromGCSWithResult is LoadFromGCS returning the outcome of every table
+func (b *BigQueryLoader) LoadFromGCSWithResult(bucket, object string) (*LoadResult, error) {
+	return b.LoadFromGCSContext(b.ctx, bucket, object)
 }
 
-func (b *BigQueryLoader) loadBuilds(dataset *bigquery.Dataset, builds []*citoolsmetrics.BuildEvent) error {
-	if len(builds) == 0 {
-		return nil
+// LoadFromGCSContext loads metrics from a GCS file, bounding the download and every API call by ctx
+func (b *BigQueryLoader) LoadFromGCSContext(ctx context.Context, bucket, object string) (*LoadResult, error) {
+	data, err := RetryDecode(ctx, b.decodeRetries, b.decodeRetryDelay, b.logger, func() (data *MetricsData, err error) {
+		err = b.retryPermissions(ctx, func() (err error) {
+			data, err = readMetricsFromGCS(ctx, bucket, object, b.decodeOpts)
+			return err
+		})
+		return data, err
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	table := dataset.Table("openshift_builds")
-	inserter := table.Inserter()
+	return b.LoadMetricsDataContext(ctx, data)
+}
 
-	schema, err := bigquery.InferSchema(citoolsmetrics.BuildEvent{})
+// loadTable creates the table if needed and inserts its rows, using the Storage Write API when a writer is given.
+// Sharded tables are split into one create and insert per shard that received rows. It returns how many rows
+// were skipped as invalid, and how many the transforms dropped before insert.
+func (b *BigQueryLoader) loadTable(ctx context.Context, dataset *bigquery.Dataset, t tableData, writer *writeAPIClient) (int, int, error) {
+	schema, rows, err := b.prepareTable(t)
 	if err != nil {
-		return fmt.Errorf("failed to infer schema: %w", err)
+		return 0, 0, err
 	}
-
-	if err := table.Create(b.ctx, &bigquery.TableMetadata{Schema: schema}); err != nil {
-		if !isAlreadyExistsError(err) {
-			return fmt.Errorf("failed to create table: %w", err)
-		}
-		b.logger.Debug("Table already exists")
+	dropped := len(t.rows) - len(rows)
+	changes, err := b.schemaBaseline.observe(t.name, schema)
+	if err != nil {
+		return 0, dropped, err
 	}
-
-	if err := inserter.Put(b.ctx, builds); err != nil {
-		return fmt.Errorf("failed to insert builds: %w", err)
+	for _, change := range changes {
+		b.logger.WithFields(logrus.Fields{"field": change.Field, "from": change.From, "to": change.To}).Warnf("Schema differs from the baseline: %s", change)
 	}
 
-	b.logger.Infof("Loaded %d builds into BigQuery", len(builds))
-	return nil
-}
+	partitioning, err := b.rangePartitioning(t.name, schema)
+	if err != nil {
+		return 0, dropped, err
+	}
 
-func (b *BigQueryLoader) loadPods(dataset *bigquery.Dataset, pods []*citoolsmetrics.PodLifecycleMetricsEvent) error {
-	if len(pods) == 0 {
-		return nil
+	name := t.name + b.tableSuffix
+	targets := []tableTarget{{table: name, rows: rows}}
+	if sharding, ok := b.shards[t.name]; ok {
+		targets, err = sharding.route(name, schema, rows)
+		if err != nil {
+			return 0, dropped, err
+		}
 	}
 
-	table := dataset.Table("pods")
-	inserter := table.Inserter()
+	invalidRows := 0
+	for _, target := range targets {
+		targetDataset, tableID, err := b.resolveTable(dataset, target.table)
+		if err != nil {
+			return invalidRows, dropped, err
+		}
+		target.table = tableID
+		table := targetDataset.Table(tableID)
+		var created bool
+		err = b.retryPermissions(ctx, func() (err error) {
+			created, err = b.creationGuard.ensure(ctx, table, &bigquery.TableMetadata{Schema: schema, EncryptionConfig: b.encryption, RangePartitioning: partitioning}, b.dropTable())
+			return err
+		})
+		if err != nil {
+			return invalidRows, dropped, err
+		}
+		if !created {
+			b.logger.Debugf("Table %s already exists", target.table)
+		}
 
-	schema, err := bigquery.InferSchema(citoolsmetrics.PodLifecycleMetricsEvent{})
-	if err != nil {
-		return fmt.Errorf("failed to infer schema: %w", err)
-	}
+		if err := b.quotaCooldown.wait(ctx); err != nil {
+			return invalidRows, dropped, err
+		}
+		release, err := b.insertLimiter.acquire(ctx, table)
+		if err != nil {
+			return invalidRows, dropped, fmt.Errorf("failed to wait for an insert slot of %s: %w", target.table, err)
+		}
+		if key, ok := b.upsertKeys[t.name]; ok {
+			start := b.clock()
+			err := b.upsert(ctx, targetDataset, table, schema, target.rows, key)
+			release()
+			if err != nil {
+				b.quotaCooldown.observe(err)
+				return invalidRows, dropped, err
+			}
+			b.benchmark.recordBatch(target.table, len(target.rows), b.clock().Sub(start))
+			continue
+		}
 
-	if err := table.Create(b.ctx, &bigquery.TableMetadata{Schema: schema}); err != nil {
-		if !isAlreadyExistsError(err) {
-			return fmt.Errorf("failed to create table: %w", err)
+		skipped, err := b.insertRows(ctx, table, schema, target.rows, writer)
+		release()
+		if err != nil {
+			b.quotaCooldown.observe(err)
+			b.deadLetterRows(ctx, target.table, schema, target.rows, err)
+			return invalidRows, dropped, fmt.Errorf("failed to insert %s: %w", target.table, err)
+		}
+		if len(skipped) > 0 {
+			b.logger.Warnf("Skipped %d invalid %s rows", len(skipped), target.table)
+			b.deadLetterRows(ctx, target.table, schema, target.rows, skipped)
+			invalidRows += len(skipped)
 		}
-		b.logger.Debug("Table already exists")
-	}
 
-	if err := inserter.Put(b.ctx, pods); err != nil {
-		return fmt.Errorf("failed to insert pods: %w", err)
+		b.logger.Infof("Loaded %d %s into BigQuery", len(target.rows)-len(skipped), target.table)
 	}
-
-	b.logger.Infof("Loaded %d pods into BigQuery", len(pods))
-	return nil
+	return invalidRows, dropped, nil
 }
 
-func (b *BigQueryLoader) loadEvents(dataset *bigquery.Dataset, events []*citoolsmetrics.Event) error {
-	if len(events) == 0 {
-		return nil
+// prepareTable infers the schema of a table and runs its rows through the configured transforms
+func (b *BigQueryLoader) prepareTable(t tableData) (bigquery.Schema, []any, error) {
+	schema := t.schema
+	if schema == nil {
+		inferred, err := inferSchema(t.row)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to infer schema: %w", err)
+		}
+		schema = nullableJSONColumns(inferred)
+		if b.fieldDescriptions {
+			schema = describeFields(schema, reflect.TypeOf(t.row))
+		}
+	}
+	rows := t.rows
+	if b.preserveRaw && t.schema == nil {
+		schema, rows = withRawColumn(t, schema)
+	}
+	if len(b.envelopeColumns) > 0 && t.schema == nil {
+		var err error
+		if schema, rows, err = withEnvelopeColumns(t, schema, rows, b.envelopeColumns); err != nil {
+			return nil, nil, err
+		}
 	}
 
-	table := dataset.Table("events")
-	inserter := table.Inserter()
-
-	schema, err := bigquery.InferSchema(citoolsmetrics.Event{})
+	schema, rows, err := b.applyTransforms(t.name, schema, rows)
 	if err != nil {
-		return fmt.Errorf("failed to infer schema: %w", err)
+		return nil, nil, fmt.Errorf("failed to transform rows: %w", err)
 	}
+	return schema, rows, nil
+}
 
-	if err := table.Create(b.ctx, &bigquery.TableMetadata{Schema: schema}); err != nil {
-		if !isAlreadyExistsError(err) {
-			return fmt.Errorf("failed to create table: %w", err)
+// insertRows writes rows through the Storage Write API when available, and through streaming inserts otherwise.
+// With WithSkipInvalidRows, the rows BigQuery rejects are returned instead of failing the insert.
+func (b *BigQueryLoader) insertRows(ctx context.Context, table *bigquery.Table, schema bigquery.Schema, rows []any, writer *writeAPIClient) (bigquery.PutMultiError, error) {
+	if writer != nil {
+		stream, err := writer.newStream(ctx, table.DatasetID, table.TableID, schema)
+		if err == nil {
+			defer stream.close()
+			stream.observe = func(rows int, elapsed time.Duration) {
+				b.benchmark.recordBatch(table.TableID, rows, elapsed)
+			}
+			stream.clock = b.clock
+			return b.skipInvalid(stream.appendRows(ctx, rows), func(rowErrors bigquery.PutMultiError) error {
+				if valid := uncommittedRows(rows, rowErrors); len(valid) > 0 {
+					return stream.appendRows(ctx, valid)
+				}
+				return nil
+			})
 		}
-		b.logger.Debug("Table already exists")
+		b.logger.WithError(err).Warnf("Failed to open a Storage Write API stream for %s, falling back to streaming inserts", table.TableID)
 	}
-
-	if err := inserter.Put(b.ctx, events); err != nil {
-		return fmt.Errorf("failed to insert events: %w", err)
+	inserter := table.Inserter()
+	inserter.SkipInvalidRows = b.skipInvalidRows
+	// every attempt waits out missing permissions, including the ones sent again after a quota cool-down
+	put := func() error {
+		return b.retryPermissions(ctx, func() error { return inserter.Put(ctx, rows) })
+	}
+	start := b.clock()
+	err := put()
+	// a request rejected on a quota inserted nothing, so it is sent again once the cool-down is over
+	for retries := 0; retries < maxQuotaRetries && b.quotaCooldown.observe(err); retries++ {
+		if err := b.quotaCooldown.wait(ctx); err != nil {
+			return nil, err
+		}
+		start = b.clock()
+		err = put()
+	}
+	if err == nil {
+		b.benchmark.recordBatch(table.TableID, len(rows), b.clock().Sub(start))
 	}
+	return b.skipInvalid(err, nil)
+}
 
-	b.logger.Infof("Loaded %d events into BigQuery", len(events))
-	return nil
+// skipInvalid turns the row errors of an insert into skipped rows when WithSkipInvalidRows is set. Streaming
+// inserts have already written the valid rows; the Write API rejects the batches holding an invalid row, so
+// retry, when set, is given the row errors to write the valid rows of those batches again.
+func (b *BigQueryLoader) skipInvalid(err error, retry func(rowErrors bigquery.PutMultiError) error) (bigquery.PutMultiError, error) {
+	var rowErrors bigquery.PutMultiError
+	if err == nil || !b.skipInvalidRows || !errors.As(err, &rowErrors) {
+		return nil, err
+	}
+	if retry != nil {
+		if err := retry(rowErrors); err != nil {
+			return nil, fmt.Errorf("failed to append the valid rows: %w", err)
+		}
+	}
+	return rowErrors, nil
 }
 
 func IsMetricsFile(name string) bool {
-	return strings.HasSuffix(name, MetricsFileName)
+	return strings.HasSuffix(name, MetricsFileName) || strings.HasSuffix(name, MetricsFileName+ZstdSuffix)
+}
+
+// isNotFoundError checks if the error indicates the resource does not exist
+func isNotFoundError(err error) bool {
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound
 }
 
 // isAlreadyExistsError checks if the error indicates the resource already exists
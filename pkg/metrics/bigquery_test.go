@@ -0,0 +1,198 @@
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"google.golang.org/api/option"
+)
+
+// fakeBigQuery is a BigQuery REST server answering table creations with 409, as for tables that already exist,
+// and accepting every streaming insert, recording the rows inserted keyed by the path of their table
+type fakeBigQuery struct {
+	lock     sync.Mutex
+	inserted map[string][]map[string]json.RawMessage
+	created  []string
+	// insertFailures fail the first streaming inserts, one each, before the next ones are accepted
+	insertFailures []fakeInsertFailure
+	insertRequests int
+	// onInsert, when set, is called for every streaming insert
+	onInsert func()
+}
+
+// fakeInsertFailure is the status and error reason a streaming insert fails with
+type fakeInsertFailure struct {
+	code   int
+	reason string
+}
+
+// newFakeBigQuery starts a fakeBigQuery and returns a client talking to it
+func newFakeBigQuery(t *testing.T) (*fakeBigQuery, *bigquery.Client) {
+	t.Helper()
+	fake := &fakeBigQuery{inserted: map[string][]map[string]json.RawMessage{}}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	client, err := bigquery.NewClient(context.Background(), "project", option.WithEndpoint(server.URL), option.WithoutAuthentication())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return fake, client
+}
+
+func (f *fakeBigQuery) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	path := strings.TrimPrefix(r.URL.Path, "/bigquery/v2")
+	switch {
+	case r.Method == http.MethodPost && strings.HasSuffix(path, "/tables"):
+		var table struct {
+			TableReference struct {
+				DatasetID string `json:"datasetId"`
+				TableID   string `json:"tableId"`
+			} `json:"tableReference"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&table); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.lock.Lock()
+		f.created = append(f.created, table.TableReference.DatasetID+"."+table.TableReference.TableID)
+		f.lock.Unlock()
+		w.WriteHeader(http.StatusConflict)
+		_, _ = w.Write([]byte(`{"error": {"code": 409, "message": "Already Exists", "errors": [{"reason": "duplicate"}]}}`))
+
+	case r.Method == http.MethodPost && strings.HasSuffix(path, "/insertAll"):
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var request struct {
+			Rows []struct {
+				JSON map[string]json.RawMessage `json:"json"`
+			} `json:"rows"`
+		}
+		if err := json.Unmarshal(body, &request); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		table := strings.TrimSuffix(path, "/insertAll")
+		f.lock.Lock()
+		f.insertRequests++
+		if f.onInsert != nil {
+			f.onInsert()
+		}
+		if len(f.insertFailures) > 0 {
+			failure := f.insertFailures[0]
+			f.insertFailures = f.insertFailures[1:]
+			f.lock.Unlock()
+			w.WriteHeader(failure.code)
+			_, _ = fmt.Fprintf(w, `{"error": {"code": %d, "message": "failed", "errors": [{"reason": %q}]}}`, failure.code, failure.reason)
+			return
+		}
+		for _, row := range request.Rows {
+			f.inserted[table] = append(f.inserted[table], row.JSON)
+		}
+		f.lock.Unlock()
+		_, _ = w.Write([]byte(`{"kind": "bigquery#tableDataInsertAllResponse"}`))
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// rows returns the rows inserted into table of dataset in project
+func (f *fakeBigQuery) rows(project, dataset, table string) []map[string]json.RawMessage {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.inserted["/projects/"+project+"/datasets/"+dataset+"/tables/"+table]
+}
+
+// tableRows returns the rows of the table named name in data
+func tableRows(data *MetricsData, name string) tableData {
+	for _, table := range data.tables() {
+		if table.name == name {
+			return table
+		}
+	}
+	return tableData{}
+}
+
+func TestInsertRowsRetriesPermissionsAfterQuota(t *testing.T) {
+	fake, client := newFakeBigQuery(t)
+	// the insert sent again after the cool-down is denied until the permissions propagate
+	fake.insertFailures = []fakeInsertFailure{
+		{code: http.StatusForbidden, reason: "quotaExceeded"},
+		{code: http.StatusForbidden, reason: "accessDenied"},
+	}
+	loader := NewBigQueryLoader(context.Background(), client, "project", "dataset",
+		WithQuotaCooldown(NewQuotaCooldown(time.Millisecond)),
+		WithPermissionWait(50*time.Millisecond),
+	)
+
+	rows := []any{&writeAPITestRow{ID: "a"}}
+	schema := bigquery.Schema{{Name: "id", Type: bigquery.StringFieldType}}
+	if _, err := loader.insertRows(context.Background(), client.Dataset("dataset").Table("table"), schema, rows, nil); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	if fake.insertRequests != 3 {
+		t.Errorf("expected the insert to be sent 3 times, got %d", fake.insertRequests)
+	}
+	if inserted := fake.rows("project", "dataset", "table"); len(inserted) != 1 {
+		t.Errorf("expected 1 inserted row, got %d", len(inserted))
+	}
+}
+
+func TestInsertRowsBenchmarkUsesClock(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		writeAPI bool
+	}{
+		{name: "streaming inserts"},
+		{name: "write API", writeAPI: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			// the clock only moves, by a second, while BigQuery handles the batch
+			var lock sync.Mutex
+			now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+			clock := func() time.Time {
+				lock.Lock()
+				defer lock.Unlock()
+				return now
+			}
+			tick := func() {
+				lock.Lock()
+				defer lock.Unlock()
+				now = now.Add(time.Second)
+			}
+			fake, client := newFakeBigQuery(t)
+			fake.onInsert = tick
+			var writer *writeAPIClient
+			if tc.writeAPI {
+				writer = newFakeWriteAPIClient(t, &fakeWriteServer{committed: map[string]int{}, onAppend: tick})
+			}
+			bench := NewBenchmark()
+			loader := NewBigQueryLoader(context.Background(), client, "project", "dataset", WithClock(clock), WithBenchmark(bench))
+
+			rows := []any{&writeAPITestRow{ID: "a"}}
+			schema := bigquery.Schema{{Name: "id", Type: bigquery.StringFieldType}}
+			if _, err := loader.insertRows(context.Background(), client.Dataset("dataset").Table("table"), schema, rows, writer); err != nil {
+				t.Fatalf("failed to insert: %v", err)
+			}
+			report := bench.Report()
+			if report.Batches != 1 || report.BatchP50 != time.Second {
+				t.Errorf("expected one batch taking the second of the loader clock, got %d batches taking %s", report.Batches, report.BatchP50)
+			}
+		})
+	}
+}
@@ -0,0 +1,40 @@
+package metrics
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+// ErrCircuitBreakerTripped is returned once a CircuitBreaker has seen its limit of errors
+var ErrCircuitBreakerTripped = errors.New("circuit breaker tripped")
+
+// CircuitBreaker counts errors across tables, files and concurrent loaders and trips once a limit is reached.
+// It is safe for concurrent use, so a single breaker can be shared by every loader of a run.
+type CircuitBreaker struct {
+	limit  int64
+	errors atomic.Int64
+}
+
+// NewCircuitBreaker creates a breaker that trips after limit errors
+func NewCircuitBreaker(limit int) *CircuitBreaker {
+	return &CircuitBreaker{limit: int64(limit)}
+}
+
+// Record counts err and returns an error wrapping ErrCircuitBreakerTripped if this pushed the breaker over its limit
+func (c *CircuitBreaker) Record(err error) error {
+	if count := c.errors.Add(1); count >= c.limit {
+		return fmt.Errorf("%w after %d errors: %w", ErrCircuitBreakerTripped, count, err)
+	}
+	return nil
+}
+
+// Tripped reports whether the breaker has reached its limit
+func (c *CircuitBreaker) Tripped() bool {
+	return c.errors.Load() >= c.limit
+}
+
+// Errors returns the number of errors recorded so far
+func (c *CircuitBreaker) Errors() int {
+	return int(c.errors.Load())
+}
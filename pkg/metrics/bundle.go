@@ -0,0 +1,94 @@
+package metrics
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// WriteExportBundle writes the table files of ExportMetricsData together with their manifest.json into a single
+// gzipped tarball at path. The manifest is the first member so that a worker can read it before the table files.
+// Every table is encoded to a temporary file before it is added, so memory stays bounded by a single row.
+func WriteExportBundle(data *MetricsData, path, source string, exportedAt time.Time, includeEmpty bool) (err error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create bundle: %w", err)
+	}
+	defer func() {
+		if closeErr := file.Close(); err == nil && closeErr != nil {
+			err = fmt.Errorf("failed to close bundle: %w", closeErr)
+		}
+	}()
+
+	gz := gzip.NewWriter(file)
+	tw := tar.NewWriter(gz)
+
+	manifest := BuildExportManifest(data, source, exportedAt, includeEmpty)
+	encoded, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest: %w", err)
+	}
+	encoded = append(encoded, '\n')
+	if err := tw.WriteHeader(bundleHeader(ExportManifestFileName, int64(len(encoded)), exportedAt)); err != nil {
+		return fmt.Errorf("failed to write manifest header: %w", err)
+	}
+	if _, err := tw.Write(encoded); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+
+	for _, t := range data.tables() {
+		if len(t.rows) == 0 && !includeEmpty {
+			continue
+		}
+		if err := addBundleTable(tw, t, exportedAt); err != nil {
+			return fmt.Errorf("failed to add %s to the bundle: %w", t.name, err)
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to finish bundle: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("failed to finish bundle: %w", err)
+	}
+	return nil
+}
+
+// addBundleTable encodes the rows of t as NDJSON into a temporary file and copies it into tw, since a tar
+// header needs the size of the member up front
+func addBundleTable(tw *tar.Writer, t tableData, modTime time.Time) error {
+	tmp, err := os.CreateTemp("", "ci-metrics-bundle-*.json")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	encoder := json.NewEncoder(tmp)
+	for _, row := range t.rows {
+		if err := encoder.Encode(row); err != nil {
+			return fmt.Errorf("failed to encode item: %w", err)
+		}
+	}
+	size, err := tmp.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	if err := tw.WriteHeader(bundleHeader(t.name+".json", size, modTime)); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, tmp)
+	return err
+}
+
+func bundleHeader(name string, size int64, modTime time.Time) *tar.Header {
+	return &tar.Header{Name: name, Mode: 0644, Size: size, ModTime: modTime, Typeflag: tar.TypeReg, Format: tar.FormatPAX}
+}
@@ -0,0 +1,47 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+)
+
+// TableCheck is the outcome of checking a single non-empty table
+type TableCheck struct {
+	Table string `json:"table"`
+	Rows  int    `json:"rows"`
+	Error string `json:"error,omitempty"`
+}
+
+// CheckMetricsData runs schema inference, the loader options' transforms and row conversion for every non-empty
+// table, exactly as a load would, without making any GCS or BigQuery calls. It returns one check per non-empty table.
+func CheckMetricsData(data *MetricsData, opts ...Option) []TableCheck {
+	b := NewBigQueryLoader(context.Background(), nil, "", "", opts...)
+	var checks []TableCheck
+	for _, t := range b.tablesOf(data) {
+		if len(t.rows) == 0 {
+			continue
+		}
+		check := TableCheck{Table: t.name, Rows: len(t.rows)}
+		if err := b.checkTable(t); err != nil {
+			check.Error = err.Error()
+		}
+		checks = append(checks, check)
+	}
+	return checks
+}
+
+func (b *BigQueryLoader) checkTable(t tableData) error {
+	schema, rows, err := b.prepareTable(t)
+	if err != nil {
+		return err
+	}
+	if _, err := b.rangePartitioning(t.name, schema); err != nil {
+		return err
+	}
+	for i, row := range rows {
+		if _, _, err := saveRow(row, schema); err != nil {
+			return fmt.Errorf("row %d: %w", i, err)
+		}
+	}
+	return nil
+}
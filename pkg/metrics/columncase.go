@@ -0,0 +1,112 @@
+package metrics
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"cloud.google.com/go/bigquery"
+)
+
+// Supported column name casings
+const (
+	// ColumnCasePreserve keeps the column names produced by schema inference
+	ColumnCasePreserve = "preserve"
+	// ColumnCaseSnake rewrites column names to snake_case
+	ColumnCaseSnake = "snake"
+)
+
+// WithColumnCase rewrites inferred column names, including nested record fields, to the given casing
+func WithColumnCase(columnCase string) Option {
+	return func(b *BigQueryLoader) {
+		if columnCase == ColumnCaseSnake {
+			b.transforms = append(b.transforms, snakeCaseColumns{})
+		}
+	}
+}
+
+// snakeCaseColumns renames every column to snake_case
+type snakeCaseColumns struct{}
+
+func (snakeCaseColumns) schema(table string, in bigquery.Schema) (bigquery.Schema, error) {
+	return snakeCaseSchema(table, in)
+}
+
+func snakeCaseSchema(path string, in bigquery.Schema) (bigquery.Schema, error) {
+	out := make(bigquery.Schema, 0, len(in))
+	seen := map[string]string{}
+	for _, field := range in {
+		name := toSnakeCase(field.Name)
+		if original, ok := seen[name]; ok {
+			return nil, fmt.Errorf("columns %s and %s of %s both map to %s in snake_case", original, field.Name, path, name)
+		}
+		seen[name] = field.Name
+
+		renamed := *field
+		renamed.Name = name
+		if field.Type == bigquery.RecordFieldType {
+			nested, err := snakeCaseSchema(path+"."+field.Name, field.Schema)
+			if err != nil {
+				return nil, err
+			}
+			renamed.Schema = nested
+		}
+		out = append(out, &renamed)
+	}
+	return out, nil
+}
+
+func (snakeCaseColumns) row(_ string, in bigquery.Schema, values map[string]bigquery.Value) (map[string]bigquery.Value, error) {
+	return snakeCaseValues(in, values), nil
+}
+
+func snakeCaseValues(schema bigquery.Schema, values map[string]bigquery.Value) map[string]bigquery.Value {
+	out := make(map[string]bigquery.Value, len(values))
+	for _, field := range schema {
+		value, ok := values[field.Name]
+		if !ok {
+			continue
+		}
+		if field.Type == bigquery.RecordFieldType {
+			switch v := value.(type) {
+			case map[string]bigquery.Value:
+				value = snakeCaseValues(field.Schema, v)
+			case []bigquery.Value:
+				items := make([]bigquery.Value, 0, len(v))
+				for _, item := range v {
+					if nested, ok := item.(map[string]bigquery.Value); ok {
+						item = snakeCaseValues(field.Schema, nested)
+					}
+					items = append(items, item)
+				}
+				value = items
+			}
+		}
+		out[toSnakeCase(field.Name)] = value
+	}
+	return out
+}
+
+// toSnakeCase converts CamelCase and mixedCase names to snake_case, keeping acronyms together (MachineID -> machine_id)
+func toSnakeCase(name string) string {
+	runes := []rune(name)
+	var out strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			if i > 0 && runes[i-1] != '_' {
+				previousLower := unicode.IsLower(runes[i-1]) || unicode.IsDigit(runes[i-1])
+				nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+				if previousLower || (unicode.IsUpper(runes[i-1]) && nextLower) {
+					out.WriteRune('_')
+				}
+			}
+			out.WriteRune(unicode.ToLower(r))
+			continue
+		}
+		if r == '-' || r == ' ' {
+			r = '_'
+		}
+		out.WriteRune(r)
+	}
+	return out.String()
+}
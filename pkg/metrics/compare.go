@@ -0,0 +1,200 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"cloud.google.com/go/bigquery"
+	"google.golang.org/api/iterator"
+)
+
+// compareSampleKeys is the number of example keys reported for each kind of difference
+const compareSampleKeys = 10
+
+// TableRef names a BigQuery table
+type TableRef struct {
+	ProjectID string
+	DatasetID string
+	TableID   string
+}
+
+// ParseTableRef parses a [project:]dataset.table or a bare table reference, filling in the missing parts from
+// project and dataset
+func ParseTableRef(value, project, dataset string) (TableRef, error) {
+	ref := TableRef{ProjectID: project, DatasetID: dataset}
+	rest := value
+	if p, r, ok := strings.Cut(value, ":"); ok {
+		ref.ProjectID, rest = p, r
+	}
+	if d, t, ok := strings.Cut(rest, "."); ok {
+		ref.DatasetID, rest = d, t
+	}
+	ref.TableID = rest
+	if ref.ProjectID == "" || ref.DatasetID == "" || ref.TableID == "" || strings.ContainsAny(ref.TableID, ".:") {
+		return TableRef{}, fmt.Errorf("expected [project:]dataset.table or a table of the dataset, got %q", value)
+	}
+	return ref, nil
+}
+
+func (r TableRef) String() string {
+	return fmt.Sprintf("%s:%s.%s", r.ProjectID, r.DatasetID, r.TableID)
+}
+
+// TableComparison is the difference between two tables, as reported by CompareTables
+type TableComparison struct {
+	TableA   string `json:"table_a"`
+	TableB   string `json:"table_b"`
+	RowsA    int64  `json:"rows_a"`
+	RowsB    int64  `json:"rows_b"`
+	RowDelta int64  `json:"row_delta"`
+	// OnlyInA and OnlyInB list the columns missing from the other table, which are left out of the row comparison
+	OnlyInA []string `json:"columns_only_in_a,omitempty"`
+	OnlyInB []string `json:"columns_only_in_b,omitempty"`
+	// Rows is set when the tables were compared on key columns
+	Rows *RowComparison `json:"rows,omitempty"`
+}
+
+// RowComparison counts the rows that differ between two tables matched on key columns, with example keys of each
+// kind encoded as JSON objects
+type RowComparison struct {
+	Key            []string `json:"key"`
+	Added          int64    `json:"added"`
+	Removed        int64    `json:"removed"`
+	Changed        int64    `json:"changed"`
+	Unchanged      int64    `json:"unchanged"`
+	AddedSamples   []string `json:"added_samples,omitempty"`
+	RemovedSamples []string `json:"removed_samples,omitempty"`
+	ChangedSamples []string `json:"changed_samples,omitempty"`
+}
+
+// CompareTables reports how table b differs from table a using read-only queries. Without key columns only the
+// row counts are compared. With key columns, rows are matched on them: rows of b whose key is missing from a are
+// added, rows of a whose key is missing from b are removed, and matched rows whose columns common to both tables
+// differ are changed. Keys are expected to be unique; duplicate keys are matched with every row sharing them.
+func CompareTables(ctx context.Context, client *bigquery.Client, a, b TableRef, key []string) (*TableComparison, error) {
+	schemaA, err := tableSchema(ctx, client, a)
+	if err != nil {
+		return nil, err
+	}
+	schemaB, err := tableSchema(ctx, client, b)
+	if err != nil {
+		return nil, err
+	}
+
+	comparison := &TableComparison{TableA: a.String(), TableB: b.String()}
+	var common []string
+	for _, field := range schemaA {
+		if hasColumn(schemaB, field.Name) {
+			common = append(common, field.Name)
+		} else {
+			comparison.OnlyInA = append(comparison.OnlyInA, field.Name)
+		}
+	}
+	for _, field := range schemaB {
+		if !hasColumn(schemaA, field.Name) {
+			comparison.OnlyInB = append(comparison.OnlyInB, field.Name)
+		}
+	}
+
+	var counts struct {
+		RowsA int64 `bigquery:"rows_a"`
+		RowsB int64 `bigquery:"rows_b"`
+	}
+	if err := queryRow(ctx, client, fmt.Sprintf("SELECT (SELECT COUNT(*) FROM %s) AS rows_a, (SELECT COUNT(*) FROM %s) AS rows_b", a.sql(), b.sql()), &counts); err != nil {
+		return nil, fmt.Errorf("failed to count rows: %w", err)
+	}
+	comparison.RowsA, comparison.RowsB, comparison.RowDelta = counts.RowsA, counts.RowsB, counts.RowsB-counts.RowsA
+	if len(key) == 0 {
+		return comparison, nil
+	}
+
+	keyColumns := make([]string, 0, len(key))
+	for _, column := range key {
+		i := slices.IndexFunc(common, func(name string) bool { return strings.EqualFold(name, column) })
+		if i < 0 {
+			return nil, fmt.Errorf("key column %s is not a column of both tables", column)
+		}
+		keyColumns = append(keyColumns, common[i])
+	}
+	var rows struct {
+		Added          int64    `bigquery:"added"`
+		Removed        int64    `bigquery:"removed"`
+		Changed        int64    `bigquery:"changed"`
+		Unchanged      int64    `bigquery:"unchanged"`
+		AddedSamples   []string `bigquery:"added_samples"`
+		RemovedSamples []string `bigquery:"removed_samples"`
+		ChangedSamples []string `bigquery:"changed_samples"`
+	}
+	if err := queryRow(ctx, client, compareStatement(a, b, keyColumns, common), &rows); err != nil {
+		return nil, fmt.Errorf("failed to compare rows: %w", err)
+	}
+	comparison.Rows = &RowComparison{
+		Key:            keyColumns,
+		Added:          rows.Added,
+		Removed:        rows.Removed,
+		Changed:        rows.Changed,
+		Unchanged:      rows.Unchanged,
+		AddedSamples:   rows.AddedSamples,
+		RemovedSamples: rows.RemovedSamples,
+		ChangedSamples: rows.ChangedSamples,
+	}
+	return comparison, nil
+}
+
+func (r TableRef) sql() string {
+	return fmt.Sprintf("`%s.%s.%s`", r.ProjectID, r.DatasetID, r.TableID)
+}
+
+func tableSchema(ctx context.Context, client *bigquery.Client, ref TableRef) (bigquery.Schema, error) {
+	metadata, err := client.DatasetInProject(ref.ProjectID, ref.DatasetID).Table(ref.TableID).Metadata(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get metadata of %s: %w", ref, err)
+	}
+	return metadata.Schema, nil
+}
+
+func hasColumn(schema bigquery.Schema, name string) bool {
+	return slices.ContainsFunc(schema, func(field *bigquery.FieldSchema) bool { return strings.EqualFold(field.Name, name) })
+}
+
+// compareStatement matches the rows of a and b on their key, encoded as JSON, and compares the JSON encoding of
+// the columns common to both tables
+func compareStatement(a, b TableRef, key, common []string) string {
+	encode := func(columns []string) string {
+		quoted := make([]string, 0, len(columns))
+		for _, column := range columns {
+			quoted = append(quoted, fmt.Sprintf("t.`%s`", column))
+		}
+		return fmt.Sprintf("TO_JSON_STRING(STRUCT(%s))", strings.Join(quoted, ", "))
+	}
+	side := func(ref TableRef) string {
+		return fmt.Sprintf("SELECT %s AS k, %s AS v FROM %s t", encode(key), encode(common), ref.sql())
+	}
+	return fmt.Sprintf(`WITH a AS (%s), b AS (%s)
+SELECT
+  COUNTIF(a.k IS NULL) AS added,
+  COUNTIF(b.k IS NULL) AS removed,
+  COUNTIF(a.k IS NOT NULL AND b.k IS NOT NULL AND a.v != b.v) AS changed,
+  COUNTIF(a.k IS NOT NULL AND b.k IS NOT NULL AND a.v = b.v) AS unchanged,
+  ARRAY_AGG(IF(a.k IS NULL, b.k, NULL) IGNORE NULLS LIMIT %[3]d) AS added_samples,
+  ARRAY_AGG(IF(b.k IS NULL, a.k, NULL) IGNORE NULLS LIMIT %[3]d) AS removed_samples,
+  ARRAY_AGG(IF(a.k IS NOT NULL AND b.k IS NOT NULL AND a.v != b.v, a.k, NULL) IGNORE NULLS LIMIT %[3]d) AS changed_samples
+FROM a FULL OUTER JOIN b ON a.k = b.k`, side(a), side(b), compareSampleKeys)
+}
+
+// queryRow runs a query returning a single row and reads it into dst
+func queryRow(ctx context.Context, client *bigquery.Client, sql string, dst any) error {
+	it, err := client.Query(sql).Read(ctx)
+	if err != nil {
+		return err
+	}
+	if err := it.Next(dst); errors.Is(err, iterator.Done) {
+		return fmt.Errorf("query returned no rows")
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// ZstdSuffix marks a zstd-compressed metrics file, e.g. ci-operator-metrics.json.zst
+const ZstdSuffix = ".zst"
+
+// zstdContentEncoding is the Content-Encoding of a zstd-compressed GCS object
+const zstdContentEncoding = "zstd"
+
+// zstdReader closes the zstd decoder together with the compressed source
+type zstdReader struct {
+	*zstd.Decoder
+	source io.Closer
+}
+
+func (r *zstdReader) Close() error {
+	r.Decoder.Close()
+	return r.source.Close()
+}
+
+// decompress wraps r in a zstd decoder when the name suffix or content encoding says the source is zstd-compressed,
+// and returns r unchanged otherwise
+func decompress(name, contentEncoding string, r io.ReadCloser) (io.ReadCloser, error) {
+	if !strings.HasSuffix(name, ZstdSuffix) && contentEncoding != zstdContentEncoding {
+		return r, nil
+	}
+	decoder, err := zstd.NewReader(r)
+	if err != nil {
+		r.Close()
+		return nil, fmt.Errorf("failed to open zstd stream: %w", err)
+	}
+	return &zstdReader{Decoder: decoder, source: r}, nil
+}
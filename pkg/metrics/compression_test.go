@@ -0,0 +1,54 @@
+package metrics
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestReadMetricsFromZstdFile(t *testing.T) {
+	data, err := ReadMetricsFromFile("testdata/metrics.json.zst")
+	if err != nil {
+		t.Fatalf("failed to read: %v", err)
+	}
+	if len(data.Leases) != 1 || data.Leases[0].LeaseName != "aws-quota-slice" {
+		t.Errorf("unexpected leases %+v", data.Leases)
+	}
+}
+
+func TestDecompress(t *testing.T) {
+	compressed, err := os.ReadFile("testdata/metrics.json.zst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := os.ReadFile("testdata/no_bom.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name            string
+		object          string
+		contentEncoding string
+		content         []byte
+	}{
+		{name: "zst suffix", object: "ci-operator-metrics.json.zst", content: compressed},
+		{name: "zstd content encoding", object: "ci-operator-metrics.json", contentEncoding: "zstd", content: compressed},
+		{name: "plain", object: "ci-operator-metrics.json", content: plain},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r, err := decompress(tc.object, tc.contentEncoding, io.NopCloser(bytes.NewReader(tc.content)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+			decompressed, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(decompressed, plain) {
+				t.Errorf("expected %q, got %q", plain, decompressed)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// CountRows counts the records of every top-level array of a metrics JSON document by scanning tokens,
+// without decoding any record, so memory stays bounded regardless of the file size. Every known table
+// is present in the result, with zero when the file has no rows for it.
+func CountRows(ctx context.Context, r io.Reader) (map[string]int, error) {
+	decoder := json.NewDecoder(skipPreamble(r))
+	if err := expectDelim(decoder, '{'); err != nil {
+		return nil, err
+	}
+
+	counts := map[string]int{}
+	for section := range sectionDecoders {
+		counts[section] = 0
+	}
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		section, _ := token.(string)
+
+		token, err = decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		delim, isDelim := token.(json.Delim)
+		if !isDelim {
+			continue
+		}
+		if delim != '[' {
+			if err := skipNested(decoder); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		for decoder.More() {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			if err := skipValue(decoder); err != nil {
+				return nil, fmt.Errorf("section %s: record %d: %w", section, counts[section], err)
+			}
+			counts[section]++
+		}
+		if err := expectDelim(decoder, ']'); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := expectDelim(decoder, '}'); err != nil {
+		return nil, err
+	}
+	return counts, nil
+}
+
+// skipValue consumes the next value, descending into objects and arrays token by token
+func skipValue(decoder *json.Decoder) error {
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+	if delim, ok := token.(json.Delim); ok && (delim == '{' || delim == '[') {
+		return skipNested(decoder)
+	}
+	return nil
+}
+
+// skipNested consumes the tokens up to and including the delimiter closing an object or array that was just opened
+func skipNested(decoder *json.Decoder) error {
+	for depth := 1; depth > 0; {
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		if delim, ok := token.(json.Delim); ok {
+			switch delim {
+			case '{', '[':
+				depth++
+			case '}', ']':
+				depth--
+			}
+		}
+	}
+	return nil
+}
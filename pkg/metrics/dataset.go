@@ -0,0 +1,38 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+
+	"cloud.google.com/go/bigquery"
+)
+
+// WithDatasetCreation creates the dataset with metadata before loading if it does not exist yet.
+// An existing dataset is used as-is; its settings are not updated to match metadata.
+func WithDatasetCreation(metadata *bigquery.DatasetMetadata) Option {
+	return func(b *BigQueryLoader) {
+		b.datasetMetadata = metadata
+	}
+}
+
+// ensureDataset creates the dataset once per loader when dataset creation is enabled
+func (b *BigQueryLoader) ensureDataset(ctx context.Context, dataset *bigquery.Dataset) error {
+	if b.datasetMetadata == nil || b.datasetEnsured {
+		return nil
+	}
+
+	if err := b.retryPermissions(ctx, func() error { return dataset.Create(ctx, b.datasetMetadata) }); err != nil {
+		if !isAlreadyExistsError(err) {
+			return fmt.Errorf("failed to create dataset %s: %w", dataset.DatasetID, err)
+		}
+		b.logger.Debugf("Dataset %s already exists, leaving its settings unchanged", dataset.DatasetID)
+	} else {
+		logger := b.logger.WithField("location", b.datasetMetadata.Location)
+		if expiration := b.datasetMetadata.DefaultPartitionExpiration; expiration > 0 {
+			logger = logger.WithField("defaultPartitionExpiration", expiration)
+		}
+		logger.Infof("Created dataset %s", dataset.DatasetID)
+	}
+	b.datasetEnsured = true
+	return nil
+}
@@ -0,0 +1,161 @@
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/option"
+)
+
+// FailedRow is a row BigQuery rejected, as written to the dead-letter location
+type FailedRow struct {
+	Table  string                    `json:"table"`
+	Index  int                       `json:"row_index"`
+	Reason string                    `json:"reason"`
+	Row    map[string]bigquery.Value `json:"row"`
+}
+
+// FailedObject is a metrics file that could not be loaded, as written to the dead-letter location
+type FailedObject struct {
+	Bucket   string    `json:"bucket"`
+	Object   string    `json:"object"`
+	Reason   string    `json:"reason"`
+	FailedAt time.Time `json:"failed_at"`
+}
+
+// DeadLetterWriter writes rows that BigQuery rejected as NDJSON objects under a GCS prefix, one object per
+// table and insert, so that they can be inspected and reprocessed later. It is safe for concurrent use.
+type DeadLetterWriter struct {
+	client *storage.Client
+	bucket string
+	prefix string
+	clock  func() time.Time
+
+	lock     sync.Mutex
+	sequence int
+}
+
+// NewDeadLetterWriter creates a writer for a gs://bucket/prefix/ location
+func NewDeadLetterWriter(ctx context.Context, path string, clientOpts ...option.ClientOption) (*DeadLetterWriter, error) {
+	bucket, prefix, err := ParseGCSPath(path)
+	if err != nil {
+		return nil, fmt.Errorf("invalid dead-letter location: %w", err)
+	}
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	client, err := storage.NewClient(ctx, clientOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCS client: %w", err)
+	}
+	return &DeadLetterWriter{client: client, bucket: bucket, prefix: prefix, clock: time.Now}, nil
+}
+
+// Close releases the GCS client
+func (w *DeadLetterWriter) Close() error {
+	return w.client.Close()
+}
+
+// WithDeadLetter writes the rows BigQuery rejects during inserts to writer
+func WithDeadLetter(writer *DeadLetterWriter) Option {
+	return func(b *BigQueryLoader) {
+		b.deadLetter = writer
+	}
+}
+
+// WithSkipInvalidRows inserts the valid rows of every insert and skips the rows BigQuery rejects instead of
+// failing the table. Skipped rows are counted in TableResult.InvalidRows and written to the dead-letter location.
+func WithSkipInvalidRows(enabled bool) Option {
+	return func(b *BigQueryLoader) {
+		b.skipInvalidRows = enabled
+	}
+}
+
+// writeDeadLetter stores entries as a new NDJSON object named after kind, a table or the kind of the entries,
+// and returns its gs:// path
+func writeDeadLetter[T any](ctx context.Context, w *DeadLetterWriter, kind string, entries []T) (string, error) {
+	w.lock.Lock()
+	w.sequence++
+	name := fmt.Sprintf("%s%s-%s-%d.ndjson", w.prefix, kind, w.clock().UTC().Format("20060102T150405Z"), w.sequence)
+	w.lock.Unlock()
+
+	writer := w.client.Bucket(w.bucket).Object(name).NewWriter(ctx)
+	writer.ContentType = "application/x-ndjson"
+	encoder := json.NewEncoder(writer)
+	for _, entry := range entries {
+		if err := encoder.Encode(entry); err != nil {
+			writer.Close()
+			return "", fmt.Errorf("failed to encode dead-letter entry: %w", err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return "", fmt.Errorf("failed to write gs://%s/%s: %w", w.bucket, name, err)
+	}
+	return fmt.Sprintf("gs://%s/%s", w.bucket, name), nil
+}
+
+// WriteFailedObject records a metrics file that failed to load with cause as a JSON object and returns its
+// gs:// path, so that files dropped without a retry can be found and reprocessed
+func (w *DeadLetterWriter) WriteFailedObject(ctx context.Context, bucket, object string, cause error) (string, error) {
+	w.lock.Lock()
+	w.sequence++
+	now := w.clock().UTC()
+	name := fmt.Sprintf("%sobjects/%s-%d.json", w.prefix, now.Format("20060102T150405Z"), w.sequence)
+	w.lock.Unlock()
+
+	encoded, err := json.Marshal(FailedObject{Bucket: bucket, Object: object, Reason: cause.Error(), FailedAt: now})
+	if err != nil {
+		return "", fmt.Errorf("failed to encode failed object: %w", err)
+	}
+	writer := w.client.Bucket(w.bucket).Object(name).NewWriter(ctx)
+	writer.ContentType = "application/json"
+	if _, err := writer.Write(encoded); err != nil {
+		writer.Close()
+		return "", fmt.Errorf("failed to write gs://%s/%s: %w", w.bucket, name, err)
+	}
+	if err := writer.Close(); err != nil {
+		return "", fmt.Errorf("failed to write gs://%s/%s: %w", w.bucket, name, err)
+	}
+	return fmt.Sprintf("gs://%s/%s", w.bucket, name), nil
+}
+
+// failedRows extracts the rejected rows from an insert error, returning nil when the error is not about individual rows
+func failedRows(table string, schema bigquery.Schema, rows []any, err error) []FailedRow {
+	var rowErrors bigquery.PutMultiError
+	if !errors.As(err, &rowErrors) {
+		return nil
+	}
+	failed := make([]FailedRow, 0, len(rowErrors))
+	for _, rowError := range rowErrors {
+		failedRow := FailedRow{Table: table, Index: rowError.RowIndex, Reason: rowError.Errors.Error()}
+		if rowError.RowIndex >= 0 && rowError.RowIndex < len(rows) {
+			failedRow.Row, _, _ = saveRow(rows[rowError.RowIndex], schema)
+		}
+		failed = append(failed, failedRow)
+	}
+	return failed
+}
+
+// deadLetterRows writes the rows rejected by a failed insert to the dead-letter location, if one is configured
+func (b *BigQueryLoader) deadLetterRows(ctx context.Context, table string, schema bigquery.Schema, rows []any, insertErr error) {
+	if b.deadLetter == nil {
+		return
+	}
+	failed := failedRows(table, schema, rows, insertErr)
+	if len(failed) == 0 {
+		return
+	}
+	path, err := writeDeadLetter(ctx, b.deadLetter, table, failed)
+	if err != nil {
+		b.logger.WithError(err).Errorf("Failed to write %d rejected %s rows to the dead-letter location", len(failed), table)
+		return
+	}
+	b.logger.Warnf("Wrote %d rejected %s rows to %s", len(failed), table, path)
+}
@@ -0,0 +1,46 @@
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// IsDecodeError reports whether err comes from malformed or truncated JSON, as seen when an object is read
+// while it is still being uploaded. Schema mismatches such as a string in a numeric field
+// (json.UnmarshalTypeError) fail the same way on every read and are not decode errors, and neither are limit
+// errors such as ErrTooManyRows.
+func IsDecodeError(err error) bool {
+	var syntaxErr *json.SyntaxError
+	return errors.Is(err, io.ErrUnexpectedEOF) || errors.As(err, &syntaxErr)
+}
+
+// RetryDecode calls read and, while it fails with a decode error, waits delay and calls it again, up to retries
+// more times. read must download the source afresh on every call; other errors are returned immediately.
+func RetryDecode(ctx context.Context, retries int, delay time.Duration, logger *logrus.Entry, read func() (*MetricsData, error)) (*MetricsData, error) {
+	for attempt := 1; ; attempt++ {
+		data, err := read()
+		if err == nil || attempt > retries || !IsDecodeError(err) {
+			return data, err
+		}
+		logger.WithError(err).Warnf("Decoding failed, re-reading the source in %s (retry %d/%d)", delay, attempt, retries)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// WithDecodeRetries makes LoadFromGCS download and decode the object again, up to retries times with delay
+// between attempts, when it is not valid JSON, covering objects read before their upload completed
+func WithDecodeRetries(retries int, delay time.Duration) Option {
+	return func(b *BigQueryLoader) {
+		b.decodeRetries = retries
+		b.decodeRetryDelay = delay
+	}
+}
@@ -0,0 +1,65 @@
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func decodeTestFile(raw string) error {
+	_, err := DecodeMetricsData(strings.NewReader(raw))
+	return err
+}
+
+func TestIsDecodeError(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{name: "truncated upload", err: decodeTestFile(`{"nodes": [{"node": "a"`), expected: true},
+		{name: "malformed", err: decodeTestFile(`{"nodes": [{"node": }]}`), expected: true},
+		{name: "syntax error", err: json.Unmarshal([]byte(`{"rate": }`), new(map[string]float64)), expected: true},
+		{name: "wrong type", err: decodeTestFile(`{"nodes": [{"node": 1}]}`)},
+		{name: "too many rows", err: ErrTooManyRows},
+		{name: "deadline", err: context.DeadlineExceeded},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.err == nil {
+				t.Fatal("expected an error")
+			}
+			if actual := IsDecodeError(tc.err); actual != tc.expected {
+				t.Errorf("expected %v for %v, got %v", tc.expected, tc.err, actual)
+			}
+		})
+	}
+}
+
+func TestRetryDecode(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		raw      string
+		expected int
+	}{
+		{name: "truncated upload is read again", raw: `{"nodes": [{"node": "a"`, expected: 3},
+		{name: "wrong type is read once", raw: `{"nodes": [{"node": 1}]}`, expected: 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			reads := 0
+			_, err := RetryDecode(context.Background(), 2, time.Millisecond, logrus.NewEntry(logrus.New()), func() (*MetricsData, error) {
+				reads++
+				return DecodeMetricsData(strings.NewReader(tc.raw))
+			})
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if reads != tc.expected {
+				t.Errorf("expected %d reads, got %d", tc.expected, reads)
+			}
+		})
+	}
+}
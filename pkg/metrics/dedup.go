@@ -0,0 +1,127 @@
+package metrics
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"cloud.google.com/go/bigquery"
+)
+
+// WithDedupKey sets the insert ID of every row of table to a hash of the given fields, so that streaming inserts
+// of rows with the same key are de-duplicated by BigQuery. Fields are matched against the top-level column names
+// ignoring case and underscores, so both ImageStreamName and image_stream_name name the same column. A field that
+// matches no column fails the load of the table.
+func WithDedupKey(table string, fields []string) Option {
+	return func(b *BigQueryLoader) {
+		b.transforms = append(b.transforms, &dedupKey{table: table, fields: fields})
+	}
+}
+
+// WithInsertIDColumn uses the value of a string field of every row of table as its insert ID, for producers that
+// already emit a unique ID. The field is matched like the fields of WithDedupKey; a field that matches no column
+// or is not a string fails the load of the table. Rows with an empty value are inserted without an insert ID.
+func WithInsertIDColumn(table, field string) Option {
+	return func(b *BigQueryLoader) {
+		b.transforms = append(b.transforms, &insertIDColumn{table: table, field: field})
+	}
+}
+
+// insertIDTransform is implemented by transforms that derive the insert ID of a row from its transformed values
+type insertIDTransform interface {
+	insertID(table string, values map[string]bigquery.Value) (string, error)
+}
+
+// dedupKey derives insert IDs from a fixed set of columns of one table
+type dedupKey struct {
+	table  string
+	fields []string
+	// columns are the schema names of fields, resolved by schema
+	columns []string
+}
+
+func (d *dedupKey) schema(table string, in bigquery.Schema) (bigquery.Schema, error) {
+	if table != d.table {
+		return in, nil
+	}
+	d.columns = d.columns[:0]
+	for _, field := range d.fields {
+		column := ""
+		for _, candidate := range in {
+			if sameColumn(candidate.Name, field) {
+				column = candidate.Name
+				break
+			}
+		}
+		if column == "" {
+			return nil, fmt.Errorf("dedup key field %s does not exist in table %s", field, table)
+		}
+		d.columns = append(d.columns, column)
+	}
+	return in, nil
+}
+
+func (d *dedupKey) row(_ string, _ bigquery.Schema, values map[string]bigquery.Value) (map[string]bigquery.Value, error) {
+	return values, nil
+}
+
+func (d *dedupKey) insertID(table string, values map[string]bigquery.Value) (string, error) {
+	if table != d.table {
+		return "", nil
+	}
+	key := make([]bigquery.Value, 0, len(d.columns))
+	for _, column := range d.columns {
+		key = append(key, values[column])
+	}
+	encoded, err := json.Marshal(key)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode dedup key: %w", err)
+	}
+	sum := sha256.Sum256(append([]byte(table+"\x00"), encoded...))
+	return hex.EncodeToString(sum[:16]), nil
+}
+
+// insertIDColumn takes insert IDs from a single string column of one table
+type insertIDColumn struct {
+	table string
+	field string
+	// column is the schema name of field, resolved by schema
+	column string
+}
+
+func (c *insertIDColumn) schema(table string, in bigquery.Schema) (bigquery.Schema, error) {
+	if table != c.table {
+		return in, nil
+	}
+	c.column = ""
+	for _, candidate := range in {
+		if !sameColumn(candidate.Name, c.field) {
+			continue
+		}
+		if candidate.Type != bigquery.StringFieldType || candidate.Repeated {
+			return nil, fmt.Errorf("insert ID field %s of table %s is %s, not a string", c.field, table, candidate.Type)
+		}
+		c.column = candidate.Name
+		return in, nil
+	}
+	return nil, fmt.Errorf("insert ID field %s does not exist in table %s", c.field, table)
+}
+
+func (c *insertIDColumn) row(_ string, _ bigquery.Schema, values map[string]bigquery.Value) (map[string]bigquery.Value, error) {
+	return values, nil
+}
+
+func (c *insertIDColumn) insertID(table string, values map[string]bigquery.Value) (string, error) {
+	if table != c.table {
+		return "", nil
+	}
+	id, _ := values[c.column].(string)
+	return id, nil
+}
+
+// sameColumn reports whether two column names are equal ignoring case and underscores
+func sameColumn(a, b string) bool {
+	return strings.EqualFold(strings.ReplaceAll(a, "_", ""), strings.ReplaceAll(b, "_", ""))
+}
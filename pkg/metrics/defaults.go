@@ -0,0 +1,34 @@
+package metrics
+
+import (
+	"reflect"
+	"time"
+)
+
+// WithDefaultTimestampToNow sets the Timestamp of every row that has none to the load time, as reported by the
+// loader's clock, so that such rows do not land in the epoch partition
+func WithDefaultTimestampToNow() Option {
+	return func(b *BigQueryLoader) {
+		b.defaultTimestamp = true
+	}
+}
+
+// defaultTimestamps sets the zero Timestamp fields of rows to now and returns how many were changed
+func defaultTimestamps(rows []any, now time.Time) int {
+	defaulted := 0
+	for _, row := range rows {
+		v := reflect.ValueOf(row)
+		if v.Kind() != reflect.Pointer || v.IsNil() {
+			continue
+		}
+		field := v.Elem().FieldByName("Timestamp")
+		if !field.IsValid() || field.Type() != timeType || !field.CanSet() {
+			continue
+		}
+		if field.Interface().(time.Time).IsZero() {
+			field.Set(reflect.ValueOf(now))
+			defaulted++
+		}
+	}
+	return defaulted
+}
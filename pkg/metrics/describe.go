@@ -0,0 +1,108 @@
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// TableDescription summarizes a single non-empty table of a metrics file
+type TableDescription struct {
+	Table        string              `json:"table"`
+	Rows         int                 `json:"rows"`
+	Columns      []ColumnDescription `json:"columns,omitempty"`
+	MinTimestamp *time.Time          `json:"min_timestamp,omitempty"`
+	MaxTimestamp *time.Time          `json:"max_timestamp,omitempty"`
+	// SchemaError is set instead of Columns when the schema of the table cannot be built
+	SchemaError string `json:"schema_error,omitempty"`
+}
+
+// ColumnDescription is a top-level column of a table schema
+type ColumnDescription struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Repeated bool   `json:"repeated,omitempty"`
+}
+
+// DescribeMetricsData describes every non-empty table of data with the schema a load with the same options would
+// use and the range of its row timestamps, without making any GCS or BigQuery calls. Zero timestamps are ignored.
+func DescribeMetricsData(data *MetricsData, opts ...Option) []TableDescription {
+	b := NewBigQueryLoader(context.Background(), nil, "", "", opts...)
+	var descriptions []TableDescription
+	for _, t := range b.tablesOf(data) {
+		if len(t.rows) == 0 {
+			continue
+		}
+		description := TableDescription{Table: t.name, Rows: len(t.rows)}
+		if schema, _, err := b.prepareTable(t); err != nil {
+			description.SchemaError = err.Error()
+		} else {
+			for _, field := range schema {
+				description.Columns = append(description.Columns, ColumnDescription{Name: field.Name, Type: string(field.Type), Repeated: field.Repeated})
+			}
+		}
+		for _, row := range t.rows {
+			ts, ok := rowTimestamp(row)
+			if !ok || ts.IsZero() {
+				continue
+			}
+			if description.MinTimestamp == nil || ts.Before(*description.MinTimestamp) {
+				description.MinTimestamp = &ts
+			}
+			if description.MaxTimestamp == nil || ts.After(*description.MaxTimestamp) {
+				description.MaxTimestamp = &ts
+			}
+		}
+		descriptions = append(descriptions, description)
+	}
+	return descriptions
+}
+
+// DescribeMetrics writes the descriptions of DescribeMetricsData to w. The text format prints a header per table
+// followed by one line per column, while the JSON format writes a single object with a list of tables.
+func DescribeMetrics(w io.Writer, data *MetricsData, format string, opts ...Option) error {
+	descriptions := DescribeMetricsData(data, opts...)
+	switch format {
+	case OutputJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(map[string][]TableDescription{"tables": descriptions}); err != nil {
+			return fmt.Errorf("failed to encode description: %w", err)
+		}
+	case OutputText:
+		for _, d := range descriptions {
+			timestamps := "no timestamps"
+			if d.MinTimestamp != nil {
+				timestamps = fmt.Sprintf("%s to %s", d.MinTimestamp.UTC().Format(time.RFC3339), d.MaxTimestamp.UTC().Format(time.RFC3339))
+			}
+			if _, err := fmt.Fprintf(w, "=== %s (%d rows, %s) ===\n", d.Table, d.Rows, timestamps); err != nil {
+				return err
+			}
+			if d.SchemaError != "" {
+				if _, err := fmt.Fprintf(w, "  schema error: %s\n", d.SchemaError); err != nil {
+					return err
+				}
+				continue
+			}
+			width := 0
+			for _, column := range d.Columns {
+				width = max(width, len(column.Name))
+			}
+			for _, column := range d.Columns {
+				columnType := column.Type
+				if column.Repeated {
+					columnType = "REPEATED " + columnType
+				}
+				if _, err := fmt.Fprintf(w, "  %s%s  %s\n", column.Name, strings.Repeat(" ", width-len(column.Name)), columnType); err != nil {
+					return err
+				}
+			}
+		}
+	default:
+		return fmt.Errorf("unsupported output format %q", format)
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package metrics
+
+import (
+	"reflect"
+
+	"cloud.google.com/go/bigquery"
+)
+
+//go:generate go run ../../hack/gen-field-descriptions -output zz_generated.descriptions.go -package metrics -roots github.com/droslean/ci-metrics-bigquery/pkg/metrics.ImageEventUnion,github.com/droslean/ci-metrics-bigquery/pkg/metrics.LeaseEventUnion,github.com/openshift/ci-tools/pkg/metrics.NodeEvent,github.com/openshift/ci-tools/pkg/metrics.InsightsEvent,github.com/openshift/ci-tools/pkg/metrics.BuildEvent,github.com/openshift/ci-tools/pkg/metrics.PodLifecycleMetricsEvent,github.com/openshift/ci-tools/pkg/metrics.Event . github.com/openshift/ci-tools/pkg/metrics
+
+// WithFieldDescriptions sets the description of every column, including those of nested records, to the comment
+// of the struct field it was inferred from. The comments are extracted into fieldDescriptions by go generate;
+// columns of uncommented fields keep an empty description. Descriptions are only set on tables the loader creates.
+func WithFieldDescriptions() Option {
+	return func(b *BigQueryLoader) {
+		b.fieldDescriptions = true
+	}
+}
+
+// describeFields returns a copy of schema, inferred from the struct type t, with the description of every column
+// taken from the comment of its field
+func describeFields(schema bigquery.Schema, t reflect.Type) bigquery.Schema {
+	for t.Kind() == reflect.Pointer || t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return schema
+	}
+
+	out := make(bigquery.Schema, 0, len(schema))
+	for _, field := range schema {
+		described := *field
+		if structField, ok := t.FieldByName(field.Name); ok {
+			if described.Description == "" {
+				described.Description = fieldDescriptions[declaringType(t, structField)][structField.Name]
+			}
+			if field.Type == bigquery.RecordFieldType {
+				described.Schema = describeFields(field.Schema, structField.Type)
+			}
+		}
+		out = append(out, &described)
+	}
+	return out
+}
+
+// declaringType returns the fieldDescriptions key of the struct that declares field, which differs from t for
+// fields promoted from embedded structs
+func declaringType(t reflect.Type, field reflect.StructField) string {
+	for _, i := range field.Index[:len(field.Index)-1] {
+		t = t.Field(i).Type
+		for t.Kind() == reflect.Pointer {
+			t = t.Elem()
+		}
+	}
+	return t.PkgPath() + "." + t.Name()
+}
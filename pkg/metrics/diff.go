@@ -0,0 +1,52 @@
+package metrics
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+)
+
+// WithPrevious skips every table whose rows are identical to those in previous, so that reloading a
+// corrected file only inserts the sections that actually changed. A nil previous loads everything.
+func WithPrevious(previous *MetricsData) Option {
+	return func(b *BigQueryLoader) {
+		b.previous = previous
+	}
+}
+
+// TableHashes returns a content hash of the rows of every table, keyed by table name
+func TableHashes(data *MetricsData) (map[string]string, error) {
+	hashes := map[string]string{}
+	for _, t := range data.tables() {
+		encoded, err := json.Marshal(t.rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to hash %s: %w", t.name, err)
+		}
+		sum := sha256.Sum256(encoded)
+		hashes[t.name] = hex.EncodeToString(sum[:])
+	}
+	return hashes, nil
+}
+
+// unchangedTables returns the tables of data whose rows match the previous file
+func unchangedTables(data, previous *MetricsData) (map[string]bool, error) {
+	if previous == nil {
+		return nil, nil
+	}
+	current, err := TableHashes(data)
+	if err != nil {
+		return nil, err
+	}
+	old, err := TableHashes(previous)
+	if err != nil {
+		return nil, err
+	}
+	unchanged := map[string]bool{}
+	for table, hash := range current {
+		if old[table] == hash {
+			unchanged[table] = true
+		}
+	}
+	return unchanged, nil
+}
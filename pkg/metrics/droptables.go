@@ -0,0 +1,38 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"cloud.google.com/go/bigquery"
+)
+
+// WithDropTablesFirst deletes every table written to before creating it again and loading into it, once per run
+// with the shared TableCreationGuard, for reproducible development loads. Tables whose dataset.table name does not
+// start with prefix are refused and fail to load, so that a misconfigured run cannot delete production tables.
+func WithDropTablesFirst(prefix string) Option {
+	return func(b *BigQueryLoader) {
+		b.dropTablesPrefix = prefix
+	}
+}
+
+// dropTable returns the function deleting a table before it is created, or nil when tables are not dropped
+func (b *BigQueryLoader) dropTable() func(context.Context, *bigquery.Table) error {
+	if b.dropTablesPrefix == "" {
+		return nil
+	}
+	return func(ctx context.Context, table *bigquery.Table) error {
+		name := table.DatasetID + "." + table.TableID
+		if !strings.HasPrefix(name, b.dropTablesPrefix) {
+			return fmt.Errorf("refusing to drop table %s: it does not start with %q", name, b.dropTablesPrefix)
+		}
+		if err := table.Delete(ctx); isNotFoundError(err) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("failed to drop table %s: %w", name, err)
+		}
+		b.logger.Warnf("Dropped table %s.%s before loading", table.ProjectID, name)
+		return nil
+	}
+}
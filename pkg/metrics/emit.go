@@ -0,0 +1,37 @@
+package metrics
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// emittedRow is the envelope EmitNDJSON wraps the rows of every table in, naming the table each comes from
+type emittedRow struct {
+	Table string `json:"table"`
+	Row   any    `json:"row"`
+}
+
+// EmitNDJSON writes the rows of table to w as NDJSON, one row per line with the JSON field names of the metrics file,
+// for piping into other tools. With an empty table the rows of every table are written, each line wrapped in an
+// envelope of the form {"table": "nodes", "row": {...}}.
+func EmitNDJSON(w io.Writer, data *MetricsData, table string) error {
+	buffered := bufio.NewWriter(w)
+	encoder := json.NewEncoder(buffered)
+	for _, t := range data.tables() {
+		if table != "" && t.name != table {
+			continue
+		}
+		for i, row := range t.rows {
+			var line any = row
+			if table == "" {
+				line = emittedRow{Table: t.name, Row: row}
+			}
+			if err := encoder.Encode(line); err != nil {
+				return fmt.Errorf("failed to encode %s row %d: %w", t.name, i, err)
+			}
+		}
+	}
+	return buffered.Flush()
+}
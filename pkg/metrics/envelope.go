@@ -0,0 +1,179 @@
+package metrics
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"cloud.google.com/go/bigquery"
+)
+
+// EnvelopeColumn is a STRING column added to every table by WithEnvelopeColumns, holding a field of the envelope
+// the metrics file is wrapped in
+type EnvelopeColumn struct {
+	Name string
+	// Field is the dotted path of the field from the root of the file, such as metadata.job_name
+	Field string
+}
+
+// WithEnvelopeColumns adds a column to every table for each of columns, holding the value its field has in the
+// envelope of the file loaded, as set with DecodeOptions.EnvelopePath. Strings are stored as they are, other
+// values as JSON, and fields missing from the file as NULL. Files decoded by the caller need
+// DecodeOptions.EnvelopeFields to list the fields.
+func WithEnvelopeColumns(columns []EnvelopeColumn) Option {
+	return func(b *BigQueryLoader) {
+		b.envelopeColumns = columns
+		for _, column := range columns {
+			if !slices.Contains(b.decodeOpts.EnvelopeFields, column.Field) {
+				b.decodeOpts.EnvelopeFields = append(b.decodeOpts.EnvelopeFields, column.Field)
+			}
+		}
+	}
+}
+
+// envelope walks the objects wrapping the metrics sections of a file, capturing the fields asked for on the way
+type envelope struct {
+	path   []string
+	fields []string
+	values map[string]string
+}
+
+func newEnvelope(opts DecodeOptions) *envelope {
+	e := &envelope{fields: opts.EnvelopeFields, values: map[string]string{}}
+	if opts.EnvelopePath != "" {
+		e.path = strings.Split(opts.EnvelopePath, ".")
+	}
+	return e
+}
+
+// enter reads the keys of the object at depth of the path until the next object of the path, and the start of that
+// object. The decoder is expected to be inside the object at depth.
+func (e *envelope) enter(decoder *json.Decoder, depth int) error {
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		key, _ := token.(string)
+		if key != e.path[depth] {
+			if err := e.capture(decoder, depth, key); err != nil {
+				return err
+			}
+			continue
+		}
+		token, err = decoder.Token()
+		if err != nil {
+			return err
+		}
+		if delim, ok := token.(json.Delim); !ok || delim != '{' {
+			return fmt.Errorf("envelope path %s: expected an object, got %v", strings.Join(e.path[:depth+1], "."), token)
+		}
+		return nil
+	}
+	return fmt.Errorf("envelope path %s not found", strings.Join(e.path[:depth+1], "."))
+}
+
+// leave reads the keys of the object at depth of the path that follow the object it holds, and its end
+func (e *envelope) leave(decoder *json.Decoder, depth int) error {
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		key, _ := token.(string)
+		if err := e.capture(decoder, depth, key); err != nil {
+			return err
+		}
+	}
+	return expectDelim(decoder, '}')
+}
+
+// capture reads the value of key in the object at depth of the path, recording the fields asked for within it
+func (e *envelope) capture(decoder *json.Decoder, depth int, key string) error {
+	prefix := strings.Join(append(slices.Clip(e.path[:depth]), key), ".")
+	var raw json.RawMessage
+	if err := decoder.Decode(&raw); err != nil {
+		return err
+	}
+	for _, field := range e.fields {
+		if field == prefix {
+			e.record(field, raw)
+		} else if rest, ok := strings.CutPrefix(field, prefix+"."); ok {
+			if value, found := lookupJSON(raw, strings.Split(rest, ".")); found {
+				e.record(field, value)
+			}
+		}
+	}
+	return nil
+}
+
+func (e *envelope) record(field string, raw json.RawMessage) {
+	var value any
+	if err := json.Unmarshal(raw, &value); err != nil || value == nil {
+		return
+	}
+	if s, ok := value.(string); ok {
+		e.values[field] = s
+		return
+	}
+	var compacted bytes.Buffer
+	if err := json.Compact(&compacted, raw); err == nil {
+		e.values[field] = compacted.String()
+	}
+}
+
+// lookupJSON returns the value at path within the JSON object raw
+func lookupJSON(raw json.RawMessage, path []string) (json.RawMessage, bool) {
+	for _, key := range path {
+		var object map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &object); err != nil {
+			return nil, false
+		}
+		value, ok := object[key]
+		if !ok {
+			return nil, false
+		}
+		raw = value
+	}
+	return raw, true
+}
+
+// envelopeRow saves a row along with the envelope columns
+type envelopeRow struct {
+	row     any
+	schema  bigquery.Schema
+	columns []EnvelopeColumn
+	values  map[string]string
+}
+
+func (r *envelopeRow) Save() (map[string]bigquery.Value, string, error) {
+	values, insertID, err := saveRow(r.row, r.schema)
+	if err != nil {
+		return nil, "", err
+	}
+	for _, column := range r.columns {
+		values[column.Name] = nil
+		if value, ok := r.values[column.Field]; ok {
+			values[column.Name] = value
+		}
+	}
+	return values, insertID, nil
+}
+
+// withEnvelopeColumns adds the envelope columns to schema, filled from the envelope of t
+func withEnvelopeColumns(t tableData, schema bigquery.Schema, rows []any, columns []EnvelopeColumn) (bigquery.Schema, []any, error) {
+	extended := schema[:len(schema):len(schema)]
+	for _, column := range columns {
+		if hasColumn(extended, column.Name) {
+			return nil, nil, fmt.Errorf("table %s already has a %s column", t.name, column.Name)
+		}
+		extended = append(extended, &bigquery.FieldSchema{Name: column.Name, Type: bigquery.StringFieldType})
+	}
+	wrapped := make([]any, 0, len(rows))
+	for _, row := range rows {
+		wrapped = append(wrapped, &envelopeRow{row: row, schema: schema, columns: columns, values: t.envelope})
+	}
+	return extended, wrapped, nil
+}
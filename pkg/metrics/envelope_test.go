@@ -0,0 +1,85 @@
+package metrics
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestDecodeEnvelope(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		fixture  string
+		opts     DecodeOptions
+		expected map[string]string
+	}{
+		{
+			name:    "flat",
+			fixture: "testdata/envelope_flat.json",
+			opts: DecodeOptions{
+				EnvelopePath:   "data",
+				EnvelopeFields: []string{"metadata.job.name", "metadata.job.build_id", "producer", "metadata.missing"},
+			},
+			expected: map[string]string{
+				"metadata.job.name":     "pull-ci-openshift-release-master-e2e",
+				"metadata.job.build_id": "1234",
+				"producer":              "ci-operator",
+			},
+		},
+		{
+			name:    "nested",
+			fixture: "testdata/envelope_nested.json",
+			opts: DecodeOptions{
+				EnvelopePath:   "report.payload.data",
+				EnvelopeFields: []string{"kind", "report.metadata.job", "report.payload.checksum", "producer"},
+			},
+			expected: map[string]string{
+				"kind":                    "MetricsReport",
+				"report.metadata.job":     `{"name":"pull-ci-openshift-release-master-e2e","build_id":1234}`,
+				"report.payload.checksum": "abc",
+				"producer":                "ci-operator",
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			file, err := os.Open(tc.fixture)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer file.Close()
+			data, err := DecodeMetricsDataWithOptions(file, tc.opts)
+			if err != nil {
+				t.Fatalf("failed to decode: %v", err)
+			}
+			if len(data.Leases) != 1 || data.Leases[0].LeaseName != "aws-quota-slice" || data.Leases[0].LeasesTotal != 10 {
+				t.Errorf("unexpected leases %+v", data.Leases)
+			}
+			if len(data.Nodes) != 0 {
+				t.Errorf("expected no nodes, got %d", len(data.Nodes))
+			}
+			if !reflect.DeepEqual(data.envelope, tc.expected) {
+				t.Errorf("expected envelope fields %v, got %v", tc.expected, data.envelope)
+			}
+		})
+	}
+}
+
+func TestDecodeEnvelopeWrongPath(t *testing.T) {
+	for _, tc := range []struct {
+		fixture string
+		path    string
+	}{
+		{fixture: "testdata/envelope_flat.json", path: "payload"},
+		{fixture: "testdata/envelope_nested.json", path: "data"},
+		{fixture: "testdata/envelope_nested.json", path: "report.metadata.job.name"},
+	} {
+		file, err := os.Open(tc.fixture)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := DecodeMetricsDataWithOptions(file, DecodeOptions{EnvelopePath: tc.path}); err == nil {
+			t.Errorf("expected decoding %s at %s to fail", tc.fixture, tc.path)
+		}
+		file.Close()
+	}
+}
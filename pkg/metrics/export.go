@@ -1,39 +1,48 @@
 package metrics
 
 import (
+	"compress/gzip"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/sirupsen/logrus"
-
-	"cloud.google.com/go/storage"
+	"google.golang.org/api/option"
 
 	citoolsmetrics "github.com/openshift/ci-tools/pkg/metrics"
 )
 
 // ExportMetricsFromGCS reads metrics from GCS and exports them as JSON files for manual BigQuery import
-func ExportMetricsFromGCS(ctx context.Context, bucket, object, exportDir string) error {
-	gcsClient, err := storage.NewClient(ctx)
+func ExportMetricsFromGCS(ctx context.Context, bucket, object, exportDir string, clientOpts ...option.ClientOption) error {
+	data, err := ReadMetricsFromGCS(ctx, bucket, object, clientOpts...)
 	if err != nil {
-		return fmt.Errorf("failed to create GCS client: %w", err)
+		return err
 	}
-	defer gcsClient.Close()
 
-	obj := gcsClient.Bucket(bucket).Object(object)
-	reader, err := obj.NewReader(ctx)
+	return ExportMetricsData(data, exportDir)
+}
+
+// ExportMetricsFromGCSWithManifest exports like ExportMetricsFromGCS and also writes a manifest.json describing the files
+func ExportMetricsFromGCSWithManifest(ctx context.Context, bucket, object, exportDir string, clientOpts ...option.ClientOption) error {
+	data, err := ReadMetricsFromGCS(ctx, bucket, object, clientOpts...)
 	if err != nil {
-		return fmt.Errorf("failed to open GCS object: %w", err)
+		return err
 	}
-	defer reader.Close()
 
-	var data MetricsData
-	if err := json.NewDecoder(reader).Decode(&data); err != nil {
-		return fmt.Errorf("failed to decode JSON from GCS: %w", err)
+	if err := ExportMetricsData(data, exportDir); err != nil {
+		return err
 	}
+	return WriteExportManifest(data, exportDir, fmt.Sprintf("gs://%s/%s", bucket, object), time.Now(), false)
+}
 
+// ExportMetricsData exports already decoded metrics as JSON files for manual BigQuery import
+func ExportMetricsData(data *MetricsData, exportDir string) error {
 	if err := os.MkdirAll(exportDir, 0755); err != nil {
 		return fmt.Errorf("failed to create export directory: %w", err)
 	}
@@ -93,6 +102,68 @@ func ExportMetricsFromGCS(ctx context.Context, bucket, object, exportDir string)
 	return nil
 }
 
+// exportSourceExtensions are trimmed from the file name of a source to name its export subdirectory
+var exportSourceExtensions = []string{ZstdSuffix, ".gz", ".ndjson", ".json"}
+
+// ExportSubdirs returns the directory, relative to the export directory, that the export of each source is written
+// to when many sources are exported into one directory: the bucket and object path of a gs:// source, or the path
+// of a local file, without the compression and JSON extensions of the file name, so gs://bucket/a/metrics.json
+// becomes bucket/a/metrics. Path elements that are not safe directory names are replaced by "_". A directory already
+// taken by an earlier source gets the first free "~2", "~3"... suffix, so the layout only depends on the order of
+// sources.
+func ExportSubdirs(sources []string) []string {
+	used := map[string]bool{}
+	subdirs := make([]string, 0, len(sources))
+	for _, source := range sources {
+		var elements []string
+		for _, element := range strings.Split(strings.TrimPrefix(source, "gs://"), "/") {
+			switch element {
+			case "", ".":
+				continue
+			case "..":
+				element = "_"
+			}
+			elements = append(elements, element)
+		}
+		if len(elements) == 0 {
+			elements = []string{"_"}
+		}
+		last := elements[len(elements)-1]
+		for trimmed := true; trimmed; {
+			trimmed = false
+			for _, extension := range exportSourceExtensions {
+				if name, ok := strings.CutSuffix(last, extension); ok && name != "" {
+					last, trimmed = name, true
+				}
+			}
+		}
+		elements[len(elements)-1] = last
+
+		subdir := filepath.Join(elements...)
+		for n := 2; used[subdir]; n++ {
+			subdir = fmt.Sprintf("%s~%d", filepath.Join(elements...), n)
+		}
+		used[subdir] = true
+		subdirs = append(subdirs, subdir)
+	}
+	return subdirs
+}
+
+// ExportEmptyTables writes an empty file for every table of data that has no rows, so that together with
+// ExportMetricsData every table has a file and consumers see a fixed set of files
+func ExportEmptyTables(data *MetricsData, exportDir string) error {
+	for _, t := range data.tables() {
+		if len(t.rows) > 0 {
+			continue
+		}
+		filename := t.name + ".json"
+		if err := os.WriteFile(filepath.Join(exportDir, filename), nil, 0644); err != nil {
+			return fmt.Errorf("failed to create %s: %w", filename, err)
+		}
+	}
+	return nil
+}
+
 func exportTable(exportDir, filename string, data interface{}) error {
 	filePath := filepath.Join(exportDir, filename)
 	file, err := os.Create(filePath)
@@ -151,3 +222,70 @@ func exportTable(exportDir, filename string, data interface{}) error {
 
 	return nil
 }
+
+// ReadExportDir reads a directory of <table>.json NDJSON files, as written by ExportMetricsData, back into
+// MetricsData so that exported files can be edited and reloaded. Files may be gzipped (<table>.json.gz).
+// Files that do not name a known table are skipped with a warning.
+func ReadExportDir(dir string) (*MetricsData, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read export directory: %w", err)
+	}
+
+	logger := logrus.WithField("component", "readExportDir")
+	data := &MetricsData{}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		name := entry.Name()
+		compressed := strings.HasSuffix(name, ".gz")
+		table, ok := strings.CutSuffix(strings.TrimSuffix(name, ".gz"), ".json")
+		decode, known := sectionDecoders[table]
+		if !ok || !known {
+			logger.Warnf("Skipping %s: it does not match a known table", name)
+			continue
+		}
+
+		count, err := readExportFile(filepath.Join(dir, name), compressed, func(raw json.RawMessage) error { return decode(data, raw) })
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", name, err)
+		}
+		logger.Infof("Read %d rows for %s from %s", count, table, name)
+	}
+	return data, nil
+}
+
+// readExportFile passes every JSON value of an NDJSON file to decode and returns how many it read
+func readExportFile(path string, compressed bool, decode func(json.RawMessage) error) (int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	var r io.Reader = file
+	if compressed {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return 0, fmt.Errorf("failed to open gzip stream: %w", err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	decoder := json.NewDecoder(skipPreamble(r))
+	count := 0
+	for {
+		var raw json.RawMessage
+		if err := decoder.Decode(&raw); errors.Is(err, io.EOF) {
+			return count, nil
+		} else if err != nil {
+			return count, fmt.Errorf("record %d: %w", count, err)
+		}
+		if err := decode(raw); err != nil {
+			return count, fmt.Errorf("record %d: %w", count, err)
+		}
+		count++
+	}
+}
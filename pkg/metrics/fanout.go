@@ -0,0 +1,63 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// DatasetRef names a dataset in a project
+type DatasetRef struct {
+	ProjectID string
+	DatasetID string
+}
+
+// ParseDatasetRef parses a project:dataset reference
+func ParseDatasetRef(value string) (DatasetRef, error) {
+	project, dataset, ok := strings.Cut(value, ":")
+	if !ok || project == "" || dataset == "" || strings.Contains(dataset, ":") {
+		return DatasetRef{}, fmt.Errorf("expected project:dataset, got %q", value)
+	}
+	return DatasetRef{ProjectID: project, DatasetID: dataset}, nil
+}
+
+func (r DatasetRef) String() string {
+	return r.ProjectID + ":" + r.DatasetID
+}
+
+// WithAlsoWriteTo loads every file into each of datasets after it loaded into the loader's dataset, with the
+// same tables and options. The datasets must exist. A failure on one of them is logged and the load carries on
+// with the next, unless strict is set, in which case the load fails once every dataset was attempted.
+func WithAlsoWriteTo(datasets []DatasetRef, strict bool) Option {
+	return func(b *BigQueryLoader) {
+		b.fanOut = datasets
+		b.strictFanOut = strict
+	}
+}
+
+// fanOutData replays the load of data into every fan-out dataset
+func (b *BigQueryLoader) fanOutData(ctx context.Context, data *MetricsData) error {
+	var failures []error
+	for _, target := range b.fanOut {
+		secondary := *b
+		secondary.projectID, secondary.datasetID = target.ProjectID, target.DatasetID
+		secondary.datasetMetadata = nil
+		secondary.datasetEnsured = false
+		secondary.fanOut = nil
+		secondary.logger = b.logger.WithField("fanOutDataset", target.String())
+
+		result, err := secondary.loadDataset(ctx, data)
+		if err != nil {
+			err = fmt.Errorf("failed to write to %s: %w", target, err)
+			secondary.logger.WithError(err).Error("Failed to write to a secondary dataset")
+			failures = append(failures, err)
+			continue
+		}
+		secondary.logger.Infof("Wrote %d tables to the secondary dataset %s", result.TablesWithData(), target)
+	}
+	if b.strictFanOut {
+		return errors.Join(failures...)
+	}
+	return nil
+}
@@ -0,0 +1,233 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+)
+
+// fieldTypeConversions lists the column types each inferred type can be overridden with. INTEGER cannot become
+// FLOAT, which rounds integers above 2^53.
+var fieldTypeConversions = map[bigquery.FieldType][]bigquery.FieldType{
+	bigquery.FloatFieldType:     {bigquery.NumericFieldType, bigquery.BigNumericFieldType, bigquery.StringFieldType},
+	bigquery.IntegerFieldType:   {bigquery.NumericFieldType, bigquery.BigNumericFieldType, bigquery.StringFieldType},
+	bigquery.StringFieldType:    {bigquery.NumericFieldType, bigquery.BigNumericFieldType, bigquery.BytesFieldType},
+	bigquery.BooleanFieldType:   {bigquery.StringFieldType},
+	bigquery.TimestampFieldType: {bigquery.StringFieldType},
+}
+
+// ParseFieldType returns the BigQuery column type named by name, case-insensitively
+func ParseFieldType(name string) (bigquery.FieldType, error) {
+	fieldType := bigquery.FieldType(strings.ToUpper(name))
+	switch fieldType {
+	case bigquery.StringFieldType, bigquery.BytesFieldType, bigquery.IntegerFieldType, bigquery.FloatFieldType,
+		bigquery.BooleanFieldType, bigquery.TimestampFieldType, bigquery.RecordFieldType, bigquery.DateFieldType,
+		bigquery.TimeFieldType, bigquery.DateTimeFieldType, bigquery.NumericFieldType, bigquery.GeographyFieldType,
+		bigquery.BigNumericFieldType, bigquery.IntervalFieldType, bigquery.JSONFieldType, bigquery.RangeFieldType:
+		return fieldType, nil
+	}
+	return "", fmt.Errorf("%q is not a BigQuery column type", name)
+}
+
+// WithFieldType stores the column at path of table, a dot-separated path into nested records, as fieldType
+// instead of the inferred type, converting every value: for example FLOAT durations as exact NUMERIC decimals.
+// Path elements are matched case-insensitively against the column names as created, i.e. after WithColumnCase
+// when it comes first. Only lossless conversions are allowed; see fieldTypeConversions.
+func WithFieldType(table, path string, fieldType bigquery.FieldType) Option {
+	return func(b *BigQueryLoader) {
+		b.transforms = append(b.transforms, fieldTypeOverride{table: table, path: strings.Split(path, "."), to: fieldType})
+	}
+}
+
+// FieldTypeError reports a WithFieldType override that does not apply to its table
+type FieldTypeError struct {
+	Table string
+	Path  string
+	Err   error
+}
+
+func (e *FieldTypeError) Error() string {
+	return fmt.Sprintf("cannot override the type of %s.%s: %v", e.Table, e.Path, e.Err)
+}
+
+func (e *FieldTypeError) Unwrap() error {
+	return e.Err
+}
+
+// CheckFieldTypes reports the WithFieldType overrides of opts that name no column or a column whose inferred
+// type cannot be converted, without loading anything
+func CheckFieldTypes(opts ...Option) []*FieldTypeError {
+	b := NewBigQueryLoader(context.Background(), nil, "", "", opts...)
+	var failures []*FieldTypeError
+	for _, t := range b.tablesOf(&MetricsData{}) {
+		var fieldTypeErr *FieldTypeError
+		if _, _, err := b.prepareTable(t); errors.As(err, &fieldTypeErr) {
+			failures = append(failures, fieldTypeErr)
+		}
+	}
+	return failures
+}
+
+// fieldTypeOverride changes the type of a single column and converts its values accordingly
+type fieldTypeOverride struct {
+	table string
+	path  []string
+	to    bigquery.FieldType
+}
+
+func (o fieldTypeOverride) schema(table string, in bigquery.Schema) (bigquery.Schema, error) {
+	if table != o.table {
+		return in, nil
+	}
+	out, err := o.overrideSchema(in, o.path)
+	if err != nil {
+		return nil, &FieldTypeError{Table: table, Path: strings.Join(o.path, "."), Err: err}
+	}
+	return out, nil
+}
+
+// overrideSchema returns a copy of schema with the type of the column at path replaced
+func (o fieldTypeOverride) overrideSchema(schema bigquery.Schema, path []string) (bigquery.Schema, error) {
+	i := slices.IndexFunc(schema, func(field *bigquery.FieldSchema) bool { return strings.EqualFold(field.Name, path[0]) })
+	if i < 0 {
+		return nil, fmt.Errorf("column %s does not exist", path[0])
+	}
+	out := slices.Clone(schema)
+	field := *schema[i]
+	if len(path) > 1 {
+		if field.Type != bigquery.RecordFieldType {
+			return nil, fmt.Errorf("column %s is a %s, not a RECORD", field.Name, field.Type)
+		}
+		nested, err := o.overrideSchema(field.Schema, path[1:])
+		if err != nil {
+			return nil, err
+		}
+		field.Schema = nested
+	} else {
+		if !slices.Contains(fieldTypeConversions[field.Type], o.to) {
+			return nil, fmt.Errorf("a %s column cannot be stored as %s", field.Type, o.to)
+		}
+		field.Type = o.to
+	}
+	out[i] = &field
+	return out, nil
+}
+
+func (o fieldTypeOverride) row(table string, in bigquery.Schema, values map[string]bigquery.Value) (map[string]bigquery.Value, error) {
+	if table != o.table {
+		return values, nil
+	}
+	if err := o.convertValues(in, o.path, values); err != nil {
+		return nil, fmt.Errorf("failed to convert %s: %w", strings.Join(o.path, "."), err)
+	}
+	return values, nil
+}
+
+// convertValues converts the value at path of a saved row, or of every record of a repeated record, in place
+func (o fieldTypeOverride) convertValues(schema bigquery.Schema, path []string, values map[string]bigquery.Value) error {
+	i := slices.IndexFunc(schema, func(field *bigquery.FieldSchema) bool { return strings.EqualFold(field.Name, path[0]) })
+	field := schema[i]
+	value, ok := values[field.Name]
+	if !ok || isNil(value) {
+		return nil
+	}
+	if len(path) > 1 {
+		switch v := value.(type) {
+		case map[string]bigquery.Value:
+			return o.convertValues(field.Schema, path[1:], v)
+		case []bigquery.Value:
+			for _, item := range v {
+				if nested, ok := item.(map[string]bigquery.Value); ok {
+					if err := o.convertValues(field.Schema, path[1:], nested); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		return nil
+	}
+
+	if !field.Repeated {
+		converted, err := convertFieldValue(value, o.to)
+		if err != nil {
+			return err
+		}
+		values[field.Name] = converted
+		return nil
+	}
+	items := reflect.ValueOf(value)
+	if items.Kind() != reflect.Slice && items.Kind() != reflect.Array {
+		return fmt.Errorf("repeated field has non-slice value %T", value)
+	}
+	converted := make([]bigquery.Value, 0, items.Len())
+	for j := 0; j < items.Len(); j++ {
+		item, err := convertFieldValue(items.Index(j).Interface(), o.to)
+		if err != nil {
+			return err
+		}
+		converted = append(converted, item)
+	}
+	values[field.Name] = converted
+	return nil
+}
+
+// convertFieldValue converts a saved value to the representation of a column of type to. NUMERIC and BIGNUMERIC
+// values are decimal strings, which streaming inserts accept as is and the Storage Write API encoding parses.
+func convertFieldValue(value bigquery.Value, to bigquery.FieldType) (bigquery.Value, error) {
+	v := reflect.ValueOf(value)
+	switch to {
+	case bigquery.NumericFieldType, bigquery.BigNumericFieldType:
+		var decimal string
+		switch v.Kind() {
+		case reflect.Float32, reflect.Float64:
+			// the shortest representation keeps the decimal the producer wrote rather than its binary approximation
+			decimal = strconv.FormatFloat(v.Float(), 'g', -1, v.Type().Bits())
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			decimal = strconv.FormatInt(v.Int(), 10)
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			decimal = strconv.FormatUint(v.Uint(), 10)
+		case reflect.String:
+			if v.String() == "" {
+				return nil, nil
+			}
+			decimal = v.String()
+		default:
+			return nil, fmt.Errorf("cannot store %T as %s", value, to)
+		}
+		rat, ok := new(big.Rat).SetString(decimal)
+		if !ok {
+			return nil, fmt.Errorf("%q is not a decimal number", decimal)
+		}
+		if to == bigquery.NumericFieldType {
+			return bigquery.NumericString(rat), nil
+		}
+		return bigquery.BigNumericString(rat), nil
+	case bigquery.BytesFieldType:
+		if v.Kind() == reflect.String {
+			return []byte(v.String()), nil
+		}
+	case bigquery.StringFieldType:
+		if t, ok := value.(time.Time); ok {
+			return t.UTC().Format(time.RFC3339Nano), nil
+		}
+		switch v.Kind() {
+		case reflect.Float32, reflect.Float64:
+			return strconv.FormatFloat(v.Float(), 'g', -1, v.Type().Bits()), nil
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return strconv.FormatInt(v.Int(), 10), nil
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			return strconv.FormatUint(v.Uint(), 10), nil
+		case reflect.Bool:
+			return strconv.FormatBool(v.Bool()), nil
+		}
+	}
+	return nil, fmt.Errorf("cannot store %T as %s", value, to)
+}
@@ -0,0 +1,382 @@
+package metrics
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"math"
+	"reflect"
+	"strconv"
+	"sync"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/sirupsen/logrus"
+)
+
+// Modes of WithNonFiniteFloats
+const (
+	// DropNonFiniteRows drops the rows holding a NaN or infinite float
+	DropNonFiniteRows = "drop"
+	// NullNonFiniteFields sets the NaN and infinite floats to NULL
+	NullNonFiniteFields = "null"
+)
+
+// WithNonFiniteFloats checks every FLOAT value before insert, including those of nested records, for NaN and
+// infinities, which BigQuery rejects and which fail the whole insert of the rows sent with them. With
+// DropNonFiniteRows the rows holding one are dropped. With NullNonFiniteFields the fields are set to NULL instead,
+// a repeated field losing every value, and the FLOAT columns are created NULLABLE so that they can hold NULL. Both
+// are counted and logged per table. An empty mode disables the check. NaN and infinities only decode with
+// DecodeOptions.AllowNonFiniteFloats.
+func WithNonFiniteFloats(mode string) Option {
+	return func(b *BigQueryLoader) {
+		if mode != "" {
+			b.transforms = append(b.transforms, &nonFiniteFloats{null: mode == NullNonFiniteFields, dropped: map[string]int{}, nulled: map[string]int{}})
+		}
+	}
+}
+
+// nonFiniteFloats drops or nulls the non-finite FLOAT values of rows
+type nonFiniteFloats struct {
+	null bool
+
+	lock    sync.Mutex
+	dropped map[string]int
+	nulled  map[string]int
+}
+
+func (n *nonFiniteFloats) schema(_ string, in bigquery.Schema) (bigquery.Schema, error) {
+	if !n.null {
+		return in, nil
+	}
+	return nullableFloatColumns(in), nil
+}
+
+func (n *nonFiniteFloats) row(table string, in bigquery.Schema, values map[string]bigquery.Value) (map[string]bigquery.Value, error) {
+	fields := nonFiniteValues(in, values, n.null)
+	if fields == 0 {
+		return values, nil
+	}
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	if !n.null {
+		n.dropped[table]++
+		return nil, nil
+	}
+	n.nulled[table] += fields
+	return values, nil
+}
+
+func (n *nonFiniteFloats) report(table string, logger *logrus.Entry) {
+	n.lock.Lock()
+	dropped, nulled := n.dropped[table], n.nulled[table]
+	delete(n.dropped, table)
+	delete(n.nulled, table)
+	n.lock.Unlock()
+	if dropped > 0 {
+		logger.Warnf("Dropped %d %s rows holding a NaN or infinite float", dropped, table)
+	}
+	if nulled > 0 {
+		logger.Warnf("Set %d NaN or infinite float fields of %s to NULL", nulled, table)
+	}
+}
+
+// nullableFloatColumns relaxes the FLOAT columns of schema, including those of nested records, to NULLABLE
+func nullableFloatColumns(in bigquery.Schema) bigquery.Schema {
+	out := make(bigquery.Schema, 0, len(in))
+	for _, field := range in {
+		relaxed := *field
+		switch field.Type {
+		case bigquery.FloatFieldType:
+			relaxed.Required = false
+		case bigquery.RecordFieldType:
+			relaxed.Schema = nullableFloatColumns(field.Schema)
+		}
+		out = append(out, &relaxed)
+	}
+	return out
+}
+
+// nonFiniteValues counts the fields of a row holding a non-finite float, setting them to NULL when null is set
+func nonFiniteValues(schema bigquery.Schema, values map[string]bigquery.Value, null bool) int {
+	fields := 0
+	for _, field := range schema {
+		value, ok := values[field.Name]
+		if !ok {
+			continue
+		}
+		switch field.Type {
+		case bigquery.FloatFieldType:
+			if !isNonFinite(value) {
+				continue
+			}
+			fields++
+			if null {
+				values[field.Name] = nil
+			}
+		case bigquery.RecordFieldType:
+			switch v := value.(type) {
+			case map[string]bigquery.Value:
+				fields += nonFiniteValues(field.Schema, v, null)
+			case []bigquery.Value:
+				for _, item := range v {
+					if record, ok := item.(map[string]bigquery.Value); ok {
+						fields += nonFiniteValues(field.Schema, record, null)
+					}
+				}
+			}
+		}
+	}
+	return fields
+}
+
+// isNonFinite reports whether value is, or for a repeated field holds, a NaN or infinite float
+func isNonFinite(value bigquery.Value) bool {
+	switch v := value.(type) {
+	case float64:
+		return math.IsNaN(v) || math.IsInf(v, 0)
+	case float32:
+		return isNonFinite(float64(v))
+	case []float64:
+		for _, f := range v {
+			if isNonFinite(f) {
+				return true
+			}
+		}
+	case []bigquery.Value:
+		for _, item := range v {
+			if isNonFinite(item) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// nonFiniteLiterals are the bare NaN and infinity literals some JSON encoders write for non-finite floats
+var nonFiniteLiterals = [][]byte{[]byte("NaN"), []byte("Infinity"), []byte("-Infinity")}
+
+// nonFiniteLiteralReader quotes the bare non-finite literals found outside strings, which encoding/json rejects
+type nonFiniteLiteralReader struct {
+	r        *bufio.Reader
+	pending  []byte
+	inString bool
+	escaped  bool
+}
+
+// quoteNonFiniteLiterals returns a reader of r with every bare NaN, Infinity and -Infinity turned into a string,
+// which setNonFiniteFloats then sets in float fields
+func quoteNonFiniteLiterals(r io.Reader) io.Reader {
+	return &nonFiniteLiteralReader{r: bufio.NewReader(r)}
+}
+
+func (n *nonFiniteLiteralReader) Read(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		if len(n.pending) > 0 {
+			copied := copy(p[written:], n.pending)
+			n.pending = n.pending[copied:]
+			written += copied
+			continue
+		}
+		// return what was read so far instead of blocking on a slow source
+		if written > 0 && n.r.Buffered() == 0 {
+			break
+		}
+		c, err := n.r.ReadByte()
+		if err != nil {
+			if written > 0 {
+				return written, nil
+			}
+			return 0, err
+		}
+		switch {
+		case n.inString:
+			switch {
+			case n.escaped:
+				n.escaped = false
+			case c == '\\':
+				n.escaped = true
+			case c == '"':
+				n.inString = false
+			}
+		case c == '"':
+			n.inString = true
+		case c == 'N' || c == 'I' || c == '-':
+			if literal := n.literal(c); literal != nil {
+				n.pending = append(append(append(n.pending[:0], '"'), literal...), '"')
+				continue
+			}
+		}
+		p[written] = c
+		written++
+	}
+	return written, nil
+}
+
+// literal consumes and returns the non-finite literal starting with c, which was just read, if there is one
+func (n *nonFiniteLiteralReader) literal(c byte) []byte {
+	for _, literal := range nonFiniteLiterals {
+		if literal[0] != c {
+			continue
+		}
+		// Peek returns fewer bytes at the end of the stream, which cannot hold the literal then
+		if next, _ := n.r.Peek(len(literal) - 1); bytes.Equal(next, literal[1:]) {
+			_, _ = n.r.Discard(len(literal) - 1)
+			return literal
+		}
+	}
+	return nil
+}
+
+// parseNonFinite parses a NaN or infinite float written as a JSON string, such as "NaN", "Infinity" or "+Inf"
+func parseNonFinite(raw []byte) (float64, bool) {
+	if len(raw) == 0 || raw[0] != '"' {
+		return 0, false
+	}
+	var value string
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return 0, false
+	}
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil || !(math.IsNaN(parsed) || math.IsInf(parsed, 0)) {
+		return 0, false
+	}
+	return parsed, true
+}
+
+// zeroNonFiniteFloats walks raw guided by the Go type t and replaces the NaN and infinite floats written as strings
+// in float fields, including those of nested structs and arrays, with 0 so that raw decodes
+func zeroNonFiniteFloats(raw json.RawMessage, t reflect.Type) (json.RawMessage, bool, error) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	trimmed := bytes.TrimSpace(raw)
+	if len(trimmed) == 0 || bytes.Equal(trimmed, []byte("null")) {
+		return raw, false, nil
+	}
+
+	switch t.Kind() {
+	case reflect.Float32, reflect.Float64:
+		if _, ok := parseNonFinite(trimmed); ok {
+			return json.RawMessage("0"), true, nil
+		}
+
+	case reflect.Struct:
+		if t == timeType || trimmed[0] != '{' {
+			return raw, false, nil
+		}
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &fields); err != nil {
+			return nil, false, err
+		}
+		changed := false
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name, ok := jsonFieldName(field)
+			if !ok {
+				continue
+			}
+			value, ok := fields[name]
+			if !ok {
+				continue
+			}
+			normalized, fieldChanged, err := zeroNonFiniteFloats(value, field.Type)
+			if err != nil {
+				return nil, false, err
+			}
+			if fieldChanged {
+				fields[name] = normalized
+				changed = true
+			}
+		}
+		if !changed {
+			return raw, false, nil
+		}
+		encoded, err := json.Marshal(fields)
+		return encoded, err == nil, err
+
+	case reflect.Slice, reflect.Array:
+		if trimmed[0] != '[' {
+			return raw, false, nil
+		}
+		var items []json.RawMessage
+		if err := json.Unmarshal(raw, &items); err != nil {
+			return nil, false, err
+		}
+		changed := false
+		for i, item := range items {
+			normalized, itemChanged, err := zeroNonFiniteFloats(item, t.Elem())
+			if err != nil {
+				return nil, false, err
+			}
+			if itemChanged {
+				items[i] = normalized
+				changed = true
+			}
+		}
+		if !changed {
+			return raw, false, nil
+		}
+		encoded, err := json.Marshal(items)
+		return encoded, err == nil, err
+	}
+	return raw, false, nil
+}
+
+// setNonFiniteFloats walks raw along v, which raw was decoded into once zeroNonFiniteFloats replaced its NaN and
+// infinite floats, and sets them back in v
+func setNonFiniteFloats(raw json.RawMessage, v reflect.Value) error {
+	for v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	trimmed := bytes.TrimSpace(raw)
+	if len(trimmed) == 0 || bytes.Equal(trimmed, []byte("null")) {
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Float32, reflect.Float64:
+		if value, ok := parseNonFinite(trimmed); ok {
+			v.SetFloat(value)
+		}
+
+	case reflect.Struct:
+		if v.Type() == timeType || trimmed[0] != '{' {
+			return nil
+		}
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &fields); err != nil {
+			return err
+		}
+		for i := 0; i < v.NumField(); i++ {
+			name, ok := jsonFieldName(v.Type().Field(i))
+			if !ok {
+				continue
+			}
+			if value, ok := fields[name]; ok {
+				if err := setNonFiniteFloats(value, v.Field(i)); err != nil {
+					return err
+				}
+			}
+		}
+
+	case reflect.Slice, reflect.Array:
+		if trimmed[0] != '[' {
+			return nil
+		}
+		var items []json.RawMessage
+		if err := json.Unmarshal(raw, &items); err != nil {
+			return err
+		}
+		for i := 0; i < len(items) && i < v.Len(); i++ {
+			if err := setNonFiniteFloats(items[i], v.Index(i)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
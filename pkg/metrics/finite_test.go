@@ -0,0 +1,113 @@
+package metrics
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestDecodeNonFiniteFloats(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		value    string
+		expected func(float64) bool
+	}{
+		{name: "bare NaN", value: `NaN`, expected: math.IsNaN},
+		{name: "quoted NaN", value: `"NaN"`, expected: math.IsNaN},
+		{name: "bare Infinity", value: `Infinity`, expected: func(f float64) bool { return math.IsInf(f, 1) }},
+		{name: "quoted +Inf", value: `"+Inf"`, expected: func(f float64) bool { return math.IsInf(f, 1) }},
+		{name: "bare -Infinity", value: `-Infinity`, expected: func(f float64) bool { return math.IsInf(f, -1) }},
+		{name: "quoted -Infinity", value: `"-Infinity"`, expected: func(f float64) bool { return math.IsInf(f, -1) }},
+		{name: "finite", value: `-1.5`, expected: func(f float64) bool { return f == -1.5 }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			file := `{"images": [{"namespace": "ci-op-NaN", "tag_name": "Infinity", "duration_seconds": ` + tc.value + `}]}`
+			data, err := DecodeMetricsDataWithOptions(strings.NewReader(file), DecodeOptions{AllowNonFiniteFloats: true})
+			if err != nil {
+				t.Fatalf("failed to decode: %v", err)
+			}
+			if len(data.Images) != 1 {
+				t.Fatalf("expected 1 image, got %d", len(data.Images))
+			}
+			image := data.Images[0]
+			if !tc.expected(image.DurationSeconds) {
+				t.Errorf("unexpected duration_seconds %v", image.DurationSeconds)
+			}
+			if image.Namespace != "ci-op-NaN" || image.TagName != "Infinity" {
+				t.Errorf("strings holding a non-finite literal were changed: %q, %q", image.Namespace, image.TagName)
+			}
+
+			ndjson := `{"type": "images", "duration_seconds": ` + tc.value + `}` + "\n"
+			data, err = DecodeNDJSONWithOptions(strings.NewReader(ndjson), DecodeOptions{AllowNonFiniteFloats: true})
+			if err != nil {
+				t.Fatalf("failed to decode NDJSON: %v", err)
+			}
+			if len(data.Images) != 1 || !tc.expected(data.Images[0].DurationSeconds) {
+				t.Errorf("unexpected NDJSON images %v", data.Images)
+			}
+		})
+	}
+}
+
+func TestDecodeNonFiniteFloatsDisabled(t *testing.T) {
+	for _, value := range []string{`NaN`, `"NaN"`, `"+Inf"`} {
+		file := `{"images": [{"duration_seconds": ` + value + `}]}`
+		if _, err := DecodeMetricsData(strings.NewReader(file)); err == nil {
+			t.Errorf("expected %s to fail to decode without AllowNonFiniteFloats", value)
+		}
+	}
+}
+
+func TestNonFiniteFloats(t *testing.T) {
+	schema, err := inferSchema(ImageEventUnion{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name     string
+		mode     string
+		duration float64
+		dropped  bool
+		nulled   bool
+	}{
+		{name: "drop NaN", mode: DropNonFiniteRows, duration: math.NaN(), dropped: true},
+		{name: "drop +Inf", mode: DropNonFiniteRows, duration: math.Inf(1), dropped: true},
+		{name: "null NaN", mode: NullNonFiniteFields, duration: math.NaN(), nulled: true},
+		{name: "null +Inf", mode: NullNonFiniteFields, duration: math.Inf(1), nulled: true},
+		{name: "finite is kept", mode: DropNonFiniteRows, duration: 2.5},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			transform := &nonFiniteFloats{null: tc.mode == NullNonFiniteFields, dropped: map[string]int{}, nulled: map[string]int{}}
+			values, _, err := saveRow(&ImageEventUnion{Namespace: "ns", DurationSeconds: tc.duration}, schema)
+			if err != nil {
+				t.Fatal(err)
+			}
+			out, err := transform.row(ImagesTable, schema, values)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if dropped := out == nil; dropped != tc.dropped {
+				t.Fatalf("expected the row to be dropped: %t, got %t", tc.dropped, dropped)
+			}
+			if tc.dropped {
+				if transform.dropped[ImagesTable] != 1 {
+					t.Errorf("expected 1 dropped row, got %d", transform.dropped[ImagesTable])
+				}
+				return
+			}
+			if tc.nulled {
+				if out["DurationSeconds"] != nil {
+					t.Errorf("expected DurationSeconds to be NULL, got %v", out["DurationSeconds"])
+				}
+				if transform.nulled[ImagesTable] != 1 {
+					t.Errorf("expected 1 nulled field, got %d", transform.nulled[ImagesTable])
+				}
+			} else if out["DurationSeconds"] != tc.duration {
+				t.Errorf("expected DurationSeconds %v, got %v", tc.duration, out["DurationSeconds"])
+			}
+			if out["Namespace"] != "ns" {
+				t.Errorf("expected Namespace to be kept, got %v", out["Namespace"])
+			}
+		})
+	}
+}
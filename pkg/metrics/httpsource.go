@@ -0,0 +1,51 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// IsHTTPSource reports whether source is an http:// or https:// URL, such as a signed GCS URL
+func IsHTTPSource(source string) bool {
+	return strings.HasPrefix(source, "https://") || strings.HasPrefix(source, "http://")
+}
+
+// RedactURL returns rawURL without its query string and fragment. The query of a signed URL holds its signature,
+// which grants access to the object until it expires and must not end up in logs or error messages.
+func RedactURL(rawURL string) string {
+	redacted, _, _ := strings.Cut(rawURL, "#")
+	redacted, _, _ = strings.Cut(redacted, "?")
+	return redacted
+}
+
+// OpenURL opens a reader on the body of a plain GET of rawURL, decompressing zstd files (see ZstdSuffix). The URL
+// is requested exactly as given: its query string is sent untouched, so that the signature of a signed URL still
+// matches, and no Google credentials are added. A nil client uses http.DefaultClient. Errors name the URL
+// without its query.
+func OpenURL(ctx context.Context, client *http.Client, rawURL string) (io.ReadCloser, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL %s", RedactURL(rawURL))
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			urlErr.URL = RedactURL(urlErr.URL)
+		}
+		return nil, fmt.Errorf("failed to download %s: %w", RedactURL(rawURL), err)
+	}
+	if response.StatusCode != http.StatusOK {
+		response.Body.Close()
+		return nil, fmt.Errorf("failed to download %s: %s", RedactURL(rawURL), response.Status)
+	}
+	return decompress(request.URL.Path, response.Header.Get("Content-Encoding"), response.Body)
+}
@@ -0,0 +1,66 @@
+package metrics
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// signedQuery is the query string of a V4 signed GCS URL, whose escaping must reach the server untouched for the
+// signature to match
+const signedQuery = "X-Goog-Algorithm=GOOG4-RSA-SHA256&X-Goog-Credential=loader%40project.iam.gserviceaccount.com%2F20251015%2Fauto%2Fstorage%2Fgoog4_request&X-Goog-Date=20251015T000000Z&X-Goog-Expires=900&X-Goog-SignedHeaders=host&X-Goog-Signature=0a1b2c3d"
+
+func TestOpenURL(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			t.Errorf("expected a GET, got %s", r.Method)
+		}
+		if r.URL.RawQuery != signedQuery {
+			t.Errorf("expected the query string to be sent untouched, got %s", r.URL.RawQuery)
+		}
+		if auth := r.Header.Get("Authorization"); auth != "" {
+			t.Errorf("expected no Authorization header, got %q", auth)
+		}
+		_, _ = w.Write([]byte(`{"images": []}`))
+	}))
+	defer server.Close()
+
+	body, err := OpenURL(context.Background(), server.Client(), server.URL+"/bucket/metrics.json?"+signedQuery)
+	if err != nil {
+		t.Fatalf("failed to open URL: %v", err)
+	}
+	defer body.Close()
+	content, err := io.ReadAll(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != `{"images": []}` {
+		t.Errorf("unexpected body %s", content)
+	}
+}
+
+func TestOpenURLStatusErrors(t *testing.T) {
+	for _, status := range []int{http.StatusForbidden, http.StatusNotFound, http.StatusInternalServerError} {
+		t.Run(http.StatusText(status), func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, "denied", status)
+			}))
+			defer server.Close()
+
+			body, err := OpenURL(context.Background(), server.Client(), server.URL+"/bucket/metrics.json?"+signedQuery)
+			if err == nil {
+				body.Close()
+				t.Fatalf("expected a %d status to fail", status)
+			}
+			if !strings.Contains(err.Error(), http.StatusText(status)) {
+				t.Errorf("expected the error to name the status, got %v", err)
+			}
+			if strings.Contains(err.Error(), "X-Goog-Signature") {
+				t.Errorf("expected the error not to hold the signature, got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// sectionBatch is a batch of records of one section, decoded by LoadIncrementalContext
+type sectionBatch struct {
+	section string
+	index   int
+	data    *MetricsData
+}
+
+// LoadIncrementalContext loads a metrics JSON document while it is decoded: every batchSize records of a section
+// are inserted as soon as they are decoded, without waiting for the rest of the document, so that downloading and
+// parsing overlap with the inserts and no more than a batch of records is held at once. A failed batch is reported
+// with its section and its position in the section. Since no table is complete before its section was read,
+// WithRequiredTables is not checked and WithPrevious only skips the tables that fit in a single batch.
+func (b *BigQueryLoader) LoadIncrementalContext(ctx context.Context, r io.Reader, batchSize int) (*LoadResult, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// one batch is decoded while the previous one is inserted
+	batches := make(chan sectionBatch)
+	decoded := make(chan error, 1)
+	go func() {
+		defer close(batches)
+		_, err := decodeSections(r, b.decodeOpts, batchSize, func(section string, index int, data *MetricsData) error {
+			select {
+			case batches <- sectionBatch{section: section, index: index, data: data}:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+		decoded <- err
+	}()
+
+	result := &LoadResult{}
+	var failures []error
+	for batch := range batches {
+		b.logger.Debugf("Loading batch %d of section %s", batch.index, batch.section)
+		batchResult, err := b.loadData(ctx, batch.data)
+		result.Merge(batchResult)
+		if err == nil {
+			continue
+		}
+		err = fmt.Errorf("section %s: batch %d: %w", batch.section, batch.index, err)
+		if b.breaker == nil || errors.Is(err, ErrCircuitBreakerTripped) {
+			// the deferred cancel stops the decoder once it hands over its next batch
+			return result, err
+		}
+		failures = append(failures, err)
+	}
+	if err := <-decoded; err != nil {
+		return result, err
+	}
+
+	if len(failures) > 0 {
+		return result, errors.Join(failures...)
+	}
+	b.logSummary(result)
+	return result, nil
+}
@@ -0,0 +1,63 @@
+package metrics
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/option"
+)
+
+// Lineage columns added to every table
+const (
+	// IngestionIDColumn is the column WithIngestionID adds
+	IngestionIDColumn = "ingestion_id"
+	// SourceBucketColumn and SourceObjectColumn are the columns WithSourceColumns adds
+	SourceBucketColumn = "source_bucket"
+	SourceObjectColumn = "source_object"
+)
+
+// IngestionID derives a stable id for one version of a source, so that retrying the load of the same GCS
+// object generation (or local file modification) tags its rows with the same id
+func IngestionID(source string, generation int64) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s#%d", source, generation)))
+	return hex.EncodeToString(sum[:16])
+}
+
+// GCSObjectGeneration returns the current generation of a GCS object
+func GCSObjectGeneration(ctx context.Context, bucket, object string, clientOpts ...option.ClientOption) (int64, error) {
+	gcsClient, err := storage.NewClient(ctx, clientOpts...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create GCS client: %w", err)
+	}
+	defer gcsClient.Close()
+
+	attrs, err := gcsClient.Bucket(bucket).Object(object).Attrs(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read GCS object attributes: %w", err)
+	}
+	return attrs.Generation, nil
+}
+
+// WithIngestionID adds an ingestion_id column holding id to every row of every table, so that all rows of one
+// load can be grouped together
+func WithIngestionID(id string) Option {
+	return func(b *BigQueryLoader) {
+		if id != "" {
+			b.transforms = append(b.transforms, constantColumn{name: IngestionIDColumn, value: id})
+		}
+	}
+}
+
+// WithSourceColumns adds source_bucket and source_object columns naming the file every row was loaded from, so that
+// a bad row can be traced back to its origin. An empty bucket, as for local files, is stored as NULL.
+func WithSourceColumns(bucket, object string) Option {
+	return func(b *BigQueryLoader) {
+		b.transforms = append(b.transforms,
+			constantColumn{name: SourceBucketColumn, value: bucket},
+			constantColumn{name: SourceObjectColumn, value: object},
+		)
+	}
+}
@@ -0,0 +1,53 @@
+package metrics
+
+import (
+	"context"
+	"sync"
+
+	"cloud.google.com/go/bigquery"
+)
+
+// TableInsertLimiter bounds how many loaders insert into the same table at once, so that concurrent loads of
+// many files stay below BigQuery's per-table rate of update operations. Inserts into different tables are not
+// limited. It is safe for concurrent use.
+type TableInsertLimiter struct {
+	limit int
+
+	lock  sync.Mutex
+	slots map[string]chan struct{}
+}
+
+// NewTableInsertLimiter creates a limiter allowing limit concurrent inserts per table
+func NewTableInsertLimiter(limit int) *TableInsertLimiter {
+	return &TableInsertLimiter{limit: limit, slots: map[string]chan struct{}{}}
+}
+
+// WithTableInsertLimiter shares limiter between loaders so their inserts into the same table wait for each other.
+// Without it the inserts of concurrent loaders are not coordinated.
+func WithTableInsertLimiter(limiter *TableInsertLimiter) Option {
+	return func(b *BigQueryLoader) {
+		b.insertLimiter = limiter
+	}
+}
+
+// acquire waits for an insert slot of table and returns the function releasing it. A nil limiter never waits.
+func (l *TableInsertLimiter) acquire(ctx context.Context, table *bigquery.Table) (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+	key := table.FullyQualifiedName()
+	l.lock.Lock()
+	slots, ok := l.slots[key]
+	if !ok {
+		slots = make(chan struct{}, l.limit)
+		l.slots[key] = slots
+	}
+	l.lock.Unlock()
+
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
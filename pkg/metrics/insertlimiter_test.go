@@ -0,0 +1,85 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+)
+
+func TestTableInsertLimiterContention(t *testing.T) {
+	const limit = 2
+	limiter := NewTableInsertLimiter(limit)
+	table := &bigquery.Table{ProjectID: "project", DatasetID: "dataset", TableID: "leases"}
+
+	var lock sync.Mutex
+	running, maxRunning := 0, 0
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			release, err := limiter.acquire(context.Background(), table)
+			if err != nil {
+				t.Errorf("failed to acquire a slot: %v", err)
+				return
+			}
+			lock.Lock()
+			running++
+			maxRunning = max(maxRunning, running)
+			lock.Unlock()
+
+			time.Sleep(5 * time.Millisecond)
+
+			lock.Lock()
+			running--
+			lock.Unlock()
+			release()
+		}()
+	}
+	wg.Wait()
+	if maxRunning > limit {
+		t.Errorf("expected at most %d concurrent inserts, got %d", limit, maxRunning)
+	}
+}
+
+func TestTableInsertLimiterOtherTables(t *testing.T) {
+	limiter := NewTableInsertLimiter(1)
+	leases := &bigquery.Table{ProjectID: "project", DatasetID: "dataset", TableID: "leases"}
+	release, err := limiter.acquire(context.Background(), leases)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	for _, other := range []*bigquery.Table{
+		{ProjectID: "project", DatasetID: "dataset", TableID: "nodes"},
+		{ProjectID: "project", DatasetID: "other", TableID: "leases"},
+	} {
+		releaseOther, err := limiter.acquire(ctx, other)
+		if err != nil {
+			t.Fatalf("expected an insert into %s not to wait for leases: %v", other.FullyQualifiedName(), err)
+		}
+		releaseOther()
+	}
+
+	blocked, cancelBlocked := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancelBlocked()
+	if _, err := limiter.acquire(blocked, leases); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected a second insert into leases to wait until its context is done, got %v", err)
+	}
+}
+
+func TestTableInsertLimiterNil(t *testing.T) {
+	var limiter *TableInsertLimiter
+	release, err := limiter.acquire(context.Background(), &bigquery.Table{TableID: "leases"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+}
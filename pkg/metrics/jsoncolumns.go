@@ -0,0 +1,21 @@
+package metrics
+
+import "cloud.google.com/go/bigquery"
+
+// nullableJSONColumns marks every JSON column, including those of nested records, as NULLABLE.
+// InferSchema maps Go maps to REQUIRED JSON columns, but maps such as BuildEvent.AdditionalContext
+// are omitted from most events, and a nil map is inserted as null, which a REQUIRED column rejects.
+func nullableJSONColumns(in bigquery.Schema) bigquery.Schema {
+	out := make(bigquery.Schema, 0, len(in))
+	for _, field := range in {
+		relaxed := *field
+		switch field.Type {
+		case bigquery.JSONFieldType:
+			relaxed.Required = false
+		case bigquery.RecordFieldType:
+			relaxed.Schema = nullableJSONColumns(field.Schema)
+		}
+		out = append(out, &relaxed)
+	}
+	return out
+}
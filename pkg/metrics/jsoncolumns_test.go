@@ -0,0 +1,127 @@
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	citoolsmetrics "github.com/openshift/ci-tools/pkg/metrics"
+)
+
+// nestedBuilds is a builds section whose AdditionalContext holds nested objects and arrays, and one build without it
+const nestedBuilds = `{"openshift_builds": [
+	{
+		"namespace": "ci-op-1234",
+		"name": "src",
+		"start_time": "2025-01-02T03:04:05Z",
+		"completion_time": "2025-01-02T03:06:05Z",
+		"duration_seconds": 120,
+		"status": "Complete",
+		"output_image": "registry/ci-op-1234/pipeline:src",
+		"additional_context": {"steps": [{"name": "clone", "seconds": 1.5}, {"name": "build", "seconds": 118}], "node": {"zone": "us-east1-b", "labels": ["a", "b"]}},
+		"timestamp": "2025-01-02T03:06:06Z",
+		"for_image": "src"
+	},
+	{
+		"namespace": "ci-op-1234",
+		"name": "bin",
+		"start_time": "2025-01-02T03:07:00Z",
+		"completion_time": "2025-01-02T03:08:00Z",
+		"duration_seconds": 60,
+		"status": "Failed",
+		"reason": "GenericBuildFailed",
+		"timestamp": "2025-01-02T03:08:01Z"
+	}
+]}`
+
+func decodeNestedBuilds(t *testing.T) *MetricsData {
+	t.Helper()
+	data, err := DecodeMetricsData(strings.NewReader(nestedBuilds))
+	if err != nil {
+		t.Fatalf("failed to decode builds: %v", err)
+	}
+	if len(data.OpenshiftBuilds) != 2 {
+		t.Fatalf("expected 2 builds, got %d", len(data.OpenshiftBuilds))
+	}
+	return data
+}
+
+func TestNestedBuildsExportRoundTrip(t *testing.T) {
+	data := decodeNestedBuilds(t)
+	dir := t.TempDir()
+	if err := ExportMetricsData(data, dir); err != nil {
+		t.Fatalf("failed to export: %v", err)
+	}
+	read, err := ReadExportDir(dir)
+	if err != nil {
+		t.Fatalf("failed to read the export back: %v", err)
+	}
+	if !reflect.DeepEqual(read.OpenshiftBuilds, data.OpenshiftBuilds) {
+		t.Errorf("builds changed through export:\nexpected %+v\ngot      %+v", data.OpenshiftBuilds, read.OpenshiftBuilds)
+	}
+}
+
+func TestNestedBuildsInsertRoundTrip(t *testing.T) {
+	data := decodeNestedBuilds(t)
+
+	fake, client := newFakeBigQuery(t)
+	loader := NewBigQueryLoader(context.Background(), client, "project", "dataset")
+
+	schema, rows, err := loader.prepareTable(tableRows(data, OpenshiftBuildsTable))
+	if err != nil {
+		t.Fatalf("failed to prepare builds: %v", err)
+	}
+	if _, err := loader.insertRows(context.Background(), client.Dataset("dataset").Table(OpenshiftBuildsTable), schema, rows, nil); err != nil {
+		t.Fatalf("failed to insert builds: %v", err)
+	}
+
+	inserted := fake.rows("project", "dataset", OpenshiftBuildsTable)
+	if len(inserted) != len(data.OpenshiftBuilds) {
+		t.Fatalf("expected %d inserted rows, got %d", len(data.OpenshiftBuilds), len(inserted))
+	}
+	for i, build := range data.OpenshiftBuilds {
+		if actual := decodeInsertedBuild(t, inserted[i]); !reflect.DeepEqual(actual, build) {
+			t.Errorf("build %d changed through insert:\nexpected %+v\ngot      %+v", i, build, actual)
+		}
+	}
+}
+
+// decodeInsertedBuild decodes a row of an insertAll request back into the build it was saved from
+func decodeInsertedBuild(t *testing.T, row map[string]json.RawMessage) *citoolsmetrics.BuildEvent {
+	t.Helper()
+	field := func(name string, into any) {
+		if value, ok := row[name]; ok && string(value) != "null" {
+			if err := json.Unmarshal(value, into); err != nil {
+				t.Fatalf("failed to decode %s %s: %v", name, value, err)
+			}
+		}
+	}
+	build := &citoolsmetrics.BuildEvent{}
+	var durationSeconds json.Number
+	var additionalContext json.RawMessage
+	field("Namespace", &build.Namespace)
+	field("Name", &build.Name)
+	field("StartTime", &build.StartTime)
+	field("CompletionTime", &build.CompletionTime)
+	field("DurationSeconds", &durationSeconds)
+	field("Status", &build.Status)
+	field("Reason", &build.Reason)
+	field("OutputImage", &build.OutputImage)
+	field("AdditionalContext", &additionalContext)
+	field("Timestamp", &build.Timestamp)
+	field("ForImage", &build.ForImage)
+
+	duration, err := durationSeconds.Int64()
+	if err != nil {
+		t.Fatalf("failed to parse duration %q: %v", durationSeconds, err)
+	}
+	build.DurationSeconds = int(duration)
+	if additionalContext != nil {
+		if err := decodeRecord(additionalContext, &build.AdditionalContext); err != nil {
+			t.Fatalf("failed to decode AdditionalContext %s: %v", additionalContext, err)
+		}
+	}
+	return build
+}
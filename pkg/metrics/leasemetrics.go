@@ -0,0 +1,125 @@
+package metrics
+
+import (
+	"fmt"
+
+	"cloud.google.com/go/bigquery"
+)
+
+// Columns WithLeaseMetrics adds to the lease tables
+const (
+	AcquisitionEfficiencyColumn = "acquisition_efficiency"
+	UtilizationColumn           = "utilization"
+)
+
+// WithLeaseMetrics adds columns derived from the other columns of a lease event to the leases table, and to the
+// lease_acquisitions and lease_releases tables of WithSplitUnions, so that queries do not recompute them:
+//
+//   - acquisition_efficiency is acquisition_duration_seconds / leases_total, the seconds waited for a lease per
+//     lease of the pool, set on acquisitions
+//   - utilization is (leases_total - free) / leases_total, the fraction of the pool in use, where free is
+//     leases_remaining_at_acquisition for acquisitions and leases_available_at_release for releases
+//
+// Both are NULLABLE FLOAT columns, NULL when leases_total is zero or the event has nothing to derive them from.
+// Union rows are told apart as WithSplitUnions does.
+func WithLeaseMetrics(enabled bool) Option {
+	return func(b *BigQueryLoader) {
+		if enabled {
+			b.transforms = append(b.transforms, leaseMetrics{})
+		}
+	}
+}
+
+// leaseMetrics computes the columns of WithLeaseMetrics
+type leaseMetrics struct{}
+
+// leaseMetricColumns returns the derived columns of table, in the order they are added
+func leaseMetricColumns(table string) []string {
+	switch table {
+	case LeasesTable, LeaseAcquisitionsTable:
+		return []string{AcquisitionEfficiencyColumn, UtilizationColumn}
+	case LeaseReleasesTable:
+		return []string{UtilizationColumn}
+	}
+	return nil
+}
+
+func (leaseMetrics) schema(table string, in bigquery.Schema) (bigquery.Schema, error) {
+	columns := leaseMetricColumns(table)
+	if len(columns) == 0 {
+		return in, nil
+	}
+	out := append(bigquery.Schema{}, in...)
+	for _, column := range columns {
+		if hasColumn(in, column) {
+			return nil, fmt.Errorf("table %s already has a %s column", table, column)
+		}
+		out = append(out, &bigquery.FieldSchema{Name: column, Type: bigquery.FloatFieldType})
+	}
+	return out, nil
+}
+
+func (leaseMetrics) row(table string, in bigquery.Schema, values map[string]bigquery.Value) (map[string]bigquery.Value, error) {
+	columns := leaseMetricColumns(table)
+	if len(columns) == 0 {
+		return values, nil
+	}
+	for _, column := range columns {
+		values[column] = nil
+	}
+
+	lease := leaseValues{schema: in, values: values}
+	release := table == LeaseReleasesTable
+	if table == LeasesTable {
+		released, _ := lease.lookup("released").(bool)
+		message, _ := lease.lookup("error").(string)
+		releaseDuration, _ := lease.number("release_duration_seconds")
+		available, _ := lease.number("leases_available_at_release")
+		release = released || message != "" || releaseDuration != 0 || available != 0
+	}
+	total, ok := lease.number("leases_total")
+	if !ok || total == 0 {
+		return values, nil
+	}
+
+	free := "leases_remaining_at_acquisition"
+	if release {
+		free = "leases_available_at_release"
+	}
+	if free, ok := lease.number(free); ok {
+		values[UtilizationColumn] = (total - free) / total
+	}
+	if duration, ok := lease.number("acquisition_duration_seconds"); ok && !release {
+		values[AcquisitionEfficiencyColumn] = duration / total
+	}
+	return values, nil
+}
+
+// leaseValues reads the columns of a lease row by their snake_case name, whichever casing the columns have
+type leaseValues struct {
+	schema bigquery.Schema
+	values map[string]bigquery.Value
+}
+
+// lookup returns the value of column, or nil when the table has no such column
+func (l leaseValues) lookup(column string) bigquery.Value {
+	for _, field := range l.schema {
+		if toSnakeCase(field.Name) == column {
+			return l.values[field.Name]
+		}
+	}
+	return nil
+}
+
+// number returns the value of a numeric column as a float, reporting whether the column holds one
+func (l leaseValues) number(column string) (float64, bool) {
+	switch v := l.lookup(column).(type) {
+	case float64:
+		return v, true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	}
+	return 0, false
+}
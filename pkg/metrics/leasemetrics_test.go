@@ -0,0 +1,118 @@
+package metrics
+
+import (
+	"testing"
+
+	"cloud.google.com/go/bigquery"
+)
+
+func TestLeaseMetrics(t *testing.T) {
+	inferred, err := inferSchema(LeaseEventUnion{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name        string
+		table       string
+		lease       *LeaseEventUnion
+		efficiency  bigquery.Value
+		utilization bigquery.Value
+	}{
+		{
+			name:        "acquisition",
+			table:       LeasesTable,
+			lease:       &LeaseEventUnion{AcquisitionDurationSeconds: 5, LeasesRemainingAtAcquisition: 4, LeasesTotal: 10},
+			efficiency:  0.5,
+			utilization: 0.6,
+		},
+		{
+			name:        "acquisition of the last free lease",
+			table:       LeasesTable,
+			lease:       &LeaseEventUnion{AcquisitionDurationSeconds: 30, LeasesTotal: 4},
+			efficiency:  7.5,
+			utilization: 1.0,
+		},
+		{
+			name:        "release",
+			table:       LeasesTable,
+			lease:       &LeaseEventUnion{ReleaseDurationSeconds: 2, LeasesAvailableAtRelease: 7, LeasesTotal: 10, Released: true},
+			utilization: 0.3,
+		},
+		{
+			name:  "acquisition without leases_total",
+			table: LeasesTable,
+			lease: &LeaseEventUnion{AcquisitionDurationSeconds: 5, LeasesRemainingAtAcquisition: 4},
+		},
+		{
+			name:  "release without leases_total",
+			table: LeasesTable,
+			lease: &LeaseEventUnion{LeasesAvailableAtRelease: 7, Released: true},
+		},
+		{
+			name:        "split acquisition",
+			table:       LeaseAcquisitionsTable,
+			lease:       &LeaseEventUnion{AcquisitionDurationSeconds: 8, LeasesRemainingAtAcquisition: 1, LeasesTotal: 4},
+			efficiency:  2.0,
+			utilization: 0.75,
+		},
+		{
+			name:        "split release",
+			table:       LeaseReleasesTable,
+			lease:       &LeaseEventUnion{LeasesAvailableAtRelease: 4, LeasesTotal: 4},
+			utilization: 0.0,
+		},
+		{
+			name:  "split acquisition without leases_total",
+			table: LeaseAcquisitionsTable,
+			lease: &LeaseEventUnion{AcquisitionDurationSeconds: 8},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			schema, err := leaseMetrics{}.schema(tc.table, inferred)
+			if err != nil {
+				t.Fatal(err)
+			}
+			values, _, err := saveRow(tc.lease, inferred)
+			if err != nil {
+				t.Fatal(err)
+			}
+			out, err := leaseMetrics{}.row(tc.table, schema, values)
+			if err != nil {
+				t.Fatal(err)
+			}
+			efficiency, hasEfficiency := out[AcquisitionEfficiencyColumn]
+			if tc.table == LeaseReleasesTable {
+				if hasEfficiency {
+					t.Errorf("expected no %s column for releases, got %v", AcquisitionEfficiencyColumn, efficiency)
+				}
+			} else if efficiency != tc.efficiency {
+				t.Errorf("expected %s %v, got %v", AcquisitionEfficiencyColumn, tc.efficiency, efficiency)
+			}
+			if out[UtilizationColumn] != tc.utilization {
+				t.Errorf("expected %s %v, got %v", UtilizationColumn, tc.utilization, out[UtilizationColumn])
+			}
+		})
+	}
+}
+
+func TestLeaseMetricsSchema(t *testing.T) {
+	inferred, err := inferSchema(LeaseEventUnion{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema, err := leaseMetrics{}.schema(LeasesTable, inferred)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, column := range []string{AcquisitionEfficiencyColumn, UtilizationColumn} {
+		if !hasColumn(schema, column) {
+			t.Errorf("expected a %s column", column)
+		}
+	}
+	if _, err := (leaseMetrics{}).schema(LeasesTable, schema); err == nil {
+		t.Error("expected adding the columns twice to fail")
+	}
+	if unchanged, err := (leaseMetrics{}).schema(ImagesTable, inferred); err != nil || len(unchanged) != len(inferred) {
+		t.Errorf("expected the images schema to be left alone, got %d columns and %v", len(unchanged), err)
+	}
+}
@@ -0,0 +1,51 @@
+package metrics
+
+import (
+	"context"
+)
+
+// badRecordLogLimit is the number of bytes of a bad record included in its log line
+const badRecordLogLimit = 512
+
+// BadRecord is a record skipped with DecodeOptions.LenientDecode, as written to the dead-letter location
+type BadRecord struct {
+	// Section is the metrics section, or the NDJSON event type, of the record when it is known
+	Section string `json:"section,omitempty"`
+	Index   int    `json:"record_index"`
+	Reason  string `json:"reason"`
+	// Record holds the record as it was read, which is not necessarily valid JSON
+	Record string `json:"record"`
+}
+
+// BadRecords returns the records that failed to decode and were skipped with DecodeOptions.LenientDecode
+func (d *MetricsData) BadRecords() []BadRecord {
+	return d.badRecords
+}
+
+func (d *MetricsData) recordBad(section string, index int, raw []byte, err error) {
+	d.badRecords = append(d.badRecords, BadRecord{Section: section, Index: index, Reason: err.Error(), Record: string(raw)})
+}
+
+// reportBadRecords logs the bad records of data and writes them to the dead-letter location, if one is
+// configured, returning how many there were
+func (b *BigQueryLoader) reportBadRecords(ctx context.Context, data *MetricsData) int {
+	if len(data.badRecords) == 0 {
+		return 0
+	}
+	for _, record := range data.badRecords {
+		logged := record.Record
+		if len(logged) > badRecordLogLimit {
+			logged = logged[:badRecordLogLimit] + truncationMarker
+		}
+		b.logger.WithField("record", logged).Warnf("Skipped a record that failed to decode: %s", record.Reason)
+	}
+	if b.deadLetter != nil {
+		path, err := writeDeadLetter(ctx, b.deadLetter, "records", data.badRecords)
+		if err != nil {
+			b.logger.WithError(err).Errorf("Failed to write %d bad records to the dead-letter location", len(data.badRecords))
+		} else {
+			b.logger.Warnf("Wrote %d bad records to %s", len(data.badRecords), path)
+		}
+	}
+	return len(data.badRecords)
+}
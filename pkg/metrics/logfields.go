@@ -0,0 +1,37 @@
+package metrics
+
+import (
+	"github.com/sirupsen/logrus"
+)
+
+// WithLogFields adds fields to every line the loader logs, such as the ID of the invocation it runs in, so that the
+// lines of one load can be told apart from those of concurrent ones
+func WithLogFields(fields logrus.Fields) Option {
+	return func(b *BigQueryLoader) {
+		b.logger = b.logger.WithFields(fields)
+	}
+}
+
+// LogFieldsHook is a logrus hook adding the same fields to every entry of a logger, for the lines logged outside of
+// a loader. Fields the entry already has are kept.
+type LogFieldsHook struct {
+	fields logrus.Fields
+}
+
+// NewLogFieldsHook returns a hook adding fields to every entry
+func NewLogFieldsHook(fields logrus.Fields) *LogFieldsHook {
+	return &LogFieldsHook{fields: fields}
+}
+
+func (h *LogFieldsHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h *LogFieldsHook) Fire(entry *logrus.Entry) error {
+	for key, value := range h.fields {
+		if _, ok := entry.Data[key]; !ok {
+			entry.Data[key] = value
+		}
+	}
+	return nil
+}
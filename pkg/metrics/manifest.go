@@ -0,0 +1,63 @@
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ExportManifestFileName is the file WriteExportManifest writes next to the exported table files
+const ExportManifestFileName = "manifest.json"
+
+// ExportManifest describes the files written by an export so that a follow-up `bq load` can be data-driven
+type ExportManifest struct {
+	Source     string               `json:"source"`
+	ExportedAt time.Time            `json:"exported_at"`
+	Files      []ExportManifestFile `json:"files"`
+}
+
+// ExportManifestFile describes a single exported table file
+type ExportManifestFile struct {
+	File  string `json:"file"`
+	Table string `json:"table"`
+	Rows  int    `json:"rows"`
+	// Schema is the inferred BigQuery schema in the JSON format accepted by `bq load --schema`
+	Schema json.RawMessage `json:"schema,omitempty"`
+	// SchemaError is set instead of Schema when the row type cannot be inferred
+	SchemaError string `json:"schema_error,omitempty"`
+}
+
+// BuildExportManifest describes the table files ExportMetricsData writes for data, in load order.
+// includeEmpty also lists the empty files written by ExportEmptyTables.
+func BuildExportManifest(data *MetricsData, source string, exportedAt time.Time, includeEmpty bool) *ExportManifest {
+	manifest := &ExportManifest{Source: source, ExportedAt: exportedAt.UTC(), Files: []ExportManifestFile{}}
+	for _, t := range data.tables() {
+		if len(t.rows) == 0 && !includeEmpty {
+			continue
+		}
+		file := ExportManifestFile{File: t.name + ".json", Table: t.name, Rows: len(t.rows)}
+		schema, err := inferSchema(t.row)
+		if err == nil {
+			file.Schema, err = nullableJSONColumns(schema).ToJSONFields()
+		}
+		if err != nil {
+			file.SchemaError = err.Error()
+		}
+		manifest.Files = append(manifest.Files, file)
+	}
+	return manifest
+}
+
+// WriteExportManifest writes the manifest of an export of data to exportDir
+func WriteExportManifest(data *MetricsData, exportDir, source string, exportedAt time.Time, includeEmpty bool) error {
+	encoded, err := json.MarshalIndent(BuildExportManifest(data, source, exportedAt, includeEmpty), "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(exportDir, ExportManifestFileName), append(encoded, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	return nil
+}
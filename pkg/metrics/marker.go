@@ -0,0 +1,51 @@
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/option"
+)
+
+// SuccessMarker is the run summary written as the marker object of a completed load, for orchestration that waits
+// for it before reading the tables
+type SuccessMarker struct {
+	Source  string `json:"source"`
+	Project string `json:"project"`
+	Dataset string `json:"dataset"`
+	// Partial is set when some tables failed and the marker was written anyway
+	Partial     bool        `json:"partial"`
+	CompletedAt time.Time   `json:"completed_at"`
+	Result      *LoadResult `json:"result"`
+}
+
+// WriteSuccessMarker writes marker as JSON to the gs://bucket/object path, replacing the marker of a previous run
+func WriteSuccessMarker(ctx context.Context, path string, marker *SuccessMarker, clientOpts ...option.ClientOption) error {
+	bucket, object, err := ParseGCSPath(path)
+	if err != nil {
+		return fmt.Errorf("invalid success marker path: %w", err)
+	}
+	encoded, err := json.MarshalIndent(marker, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode success marker: %w", err)
+	}
+	client, err := storage.NewClient(ctx, clientOpts...)
+	if err != nil {
+		return fmt.Errorf("failed to create GCS client: %w", err)
+	}
+	defer client.Close()
+
+	writer := client.Bucket(bucket).Object(object).NewWriter(ctx)
+	writer.ContentType = "application/json"
+	if _, err := writer.Write(append(encoded, '\n')); err != nil {
+		writer.Close()
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package metrics
+
+import "maps"
+
+// MergeImageContext consolidates image events that share a full_name into a single row. The last event of each
+// group provides the row, and the AdditionalContext and ImageStreamDetails maps of every event in the group are
+// merged into it, later events winning on conflicting keys. Each consolidated row keeps the position of the first
+// event of its group. Events without a full_name are left as they are.
+func MergeImageContext(data *MetricsData) {
+	merged := make([]*ImageEventUnion, 0, len(data.Images))
+	byName := map[string]int{}
+	for _, image := range data.Images {
+		if image.FullName == "" {
+			merged = append(merged, image)
+			continue
+		}
+		index, seen := byName[image.FullName]
+		if !seen {
+			byName[image.FullName] = len(merged)
+			merged = append(merged, image)
+			continue
+		}
+
+		previous := merged[index]
+		consolidated := *image
+		consolidated.AdditionalContext = mergeContext(previous.AdditionalContext, image.AdditionalContext)
+		consolidated.ImageStreamDetails = mergeContext(previous.ImageStreamDetails, image.ImageStreamDetails)
+		merged[index] = &consolidated
+	}
+	data.Images = merged
+}
+
+// mergeContext returns the union of two maps, with values from later replacing those from earlier
+func mergeContext(earlier, later map[string]any) map[string]any {
+	if earlier == nil && later == nil {
+		return nil
+	}
+	out := make(map[string]any, len(earlier)+len(later))
+	maps.Copy(out, earlier)
+	maps.Copy(out, later)
+	return out
+}
@@ -0,0 +1,60 @@
+package metrics
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMergeImageContext(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		images   []*ImageEventUnion
+		expected []*ImageEventUnion
+	}{
+		{
+			name: "non-overlapping keys are unioned",
+			images: []*ImageEventUnion{
+				{FullName: "ci/src", TagName: "first", AdditionalContext: map[string]any{"a": 1}, ImageStreamDetails: map[string]any{"x": "1"}},
+				{FullName: "ci/src", TagName: "second", AdditionalContext: map[string]any{"b": 2}},
+			},
+			expected: []*ImageEventUnion{
+				{FullName: "ci/src", TagName: "second", AdditionalContext: map[string]any{"a": 1, "b": 2}, ImageStreamDetails: map[string]any{"x": "1"}},
+			},
+		},
+		{
+			name: "later events win on overlapping keys",
+			images: []*ImageEventUnion{
+				{FullName: "ci/src", AdditionalContext: map[string]any{"a": 1, "shared": "first"}},
+				{FullName: "ci/src", AdditionalContext: map[string]any{"shared": "second"}},
+				{FullName: "ci/src", AdditionalContext: map[string]any{"b": 2, "shared": "third"}},
+			},
+			expected: []*ImageEventUnion{
+				{FullName: "ci/src", AdditionalContext: map[string]any{"a": 1, "b": 2, "shared": "third"}},
+			},
+		},
+		{
+			name: "groups keep the position of their first event and events without a name are kept",
+			images: []*ImageEventUnion{
+				{FullName: "ci/bin", AdditionalContext: map[string]any{"a": 1}},
+				{TagName: "unnamed"},
+				{FullName: "ci/src"},
+				{FullName: "ci/bin", AdditionalContext: map[string]any{"a": 2}},
+				{TagName: "unnamed"},
+			},
+			expected: []*ImageEventUnion{
+				{FullName: "ci/bin", AdditionalContext: map[string]any{"a": 2}},
+				{TagName: "unnamed"},
+				{FullName: "ci/src"},
+				{TagName: "unnamed"},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			data := &MetricsData{Images: tc.images}
+			MergeImageContext(data)
+			if !reflect.DeepEqual(data.Images, tc.expected) {
+				t.Errorf("expected %+v, got %+v", tc.expected, data.Images)
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package metrics
+
+import (
+	"strings"
+	"sync"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/sirupsen/logrus"
+)
+
+// WithNormalizeNamespaces trims the whitespace around every namespace value and lower-cases it before insert, so
+// that the same namespace reported with different casing or trailing whitespace groups as one. Every STRING column
+// named namespace is normalized, including those of nested records.
+func WithNormalizeNamespaces(enabled bool) Option {
+	return func(b *BigQueryLoader) {
+		if enabled {
+			b.transforms = append(b.transforms, &normalizeNamespaces{normalized: map[string]int{}})
+		}
+	}
+}
+
+// normalizeNamespaces rewrites namespace values to their trimmed lower-case form, counting the rows it changed
+type normalizeNamespaces struct {
+	lock       sync.Mutex
+	normalized map[string]int
+}
+
+func (n *normalizeNamespaces) schema(_ string, in bigquery.Schema) (bigquery.Schema, error) {
+	return in, nil
+}
+
+func (n *normalizeNamespaces) row(table string, in bigquery.Schema, values map[string]bigquery.Value) (map[string]bigquery.Value, error) {
+	if normalizeNamespaceValues(in, values) {
+		n.lock.Lock()
+		n.normalized[table]++
+		n.lock.Unlock()
+	}
+	return values, nil
+}
+
+func (n *normalizeNamespaces) report(table string, logger *logrus.Entry) {
+	n.lock.Lock()
+	count := n.normalized[table]
+	delete(n.normalized, table)
+	n.lock.Unlock()
+	if count > 0 {
+		logger.Infof("Normalized the namespace of %d %s rows", count, table)
+	}
+}
+
+// normalizeNamespaceValues normalizes the namespace values of a row in place and reports whether it changed any
+func normalizeNamespaceValues(schema bigquery.Schema, values map[string]bigquery.Value) bool {
+	changed := false
+	for _, field := range schema {
+		value, ok := values[field.Name]
+		if !ok {
+			continue
+		}
+		switch field.Type {
+		case bigquery.StringFieldType:
+			if !strings.EqualFold(field.Name, "namespace") {
+				continue
+			}
+			switch v := value.(type) {
+			case string:
+				if normalized := normalizeNamespace(v); normalized != v {
+					values[field.Name] = normalized
+					changed = true
+				}
+			case []string:
+				for i := range v {
+					if normalized := normalizeNamespace(v[i]); normalized != v[i] {
+						v[i] = normalized
+						changed = true
+					}
+				}
+			}
+		case bigquery.RecordFieldType:
+			switch v := value.(type) {
+			case map[string]bigquery.Value:
+				changed = normalizeNamespaceValues(field.Schema, v) || changed
+			case []bigquery.Value:
+				for _, item := range v {
+					if nested, ok := item.(map[string]bigquery.Value); ok {
+						changed = normalizeNamespaceValues(field.Schema, nested) || changed
+					}
+				}
+			}
+		}
+	}
+	return changed
+}
+
+func normalizeNamespace(namespace string) string {
+	return strings.ToLower(strings.TrimSpace(namespace))
+}
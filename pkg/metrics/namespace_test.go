@@ -0,0 +1,77 @@
+package metrics
+
+import (
+	"testing"
+
+	"cloud.google.com/go/bigquery"
+)
+
+func TestNormalizeNamespaces(t *testing.T) {
+	schema, err := inferSchema(ImageEventUnion{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name      string
+		namespace string
+		expected  string
+		changed   bool
+	}{
+		{name: "mixed case", namespace: "CI-Op-1234", expected: "ci-op-1234", changed: true},
+		{name: "surrounding whitespace", namespace: "  ci-op-1234\t\n", expected: "ci-op-1234", changed: true},
+		{name: "mixed case and whitespace", namespace: " CI-OP-1234 ", expected: "ci-op-1234", changed: true},
+		{name: "already normalized", namespace: "ci-op-1234", expected: "ci-op-1234"},
+		{name: "inner whitespace is kept", namespace: "ci op", expected: "ci op"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			transform := &normalizeNamespaces{normalized: map[string]int{}}
+			values, _, err := saveRow(&ImageEventUnion{Namespace: tc.namespace, ImageStreamName: " Pipeline "}, schema)
+			if err != nil {
+				t.Fatal(err)
+			}
+			out, err := transform.row(ImagesTable, schema, values)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if out["Namespace"] != tc.expected {
+				t.Errorf("expected namespace %q, got %q", tc.expected, out["Namespace"])
+			}
+			if out["ImageStreamName"] != " Pipeline " {
+				t.Errorf("expected the other columns to be left alone, got %q", out["ImageStreamName"])
+			}
+			if changed := transform.normalized[ImagesTable] == 1; changed != tc.changed {
+				t.Errorf("expected the row to be counted as changed: %t, got %t", tc.changed, changed)
+			}
+		})
+	}
+}
+
+func TestNormalizeNamespacesNested(t *testing.T) {
+	schema := bigquery.Schema{
+		{Name: "pod", Type: bigquery.RecordFieldType, Schema: bigquery.Schema{
+			{Name: "Namespace", Type: bigquery.StringFieldType},
+			{Name: "Name", Type: bigquery.StringFieldType},
+		}},
+		{Name: "namespaces", Type: bigquery.RecordFieldType, Repeated: true, Schema: bigquery.Schema{
+			{Name: "namespace", Type: bigquery.StringFieldType},
+		}},
+	}
+	values := map[string]bigquery.Value{
+		"pod": map[string]bigquery.Value{"Namespace": " CI-Op-1 ", "Name": "Test"},
+		"namespaces": []bigquery.Value{
+			map[string]bigquery.Value{"namespace": "OpenShift "},
+			map[string]bigquery.Value{"namespace": "default"},
+		},
+	}
+	if !normalizeNamespaceValues(schema, values) {
+		t.Fatal("expected the row to change")
+	}
+	pod := values["pod"].(map[string]bigquery.Value)
+	if pod["Namespace"] != "ci-op-1" || pod["Name"] != "Test" {
+		t.Errorf("unexpected pod %v", pod)
+	}
+	namespaces := values["namespaces"].([]bigquery.Value)
+	if namespaces[0].(map[string]bigquery.Value)["namespace"] != "openshift" || namespaces[1].(map[string]bigquery.Value)["namespace"] != "default" {
+		t.Errorf("unexpected namespaces %v", namespaces)
+	}
+}
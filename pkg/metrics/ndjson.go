@@ -0,0 +1,161 @@
+package metrics
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Supported input formats
+const (
+	// InputFormatJSON is the keyed MetricsData object produced by ci-operator
+	InputFormatJSON = "json"
+	// InputFormatNDJSON is one event object per line, each carrying a "type" field naming its table
+	InputFormatNDJSON = "ndjson"
+)
+
+// ndjsonBatchSize is the number of events buffered before an incremental NDJSON load flushes them to BigQuery
+const ndjsonBatchSize = 1000
+
+// ndjsonDecoder reads typed events one at a time from an NDJSON stream
+type ndjsonDecoder struct {
+	decoder *json.Decoder
+	// lines, set when decoding leniently, reads the stream line by line so that decoding can resume after a
+	// malformed line
+	lines *bufio.Reader
+	index int
+	// allowNonFinite decodes NaN and infinite floats, with DecodeOptions.AllowNonFiniteFloats
+	allowNonFinite bool
+}
+
+func newNDJSONDecoder(r io.Reader, opts DecodeOptions) *ndjsonDecoder {
+	r = skipPreamble(r)
+	if opts.AllowNonFiniteFloats {
+		r = quoteNonFiniteLiterals(r)
+	}
+	if opts.LenientDecode {
+		return &ndjsonDecoder{lines: bufio.NewReader(r), allowNonFinite: opts.AllowNonFiniteFloats}
+	}
+	return &ndjsonDecoder{decoder: json.NewDecoder(r), allowNonFinite: opts.AllowNonFiniteFloats}
+}
+
+// next decodes the next event into data, returning io.EOF once the stream is exhausted. When decoding leniently,
+// an event that fails to decode is kept in the bad records of data instead.
+func (d *ndjsonDecoder) next(data *MetricsData) error {
+	index := d.index
+	raw, err := d.read()
+	if err != nil {
+		if err == io.EOF {
+			return err
+		}
+		return fmt.Errorf("record %d: %w", index, err)
+	}
+	d.index++
+
+	data.allowNonFinite = d.allowNonFinite
+	eventType, err := decodeNDJSONRecord(data, index, raw)
+	if err != nil && d.lines != nil {
+		data.recordBad(eventType, index, raw, err)
+		return nil
+	}
+	return err
+}
+
+// read returns the next record of the stream, skipping blank lines when reading line by line
+func (d *ndjsonDecoder) read() (json.RawMessage, error) {
+	if d.lines == nil {
+		if !d.decoder.More() {
+			return nil, io.EOF
+		}
+		var raw json.RawMessage
+		err := d.decoder.Decode(&raw)
+		return raw, err
+	}
+	for {
+		line, err := d.lines.ReadBytes('\n')
+		if trimmed := bytes.TrimSpace(line); len(trimmed) > 0 {
+			return trimmed, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// decodeNDJSONRecord decodes the event at index into data, returning its type when it could be read
+func decodeNDJSONRecord(data *MetricsData, index int, raw json.RawMessage) (string, error) {
+	var discriminator struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(raw, &discriminator); err != nil {
+		return "", fmt.Errorf("record %d: %w", index, err)
+	}
+	decode, ok := sectionDecoders[discriminator.Type]
+	if !ok {
+		return discriminator.Type, fmt.Errorf("record %d: unknown event type %q", index, discriminator.Type)
+	}
+	if err := decode(data, raw); err != nil {
+		return discriminator.Type, fmt.Errorf("record %d (%s): %w", index, discriminator.Type, err)
+	}
+	return discriminator.Type, nil
+}
+
+// DecodeNDJSON decodes a whole NDJSON stream of typed events into a MetricsData
+func DecodeNDJSON(r io.Reader) (*MetricsData, error) {
+	return DecodeNDJSONWithOptions(r, DecodeOptions{})
+}
+
+// DecodeNDJSONWithOptions decodes a whole NDJSON stream like DecodeNDJSON, decoding leniently with
+// DecodeOptions.LenientDecode
+func DecodeNDJSONWithOptions(r io.Reader, opts DecodeOptions) (*MetricsData, error) {
+	decoder := newNDJSONDecoder(r, opts)
+	data := &MetricsData{}
+	for {
+		if err := decoder.next(data); err == io.EOF {
+			return data, nil
+		} else if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// LoadNDJSON loads an NDJSON stream of typed events, flushing every ndjsonBatchSize events so
+// that memory use stays bounded regardless of the stream length
+func (b *BigQueryLoader) LoadNDJSON(r io.Reader) (*LoadResult, error) {
+	decoder := newNDJSONDecoder(r, b.decodeOpts)
+	result := &LoadResult{}
+	var failures []error
+	batch, buffered := &MetricsData{}, 0
+	for {
+		err := decoder.next(batch)
+		if err != nil && err != io.EOF {
+			return result, err
+		}
+		if err == nil {
+			buffered++
+		}
+		if buffered > 0 && (buffered == ndjsonBatchSize || err == io.EOF) {
+			batchResult, loadErr := b.loadData(b.ctx, batch)
+			result.Merge(batchResult)
+			if loadErr != nil {
+				if b.breaker == nil || errors.Is(loadErr, ErrCircuitBreakerTripped) {
+					return result, loadErr
+				}
+				failures = append(failures, loadErr)
+			}
+			batch, buffered = &MetricsData{}, 0
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+
+	if len(failures) > 0 {
+		return result, errors.Join(failures...)
+	}
+	b.logSummary(result)
+	return result, nil
+}
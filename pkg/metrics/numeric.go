@@ -0,0 +1,92 @@
+package metrics
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strconv"
+
+	"github.com/sirupsen/logrus"
+)
+
+// normalizeNumericStrings walks raw guided by the Go type t and replaces numeric strings ("12.5") in integer and
+// float fields with the number they hold, for producers that serialize numbers as strings. Strings that do not
+// parse as a number of the field's kind are left alone so that decoding reports them.
+func normalizeNumericStrings(raw json.RawMessage, t reflect.Type) (json.RawMessage, bool, error) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	trimmed := bytes.TrimSpace(raw)
+	if len(trimmed) == 0 || bytes.Equal(trimmed, []byte("null")) {
+		return raw, false, nil
+	}
+
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		if trimmed[0] != '"' {
+			return raw, false, nil
+		}
+		var value string
+		if err := json.Unmarshal(trimmed, &value); err != nil {
+			return nil, false, err
+		}
+		if !isNumber(value, t.Kind()) {
+			return raw, false, nil
+		}
+		return json.RawMessage(value), true, nil
+
+	case reflect.Struct:
+		if t == timeType || trimmed[0] != '{' {
+			return raw, false, nil
+		}
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &fields); err != nil {
+			return nil, false, err
+		}
+		changed := false
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name, ok := jsonFieldName(field)
+			if !ok {
+				continue
+			}
+			value, ok := fields[name]
+			if !ok {
+				continue
+			}
+			normalized, fieldChanged, err := normalizeNumericStrings(value, field.Type)
+			if err != nil {
+				return nil, false, err
+			}
+			if fieldChanged {
+				logrus.Debugf("Coerced numeric string %s of field %s", value, name)
+				fields[name] = normalized
+				changed = true
+			}
+		}
+		if !changed {
+			return raw, false, nil
+		}
+		encoded, err := json.Marshal(fields)
+		return encoded, err == nil, err
+	}
+	return raw, false, nil
+}
+
+// isNumber reports whether value parses as a number of the given kind
+func isNumber(value string, kind reflect.Kind) bool {
+	var err error
+	switch kind {
+	case reflect.Float32, reflect.Float64:
+		_, err = strconv.ParseFloat(value, 64)
+		// ParseFloat also accepts Inf and NaN, which are not valid JSON numbers
+		return err == nil && json.Valid([]byte(value))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		_, err = strconv.ParseUint(value, 10, 64)
+	default:
+		_, err = strconv.ParseInt(value, 10, 64)
+	}
+	return err == nil
+}
@@ -0,0 +1,68 @@
+package metrics
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestUnmarshalRowNumericStrings(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		lease string
+		image string
+	}{
+		{
+			name:  "numbers",
+			lease: `{"acquisition_duration_seconds": 12.5, "leases_remaining_at_acquisition": 3, "leases_total": 10}`,
+			image: `{"duration_seconds": 4.25, "retry_count": 2}`,
+		},
+		{
+			name:  "quoted numbers",
+			lease: `{"acquisition_duration_seconds": "12.5", "leases_remaining_at_acquisition": "3", "leases_total": "10"}`,
+			image: `{"duration_seconds": "4.25", "retry_count": "2"}`,
+		},
+		{
+			name:  "quoted and plain numbers mixed",
+			lease: `{"acquisition_duration_seconds": "12.5", "leases_remaining_at_acquisition": 3, "leases_total": "10"}`,
+			image: `{"duration_seconds": 4.25, "retry_count": "2"}`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var lease LeaseEventUnion
+			if err := unmarshalRow(json.RawMessage(tc.lease), &lease, false); err != nil {
+				t.Fatalf("failed to decode lease: %v", err)
+			}
+			if lease.AcquisitionDurationSeconds != 12.5 || lease.LeasesRemainingAtAcquisition != 3 || lease.LeasesTotal != 10 {
+				t.Errorf("unexpected lease %+v", lease)
+			}
+
+			var image ImageEventUnion
+			if err := unmarshalRow(json.RawMessage(tc.image), &image, false); err != nil {
+				t.Fatalf("failed to decode image: %v", err)
+			}
+			if image.DurationSeconds != 4.25 || image.RetryCount != 2 {
+				t.Errorf("unexpected image %+v", image)
+			}
+		})
+	}
+}
+
+func TestUnmarshalRowRejectsNonNumericStrings(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		raw  string
+	}{
+		{name: "float field", raw: `{"acquisition_duration_seconds": "fast"}`},
+		{name: "int field", raw: `{"leases_total": "ten"}`},
+		{name: "fraction in int field", raw: `{"leases_total": "10.5"}`},
+		{name: "empty string", raw: `{"acquisition_duration_seconds": ""}`},
+		{name: "non-finite float", raw: `{"acquisition_duration_seconds": "NaN"}`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var lease LeaseEventUnion
+			if err := unmarshalRow(json.RawMessage(tc.raw), &lease, false); err == nil {
+				t.Errorf("expected %s to fail to decode, got %+v", tc.raw, lease)
+			}
+		})
+	}
+}
@@ -0,0 +1,207 @@
+package metrics
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+)
+
+// openMetricsAppendAttempts bounds how often AppendOpenMetrics re-reads the object after a concurrent writer
+// replaced it in the meantime
+const openMetricsAppendAttempts = 5
+
+// openMetricsEOF ends every OpenMetrics exposition
+const openMetricsEOF = "# EOF"
+
+// RunMetrics is the outcome of a load, as recorded by AppendOpenMetrics
+type RunMetrics struct {
+	Dataset  string
+	Start    time.Time
+	Duration time.Duration
+	Success  bool
+	// Result is nil when the load failed before any table was attempted
+	Result *LoadResult
+}
+
+// openMetricsFamily is a metric family of an OpenMetrics exposition, with its metadata and samples in order
+type openMetricsFamily struct {
+	name     string
+	metadata []string
+	samples  []string
+}
+
+// AppendOpenMetrics adds the samples of run to the OpenMetrics text held by the gs://bucket/object path, creating
+// it when missing, so that the object is a time series of loader runs. Every sample carries the end of the run as
+// its timestamp:
+//
+//	ci_metrics_bigquery_rows_inserted{dataset,table}
+//	ci_metrics_bigquery_invalid_rows{dataset,table}
+//	ci_metrics_bigquery_run_duration_seconds{dataset}
+//	ci_metrics_bigquery_run_success{dataset}
+//
+// The object is read and rewritten with a generation precondition, and read again when another run replaced it in
+// the meantime, so that concurrent runs do not drop each other's samples.
+func AppendOpenMetrics(ctx context.Context, path string, run RunMetrics, clientOpts ...option.ClientOption) error {
+	bucket, object, err := ParseGCSPath(path)
+	if err != nil {
+		return fmt.Errorf("invalid OpenMetrics object: %w", err)
+	}
+	client, err := storage.NewClient(ctx, clientOpts...)
+	if err != nil {
+		return fmt.Errorf("failed to create GCS client: %w", err)
+	}
+	defer client.Close()
+	handle := client.Bucket(bucket).Object(object)
+
+	samples := openMetricsSamples(run)
+	for attempt := 1; ; attempt++ {
+		existing, conditions, err := readOpenMetrics(ctx, handle)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		err = writeOpenMetrics(ctx, handle.If(conditions), appendOpenMetrics(existing, samples))
+		if err == nil {
+			return nil
+		}
+		if !isPreconditionFailed(err) || attempt == openMetricsAppendAttempts {
+			return fmt.Errorf("failed to write %s: %w", path, err)
+		}
+	}
+}
+
+// readOpenMetrics returns the families of the object and the conditions under which it is unchanged
+func readOpenMetrics(ctx context.Context, handle *storage.ObjectHandle) ([]*openMetricsFamily, storage.Conditions, error) {
+	reader, err := handle.NewReader(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return nil, storage.Conditions{DoesNotExist: true}, nil
+	} else if err != nil {
+		return nil, storage.Conditions{}, err
+	}
+	defer reader.Close()
+	families, err := parseOpenMetrics(reader)
+	if err != nil {
+		return nil, storage.Conditions{}, err
+	}
+	return families, storage.Conditions{GenerationMatch: reader.Attrs.Generation}, nil
+}
+
+func writeOpenMetrics(ctx context.Context, handle *storage.ObjectHandle, families []*openMetricsFamily) error {
+	writer := handle.NewWriter(ctx)
+	writer.ContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+	buffered := bufio.NewWriter(writer)
+	for _, family := range families {
+		for _, line := range append(slices.Clip(family.metadata), family.samples...) {
+			fmt.Fprintln(buffered, line)
+		}
+	}
+	fmt.Fprintln(buffered, openMetricsEOF)
+	if err := buffered.Flush(); err != nil {
+		writer.Close()
+		return err
+	}
+	return writer.Close()
+}
+
+// parseOpenMetrics splits an exposition into its families, keyed by the name of their metadata and samples
+func parseOpenMetrics(r io.Reader) ([]*openMetricsFamily, error) {
+	var families []*openMetricsFamily
+	family := func(name string) *openMetricsFamily {
+		if i := slices.IndexFunc(families, func(f *openMetricsFamily) bool { return f.name == name }); i >= 0 {
+			return families[i]
+		}
+		families = append(families, &openMetricsFamily{name: name})
+		return families[len(families)-1]
+	}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || line == openMetricsEOF:
+		case strings.HasPrefix(line, "# "):
+			if fields := strings.Fields(line); len(fields) >= 3 {
+				f := family(fields[2])
+				f.metadata = append(f.metadata, line)
+			}
+		default:
+			name, _, _ := strings.Cut(line, " ")
+			name, _, _ = strings.Cut(name, "{")
+			f := family(name)
+			f.samples = append(f.samples, line)
+		}
+	}
+	return families, scanner.Err()
+}
+
+// appendOpenMetrics adds samples to the families they belong to, keeping every family contiguous
+func appendOpenMetrics(families []*openMetricsFamily, samples []*openMetricsFamily) []*openMetricsFamily {
+	for _, sample := range samples {
+		i := slices.IndexFunc(families, func(f *openMetricsFamily) bool { return f.name == sample.name })
+		if i < 0 {
+			families = append(families, sample)
+			continue
+		}
+		if len(families[i].metadata) == 0 {
+			families[i].metadata = sample.metadata
+		}
+		families[i].samples = append(families[i].samples, sample.samples...)
+	}
+	return families
+}
+
+// openMetricsSamples returns the samples of run grouped into their families
+func openMetricsSamples(run RunMetrics) []*openMetricsFamily {
+	timestamp := fmt.Sprintf("%.3f", float64(run.Start.Add(run.Duration).UnixMilli())/1000)
+	dataset := label("dataset", run.Dataset)
+	gauge := func(name, help string) *openMetricsFamily {
+		return &openMetricsFamily{name: name, metadata: []string{
+			fmt.Sprintf("# TYPE %s gauge", name),
+			fmt.Sprintf("# HELP %s %s", name, help),
+		}}
+	}
+	sample := func(family *openMetricsFamily, labels, value string) {
+		family.samples = append(family.samples, fmt.Sprintf("%s{%s} %s %s", family.name, labels, value, timestamp))
+	}
+
+	inserted := gauge("ci_metrics_bigquery_rows_inserted", "Rows inserted into the table by the run.")
+	invalid := gauge("ci_metrics_bigquery_invalid_rows", "Rows of the table rejected by BigQuery and skipped by the run.")
+	if run.Result != nil {
+		for _, t := range run.Result.Tables {
+			labels := dataset + "," + label("table", t.Table)
+			sample(inserted, labels, fmt.Sprint(t.RowsInserted))
+			sample(invalid, labels, fmt.Sprint(t.InvalidRows))
+		}
+	}
+	duration := gauge("ci_metrics_bigquery_run_duration_seconds", "Duration of the run.")
+	duration.metadata = append(duration.metadata, "# UNIT ci_metrics_bigquery_run_duration_seconds seconds")
+	sample(duration, dataset, fmt.Sprintf("%.3f", run.Duration.Seconds()))
+	success := gauge("ci_metrics_bigquery_run_success", "Whether every table of the run loaded.")
+	value := "0"
+	if run.Success {
+		value = "1"
+	}
+	sample(success, dataset, value)
+	return []*openMetricsFamily{inserted, invalid, duration, success}
+}
+
+// labelEscaper escapes the characters OpenMetrics label values cannot hold as they are
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// label formats a label of a sample
+func label(name, value string) string {
+	return fmt.Sprintf(`%s="%s"`, name, labelEscaper.Replace(value))
+}
+
+func isPreconditionFailed(err error) bool {
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusPreconditionFailed
+}
@@ -0,0 +1,39 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ReadPathsFile reads a list of gs:// paths, one per line. Blank lines and lines starting with # are skipped and
+// every other line must be a valid GCS path.
+func ReadPathsFile(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open paths file: %w", err)
+	}
+	defer file.Close()
+
+	var paths []string
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if line == 1 {
+			text = strings.TrimPrefix(text, string(utf8BOM))
+		}
+		text = strings.TrimSpace(text)
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		if _, _, err := ParseGCSPath(text); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		paths = append(paths, text)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read paths file: %w", err)
+	}
+	return paths, nil
+}
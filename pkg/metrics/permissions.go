@@ -0,0 +1,69 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/api/googleapi"
+)
+
+// permissionRetryDelay is the first delay of RetryPermissionDenied, doubled after every retry up to
+// maxPermissionRetryDelay
+const (
+	permissionRetryDelay    = 5 * time.Second
+	maxPermissionRetryDelay = time.Minute
+)
+
+// IsPermissionDenied reports whether err is a 403 refusing access to a resource, as returned until newly granted IAM
+// bindings have propagated. Quota errors and disabled APIs, which share the status, are not permission errors.
+func IsPermissionDenied(err error) bool {
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) || apiErr.Code != http.StatusForbidden {
+		return false
+	}
+	var disabled *APIDisabledError
+	return quotaReason(err) == "" && !errors.As(explainAPIDisabled("", err), &disabled)
+}
+
+// RetryPermissionDenied calls fn and, while it fails with a permission error, calls it again with an exponential
+// backoff until maxWait has passed since the first failure, logging every retry with the time waited so far.
+// Other errors, and the last permission error, are returned as they are. A zero maxWait calls fn once.
+func RetryPermissionDenied(ctx context.Context, maxWait time.Duration, logger *logrus.Entry, fn func() error) error {
+	return retryPermissionDenied(ctx, maxWait, logger, time.Now, fn)
+}
+
+// retryPermissionDenied is RetryPermissionDenied measuring the time waited with clock
+func retryPermissionDenied(ctx context.Context, maxWait time.Duration, logger *logrus.Entry, clock func() time.Time, fn func() error) error {
+	start := clock()
+	for delay := permissionRetryDelay; ; delay = min(2*delay, maxPermissionRetryDelay) {
+		err := fn()
+		waited := clock().Sub(start)
+		if err == nil || !IsPermissionDenied(err) || waited >= maxWait {
+			return err
+		}
+		delay = min(delay, maxWait-waited)
+		logger.WithError(err).Warnf("Permission denied, assuming IAM bindings are still propagating: retrying in %s (waited %s of %s)", delay, waited.Round(time.Second), maxWait)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// WithPermissionWait retries the BigQuery and GCS requests of a load that fail with a permission error, for up to
+// maxWait, so that loads started right after access was granted wait for it to propagate. Without it, permission
+// errors fail the load at once.
+func WithPermissionWait(maxWait time.Duration) Option {
+	return func(b *BigQueryLoader) {
+		b.permissionWait = maxWait
+	}
+}
+
+// retryPermissions runs fn under the permission wait of the loader
+func (b *BigQueryLoader) retryPermissions(ctx context.Context, fn func() error) error {
+	return retryPermissionDenied(ctx, b.permissionWait, b.logger, b.clock, fn)
+}
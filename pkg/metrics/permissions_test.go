@@ -0,0 +1,40 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"google.golang.org/api/googleapi"
+)
+
+func TestRetryPermissionsUsesClock(t *testing.T) {
+	start := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	// the first failure comes a millisecond before the wait is over, the second one once it is over
+	readings := []time.Time{start, start.Add(time.Minute - time.Millisecond), start.Add(time.Minute)}
+	clock := func() time.Time {
+		now := readings[0]
+		if len(readings) > 1 {
+			readings = readings[1:]
+		}
+		return now
+	}
+	loader := NewBigQueryLoader(context.Background(), nil, "project", "dataset", WithClock(clock), WithPermissionWait(time.Minute))
+
+	calls := 0
+	began := time.Now()
+	err := loader.retryPermissions(context.Background(), func() error {
+		calls++
+		return &googleapi.Error{Code: http.StatusForbidden, Errors: []googleapi.ErrorItem{{Reason: "accessDenied"}}}
+	})
+	if !IsPermissionDenied(err) {
+		t.Fatalf("expected the last permission error, got %v", err)
+	}
+	if calls != 2 {
+		t.Errorf("expected 2 calls within the minute of the loader clock, got %d", calls)
+	}
+	if elapsed := time.Since(began); elapsed > 5*time.Second {
+		t.Errorf("expected the retry to wait out the remaining millisecond, took %s", elapsed)
+	}
+}
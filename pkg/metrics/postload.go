@@ -0,0 +1,90 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+
+	"cloud.google.com/go/bigquery"
+)
+
+// PostLoadQuery is a SQL script run as a query job once a load succeeded, such as a rollup into a summary table.
+// Its text is a Go template; see ReadPostLoadQuery.
+type PostLoadQuery struct {
+	template *template.Template
+}
+
+// PostLoadResult is the outcome of a PostLoadQuery job
+type PostLoadResult struct {
+	JobID          string
+	BytesProcessed int64
+}
+
+// postLoadTarget is the data a PostLoadQuery template is executed with
+type postLoadTarget struct {
+	Project     string
+	Dataset     string
+	TableSuffix string
+}
+
+// ReadPostLoadQuery reads the SQL of a PostLoadQuery from path. The SQL may reference {{.Project}}, {{.Dataset}}
+// and {{.TableSuffix}}, and {{table "nodes"}} expands to the quoted full name of a table loaded into, including
+// the table suffix.
+func ReadPostLoadQuery(path string) (*PostLoadQuery, error) {
+	sql, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read post-load SQL: %w", err)
+	}
+	if strings.TrimSpace(string(sql)) == "" {
+		return nil, fmt.Errorf("%s is empty", path)
+	}
+	parsed, err := template.New(path).Option("missingkey=error").Funcs(template.FuncMap{
+		// replaced with the target of the load in Render
+		"table": func(string) string { return "" },
+	}).Parse(string(sql))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse post-load SQL: %w", err)
+	}
+	return &PostLoadQuery{template: parsed}, nil
+}
+
+// Render returns the SQL of the query for a load into tables of project.dataset named with tableSuffix
+func (q *PostLoadQuery) Render(project, dataset, tableSuffix string) (string, error) {
+	target := postLoadTarget{Project: project, Dataset: dataset, TableSuffix: tableSuffix}
+	var sql strings.Builder
+	err := template.Must(q.template.Clone()).Funcs(template.FuncMap{
+		"table": func(name string) string {
+			return fmt.Sprintf("`%s.%s.%s%s`", project, dataset, name, tableSuffix)
+		},
+	}).Execute(&sql, target)
+	if err != nil {
+		return "", fmt.Errorf("failed to render post-load SQL: %w", err)
+	}
+	return sql.String(), nil
+}
+
+// RunPostLoadQuery runs sql as a query job with dataset as its default dataset, so unqualified table names refer to
+// the tables loaded into, and waits for it to complete
+func RunPostLoadQuery(ctx context.Context, bqClient *bigquery.Client, dataset, sql string) (*PostLoadResult, error) {
+	query := bqClient.Query(sql)
+	query.DefaultProjectID = bqClient.Project()
+	query.DefaultDatasetID = dataset
+	job, err := query.Run(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start post-load query: %w", err)
+	}
+	result := &PostLoadResult{JobID: job.ID()}
+	status, err := job.Wait(ctx)
+	if err == nil {
+		err = status.Err()
+	}
+	if err != nil {
+		return result, fmt.Errorf("post-load query job %s failed: %w", job.ID(), err)
+	}
+	if stats, ok := status.Statistics.Details.(*bigquery.QueryStatistics); ok {
+		result.BytesProcessed = stats.TotalBytesProcessed
+	}
+	return result, nil
+}
@@ -0,0 +1,129 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"cloud.google.com/go/bigquery"
+)
+
+// SchemaMismatch is a column of the rows to insert that an existing table cannot hold
+type SchemaMismatch struct {
+	Table string
+	// Field is the dot-separated path of the column, into nested records
+	Field   string
+	Problem string
+}
+
+func (m SchemaMismatch) String() string {
+	return fmt.Sprintf("%s.%s: %s", m.Table, m.Field, m.Problem)
+}
+
+// SchemaMismatchError is returned by a load checked with WithPreflightSchemaCheck when the rows do not fit the
+// existing tables
+type SchemaMismatchError struct {
+	Mismatches []SchemaMismatch
+}
+
+func (e *SchemaMismatchError) Error() string {
+	report := make([]string, 0, len(e.Mismatches))
+	for _, mismatch := range e.Mismatches {
+		report = append(report, mismatch.String())
+	}
+	return fmt.Sprintf("%d columns do not fit the existing tables: %s", len(e.Mismatches), strings.Join(report, "; "))
+}
+
+// WithPreflightSchemaCheck fetches the schema of every existing table the load writes rows to before inserting
+// anything, and fails the load with a SchemaMismatchError listing every column the table lacks or holds with an
+// incompatible type, instead of letting BigQuery reject the rows one by one. Tables that do not exist yet are
+// created with the schema of the rows, so they are not checked.
+func WithPreflightSchemaCheck(enabled bool) Option {
+	return func(b *BigQueryLoader) {
+		b.preflightSchemaCheck = enabled
+	}
+}
+
+// preflightSchemas checks the tables of dataset that rows of data are routed to against the schema of the rows
+func (b *BigQueryLoader) preflightSchemas(ctx context.Context, dataset *bigquery.Dataset, data *MetricsData) error {
+	rows := map[string]int{}
+	total := 0
+	for _, t := range b.tablesOf(data) {
+		rows[t.name] = len(t.rows)
+		total += len(t.rows)
+	}
+	if b.unifiedTable != "" {
+		rows[b.unifiedTable] = total
+	}
+
+	var mismatches []SchemaMismatch
+	for _, route := range b.routes() {
+		// tables whose schema cannot be inferred fail in loadTable with the reason
+		if rows[route.source] == 0 || route.schema == nil {
+			continue
+		}
+		tableDataset, table, err := b.resolveTable(dataset, route.table)
+		if err != nil {
+			return err
+		}
+		metadata, err := tableDataset.Table(table).Metadata(ctx)
+		if isNotFoundError(err) {
+			continue
+		} else if err != nil {
+			return fmt.Errorf("failed to get the schema of %s: %w", table, err)
+		}
+		mismatches = append(mismatches, schemaMismatches(table, nil, metadata.Schema, route.schema)...)
+	}
+	if len(mismatches) > 0 {
+		return &SchemaMismatchError{Mismatches: mismatches}
+	}
+	b.logger.Debug("The rows fit the schema of every existing table")
+	return nil
+}
+
+// schemaMismatches lists the columns of schema that live cannot hold, and the required columns of live that schema
+// lacks, descending into nested records. Columns that only live has and that may be null are fine, as are types
+// that widen into the type of the table, such as INTEGER into FLOAT.
+func schemaMismatches(table string, path []string, live, schema bigquery.Schema) []SchemaMismatch {
+	var mismatches []SchemaMismatch
+	mismatch := func(name, problem string) {
+		fieldPath := append(slices.Clip(path), name)
+		mismatches = append(mismatches, SchemaMismatch{Table: table, Field: strings.Join(fieldPath, "."), Problem: problem})
+	}
+	for _, field := range schema {
+		i := slices.IndexFunc(live, func(column *bigquery.FieldSchema) bool { return strings.EqualFold(column.Name, field.Name) })
+		if i < 0 {
+			mismatch(field.Name, "the table has no such column")
+			continue
+		}
+		column := live[i]
+		switch {
+		case column.Type != field.Type && !slices.Contains(widenings[field.Type], column.Type):
+			mismatch(field.Name, fmt.Sprintf("the table holds %s, the rows hold %s", column.Type, field.Type))
+		case column.Repeated != field.Repeated:
+			mismatch(field.Name, fmt.Sprintf("the column is %s in the table and %s in the rows", fieldMode(column), fieldMode(field)))
+		case column.Required && !field.Required:
+			mismatch(field.Name, "the column is REQUIRED in the table and may be null in the rows")
+		case field.Type == bigquery.RecordFieldType:
+			mismatches = append(mismatches, schemaMismatches(table, append(slices.Clip(path), field.Name), column.Schema, field.Schema)...)
+		}
+	}
+	for _, column := range live {
+		if column.Required && !hasColumn(schema, column.Name) {
+			mismatch(column.Name, "the column is REQUIRED in the table and missing from the rows")
+		}
+	}
+	return mismatches
+}
+
+func fieldMode(field *bigquery.FieldSchema) string {
+	switch {
+	case field.Repeated:
+		return "REPEATED"
+	case field.Required:
+		return "REQUIRED"
+	default:
+		return "NULLABLE"
+	}
+}
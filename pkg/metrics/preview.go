@@ -0,0 +1,57 @@
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Supported report output formats
+const (
+	OutputText = "text"
+	OutputJSON = "json"
+)
+
+// PreviewMetrics writes the first n records of every non-empty table to w.
+// The text format prints a header per table followed by the indented records,
+// while the JSON format writes a single object keyed by table name.
+func PreviewMetrics(w io.Writer, data *MetricsData, n int, format string) error {
+	var tables []tableData
+	for _, t := range data.tables() {
+		if len(t.rows) > 0 {
+			tables = append(tables, t)
+		}
+	}
+
+	switch format {
+	case OutputJSON:
+		preview := map[string][]any{}
+		for _, t := range tables {
+			preview[t.name] = t.rows[:min(n, len(t.rows))]
+		}
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(preview); err != nil {
+			return fmt.Errorf("failed to encode preview: %w", err)
+		}
+	case OutputText:
+		for _, t := range tables {
+			rows := t.rows[:min(n, len(t.rows))]
+			if _, err := fmt.Fprintf(w, "=== %s (showing %d of %d rows) ===\n", t.name, len(rows), len(t.rows)); err != nil {
+				return err
+			}
+			for _, row := range rows {
+				encoded, err := json.MarshalIndent(row, "", "  ")
+				if err != nil {
+					return fmt.Errorf("failed to encode %s row: %w", t.name, err)
+				}
+				if _, err := fmt.Fprintf(w, "%s\n", encoded); err != nil {
+					return err
+				}
+			}
+		}
+	default:
+		return fmt.Errorf("unsupported output format %q", format)
+	}
+	return nil
+}
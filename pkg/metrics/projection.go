@@ -0,0 +1,60 @@
+package metrics
+
+import (
+	"fmt"
+	"strings"
+
+	"cloud.google.com/go/bigquery"
+)
+
+// WithColumns keeps only the named top-level columns of table and drops the others before insert. Names are
+// matched case-insensitively against the column names as created, i.e. after WithColumnCase when it comes first.
+// A name that matches no column fails the load of the table.
+func WithColumns(table string, columns []string) Option {
+	return func(b *BigQueryLoader) {
+		b.transforms = append(b.transforms, columnProjection{table: table, columns: columns})
+	}
+}
+
+// columnProjection drops every column of one table that was not asked for
+type columnProjection struct {
+	table   string
+	columns []string
+}
+
+func (p columnProjection) schema(table string, in bigquery.Schema) (bigquery.Schema, error) {
+	if table != p.table {
+		return in, nil
+	}
+	out := make(bigquery.Schema, 0, len(p.columns))
+	for _, column := range p.columns {
+		var found *bigquery.FieldSchema
+		for _, field := range in {
+			if strings.EqualFold(field.Name, column) {
+				found = field
+				break
+			}
+		}
+		if found == nil {
+			return nil, fmt.Errorf("column %s does not exist in table %s", column, table)
+		}
+		out = append(out, found)
+	}
+	return out, nil
+}
+
+func (p columnProjection) row(table string, in bigquery.Schema, values map[string]bigquery.Value) (map[string]bigquery.Value, error) {
+	if table != p.table {
+		return values, nil
+	}
+	projected := make(map[string]bigquery.Value, len(p.columns))
+	for _, column := range p.columns {
+		for name, value := range values {
+			if strings.EqualFold(name, column) {
+				projected[name] = value
+				break
+			}
+		}
+	}
+	return projected, nil
+}
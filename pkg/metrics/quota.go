@@ -0,0 +1,121 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/api/googleapi"
+)
+
+// maxQuotaRetries bounds how many times a streaming insert is retried after cool-downs before its error is returned
+const maxQuotaRetries = 3
+
+// quotaReasons are the reasons of BigQuery errors signalling that a quota or rate limit was hit
+var quotaReasons = []string{"quotaExceeded", "rateLimitExceeded"}
+
+// QuotaCooldown pauses every loader sharing it once any of them hits a BigQuery quota or rate limit, instead of
+// letting each keep sending requests that fail the same way and use up the quota further. It is safe for
+// concurrent use.
+type QuotaCooldown struct {
+	duration time.Duration
+	logger   *logrus.Entry
+	clock    func() time.Time
+
+	lock   sync.Mutex
+	until  time.Time
+	reason string
+}
+
+// NewQuotaCooldown creates a cool-down pausing for duration after every quota error
+func NewQuotaCooldown(duration time.Duration) *QuotaCooldown {
+	return &QuotaCooldown{duration: duration, logger: logrus.WithField("component", "quotaCooldown"), clock: time.Now}
+}
+
+// WithQuotaCooldown shares cooldown between loaders so that a quota error seen by one pauses all of them. Streaming
+// inserts that failed on a quota error are retried after the pause; other requests are not retried but start the
+// pause for the next ones.
+func WithQuotaCooldown(cooldown *QuotaCooldown) Option {
+	return func(b *BigQueryLoader) {
+		b.quotaCooldown = cooldown
+	}
+}
+
+// observe starts a cool-down when err is a quota error and reports whether it was. A nil cool-down ignores errors.
+func (c *QuotaCooldown) observe(err error) bool {
+	reason := quotaReason(err)
+	if c == nil || reason == "" {
+		return false
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	now := c.clock()
+	c.expire(now)
+	until := now.Add(c.duration)
+	if c.until.IsZero() {
+		c.logger.WithField("reason", reason).Warnf("Hit a BigQuery quota, pausing every load for %s", c.duration)
+	}
+	if until.After(c.until) {
+		c.until, c.reason = until, reason
+	}
+	return true
+}
+
+// wait blocks until the current cool-down, if any, is over. A nil cool-down never waits.
+func (c *QuotaCooldown) wait(ctx context.Context) error {
+	if c == nil {
+		return nil
+	}
+	for {
+		c.lock.Lock()
+		now := c.clock()
+		c.expire(now)
+		if c.until.IsZero() {
+			c.lock.Unlock()
+			return nil
+		}
+		remaining := c.until.Sub(now)
+		c.lock.Unlock()
+
+		timer := time.NewTimer(remaining)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("interrupted during the quota cool-down: %w", ctx.Err())
+		}
+	}
+}
+
+// expire ends the cool-down once now is past it. It must be called with the lock held.
+func (c *QuotaCooldown) expire(now time.Time) {
+	if c.until.IsZero() || c.until.After(now) {
+		return
+	}
+	c.logger.WithField("reason", c.reason).Info("Quota cool-down is over, resuming loads")
+	c.until, c.reason = time.Time{}, ""
+}
+
+// quotaReason returns the reason of a BigQuery quota or rate limit error, and an empty string for other errors
+func quotaReason(err error) string {
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) {
+		return ""
+	}
+	for _, item := range apiErr.Errors {
+		for _, reason := range quotaReasons {
+			if strings.EqualFold(item.Reason, reason) {
+				return item.Reason
+			}
+		}
+	}
+	if apiErr.Code == http.StatusTooManyRequests {
+		return http.StatusText(http.StatusTooManyRequests)
+	}
+	return ""
+}
@@ -0,0 +1,67 @@
+package metrics
+
+import (
+	"fmt"
+
+	"cloud.google.com/go/bigquery"
+)
+
+// maxRangePartitions is the most partitions BigQuery allows a range partitioned table to have
+const maxRangePartitions = 10000
+
+// RangePartition partitions a table on an integer column into buckets of Interval values from Start (inclusive)
+// to End (exclusive); values outside the range land in the __UNPARTITIONED__ partition
+type RangePartition struct {
+	Column   string
+	Start    int64
+	End      int64
+	Interval int64
+}
+
+// Validate checks that the range describes at least one and at most 10000 partitions
+func (p RangePartition) Validate() error {
+	if p.Interval <= 0 {
+		return fmt.Errorf("interval must be positive")
+	}
+	if p.End <= p.Start {
+		return fmt.Errorf("end must be greater than start")
+	}
+	if partitions := (p.End - p.Start + p.Interval - 1) / p.Interval; partitions > maxRangePartitions {
+		return fmt.Errorf("the range creates %d partitions, more than the %d BigQuery allows", partitions, maxRangePartitions)
+	}
+	return nil
+}
+
+// WithRangePartitioning creates table, and all of its shards, partitioned by ranges of an integer column. The
+// column is matched like the fields of WithDedupKey; a column that matches no column or is not an integer fails
+// the load of the table. Tables that already exist keep their partitioning.
+func WithRangePartitioning(table string, partition RangePartition) Option {
+	return func(b *BigQueryLoader) {
+		if b.rangePartitions == nil {
+			b.rangePartitions = map[string]RangePartition{}
+		}
+		b.rangePartitions[table] = partition
+	}
+}
+
+// rangePartitioning returns the partitioning of the table named name with the given schema, nil when it is
+// not range partitioned
+func (b *BigQueryLoader) rangePartitioning(name string, schema bigquery.Schema) (*bigquery.RangePartitioning, error) {
+	partition, ok := b.rangePartitions[name]
+	if !ok {
+		return nil, nil
+	}
+	for _, field := range schema {
+		if !sameColumn(field.Name, partition.Column) {
+			continue
+		}
+		if field.Type != bigquery.IntegerFieldType || field.Repeated {
+			return nil, fmt.Errorf("range partitioning column %s of table %s is %s, not an integer", partition.Column, name, field.Type)
+		}
+		return &bigquery.RangePartitioning{
+			Field: field.Name,
+			Range: &bigquery.RangePartitioningRange{Start: partition.Start, End: partition.End, Interval: partition.Interval},
+		}, nil
+	}
+	return nil, fmt.Errorf("range partitioning column %s does not exist in table %s", partition.Column, name)
+}
@@ -0,0 +1,58 @@
+package metrics
+
+import (
+	"encoding/json"
+
+	"cloud.google.com/go/bigquery"
+)
+
+// RawJSONColumn is the JSON column WithPreserveRaw stores the original record of every row in
+const RawJSONColumn = "raw_json"
+
+// WithPreserveRaw adds a raw_json column to every table holding the record each row was decoded from, byte for
+// byte, so that fields the row types do not know about yet are not lost. Files decoded by the loader keep their
+// records; files decoded by the caller need DecodeOptions.PreserveRaw. Rows without a record, such as the ones
+// merged with WithMergeMapContext, get NULL.
+func WithPreserveRaw() Option {
+	return func(b *BigQueryLoader) {
+		b.preserveRaw = true
+		b.decodeOpts.PreserveRaw = true
+	}
+}
+
+// rawRow saves a row along with the record it was decoded from
+type rawRow struct {
+	row any
+	raw json.RawMessage
+	// schema is the schema of row, without the raw_json column
+	schema bigquery.Schema
+}
+
+func (r *rawRow) Save() (map[string]bigquery.Value, string, error) {
+	values, insertID, err := saveRow(r.row, r.schema)
+	if err != nil {
+		return nil, "", err
+	}
+	values[RawJSONColumn] = nil
+	if r.raw != nil {
+		values[RawJSONColumn] = json.RawMessage(r.raw)
+	}
+	return values, insertID, nil
+}
+
+// withRawColumn adds the raw_json column to schema and pairs every row of t with its record
+func withRawColumn(t tableData, schema bigquery.Schema) (bigquery.Schema, []any) {
+	rows := make([]any, 0, len(t.rows))
+	for _, row := range t.rows {
+		rows = append(rows, &rawRow{row: row, raw: t.raw[row], schema: schema})
+	}
+	extended := append(schema[:len(schema):len(schema)], &bigquery.FieldSchema{Name: RawJSONColumn, Type: bigquery.JSONFieldType})
+	return extended, rows
+}
+
+// recordRaw remembers the record row was decoded from, when d preserves records
+func (d *MetricsData) recordRaw(row any, raw json.RawMessage) {
+	if d.raw != nil {
+		d.raw[row] = raw
+	}
+}
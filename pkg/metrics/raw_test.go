@@ -0,0 +1,43 @@
+package metrics
+
+import (
+	"encoding/json"
+	"testing"
+
+	"cloud.google.com/go/bigquery/storage/managedwriter/adapt"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+func TestRawRowWriteAPI(t *testing.T) {
+	schema, err := inferSchema(writeAPITestRow{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	row := &writeAPITestRow{ID: "a"}
+	extended, rows := withRawColumn(tableData{
+		rows: []any{row},
+		raw:  map[any]json.RawMessage{row: json.RawMessage(`{"id":"a","extra":1}`)},
+	}, schema)
+
+	storageSchema, err := adapt.BQSchemaToStorageTableSchema(extended)
+	if err != nil {
+		t.Fatal(err)
+	}
+	descriptor, err := adapt.StorageSchemaToProto2Descriptor(storageSchema, "root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	field := descriptor.(protoreflect.MessageDescriptor).Fields().ByName(RawJSONColumn)
+
+	values, _, err := rows[0].(*rawRow).Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, err := toProtoValue(field, extended[len(extended)-1], values[RawJSONColumn])
+	if err != nil {
+		t.Fatalf("toProtoValue failed: %v", err)
+	}
+	if value.String() != `{"id":"a","extra":1}` {
+		t.Errorf("expected the record to be written as a JSON object, got %s", value.String())
+	}
+}
@@ -0,0 +1,48 @@
+package metrics
+
+import (
+	"fmt"
+	"strings"
+)
+
+// MissingTablesError is returned when a metrics file holds no rows for tables required with WithRequiredTables
+type MissingTablesError struct {
+	Tables []string
+}
+
+func (e *MissingTablesError) Error() string {
+	return fmt.Sprintf("required tables have no rows: %s", strings.Join(e.Tables, ", "))
+}
+
+// WithRequiredTables fails the load of every metrics file that holds no rows for any of tables, before anything
+// is inserted, to catch producers that silently write partial files. Tables are named by the sections of the
+// metrics file, regardless of WithSplitUnions or WithUnifiedTable. Streamed NDJSON is not checked, since its
+// batches are inserted before the whole stream was read.
+func WithRequiredTables(tables []string) Option {
+	return func(b *BigQueryLoader) {
+		b.requiredTables = tables
+	}
+}
+
+// MissingTables returns the tables of required that data holds no rows for, in the order of required
+func MissingTables(data *MetricsData, required []string) []string {
+	rows := map[string]int{}
+	for _, t := range data.tables() {
+		rows[t.name] = len(t.rows)
+	}
+	var missing []string
+	for _, table := range required {
+		if rows[table] == 0 {
+			missing = append(missing, table)
+		}
+	}
+	return missing
+}
+
+// checkRequiredTables returns a MissingTablesError when data lacks any of the required tables
+func (b *BigQueryLoader) checkRequiredTables(data *MetricsData) error {
+	if missing := MissingTables(data, b.requiredTables); len(missing) > 0 {
+		return &MissingTablesError{Tables: missing}
+	}
+	return nil
+}
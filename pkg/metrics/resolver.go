@@ -0,0 +1,37 @@
+package metrics
+
+import (
+	"fmt"
+
+	"cloud.google.com/go/bigquery"
+)
+
+// TableResolver maps a table the loader writes to, named as it would be written with the table suffix and shard
+// index, to the dataset and table it is written to instead. An empty dataset keeps the dataset being loaded into.
+type TableResolver func(name string) (dataset, table string)
+
+// WithTableResolver routes every table the loader creates, checks or inserts into through resolver, such as
+// tenant_<id>.events for the loader of one tenant, when the suffix and sharding options are not enough. Datasets
+// are looked up in the project of the dataset being loaded into, including the datasets of WithAlsoWriteTo, and
+// resolved datasets other than it must exist. Without a resolver tables are written to the dataset being loaded
+// into under their own name.
+func WithTableResolver(resolver TableResolver) Option {
+	return func(b *BigQueryLoader) {
+		b.tableResolver = resolver
+	}
+}
+
+// resolveTable returns the dataset and name table of dataset is written as
+func (b *BigQueryLoader) resolveTable(dataset *bigquery.Dataset, table string) (*bigquery.Dataset, string, error) {
+	if b.tableResolver == nil {
+		return dataset, table, nil
+	}
+	datasetID, resolved := b.tableResolver(table)
+	if resolved == "" {
+		return nil, "", fmt.Errorf("the table resolver returned no table for %s", table)
+	}
+	if datasetID == "" || datasetID == dataset.DatasetID {
+		return dataset, resolved, nil
+	}
+	return b.bqClient.DatasetInProject(dataset.ProjectID, datasetID), resolved, nil
+}
@@ -0,0 +1,98 @@
+package metrics
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+// tenantResolver writes every table to the dataset of a tenant, under its name
+func tenantResolver(name string) (string, string) {
+	return "tenant_42", name
+}
+
+func TestResolveTable(t *testing.T) {
+	_, client := newFakeBigQuery(t)
+	dataset := client.DatasetInProject("project", "ci_metrics")
+	for _, tc := range []struct {
+		name            string
+		resolver        TableResolver
+		expectedDataset string
+		expectedTable   string
+		expectedErr     bool
+	}{
+		{
+			name:            "no resolver",
+			expectedDataset: "ci_metrics",
+			expectedTable:   "leases",
+		},
+		{
+			name:            "other dataset",
+			resolver:        tenantResolver,
+			expectedDataset: "tenant_42",
+			expectedTable:   "leases",
+		},
+		{
+			name:            "empty dataset keeps the dataset being loaded into",
+			resolver:        func(name string) (string, string) { return "", "tenant_42_" + name },
+			expectedDataset: "ci_metrics",
+			expectedTable:   "tenant_42_leases",
+		},
+		{
+			name:            "same dataset",
+			resolver:        func(name string) (string, string) { return "ci_metrics", strings.ToUpper(name) },
+			expectedDataset: "ci_metrics",
+			expectedTable:   "LEASES",
+		},
+		{
+			name:        "no table",
+			resolver:    func(name string) (string, string) { return "tenant_42", "" },
+			expectedErr: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			loader := NewBigQueryLoader(context.Background(), client, "project", "ci_metrics", WithTableResolver(tc.resolver))
+			resolvedDataset, table, err := loader.resolveTable(dataset, "leases")
+			if tc.expectedErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resolvedDataset.ProjectID != "project" || resolvedDataset.DatasetID != tc.expectedDataset || table != tc.expectedTable {
+				t.Errorf("expected project.%s.%s, got %s.%s.%s", tc.expectedDataset, tc.expectedTable, resolvedDataset.ProjectID, resolvedDataset.DatasetID, table)
+			}
+		})
+	}
+}
+
+func TestTableResolverLoad(t *testing.T) {
+	fake, client := newFakeBigQuery(t)
+	var resolved []string
+	loader := NewBigQueryLoader(context.Background(), client, "project", "ci_metrics",
+		WithTableSuffix("_v2"),
+		WithTableResolver(func(name string) (string, string) {
+			resolved = append(resolved, name)
+			return "tenant_42", name
+		}),
+	)
+	data := &MetricsData{Leases: []*LeaseEventUnion{{LeaseName: "aws-quota-slice"}}}
+	if _, _, err := loader.loadTable(context.Background(), client.Dataset("ci_metrics"), tableRows(data, LeasesTable), nil); err != nil {
+		t.Fatalf("failed to load leases: %v", err)
+	}
+	if len(resolved) != 1 || resolved[0] != "leases_v2" {
+		t.Errorf("expected the resolver to be asked for leases_v2, got %v", resolved)
+	}
+	if len(fake.created) != 1 || fake.created[0] != "tenant_42.leases_v2" {
+		t.Errorf("expected tenant_42.leases_v2 to be created, got %v", fake.created)
+	}
+	if rows := fake.rows("project", "tenant_42", "leases_v2"); len(rows) != 1 {
+		t.Errorf("expected 1 row inserted into tenant_42.leases_v2, got %d", len(rows))
+	}
+	if rows := fake.rows("project", "ci_metrics", "leases_v2"); len(rows) != 0 {
+		t.Errorf("expected no row inserted into ci_metrics.leases_v2, got %d", len(rows))
+	}
+}
@@ -0,0 +1,128 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"cloud.google.com/go/bigquery"
+)
+
+// tableRoute is a physical table a loader writes to, with the option that routes rows into it
+type tableRoute struct {
+	table string
+	// source is the table of the metrics data whose rows are routed into table
+	source string
+	origin string
+	// schema is nil when it cannot be inferred, as for the pods and events tables
+	schema bigquery.Schema
+}
+
+// TableConflict is a table that a configuration writes with a different schema than another table routed to the
+// same name, either by the same configuration or by the other table layouts the loader supports
+type TableConflict struct {
+	Table string
+	// Origins describe the tables routed to Table, naming the options responsible
+	Origins []string
+}
+
+func (c *TableConflict) Error() string {
+	return fmt.Sprintf("table %s would be written with different schemas by %s", c.Table, strings.Join(c.Origins, " and "))
+}
+
+// CheckTableRouting computes the tables the loader options of opts write to, and reports the names that two
+// differently shaped tables map to: within the configuration itself, and against the union and split layouts
+// written by runs of the same dataset with or without WithSplitUnions, such as a unified table named after one of
+// their tables. Table shapes that cannot be inferred are treated as different from any other. It does not call
+// BigQuery.
+func CheckTableRouting(opts ...Option) []*TableConflict {
+	b := NewBigQueryLoader(context.Background(), nil, "", "", opts...)
+	routes := b.routes()
+
+	// the layouts other runs may have written into the same dataset, with the same naming options
+	var layouts []tableRoute
+	for _, splitUnions := range []bool{false, true} {
+		layout := *b
+		layout.unifiedTable, layout.shards, layout.splitUnions = "", nil, splitUnions
+		layouts = append(layouts, layout.routes()...)
+	}
+
+	var conflicts []*TableConflict
+	report := func(route, other tableRoute) {
+		if route.origin == other.origin || sameSchema(route.schema, other.schema) {
+			return
+		}
+		i := slices.IndexFunc(conflicts, func(c *TableConflict) bool { return c.Table == route.table })
+		if i < 0 {
+			conflicts = append(conflicts, &TableConflict{Table: route.table})
+			i = len(conflicts) - 1
+		}
+		for _, origin := range []string{route.origin, other.origin} {
+			if !slices.Contains(conflicts[i].Origins, origin) {
+				conflicts[i].Origins = append(conflicts[i].Origins, origin)
+			}
+		}
+	}
+	for i, route := range routes {
+		for _, other := range routes[i+1:] {
+			if other.table == route.table {
+				report(route, other)
+			}
+		}
+		for _, other := range layouts {
+			if other.table == route.table {
+				report(route, other)
+			}
+		}
+	}
+	return conflicts
+}
+
+// routes returns the tables the loader writes to, in load order
+func (b *BigQueryLoader) routes() []tableRoute {
+	if b.unifiedTable != "" {
+		return []tableRoute{b.route(tableData{name: b.unifiedTable, schema: unifiedSchema}, "--unified-table")}
+	}
+	var routes []tableRoute
+	for _, t := range b.tablesOf(&MetricsData{}) {
+		origin := fmt.Sprintf("the %s table", t.name)
+		if t.splitFrom != "" {
+			origin = fmt.Sprintf("the %s table of --split-unions", t.name)
+		}
+		route := b.route(t, origin)
+		sharding, ok := b.shards[t.name]
+		if !ok {
+			routes = append(routes, route)
+			continue
+		}
+		for shard := range sharding.shards {
+			routes = append(routes, tableRoute{
+				table:  ShardTableName(route.table, shard),
+				source: route.source,
+				origin: fmt.Sprintf("shard %d of the %s table of --shards", shard, t.name),
+				schema: route.schema,
+			})
+		}
+	}
+	return routes
+}
+
+func (b *BigQueryLoader) route(t tableData, origin string) tableRoute {
+	route := tableRoute{table: t.name + b.tableSuffix, source: t.name, origin: origin}
+	if schema, _, err := b.prepareTable(t); err == nil {
+		route.schema = schema
+	}
+	return route
+}
+
+// sameSchema reports whether two known schemas define the same columns
+func sameSchema(a, b bigquery.Schema) bool {
+	if a == nil || b == nil {
+		return false
+	}
+	encodedA, errA := a.ToJSONFields()
+	encodedB, errB := b.ToJSONFields()
+	return errA == nil && errB == nil && bytes.Equal(encodedA, encodedB)
+}
@@ -0,0 +1,102 @@
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	citoolsmetrics "github.com/openshift/ci-tools/pkg/metrics"
+)
+
+// SampleMetricsData builds a small metrics file holding one realistic record of every event type whose table can be
+// loaded, with timestamps relative to now. It is built from the same structs the loader decodes, so it stays in sync
+// with the table schemas. Pods and events are left empty: the schema of their rows cannot be inferred, so a sample
+// holding them would fail to load.
+func SampleMetricsData(now time.Time) *MetricsData {
+	now = now.UTC().Truncate(time.Second)
+	started := now.Add(-10 * time.Minute)
+	completed := now.Add(-time.Minute)
+
+	return &MetricsData{
+		Images: []*ImageEventUnion{{
+			Namespace:       "ci-op-abc123",
+			ImageStreamName: "pipeline",
+			TagName:         "src",
+			FullTagName:     "pipeline:src",
+			SourceImage:     "registry.ci.openshift.org/ocp/builder:rhel-9-golang-1.22-openshift-4.17",
+			SourceImageKind: "DockerImage",
+			StartTime:       started,
+			CompletionTime:  started.Add(12 * time.Second),
+			DurationSeconds: 12,
+			Success:         true,
+			Timestamp:       started.Add(12 * time.Second),
+		}},
+		Nodes: []*citoolsmetrics.NodeEvent{{
+			Node:        "build0-gstfj-ci-builds-worker-b-2xlarge-amd64-x7k2p",
+			Arch:        "amd64",
+			MachineType: "e2-standard-8",
+			MachineID:   "4b2c9f3e8a1d4e6f",
+			AgeSeconds:  86400,
+			Resources: citoolsmetrics.ResourcesInfo{
+				Capacity:    citoolsmetrics.ResourceDetails{CPU: "8", Memory: "32Gi", EphemeralStorage: "200Gi", Pods: "250"},
+				Allocatable: citoolsmetrics.ResourceDetails{CPU: "7500m", Memory: "29Gi", EphemeralStorage: "180Gi", Pods: "250"},
+			},
+			UsageStats:   citoolsmetrics.ResourceUsageStats{MinCPU: 250, MaxCPU: 6100, AvgCPU: 2400, MinMem: 2 << 30, MaxMem: 18 << 30, AvgMem: 9 << 30},
+			Labels:       map[string]string{"node-role.kubernetes.io/worker": ""},
+			Timestamp:    now,
+			PollStarted:  started,
+			Workloads:    []string{"ci-op-abc123/src-build"},
+			WatchHistory: []citoolsmetrics.WatchPeriod{{StartTime: started, EndTime: now}},
+		}},
+		TestPlatformInsights: []*citoolsmetrics.InsightsEvent{{
+			Name:              string(citoolsmetrics.InsightExecutionCompleted),
+			AdditionalContext: citoolsmetrics.Context{"success": true, "duration_seconds": 540},
+			Timestamp:         completed,
+		}},
+		Leases: []*LeaseEventUnion{
+			{
+				LeaseName:                    "aws-quota-slice",
+				Region:                       "us-east-1",
+				RawLeaseName:                 "us-east-1--aws-quota-slice-07",
+				AcquisitionDurationSeconds:   3.5,
+				LeasesRemainingAtAcquisition: 41,
+				LeasesTotal:                  60,
+				Timestamp:                    started.Add(30 * time.Second),
+			},
+			{
+				LeaseName:                "aws-quota-slice",
+				Region:                   "us-east-1",
+				RawLeaseName:             "us-east-1--aws-quota-slice-07",
+				ReleaseDurationSeconds:   1.2,
+				LeasesAvailableAtRelease: 42,
+				LeasesTotal:              60,
+				Released:                 true,
+				Timestamp:                completed,
+			},
+		},
+		OpenshiftBuilds: []*citoolsmetrics.BuildEvent{{
+			Namespace:       "ci-op-abc123",
+			Name:            "src",
+			StartTime:       started,
+			CompletionTime:  started.Add(3 * time.Minute),
+			DurationSeconds: 180,
+			Status:          "Complete",
+			OutputImage:     "pipeline:src",
+			Timestamp:       started.Add(3 * time.Minute),
+			ForImage:        "src",
+		}},
+	}
+}
+
+// WriteSampleFile writes SampleMetricsData as an indented metrics.json file to path
+func WriteSampleFile(path string, now time.Time) error {
+	encoded, err := json.MarshalIndent(SampleMetricsData(now), "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode sample: %w", err)
+	}
+	if err := os.WriteFile(path, append(encoded, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write sample to %s: %w", path, err)
+	}
+	return nil
+}
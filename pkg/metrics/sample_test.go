@@ -0,0 +1,32 @@
+package metrics
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSampleMetricsDataLoads(t *testing.T) {
+	data := SampleMetricsData(time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC))
+	_, client := newFakeBigQuery(t)
+	loader := NewBigQueryLoader(context.Background(), client, "project", "dataset")
+
+	for _, table := range data.tables() {
+		t.Run(table.name, func(t *testing.T) {
+			// the loader skips empty tables before inferring their schema
+			if len(table.rows) == 0 {
+				if table.name != PodsTable && table.name != EventsTable {
+					t.Fatalf("expected a sample record of %s", table.name)
+				}
+				return
+			}
+			schema, rows, err := loader.prepareTable(table)
+			if err != nil {
+				t.Fatalf("failed to prepare the sample rows: %v", err)
+			}
+			if len(schema) == 0 || len(rows) != len(table.rows) {
+				t.Errorf("expected %d rows with a schema, got %d rows and %d columns", len(table.rows), len(rows), len(schema))
+			}
+		})
+	}
+}
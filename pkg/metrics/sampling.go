@@ -0,0 +1,62 @@
+package metrics
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+)
+
+// rowSampler keeps a random subset of the rows of every table
+type rowSampler struct {
+	rate float64
+	seed uint64
+}
+
+// WithSampleRate keeps each row with probability rate, for quick test loads of a large file. Sampling is
+// deterministic: whether a row is kept depends only on the seed, its table and its content, so the same seed keeps
+// the same rows of the same file however it is split into batches, and identical rows are kept or dropped together.
+// Every table is sampled independently.
+func WithSampleRate(rate float64, seed uint64) Option {
+	return func(b *BigQueryLoader) {
+		if rate < 1 {
+			b.sampler = &rowSampler{rate: rate, seed: seed}
+		}
+	}
+}
+
+// sample returns the rows of table that were kept
+func (s *rowSampler) sample(table string, rows []any) []any {
+	kept := rows[:0:0]
+	for _, row := range rows {
+		if s.keep(table, row) {
+			kept = append(kept, row)
+		}
+	}
+	return kept
+}
+
+// keep decides whether row of table is kept from a hash of the seed, the table and the row, mapped to [0, 1)
+func (s *rowSampler) keep(table string, row any) bool {
+	hash := fnv.New64a()
+	_ = binary.Write(hash, binary.LittleEndian, s.seed)
+	hash.Write([]byte(table))
+	hash.Write([]byte{0})
+	if encoded, err := json.Marshal(row); err == nil {
+		hash.Write(encoded)
+	} else {
+		// rows JSON cannot encode, such as ones holding NaN, are hashed in their printed form
+		fmt.Fprintf(hash, "%+v", row)
+	}
+	return float64(mix64(hash.Sum64())>>11)/(1<<53) < s.rate
+}
+
+// mix64 is the finalizer of MurmurHash3, spreading every bit of x over the whole result
+func mix64(x uint64) uint64 {
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
@@ -0,0 +1,77 @@
+package metrics
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+)
+
+func sampleTestRows(n int) []any {
+	rows := make([]any, n)
+	for i := range rows {
+		rows[i] = &writeAPITestRow{ID: fmt.Sprintf("row-%d", i)}
+	}
+	return rows
+}
+
+func sampledIDs(rows []any) []string {
+	var ids []string
+	for _, row := range rows {
+		ids = append(ids, row.(*writeAPITestRow).ID)
+	}
+	return ids
+}
+
+func TestRowSamplerIgnoresBatches(t *testing.T) {
+	rows := sampleTestRows(5000)
+	sampler := &rowSampler{rate: 0.1, seed: 42}
+	expected := sampledIDs(sampler.sample(EventsTable, rows))
+
+	for _, batchSize := range []int{1, 10, 1000, 1234} {
+		t.Run(fmt.Sprintf("batches of %d", batchSize), func(t *testing.T) {
+			var kept []any
+			for batch := range slices.Chunk(rows, batchSize) {
+				kept = append(kept, sampler.sample(EventsTable, batch)...)
+			}
+			if actual := sampledIDs(kept); !slices.Equal(actual, expected) {
+				t.Errorf("batches kept %d rows, the whole file %d: %v", len(actual), len(expected), actual)
+			}
+		})
+	}
+}
+
+func TestRowSamplerRate(t *testing.T) {
+	rows := sampleTestRows(20000)
+	kept := (&rowSampler{rate: 0.1, seed: 42}).sample(EventsTable, rows)
+	if len(kept) < 1800 || len(kept) > 2200 {
+		t.Errorf("expected about 2000 of %d rows at rate 0.1, got %d", len(rows), len(kept))
+	}
+	// two consecutive batches no longer keep the same positions
+	first, second := (&rowSampler{rate: 0.5, seed: 42}).sample(EventsTable, rows[:10]), (&rowSampler{rate: 0.5, seed: 42}).sample(EventsTable, rows[10:20])
+	positions := func(batch []any, offset int) []int {
+		var kept []int
+		for _, row := range batch {
+			var index int
+			fmt.Sscanf(row.(*writeAPITestRow).ID, "row-%d", &index)
+			kept = append(kept, index-offset)
+		}
+		return kept
+	}
+	if slices.Equal(positions(first, 0), positions(second, 10)) {
+		t.Errorf("both batches kept the rows at positions %v", positions(first, 0))
+	}
+}
+
+func TestRowSamplerSeedAndTable(t *testing.T) {
+	rows := sampleTestRows(1000)
+	base := sampledIDs((&rowSampler{rate: 0.5, seed: 1}).sample(EventsTable, rows))
+	if again := sampledIDs((&rowSampler{rate: 0.5, seed: 1}).sample(EventsTable, rows)); !slices.Equal(again, base) {
+		t.Errorf("the same seed kept other rows")
+	}
+	if other := sampledIDs((&rowSampler{rate: 0.5, seed: 2}).sample(EventsTable, rows)); slices.Equal(other, base) {
+		t.Errorf("another seed kept the same rows")
+	}
+	if other := sampledIDs((&rowSampler{rate: 0.5, seed: 1}).sample(PodsTable, rows)); slices.Equal(other, base) {
+		t.Errorf("another table kept the same rows")
+	}
+}
@@ -0,0 +1,187 @@
+package metrics
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"cloud.google.com/go/bigquery"
+)
+
+// widenings lists the types every value of a type converts to without loss
+var widenings = map[bigquery.FieldType][]bigquery.FieldType{
+	bigquery.IntegerFieldType: {bigquery.FloatFieldType, bigquery.NumericFieldType, bigquery.BigNumericFieldType},
+	bigquery.NumericFieldType: {bigquery.BigNumericFieldType},
+}
+
+// SchemaBaseline remembers the first schema loaded into every table in a local JSON file, so that later loads can
+// report the columns whose type changed since: a type that silently flips between loads, because the row types,
+// the loader flags or a schema file changed, otherwise shows up as insert failures against the existing table.
+// It is safe for concurrent use.
+type SchemaBaseline struct {
+	path string
+	// pinned is set when the baseline was read from a directory written by WriteSchemas, which is never updated
+	pinned bool
+
+	lock   sync.Mutex
+	tables map[string]bigquery.Schema
+}
+
+// SchemaChange is a column whose type differs from the baseline
+type SchemaChange struct {
+	Table string
+	// Field is the dot-separated path of the column, into nested records
+	Field string
+	From  bigquery.FieldType
+	To    bigquery.FieldType
+	// Widened is set when the new type holds every value of the baseline type, such as INTEGER to FLOAT
+	Widened bool
+}
+
+func (c SchemaChange) String() string {
+	change := "changed"
+	if c.Widened {
+		change = "widened"
+	}
+	return fmt.Sprintf("column %s.%s %s from %s to %s", c.Table, c.Field, change, c.From, c.To)
+}
+
+// LoadSchemaBaseline reads the baseline recorded in path. A missing file is an empty baseline, created on the first
+// load. When path is a directory, the baseline is pinned to the <table>.schema.json files WriteSchemas wrote to it:
+// tables without a file are not compared and nothing is recorded.
+func LoadSchemaBaseline(path string) (*SchemaBaseline, error) {
+	baseline := &SchemaBaseline{path: path, tables: map[string]bigquery.Schema{}}
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		return loadPinnedSchemaBaseline(baseline)
+	}
+	encoded, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return baseline, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read schema baseline: %w", err)
+	}
+	var tables map[string]json.RawMessage
+	if err := json.Unmarshal(encoded, &tables); err != nil {
+		return nil, fmt.Errorf("failed to decode schema baseline %s: %w", path, err)
+	}
+	for table, fields := range tables {
+		schema, err := bigquery.SchemaFromJSON(fields)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode the baseline schema of %s: %w", table, err)
+		}
+		baseline.tables[table] = schema
+	}
+	return baseline, nil
+}
+
+func loadPinnedSchemaBaseline(baseline *SchemaBaseline) (*SchemaBaseline, error) {
+	baseline.pinned = true
+	files, err := filepath.Glob(filepath.Join(baseline.path, "*"+SchemaFileSuffix))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list schema files: %w", err)
+	}
+	for _, file := range files {
+		encoded, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read schema file: %w", err)
+		}
+		schema, err := bigquery.SchemaFromJSON(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode schema file %s: %w", file, err)
+		}
+		baseline.tables[strings.TrimSuffix(filepath.Base(file), SchemaFileSuffix)] = schema
+	}
+	return baseline, nil
+}
+
+// WithSchemaBaseline compares the schema of every table loaded with baseline, warning about the columns whose type
+// changed, and records the schema of the tables baseline has not seen yet
+func WithSchemaBaseline(baseline *SchemaBaseline) Option {
+	return func(b *BigQueryLoader) {
+		b.schemaBaseline = baseline
+	}
+}
+
+// observe returns how schema differs from the baseline of table, recording it as the baseline when there is none.
+// A nil baseline reports nothing.
+func (s *SchemaBaseline) observe(table string, schema bigquery.Schema) ([]SchemaChange, error) {
+	if s == nil {
+		return nil, nil
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	baseline, ok := s.tables[table]
+	if !ok {
+		if s.pinned {
+			return nil, nil
+		}
+		s.tables[table] = schema
+		return nil, s.save()
+	}
+	return schemaChanges(table, nil, baseline, schema), nil
+}
+
+// save writes the baseline through a temporary file, so that an interrupted run does not leave it truncated
+func (s *SchemaBaseline) save() error {
+	tables := make(map[string]json.RawMessage, len(s.tables))
+	for table, schema := range s.tables {
+		fields, err := schema.ToJSONFields()
+		if err != nil {
+			return fmt.Errorf("failed to encode the schema of %s: %w", table, err)
+		}
+		tables[table] = fields
+	}
+	encoded, err := json.MarshalIndent(tables, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode schema baseline: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to write schema baseline: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(encoded, '\n')); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write schema baseline: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write schema baseline: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("failed to write schema baseline: %w", err)
+	}
+	return nil
+}
+
+// schemaChanges lists the columns present in both schemas whose type differs, descending into nested records.
+// Columns only one of the schemas has are not reported.
+func schemaChanges(table string, path []string, baseline, schema bigquery.Schema) []SchemaChange {
+	var changes []SchemaChange
+	for _, field := range schema {
+		i := slices.IndexFunc(baseline, func(previous *bigquery.FieldSchema) bool { return strings.EqualFold(previous.Name, field.Name) })
+		if i < 0 {
+			continue
+		}
+		previous := baseline[i]
+		fieldPath := append(slices.Clip(path), field.Name)
+		if previous.Type != field.Type {
+			changes = append(changes, SchemaChange{
+				Table:   table,
+				Field:   strings.Join(fieldPath, "."),
+				From:    previous.Type,
+				To:      field.Type,
+				Widened: slices.Contains(widenings[previous.Type], field.Type),
+			})
+			continue
+		}
+		if field.Type == bigquery.RecordFieldType {
+			changes = append(changes, schemaChanges(table, fieldPath, previous.Schema, field.Schema)...)
+		}
+	}
+	return changes
+}
@@ -0,0 +1,51 @@
+package metrics
+
+import (
+	"reflect"
+	"sync"
+
+	"cloud.google.com/go/bigquery"
+)
+
+// inferredSchema is the result of inferring the schema of a row type
+type inferredSchema struct {
+	schema bigquery.Schema
+	err    error
+}
+
+// inferredSchemas holds the schema of the row type of every table, including the per-event-type tables of
+// WithSplitUnions, inferred once per process: the reflection is the same for every file a process loads
+var inferredSchemas = sync.OnceValue(func() map[reflect.Type]inferredSchema {
+	schemas := map[reflect.Type]inferredSchema{}
+	for _, t := range append((&MetricsData{}).tables(), splitUnionTables((&MetricsData{}).tables())...) {
+		rowType := reflect.TypeOf(t.row)
+		if _, ok := schemas[rowType]; !ok {
+			schema, err := bigquery.InferSchema(t.row)
+			schemas[rowType] = inferredSchema{schema: schema, err: err}
+		}
+	}
+	return schemas
+})
+
+// inferSchema returns the schema of the row type of row, from inferredSchemas for the row types of the tables.
+// The schema is shared: callers copy it before changing it, as nullableJSONColumns does.
+func inferSchema(row any) (bigquery.Schema, error) {
+	if inferred, ok := inferredSchemas()[reflect.TypeOf(row)]; ok {
+		return inferred.schema, inferred.err
+	}
+	return bigquery.InferSchema(row)
+}
+
+// InferSchemas returns the schema inferred from the row type of every table, keyed by table name, as tables are
+// created without loader options. The per-event-type tables of WithSplitUnions are included, while the tables
+// whose row type cannot be inferred, pods and events, are left out. Schemas are inferred once per process and
+// every call returns a copy.
+func InferSchemas() map[string]bigquery.Schema {
+	schemas := map[string]bigquery.Schema{}
+	for _, t := range append((&MetricsData{}).tables(), splitUnionTables((&MetricsData{}).tables())...) {
+		if schema, err := inferSchema(t.row); err == nil {
+			schemas[t.name] = nullableJSONColumns(schema)
+		}
+	}
+	return schemas
+}
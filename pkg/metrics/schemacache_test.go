@@ -0,0 +1,29 @@
+package metrics
+
+import (
+	"testing"
+
+	"cloud.google.com/go/bigquery"
+)
+
+// BenchmarkSchema compares looking the schemas of every table up in inferredSchemas with calling
+// bigquery.InferSchema for them on every load, as the load methods did before schemas were cached. InferSchema
+// memoizes the reflection per type itself, so uncached measures the calls of a warm process rather than the
+// reflection of the first one.
+func BenchmarkSchema(b *testing.B) {
+	tables := append((&MetricsData{}).tables(), splitUnionTables((&MetricsData{}).tables())...)
+	b.Run("cached", func(b *testing.B) {
+		for range b.N {
+			for _, t := range tables {
+				_, _ = inferSchema(t.row)
+			}
+		}
+	})
+	b.Run("uncached", func(b *testing.B) {
+		for range b.N {
+			for _, t := range tables {
+				_, _ = bigquery.InferSchema(t.row)
+			}
+		}
+	})
+}
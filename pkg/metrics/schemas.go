@@ -0,0 +1,44 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// SchemaFileSuffix is appended to the table name to form the file WriteSchemas writes for it
+const SchemaFileSuffix = ".schema.json"
+
+// WriteSchemas writes the schema every table is created with to <dir>/<table>.schema.json, in the JSON format
+// accepted by `bq mk --schema`, applying the transforms of the given loader options. Tables whose row type cannot
+// be inferred are reported in the returned error after the other files have been written. It returns the paths
+// of the written files.
+func WriteSchemas(dir string, opts ...Option) ([]string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create schema directory: %w", err)
+	}
+
+	b := NewBigQueryLoader(context.Background(), nil, "", "", opts...)
+	var written []string
+	var failures []error
+	for _, t := range b.tablesOf(&MetricsData{}) {
+		schema, _, err := b.prepareTable(t)
+		if err != nil {
+			failures = append(failures, fmt.Errorf("%s: %w", t.name, err))
+			continue
+		}
+		encoded, err := schema.ToJSONFields()
+		if err != nil {
+			failures = append(failures, fmt.Errorf("%s: failed to encode schema: %w", t.name, err))
+			continue
+		}
+		path := filepath.Join(dir, t.name+SchemaFileSuffix)
+		if err := os.WriteFile(path, append(encoded, '\n'), 0644); err != nil {
+			return written, fmt.Errorf("failed to write %s: %w", path, err)
+		}
+		written = append(written, path)
+	}
+	return written, errors.Join(failures...)
+}
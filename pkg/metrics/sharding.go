@@ -0,0 +1,72 @@
+package metrics
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+
+	"cloud.google.com/go/bigquery"
+)
+
+// shardConfig spreads the rows of one table across a fixed number of physical tables
+type shardConfig struct {
+	shards int
+	column string
+}
+
+// WithSharding routes every row of table to <table>_<hash(column) % shards> instead of the table itself.
+// Shard tables share the schema of the base table and are created the first time a row lands in them.
+// Read them back with a wildcard table (`dataset.events_*`) or a UNION ALL across the shards.
+func WithSharding(table string, shards int, column string) Option {
+	return func(b *BigQueryLoader) {
+		if b.shards == nil {
+			b.shards = map[string]shardConfig{}
+		}
+		b.shards[table] = shardConfig{shards: shards, column: column}
+	}
+}
+
+// tableTarget is a physical table together with the rows destined for it
+type tableTarget struct {
+	table string
+	rows  []any
+}
+
+// ShardTableName returns the physical table a shard index maps to
+func ShardTableName(table string, shard int) string {
+	return fmt.Sprintf("%s_%d", table, shard)
+}
+
+// route groups rows by the hash of the shard column, returning only the shards that received rows, in shard order
+func (c shardConfig) route(table string, schema bigquery.Schema, rows []any) ([]tableTarget, error) {
+	var column string
+	for _, field := range schema {
+		if strings.EqualFold(field.Name, c.column) {
+			column = field.Name
+			break
+		}
+	}
+	if column == "" {
+		return nil, fmt.Errorf("shard column %s does not exist in table %s", c.column, table)
+	}
+
+	shardRows := make([][]any, c.shards)
+	for _, row := range rows {
+		values, _, err := saveRow(row, schema)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read shard column: %w", err)
+		}
+		hash := fnv.New32a()
+		fmt.Fprint(hash, values[column])
+		shard := int(hash.Sum32() % uint32(c.shards))
+		shardRows[shard] = append(shardRows[shard], row)
+	}
+
+	var targets []tableTarget
+	for shard, rows := range shardRows {
+		if len(rows) > 0 {
+			targets = append(targets, tableTarget{table: ShardTableName(table, shard), rows: rows})
+		}
+	}
+	return targets, nil
+}
@@ -0,0 +1,299 @@
+package metrics
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/option"
+)
+
+// StdinPath is the file path that reads the metrics file from standard input
+const StdinPath = "-"
+
+// ErrTooManyRows is returned when a section of the metrics file holds more rows than DecodeOptions.MaxRowsPerTable
+var ErrTooManyRows = errors.New("too many rows")
+
+// ErrEmptySource is returned when the metrics file holds no data at all, as left behind by a producer that
+// crashed before writing anything. It is not a sign of corruption.
+var ErrEmptySource = errors.New("metrics file is empty")
+
+// DecodeOptions bounds how much of a metrics file is decoded
+type DecodeOptions struct {
+	// MaxRowsPerTable aborts decoding once a single section holds more rows than this. Zero means unlimited.
+	MaxRowsPerTable int
+	// PreserveRaw keeps the record every row was decoded from, for WithPreserveRaw
+	PreserveRaw bool
+	// LenientDecode skips the records of a section, or the NDJSON lines, that fail to decode instead of failing
+	// the whole file, keeping them in MetricsData.BadRecords. Malformed JSON between records still fails the file.
+	LenientDecode bool
+	// EnvelopePath is the dotted path of the object holding the metrics sections, such as data, for files that
+	// wrap them in an envelope like {"metadata": {...}, "data": {...}}. Empty decodes the sections at the root.
+	EnvelopePath string
+	// EnvelopeFields are the dotted paths, from the root, of the envelope fields to keep for WithEnvelopeColumns.
+	// They must lie outside the object at EnvelopePath.
+	EnvelopeFields []string
+	// AllowNonFiniteFloats accepts NaN, Infinity and -Infinity in float fields, both as bare literals, which are not
+	// valid JSON but which some producers write, and as strings such as "NaN" or "+Inf", so that WithNonFiniteFloats
+	// can drop or null them instead of the record failing to decode
+	AllowNonFiniteFloats bool
+}
+
+// DecodeMetricsData decodes a metrics JSON document.
+// Each section array is decoded one record at a time so that individual records can be normalized
+// (see unmarshalRow) and errors can name the offending section and record.
+func DecodeMetricsData(r io.Reader) (*MetricsData, error) {
+	return DecodeMetricsDataWithOptions(r, DecodeOptions{})
+}
+
+// DecodeMetricsDataWithOptions decodes a metrics JSON document like DecodeMetricsData, enforcing opts while streaming
+// so that a pathological section is rejected before it is held in memory.
+func DecodeMetricsDataWithOptions(r io.Reader, opts DecodeOptions) (*MetricsData, error) {
+	return decodeSections(r, opts, 0, nil)
+}
+
+// decodeSections decodes a metrics JSON document. With a batchSize, flush is handed the records decoded so far
+// every batchSize records of a section and at the end of every section, numbering the batches of a section from 1,
+// and the records handed over are not returned.
+func decodeSections(r io.Reader, opts DecodeOptions, batchSize int, flush func(section string, batch int, data *MetricsData) error) (*MetricsData, error) {
+	r = skipPreamble(r)
+	if opts.AllowNonFiniteFloats {
+		r = quoteNonFiniteLiterals(r)
+	}
+	decoder := json.NewDecoder(r)
+	if err := expectDelim(decoder, '{'); errors.Is(err, io.EOF) {
+		return nil, ErrEmptySource
+	} else if err != nil {
+		return nil, err
+	}
+
+	env := newEnvelope(opts)
+	for depth := range env.path {
+		if err := env.enter(decoder, depth); err != nil {
+			return nil, err
+		}
+	}
+
+	newData := func() *MetricsData {
+		data := &MetricsData{envelope: env.values, allowNonFinite: opts.AllowNonFiniteFloats}
+		if opts.PreserveRaw {
+			data.raw = map[any]json.RawMessage{}
+		}
+		return data
+	}
+	data := newData()
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		section, _ := token.(string)
+
+		decode, known := sectionDecoders[section]
+		if !known {
+			var ignored json.RawMessage
+			if err := decoder.Decode(&ignored); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		token, err = decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		if token == nil {
+			continue
+		}
+		if delim, ok := token.(json.Delim); !ok || delim != '[' {
+			return nil, fmt.Errorf("section %s: expected an array, got %v", section, token)
+		}
+		batch, pending := 1, 0
+		flushBatch := func() error {
+			if batchSize == 0 || pending == 0 {
+				return nil
+			}
+			if err := flush(section, batch, data); err != nil {
+				return err
+			}
+			data, batch, pending = newData(), batch+1, 0
+			return nil
+		}
+		for index := 0; decoder.More(); index++ {
+			if pending == batchSize && batchSize > 0 {
+				if err := flushBatch(); err != nil {
+					return nil, err
+				}
+			}
+			pending++
+			if opts.MaxRowsPerTable > 0 && index >= opts.MaxRowsPerTable {
+				return nil, fmt.Errorf("section %s: %w (limit %d)", section, ErrTooManyRows, opts.MaxRowsPerTable)
+			}
+			var raw json.RawMessage
+			if err := decoder.Decode(&raw); err != nil {
+				return nil, fmt.Errorf("section %s: record %d: %w", section, index, err)
+			}
+			if err := decode(data, raw); err != nil {
+				if opts.LenientDecode {
+					data.recordBad(section, index, raw, fmt.Errorf("section %s: record %d: %w", section, index, err))
+					continue
+				}
+				return nil, fmt.Errorf("section %s: record %d: %w", section, index, err)
+			}
+		}
+		if err := expectDelim(decoder, ']'); err != nil {
+			return nil, err
+		}
+		if err := flushBatch(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := expectDelim(decoder, '}'); err != nil {
+		return nil, err
+	}
+	for depth := len(env.path) - 1; depth >= 0; depth-- {
+		if err := env.leave(decoder, depth); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// utf8BOM is the byte order mark some tools write at the start of UTF-8 files
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// skipPreamble returns a reader of r without a leading UTF-8 byte order mark and the whitespace before the first
+// JSON value, which encoding/json rejects or which some sources prepend
+func skipPreamble(r io.Reader) io.Reader {
+	buffered := bufio.NewReader(r)
+	if prefix, err := buffered.Peek(len(utf8BOM)); err == nil && bytes.Equal(prefix, utf8BOM) {
+		buffered.Discard(len(utf8BOM))
+	}
+	for {
+		next, err := buffered.Peek(1)
+		if err != nil || !isJSONSpace(next[0]) {
+			return buffered
+		}
+		buffered.Discard(1)
+	}
+}
+
+func isJSONSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+func expectDelim(decoder *json.Decoder, want json.Delim) error {
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+	if delim, ok := token.(json.Delim); !ok || delim != want {
+		return fmt.Errorf("expected %v, got %v", want, token)
+	}
+	return nil
+}
+
+// gcsObjectReader closes the GCS client together with the object reader
+type gcsObjectReader struct {
+	*storage.Reader
+	client *storage.Client
+}
+
+func (r *gcsObjectReader) Close() error {
+	readerErr := r.Reader.Close()
+	clientErr := r.client.Close()
+	if readerErr != nil {
+		return readerErr
+	}
+	return clientErr
+}
+
+// OpenGCSObject opens a reader on a GCS object, decompressing zstd objects (see ZstdSuffix).
+// Closing it also closes the underlying GCS client.
+func OpenGCSObject(ctx context.Context, bucket, object string, clientOpts ...option.ClientOption) (io.ReadCloser, error) {
+	gcsClient, err := storage.NewClient(ctx, clientOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCS client: %w", err)
+	}
+
+	reader, err := gcsClient.Bucket(bucket).Object(object).NewReader(ctx)
+	if err != nil {
+		gcsClient.Close()
+		return nil, fmt.Errorf("failed to open GCS object: %w", explainAPIDisabled("Cloud Storage", err))
+	}
+	return decompress(object, reader.Attrs.ContentEncoding, &gcsObjectReader{Reader: reader, client: gcsClient})
+}
+
+// OpenFile opens a reader on a local file, decompressing .zst files, or on standard input when path is StdinPath
+func OpenFile(path string) (io.ReadCloser, error) {
+	if path == StdinPath {
+		return io.NopCloser(os.Stdin), nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	return decompress(path, "", file)
+}
+
+// ReadMetricsFromGCS downloads and decodes a metrics file from GCS
+func ReadMetricsFromGCS(ctx context.Context, bucket, object string, clientOpts ...option.ClientOption) (*MetricsData, error) {
+	return readMetricsFromGCS(ctx, bucket, object, DecodeOptions{}, clientOpts...)
+}
+
+func readMetricsFromGCS(ctx context.Context, bucket, object string, opts DecodeOptions, clientOpts ...option.ClientOption) (*MetricsData, error) {
+	reader, err := OpenGCSObject(ctx, bucket, object, clientOpts...)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	data, err := DecodeMetricsDataWithOptions(reader, opts)
+	if errors.Is(err, ErrEmptySource) {
+		return nil, fmt.Errorf("gs://%s/%s: %w", bucket, object, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode JSON from GCS: %w", err)
+	}
+	return data, nil
+}
+
+// ReadMetricsFromFile reads and decodes a local metrics file, or standard input when path is StdinPath
+func ReadMetricsFromFile(path string) (*MetricsData, error) {
+	reader, err := OpenFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	data, err := DecodeMetricsData(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode JSON from %s: %w", path, err)
+	}
+	return data, nil
+}
+
+// DatasetFromMetadata returns the dataset named by the custom metadata key of a GCS object, or fallback when the key is absent
+func DatasetFromMetadata(ctx context.Context, bucket, object, key, fallback string, clientOpts ...option.ClientOption) (string, error) {
+	gcsClient, err := storage.NewClient(ctx, clientOpts...)
+	if err != nil {
+		return "", fmt.Errorf("failed to create GCS client: %w", err)
+	}
+	defer gcsClient.Close()
+
+	attrs, err := gcsClient.Bucket(bucket).Object(object).Attrs(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to read GCS object attributes: %w", err)
+	}
+	if dataset := attrs.Metadata[key]; dataset != "" {
+		return dataset, nil
+	}
+	return fallback, nil
+}
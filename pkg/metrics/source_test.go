@@ -0,0 +1,75 @@
+package metrics
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestSkipPreamble(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		fixture string
+	}{
+		{name: "UTF-8 BOM and leading whitespace", fixture: "testdata/bom.json"},
+		{name: "no BOM", fixture: "testdata/no_bom.json"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			raw, err := os.ReadFile(tc.fixture)
+			if err != nil {
+				t.Fatal(err)
+			}
+			skipped, err := io.ReadAll(skipPreamble(bytes.NewReader(raw)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.HasPrefix(skipped, []byte(`{"leases"`)) {
+				t.Errorf("expected the preamble to be skipped, got %q", skipped)
+			}
+
+			data, err := DecodeMetricsData(bytes.NewReader(raw))
+			if err != nil {
+				t.Fatalf("failed to decode: %v", err)
+			}
+			if len(data.Leases) != 1 || data.Leases[0].LeaseName != "aws-quota-slice" {
+				t.Errorf("unexpected leases %+v", data.Leases)
+			}
+		})
+	}
+}
+
+func TestSkipPreambleNDJSON(t *testing.T) {
+	file, err := os.Open("testdata/bom.ndjson")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	data, err := DecodeNDJSON(file)
+	if err != nil {
+		t.Fatalf("failed to decode: %v", err)
+	}
+	if len(data.Leases) != 2 {
+		t.Errorf("expected 2 leases, got %d", len(data.Leases))
+	}
+}
+
+func TestSkipPreambleKeepsBOMInsideValues(t *testing.T) {
+	data, err := DecodeMetricsData(strings.NewReader("{\"leases\": [{\"name\": \"\ufeffslice\"}]}"))
+	if err != nil {
+		t.Fatalf("failed to decode: %v", err)
+	}
+	if len(data.Leases) != 1 || data.Leases[0].LeaseName != "\ufeffslice" {
+		t.Errorf("expected a BOM inside a value to be kept, got %+v", data.Leases)
+	}
+}
+
+func TestDecodeEmptySource(t *testing.T) {
+	for _, content := range []string{"", "\xef\xbb\xbf", "\xef\xbb\xbf \n\t"} {
+		if _, err := DecodeMetricsData(strings.NewReader(content)); !errors.Is(err, ErrEmptySource) {
+			t.Errorf("expected %q to be reported as empty, got %v", content, err)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package metrics
+
+import (
+	citoolsmetrics "github.com/openshift/ci-tools/pkg/metrics"
+)
+
+// Tables the union tables are split into by WithSplitUnions
+const (
+	LeaseAcquisitionsTable = "lease_acquisitions"
+	LeaseReleasesTable     = "lease_releases"
+	ImageStreamsTable      = "image_streams"
+	TagImportsTable        = "tag_imports"
+)
+
+// WithSplitUnions loads lease and image events into one table per original event type instead of the leases and
+// images union tables, each with the narrower schema of its ci-tools event type. A lease event is a release when
+// it is marked released or carries any release-only field (release duration, leases available, error), and an
+// acquisition otherwise. An image event is a tag import when it carries any tag import field (tag, source image,
+// start or completion time, duration, retries), and an image stream event otherwise.
+func WithSplitUnions() Option {
+	return func(b *BigQueryLoader) {
+		b.splitUnions = true
+	}
+}
+
+// tablesOf returns the tables of data the loader writes, in load order
+func (b *BigQueryLoader) tablesOf(data *MetricsData) []tableData {
+	if b.splitUnions {
+		return splitUnionTables(data.tables())
+	}
+	return data.tables()
+}
+
+// splitUnionTables replaces the leases and images tables by their per-event-type tables
+func splitUnionTables(tables []tableData) []tableData {
+	split := make([]tableData, 0, len(tables)+2)
+	for _, t := range tables {
+		switch t.name {
+		case LeasesTable:
+			acquisitions := tableData{name: LeaseAcquisitionsTable, row: citoolsmetrics.LeaseAcquisitionMetricEvent{}, splitFrom: t.name, raw: t.raw, envelope: t.envelope}
+			releases := tableData{name: LeaseReleasesTable, row: citoolsmetrics.LeaseReleaseMetricEvent{}, splitFrom: t.name, raw: t.raw, envelope: t.envelope}
+			for _, row := range t.rows {
+				lease := row.(*LeaseEventUnion)
+				if lease.isRelease() {
+					releases.rows = append(releases.rows, t.carryRaw(row, lease.release()))
+				} else {
+					acquisitions.rows = append(acquisitions.rows, t.carryRaw(row, lease.acquisition()))
+				}
+			}
+			split = append(split, acquisitions, releases)
+		case ImagesTable:
+			streams := tableData{name: ImageStreamsTable, row: citoolsmetrics.ImageStreamEvent{}, splitFrom: t.name, raw: t.raw, envelope: t.envelope}
+			imports := tableData{name: TagImportsTable, row: citoolsmetrics.TagImportEvent{}, splitFrom: t.name, raw: t.raw, envelope: t.envelope}
+			for _, row := range t.rows {
+				image := row.(*ImageEventUnion)
+				if image.isTagImport() {
+					imports.rows = append(imports.rows, t.carryRaw(row, image.tagImport()))
+				} else {
+					streams.rows = append(streams.rows, t.carryRaw(row, image.imageStream()))
+				}
+			}
+			split = append(split, streams, imports)
+		default:
+			split = append(split, t)
+		}
+	}
+	return split
+}
+
+// carryRaw gives the split row to the record of the union row from, and returns it
+func (t tableData) carryRaw(from, to any) any {
+	if t.raw != nil {
+		t.raw[to] = t.raw[from]
+	}
+	return to
+}
+
+func (l *LeaseEventUnion) isRelease() bool {
+	return l.Released || l.ReleaseDurationSeconds != 0 || l.LeasesAvailableAtRelease != 0 || l.Error != ""
+}
+
+func (l *LeaseEventUnion) acquisition() *citoolsmetrics.LeaseAcquisitionMetricEvent {
+	return &citoolsmetrics.LeaseAcquisitionMetricEvent{
+		LeaseName:                    l.LeaseName,
+		Slice:                        l.Slice,
+		Region:                       l.Region,
+		RawLeaseName:                 l.RawLeaseName,
+		AcquisitionDurationSeconds:   l.AcquisitionDurationSeconds,
+		LeasesRemainingAtAcquisition: l.LeasesRemainingAtAcquisition,
+		LeasesTotal:                  l.LeasesTotal,
+		Timestamp:                    l.Timestamp,
+	}
+}
+
+func (l *LeaseEventUnion) release() *citoolsmetrics.LeaseReleaseMetricEvent {
+	return &citoolsmetrics.LeaseReleaseMetricEvent{
+		LeaseName:                l.LeaseName,
+		Slice:                    l.Slice,
+		Region:                   l.Region,
+		RawLeaseName:             l.RawLeaseName,
+		ReleaseDurationSeconds:   l.ReleaseDurationSeconds,
+		LeasesAvailableAtRelease: l.LeasesAvailableAtRelease,
+		LeasesTotal:              l.LeasesTotal,
+		Released:                 l.Released,
+		Error:                    l.Error,
+		Timestamp:                l.Timestamp,
+	}
+}
+
+func (i *ImageEventUnion) isTagImport() bool {
+	return i.TagName != "" || i.FullTagName != "" || i.SourceImage != "" || i.SourceImageKind != "" ||
+		!i.StartTime.IsZero() || !i.CompletionTime.IsZero() || i.DurationSeconds != 0 || i.RetryCount != 0
+}
+
+func (i *ImageEventUnion) imageStream() *citoolsmetrics.ImageStreamEvent {
+	return &citoolsmetrics.ImageStreamEvent{
+		Namespace:          i.Namespace,
+		ImageStreamName:    i.ImageStreamName,
+		FullName:           i.FullName,
+		Success:            i.Success,
+		Error:              i.Error,
+		ImageStreamDetails: i.ImageStreamDetails,
+		AdditionalContext:  i.AdditionalContext,
+		Timestamp:          i.Timestamp,
+	}
+}
+
+func (i *ImageEventUnion) tagImport() *citoolsmetrics.TagImportEvent {
+	return &citoolsmetrics.TagImportEvent{
+		Namespace:         i.Namespace,
+		ImageStreamName:   i.ImageStreamName,
+		TagName:           i.TagName,
+		FullTagName:       i.FullTagName,
+		SourceImage:       i.SourceImage,
+		SourceImageKind:   i.SourceImageKind,
+		StartTime:         i.StartTime,
+		CompletionTime:    i.CompletionTime,
+		DurationSeconds:   i.DurationSeconds,
+		RetryCount:        i.RetryCount,
+		Success:           i.Success,
+		Error:             i.Error,
+		AdditionalContext: i.AdditionalContext,
+		Timestamp:         i.Timestamp,
+	}
+}
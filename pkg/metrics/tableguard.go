@@ -0,0 +1,68 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"cloud.google.com/go/bigquery"
+)
+
+// TableCreationGuard makes sure each table is created at most once per run. Loaders that share a guard and
+// need the same table wait on the first creation attempt instead of issuing their own Create call.
+// It is safe for concurrent use.
+type TableCreationGuard struct {
+	attempts sync.Map
+}
+
+// tableCreation is a single, possibly in-flight, attempt to create a table
+type tableCreation struct {
+	done chan struct{}
+	err  error
+}
+
+// NewTableCreationGuard creates an empty guard
+func NewTableCreationGuard() *TableCreationGuard {
+	return &TableCreationGuard{}
+}
+
+// WithTableCreationGuard shares guard between loaders so concurrent loads of the same tables create them only once.
+// Without it every loader uses a guard of its own.
+func WithTableCreationGuard(guard *TableCreationGuard) Option {
+	return func(b *BigQueryLoader) {
+		b.creationGuard = guard
+	}
+}
+
+// ensure creates table with metadata unless a previous or in-flight attempt already did. A table that already
+// exists counts as created. Failed attempts are forgotten, so the next caller tries again. When drop is set, it
+// runs before the first attempt to create the table, so that the table is dropped at most once per run.
+func (g *TableCreationGuard) ensure(ctx context.Context, table *bigquery.Table, metadata *bigquery.TableMetadata, drop func(context.Context, *bigquery.Table) error) (created bool, err error) {
+	key := table.FullyQualifiedName()
+	attempt := &tableCreation{done: make(chan struct{})}
+	if existing, loaded := g.attempts.LoadOrStore(key, attempt); loaded {
+		inFlight := existing.(*tableCreation)
+		select {
+		case <-inFlight.done:
+			return false, inFlight.err
+		case <-ctx.Done():
+			return false, ctx.Err()
+		}
+	}
+
+	if drop != nil {
+		if err := drop(ctx, table); err != nil {
+			attempt.err = err
+			g.attempts.Delete(key)
+			close(attempt.done)
+			return false, err
+		}
+	}
+	err = table.Create(ctx, metadata)
+	if err != nil && !isAlreadyExistsError(err) {
+		attempt.err = fmt.Errorf("failed to create table %s: %w", table.TableID, err)
+		g.attempts.Delete(key)
+	}
+	close(attempt.done)
+	return err == nil, attempt.err
+}
@@ -0,0 +1,107 @@
+package metrics
+
+import (
+	"encoding/json"
+
+	"cloud.google.com/go/bigquery"
+
+	citoolsmetrics "github.com/openshift/ci-tools/pkg/metrics"
+)
+
+// BigQuery table names for each section of the metrics file
+const (
+	ImagesTable               = "images"
+	NodesTable                = "nodes"
+	TestPlatformInsightsTable = "test_platform_insights"
+	LeasesTable               = "leases"
+	OpenshiftBuildsTable      = "openshift_builds"
+	PodsTable                 = "pods"
+	EventsTable               = "events"
+)
+
+// tableData holds the rows of a single metrics section along with the row type used for schema inference
+type tableData struct {
+	name string
+	row  any
+	rows []any
+	// schema, when set, is used instead of the schema inferred from row
+	schema bigquery.Schema
+	// splitFrom names the union table the rows were split out of by WithSplitUnions
+	splitFrom string
+	// raw holds the record every row was decoded from, when the records were preserved
+	raw map[any]json.RawMessage
+	// envelope holds the envelope fields of the file the rows were decoded from
+	envelope map[string]string
+}
+
+// tables returns every section of the metrics data in load order
+func (d *MetricsData) tables() []tableData {
+	return []tableData{
+		{name: ImagesTable, row: ImageEventUnion{}, rows: toRows(d.Images), raw: d.raw, envelope: d.envelope},
+		{name: NodesTable, row: citoolsmetrics.NodeEvent{}, rows: toRows(d.Nodes), raw: d.raw, envelope: d.envelope},
+		{name: TestPlatformInsightsTable, row: citoolsmetrics.InsightsEvent{}, rows: toRows(d.TestPlatformInsights), raw: d.raw, envelope: d.envelope},
+		{name: LeasesTable, row: LeaseEventUnion{}, rows: toRows(d.Leases), raw: d.raw, envelope: d.envelope},
+		{name: OpenshiftBuildsTable, row: citoolsmetrics.BuildEvent{}, rows: toRows(d.OpenshiftBuilds), raw: d.raw, envelope: d.envelope},
+		{name: PodsTable, row: citoolsmetrics.PodLifecycleMetricsEvent{}, rows: toRows(d.Pods), raw: d.raw, envelope: d.envelope},
+		{name: EventsTable, row: citoolsmetrics.Event{}, rows: toRows(d.Events), raw: d.raw, envelope: d.envelope},
+	}
+}
+
+// Tables returns the name of every table in load order
+func Tables() []string {
+	var names []string
+	for _, t := range (&MetricsData{}).tables() {
+		names = append(names, t.name)
+	}
+	return names
+}
+
+// append adds the rows of every section of other to d
+func (d *MetricsData) append(other *MetricsData) {
+	d.Images = append(d.Images, other.Images...)
+	d.Nodes = append(d.Nodes, other.Nodes...)
+	d.TestPlatformInsights = append(d.TestPlatformInsights, other.TestPlatformInsights...)
+	d.Leases = append(d.Leases, other.Leases...)
+	d.OpenshiftBuilds = append(d.OpenshiftBuilds, other.OpenshiftBuilds...)
+	d.Pods = append(d.Pods, other.Pods...)
+	d.Events = append(d.Events, other.Events...)
+	d.badRecords = append(d.badRecords, other.badRecords...)
+	if other.raw != nil && d.raw == nil {
+		d.raw = map[any]json.RawMessage{}
+	}
+	for row, raw := range other.raw {
+		d.raw[row] = raw
+	}
+	if d.envelope == nil {
+		d.envelope = other.envelope
+	}
+}
+
+func toRows[T any](in []*T) []any {
+	rows := make([]any, 0, len(in))
+	for _, item := range in {
+		rows = append(rows, item)
+	}
+	return rows
+}
+
+// sectionDecoders decode a single element of a top-level metrics array into the matching MetricsData slice, keyed by JSON section name
+var sectionDecoders = map[string]func(*MetricsData, json.RawMessage) error{
+	ImagesTable:               func(d *MetricsData, raw json.RawMessage) error { return decodeRow(d, raw, &d.Images) },
+	NodesTable:                func(d *MetricsData, raw json.RawMessage) error { return decodeRow(d, raw, &d.Nodes) },
+	TestPlatformInsightsTable: func(d *MetricsData, raw json.RawMessage) error { return decodeRow(d, raw, &d.TestPlatformInsights) },
+	LeasesTable:               func(d *MetricsData, raw json.RawMessage) error { return decodeRow(d, raw, &d.Leases) },
+	OpenshiftBuildsTable:      func(d *MetricsData, raw json.RawMessage) error { return decodeRow(d, raw, &d.OpenshiftBuilds) },
+	PodsTable:                 func(d *MetricsData, raw json.RawMessage) error { return decodeRow(d, raw, &d.Pods) },
+	EventsTable:               func(d *MetricsData, raw json.RawMessage) error { return decodeRow(d, raw, &d.Events) },
+}
+
+func decodeRow[T any](d *MetricsData, raw json.RawMessage, rows *[]*T) error {
+	row := new(T)
+	if err := unmarshalRow(raw, row, d.allowNonFinite); err != nil {
+		return err
+	}
+	*rows = append(*rows, row)
+	d.recordRaw(row, raw)
+	return nil
+}
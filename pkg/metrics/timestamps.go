@@ -0,0 +1,223 @@
+package metrics
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// epochMillisThreshold separates epoch seconds from epoch milliseconds: 1e12 seconds is tens of
+// thousands of years away while 1e12 milliseconds is September 2001
+const epochMillisThreshold = 1e12
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// unmarshalRow decodes a single record into row. Records whose time fields were written by older
+// producers as numeric Unix epochs (seconds or milliseconds) are rewritten to RFC3339, and numbers
+// written as strings are unquoted, before the record is decoded again. With allowNonFinite, the NaN and infinite
+// floats written as strings are set once the record is decoded.
+func unmarshalRow(raw json.RawMessage, row any, allowNonFinite bool) error {
+	err := decodeRecord(raw, row)
+	if err == nil {
+		return nil
+	}
+	changed := false
+	for _, normalize := range []func(json.RawMessage, reflect.Type) (json.RawMessage, bool, error){normalizeEpochTimes, normalizeNumericStrings} {
+		if normalized, normalizedChanged, normalizeErr := normalize(raw, reflect.TypeOf(row)); normalizeErr == nil && normalizedChanged {
+			raw, changed = normalized, true
+		}
+	}
+	nonFinite := raw
+	if allowNonFinite {
+		if normalized, normalizedChanged, normalizeErr := zeroNonFiniteFloats(raw, reflect.TypeOf(row)); normalizeErr == nil && normalizedChanged {
+			raw, changed = normalized, true
+		}
+	}
+	if !changed {
+		return err
+	}
+	if err := decodeRecord(raw, row); err != nil {
+		return err
+	}
+	if allowNonFinite {
+		return setNonFiniteFloats(nonFinite, reflect.ValueOf(row))
+	}
+	return nil
+}
+
+// decodeRecord decodes raw into row, keeping the numbers of free-form fields such as AdditionalContext as
+// json.Number: decoded as float64, integers above 2^53 such as large IDs would silently lose precision. json.Number
+// encodes back to the exact literal when the maps are written to JSON columns.
+func decodeRecord(raw json.RawMessage, row any) error {
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	return decoder.Decode(row)
+}
+
+// normalizeEpochTimes walks raw guided by the Go type t and converts numeric values of time fields into RFC3339 strings
+func normalizeEpochTimes(raw json.RawMessage, t reflect.Type) (json.RawMessage, bool, error) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	trimmed := bytes.TrimSpace(raw)
+	if len(trimmed) == 0 || bytes.Equal(trimmed, []byte("null")) || !containsTime(t, nil) {
+		return raw, false, nil
+	}
+
+	switch {
+	case t == timeType:
+		if trimmed[0] == '"' {
+			return raw, false, nil
+		}
+		parsed, err := parseEpoch(string(trimmed))
+		if err != nil {
+			return nil, false, err
+		}
+		encoded, err := json.Marshal(parsed.Format(time.RFC3339Nano))
+		return encoded, err == nil, err
+
+	case t.Kind() == reflect.Struct:
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &fields); err != nil {
+			return nil, false, err
+		}
+		changed := false
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name, ok := jsonFieldName(field)
+			if !ok {
+				continue
+			}
+			value, ok := fields[name]
+			if !ok {
+				continue
+			}
+			normalized, fieldChanged, err := normalizeEpochTimes(value, field.Type)
+			if err != nil {
+				return nil, false, fmt.Errorf("field %s: %w", name, err)
+			}
+			if fieldChanged {
+				fields[name] = normalized
+				changed = true
+			}
+		}
+		if !changed {
+			return raw, false, nil
+		}
+		encoded, err := json.Marshal(fields)
+		return encoded, err == nil, err
+
+	case t.Kind() == reflect.Slice || t.Kind() == reflect.Array:
+		var items []json.RawMessage
+		if err := json.Unmarshal(raw, &items); err != nil {
+			return nil, false, err
+		}
+		changed := false
+		for i, item := range items {
+			normalized, itemChanged, err := normalizeEpochTimes(item, t.Elem())
+			if err != nil {
+				return nil, false, err
+			}
+			if itemChanged {
+				items[i] = normalized
+				changed = true
+			}
+		}
+		if !changed {
+			return raw, false, nil
+		}
+		encoded, err := json.Marshal(items)
+		return encoded, err == nil, err
+
+	case t.Kind() == reflect.Map:
+		var items map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &items); err != nil {
+			return nil, false, err
+		}
+		changed := false
+		for key, item := range items {
+			normalized, itemChanged, err := normalizeEpochTimes(item, t.Elem())
+			if err != nil {
+				return nil, false, err
+			}
+			if itemChanged {
+				items[key] = normalized
+				changed = true
+			}
+		}
+		if !changed {
+			return raw, false, nil
+		}
+		encoded, err := json.Marshal(items)
+		return encoded, err == nil, err
+	}
+	return raw, false, nil
+}
+
+// parseEpoch interprets a JSON number as Unix epoch seconds or milliseconds, depending on its magnitude
+func parseEpoch(value string) (time.Time, error) {
+	if epoch, err := strconv.ParseInt(value, 10, 64); err == nil {
+		if epoch >= epochMillisThreshold || epoch <= -epochMillisThreshold {
+			return time.UnixMilli(epoch).UTC(), nil
+		}
+		return time.Unix(epoch, 0).UTC(), nil
+	}
+	epoch, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid epoch timestamp %s: %w", value, err)
+	}
+	if math.Abs(epoch) >= epochMillisThreshold {
+		epoch /= 1000
+	}
+	seconds, fraction := math.Modf(epoch)
+	return time.Unix(int64(seconds), int64(fraction*1e9)).UTC(), nil
+}
+
+// containsTime reports whether t is, or transitively holds, a time.Time
+func containsTime(t reflect.Type, seen map[reflect.Type]bool) bool {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == timeType {
+		return true
+	}
+	if seen[t] {
+		return false
+	}
+	if seen == nil {
+		seen = map[reflect.Type]bool{}
+	}
+	seen[t] = true
+
+	switch t.Kind() {
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			if t.Field(i).IsExported() && containsTime(t.Field(i).Type, seen) {
+				return true
+			}
+		}
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return containsTime(t.Elem(), seen)
+	}
+	return false
+}
+
+// jsonFieldName returns the key encoding/json uses for a struct field, or false when encoding/json skips the field
+func jsonFieldName(field reflect.StructField) (string, bool) {
+	if !field.IsExported() {
+		return "", false
+	}
+	tag := field.Tag.Get("json")
+	if tag == "-" {
+		return "", false
+	}
+	if name, _, _ := strings.Cut(tag, ","); name != "" {
+		return name, true
+	}
+	return field.Name, true
+}
@@ -0,0 +1,92 @@
+package metrics
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUnmarshalRowTimestamps(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		timestamp string
+		expected  time.Time
+	}{
+		{name: "RFC3339", timestamp: `"2023-11-14T22:13:20Z"`, expected: time.Unix(1700000000, 0).UTC()},
+		{name: "RFC3339 with fraction and offset", timestamp: `"2023-11-15T00:13:20.25+02:00"`, expected: time.Unix(1700000000, 250000000).UTC()},
+		{name: "epoch seconds", timestamp: `1700000000`, expected: time.Unix(1700000000, 0).UTC()},
+		{name: "fractional epoch seconds", timestamp: `1700000000.5`, expected: time.Unix(1700000000, 500000000).UTC()},
+		{name: "epoch milliseconds", timestamp: `1700000000250`, expected: time.Unix(1700000000, 250000000).UTC()},
+		{name: "fractional epoch milliseconds", timestamp: `1700000000250.0`, expected: time.Unix(1700000000, 250000000).UTC()},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var lease LeaseEventUnion
+			if err := unmarshalRow(json.RawMessage(`{"name": "aws-quota-slice", "timestamp": `+tc.timestamp+`}`), &lease, false); err != nil {
+				t.Fatalf("failed to decode: %v", err)
+			}
+			if !lease.Timestamp.Equal(tc.expected) {
+				t.Errorf("expected timestamp %s, got %s", tc.expected, lease.Timestamp)
+			}
+			if lease.LeaseName != "aws-quota-slice" {
+				t.Errorf("expected the other fields to be decoded, got name %q", lease.LeaseName)
+			}
+		})
+	}
+}
+
+func TestNormalizeEpochTimesSkipsIgnoredFields(t *testing.T) {
+	type row struct {
+		When    time.Time `json:"when"`
+		Skipped time.Time `json:"-"`
+		hidden  time.Time
+	}
+	normalized, changed, err := normalizeEpochTimes(json.RawMessage(`{"when": 1700000000, "Skipped": true, "hidden": true}`), reflect.TypeOf(row{}))
+	if err != nil {
+		t.Fatalf("expected the fields encoding/json skips to be ignored, got %v", err)
+	}
+	if !changed {
+		t.Fatal("expected when to be normalized")
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(normalized, &fields); err != nil {
+		t.Fatal(err)
+	}
+	if string(fields["when"]) != `"2023-11-14T22:13:20Z"` {
+		t.Errorf("unexpected when %s", fields["when"])
+	}
+	if string(fields["Skipped"]) != "true" || string(fields["hidden"]) != "true" {
+		t.Errorf("expected the ignored fields to be left alone, got %s", normalized)
+	}
+}
+
+func TestDecodeRecordKeepsLargeIntegers(t *testing.T) {
+	// 2^53 + 1 is the first integer float64 cannot represent, rounding to 2^53
+	const id = "9007199254740993"
+	file := `{"images": [{"full_name": "ci/src", "additional_context": {"id": ` + id + `, "nested": {"ids": [` + id + `]}}}]}`
+	data, err := DecodeMetricsData(strings.NewReader(file))
+	if err != nil {
+		t.Fatalf("failed to decode: %v", err)
+	}
+	additional := data.Images[0].AdditionalContext
+	if number, ok := additional["id"].(json.Number); !ok || number.String() != id {
+		t.Fatalf("expected id to be decoded as the number %s, got %v (%T)", id, additional["id"], additional["id"])
+	}
+
+	schema, err := inferSchema(ImageEventUnion{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	values, _, err := saveRow(data.Images[0], schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := json.Marshal(values["AdditionalContext"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := `{"id":` + id + `,"nested":{"ids":[` + id + `]}}`; string(encoded) != expected {
+		t.Errorf("expected the JSON column to hold %s, got %s", expected, encoded)
+	}
+}
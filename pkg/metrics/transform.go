@@ -0,0 +1,109 @@
+package metrics
+
+import (
+	"fmt"
+
+	"cloud.google.com/go/bigquery"
+)
+
+// rowTransform rewrites the schema of a table and every row saved against it before insert
+type rowTransform interface {
+	// schema returns the schema rows will have after the transform
+	schema(table string, in bigquery.Schema) (bigquery.Schema, error)
+	// row rewrites the values of a row that were saved against the schema in, returning nil values to drop the row
+	row(table string, in bigquery.Schema, values map[string]bigquery.Value) (map[string]bigquery.Value, error)
+}
+
+// savedRow is a ValueSaver over values that have already been transformed
+type savedRow struct {
+	values   map[string]bigquery.Value
+	insertID string
+}
+
+func (r *savedRow) Save() (map[string]bigquery.Value, string, error) {
+	return r.values, r.insertID, nil
+}
+
+// saveRow converts a row into column values, using the row's own ValueSaver when it has one
+func saveRow(row any, schema bigquery.Schema) (map[string]bigquery.Value, string, error) {
+	if saver, ok := row.(bigquery.ValueSaver); ok {
+		return saver.Save()
+	}
+	return (&bigquery.StructSaver{Struct: row, Schema: schema}).Save()
+}
+
+// applyTransforms runs the configured transforms over the schema and rows of a table.
+// Without transforms the rows are returned untouched so they are inserted straight from their structs.
+func (b *BigQueryLoader) applyTransforms(table string, schema bigquery.Schema, rows []any) (bigquery.Schema, []any, error) {
+	if len(b.transforms) == 0 {
+		return schema, rows, nil
+	}
+
+	schemas := []bigquery.Schema{schema}
+	for _, transform := range b.transforms {
+		next, err := transform.schema(table, schemas[len(schemas)-1])
+		if err != nil {
+			return nil, nil, err
+		}
+		schemas = append(schemas, next)
+	}
+
+	transformed := make([]any, 0, len(rows))
+	for i, row := range rows {
+		values, insertID, err := saveRow(row, schema)
+		if err != nil {
+			return nil, nil, fmt.Errorf("row %d: %w", i, err)
+		}
+		for j, transform := range b.transforms {
+			if values, err = transform.row(table, schemas[j], values); err != nil {
+				return nil, nil, fmt.Errorf("row %d: %w", i, err)
+			}
+			if values == nil {
+				break
+			}
+			if keyer, ok := transform.(insertIDTransform); ok {
+				id, err := keyer.insertID(table, values)
+				if err != nil {
+					return nil, nil, fmt.Errorf("row %d: %w", i, err)
+				}
+				if id != "" {
+					insertID = id
+				}
+			}
+		}
+		if values != nil {
+			transformed = append(transformed, &savedRow{values: values, insertID: insertID})
+		}
+	}
+	for _, transform := range b.transforms {
+		if reporter, ok := transform.(transformReport); ok {
+			reporter.report(table, b.logger)
+		}
+	}
+	return schemas[len(schemas)-1], transformed, nil
+}
+
+// constantColumn appends a STRING column holding the same value on every row. An empty value is stored as NULL.
+type constantColumn struct {
+	name  string
+	value string
+}
+
+func (c constantColumn) schema(table string, in bigquery.Schema) (bigquery.Schema, error) {
+	for _, field := range in {
+		if field.Name == c.name {
+			return nil, fmt.Errorf("table %s already has a %s column", table, c.name)
+		}
+	}
+	out := append(bigquery.Schema{}, in...)
+	return append(out, &bigquery.FieldSchema{Name: c.name, Type: bigquery.StringFieldType}), nil
+}
+
+func (c constantColumn) row(_ string, _ bigquery.Schema, values map[string]bigquery.Value) (map[string]bigquery.Value, error) {
+	if c.value == "" {
+		values[c.name] = nil
+	} else {
+		values[c.name] = c.value
+	}
+	return values, nil
+}
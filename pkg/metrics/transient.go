@@ -0,0 +1,51 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+
+	"google.golang.org/api/googleapi"
+)
+
+// IsTransientError reports whether err, or any error it wraps or joins, is worth retrying: a GCS or BigQuery API
+// error with a 408, 429 or 5xx status, a BigQuery quota or rate limit error, which is reported with a 403 status, a
+// network timeout or a deadline that ran out. Everything else, such as decode errors, empty files and schema
+// mismatches rejected with a 4xx status, fails the same way on every retry.
+func IsTransientError(err error) bool {
+	if err == nil {
+		return false
+	}
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) && isTransientStatus(apiErr.Code) {
+		return true
+	}
+	if quotaReason(err) != "" {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	// errors.As stops at the first match, so look through joined errors one by one
+	switch wrapped := err.(type) {
+	case interface{ Unwrap() []error }:
+		for _, e := range wrapped.Unwrap() {
+			if IsTransientError(e) {
+				return true
+			}
+		}
+	case interface{ Unwrap() error }:
+		return IsTransientError(wrapped.Unwrap())
+	}
+	return false
+}
+
+func isTransientStatus(code int) bool {
+	return code == http.StatusRequestTimeout || code == http.StatusTooManyRequests || code >= http.StatusInternalServerError
+}
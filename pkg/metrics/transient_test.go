@@ -0,0 +1,64 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"google.golang.org/api/googleapi"
+)
+
+func TestIsTransientError(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{
+			name:     "403 rateLimitExceeded",
+			err:      &googleapi.Error{Code: http.StatusForbidden, Errors: []googleapi.ErrorItem{{Reason: "rateLimitExceeded"}}},
+			expected: true,
+		},
+		{
+			name:     "403 quotaExceeded",
+			err:      &googleapi.Error{Code: http.StatusForbidden, Errors: []googleapi.ErrorItem{{Reason: "quotaExceeded"}}},
+			expected: true,
+		},
+		{
+			name: "403 accessDenied",
+			err:  &googleapi.Error{Code: http.StatusForbidden, Errors: []googleapi.ErrorItem{{Reason: "accessDenied"}}},
+		},
+		{
+			name:     "429",
+			err:      &googleapi.Error{Code: http.StatusTooManyRequests},
+			expected: true,
+		},
+		{
+			name:     "503",
+			err:      &googleapi.Error{Code: http.StatusServiceUnavailable},
+			expected: true,
+		},
+		{
+			name:     "wrapped 403 rateLimitExceeded",
+			err:      fmt.Errorf("failed to load leases: %w", &googleapi.Error{Code: http.StatusForbidden, Errors: []googleapi.ErrorItem{{Reason: "rateLimitExceeded"}}}),
+			expected: true,
+		},
+		{
+			name:     "deadline exceeded",
+			err:      context.DeadlineExceeded,
+			expected: true,
+		},
+		{
+			name: "decode error",
+			err:  errors.New("invalid character 'N'"),
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if actual := IsTransientError(tc.err); actual != tc.expected {
+				t.Errorf("expected IsTransientError to return %t, got %t", tc.expected, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package metrics
+
+import (
+	"slices"
+	"sync"
+	"unicode/utf8"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/sirupsen/logrus"
+)
+
+// truncationMarker is appended to every truncated string
+const truncationMarker = "…"
+
+// WithTruncateStrings cuts every STRING value longer than limit bytes down to limit bytes, ending it with an
+// ellipsis, so that a single huge error message cannot fail the whole insert. A limit of zero disables it.
+func WithTruncateStrings(limit int) Option {
+	return func(b *BigQueryLoader) {
+		if limit > 0 {
+			b.transforms = append(b.transforms, &truncateStrings{limit: limit, truncated: map[string]int{}})
+		}
+	}
+}
+
+// transformReport is implemented by transforms that log a summary once all rows of a table went through them
+type transformReport interface {
+	report(table string, logger *logrus.Entry)
+}
+
+// truncateStrings caps the length of STRING columns, including those of nested records
+type truncateStrings struct {
+	limit int
+
+	lock      sync.Mutex
+	truncated map[string]int
+}
+
+func (t *truncateStrings) schema(_ string, in bigquery.Schema) (bigquery.Schema, error) {
+	return in, nil
+}
+
+func (t *truncateStrings) row(table string, in bigquery.Schema, values map[string]bigquery.Value) (map[string]bigquery.Value, error) {
+	if count := t.truncateValues(in, values); count > 0 {
+		t.lock.Lock()
+		t.truncated[table] += count
+		t.lock.Unlock()
+	}
+	return values, nil
+}
+
+func (t *truncateStrings) report(table string, logger *logrus.Entry) {
+	t.lock.Lock()
+	count := t.truncated[table]
+	delete(t.truncated, table)
+	t.lock.Unlock()
+	if count > 0 {
+		logger.Warnf("Truncated %d string fields of %s to %d bytes", count, table, t.limit)
+	}
+}
+
+// truncateValues truncates the string values of a row in place and returns how many it changed
+func (t *truncateStrings) truncateValues(schema bigquery.Schema, values map[string]bigquery.Value) int {
+	count := 0
+	for _, field := range schema {
+		value, ok := values[field.Name]
+		if !ok {
+			continue
+		}
+		switch field.Type {
+		case bigquery.StringFieldType:
+			switch v := value.(type) {
+			case string:
+				if truncated, changed := t.truncate(v); changed {
+					values[field.Name] = truncated
+					count++
+				}
+			case []string:
+				// the slice is the one of the decoded event, which must stay as it was decoded
+				var copied []string
+				for i := range v {
+					if truncated, changed := t.truncate(v[i]); changed {
+						if copied == nil {
+							copied = slices.Clone(v)
+							values[field.Name] = copied
+						}
+						copied[i] = truncated
+						count++
+					}
+				}
+			}
+		case bigquery.RecordFieldType:
+			switch v := value.(type) {
+			case map[string]bigquery.Value:
+				count += t.truncateValues(field.Schema, v)
+			case []bigquery.Value:
+				for _, item := range v {
+					if nested, ok := item.(map[string]bigquery.Value); ok {
+						count += t.truncateValues(field.Schema, nested)
+					}
+				}
+			}
+		}
+	}
+	return count
+}
+
+// truncate shortens s to at most limit bytes without splitting a UTF-8 sequence
+func (t *truncateStrings) truncate(s string) (string, bool) {
+	if len(s) <= t.limit {
+		return s, false
+	}
+	keep := t.limit - len(truncationMarker)
+	marker := truncationMarker
+	if keep < 0 {
+		keep, marker = t.limit, ""
+	}
+	for keep > 0 && !utf8.RuneStart(s[keep]) {
+		keep--
+	}
+	return s[:keep] + marker, true
+}
@@ -0,0 +1,34 @@
+package metrics
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	citoolsmetrics "github.com/openshift/ci-tools/pkg/metrics"
+)
+
+func TestTruncateStringsKeepsDecodedEvent(t *testing.T) {
+	workload := strings.Repeat("x", 20)
+	node := &citoolsmetrics.NodeEvent{Node: "node", Workloads: []string{workload, "short"}}
+	data := &MetricsData{Nodes: []*citoolsmetrics.NodeEvent{node}}
+
+	_, client := newFakeBigQuery(t)
+	loader := NewBigQueryLoader(context.Background(), client, "project", "dataset", WithTruncateStrings(8))
+	schema, rows, err := loader.prepareTable(tableRows(data, NodesTable))
+	if err != nil {
+		t.Fatalf("failed to prepare nodes: %v", err)
+	}
+
+	if expected := []string{workload, "short"}; !slices.Equal(node.Workloads, expected) {
+		t.Errorf("the decoded event was changed to %v", node.Workloads)
+	}
+	values, _, err := saveRow(rows[0], schema)
+	if err != nil {
+		t.Fatalf("failed to save the row: %v", err)
+	}
+	if expected := []string{"xxxxx…", "short"}; !slices.Equal(values["Workloads"].([]string), expected) {
+		t.Errorf("expected the inserted workloads %v, got %v", expected, values["Workloads"])
+	}
+}
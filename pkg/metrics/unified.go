@@ -0,0 +1,66 @@
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+)
+
+// Columns of the table written by WithUnifiedTable
+const (
+	UnifiedTypeColumn      = "type"
+	UnifiedTimestampColumn = "timestamp"
+	UnifiedPayloadColumn   = "payload"
+)
+
+// unifiedSchema is the schema of the table written by WithUnifiedTable
+var unifiedSchema = bigquery.Schema{
+	{Name: UnifiedTypeColumn, Type: bigquery.StringFieldType, Required: true},
+	{Name: UnifiedTimestampColumn, Type: bigquery.TimestampFieldType},
+	{Name: UnifiedPayloadColumn, Type: bigquery.JSONFieldType},
+}
+
+// WithUnifiedTable loads every event into the single table name instead of one table per type. Each row holds the
+// table the event would otherwise go to as its type, the event timestamp, and the event itself as a JSON payload.
+func WithUnifiedTable(name string) Option {
+	return func(b *BigQueryLoader) {
+		b.unifiedTable = name
+	}
+}
+
+// unifiedRows converts the rows of a table into rows of the unified table
+func unifiedRows(t tableData) ([]any, error) {
+	rows := make([]any, 0, len(t.rows))
+	for i, row := range t.rows {
+		payload, err := json.Marshal(row)
+		if err != nil {
+			return nil, fmt.Errorf("%s row %d: failed to encode payload: %w", t.name, i, err)
+		}
+		var timestamp bigquery.Value
+		if ts, ok := rowTimestamp(row); ok && !ts.IsZero() {
+			timestamp = ts
+		}
+		rows = append(rows, &savedRow{values: map[string]bigquery.Value{
+			UnifiedTypeColumn:      t.name,
+			UnifiedTimestampColumn: timestamp,
+			UnifiedPayloadColumn:   json.RawMessage(payload),
+		}})
+	}
+	return rows, nil
+}
+
+// rowTimestamp returns the Timestamp field of a row, if its type has one
+func rowTimestamp(row any) (time.Time, bool) {
+	v := reflect.ValueOf(row)
+	if v.Kind() != reflect.Pointer || v.IsNil() {
+		return time.Time{}, false
+	}
+	field := v.Elem().FieldByName("Timestamp")
+	if !field.IsValid() || field.Type() != timeType {
+		return time.Time{}, false
+	}
+	return field.Interface().(time.Time), true
+}
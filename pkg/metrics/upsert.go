@@ -0,0 +1,168 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+)
+
+// stagingTableExpiration bounds how long a staging table left behind by a failed upsert is kept
+const stagingTableExpiration = 24 * time.Hour
+
+// WithUpsertKey makes loads of table update the existing rows whose key columns match and insert the others,
+// instead of appending. The rows are written to a staging table with a load job and merged into the table with a
+// MERGE statement, so every load runs a query job that scans the target table.
+func WithUpsertKey(table string, columns []string) Option {
+	return func(b *BigQueryLoader) {
+		if b.upsertKeys == nil {
+			b.upsertKeys = map[string][]string{}
+		}
+		b.upsertKeys[table] = columns
+	}
+}
+
+// upsert stages rows next to table and merges them into it on the key columns
+func (b *BigQueryLoader) upsert(ctx context.Context, dataset *bigquery.Dataset, table *bigquery.Table, schema bigquery.Schema, rows []any, key []string) error {
+	keyColumns, err := resolveColumns(table.TableID, schema, key)
+	if err != nil {
+		return err
+	}
+
+	staging := dataset.Table(fmt.Sprintf("%s_upsert_staging_%d", table.TableID, b.clock().UnixNano()))
+	if err := staging.Create(ctx, &bigquery.TableMetadata{
+		Schema:           schema,
+		EncryptionConfig: b.encryption,
+		ExpirationTime:   b.clock().Add(stagingTableExpiration),
+	}); err != nil {
+		return fmt.Errorf("failed to create staging table %s: %w", staging.TableID, err)
+	}
+	defer func() {
+		if err := staging.Delete(ctx); err != nil {
+			b.logger.WithError(err).Warnf("Failed to delete staging table %s, it expires on its own", staging.TableID)
+		}
+	}()
+
+	if err := b.stageRows(ctx, staging, schema, rows); err != nil {
+		return err
+	}
+
+	job, err := b.bqClient.Query(mergeStatement(table, staging, schema, keyColumns)).Run(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to start MERGE into %s: %w", table.TableID, err)
+	}
+	status, err := job.Wait(ctx)
+	if err == nil {
+		err = status.Err()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to MERGE into %s: %w", table.TableID, err)
+	}
+	if stats, ok := status.Statistics.Details.(*bigquery.QueryStatistics); ok && stats.DMLStats != nil {
+		b.logger.Infof("Upserted into %s: %d rows updated, %d inserted", table.TableID, stats.DMLStats.UpdatedRowCount, stats.DMLStats.InsertedRowCount)
+	}
+	return nil
+}
+
+// stageRows writes rows into the staging table with a load job, so that they are not held in a streaming buffer.
+// The job is given the schema the rows were saved with and never autodetects one, so that the staged columns are
+// typed exactly like streamed inserts.
+func (b *BigQueryLoader) stageRows(ctx context.Context, staging *bigquery.Table, schema bigquery.Schema, rows []any) error {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for i, row := range rows {
+		values, _, err := saveRow(row, schema)
+		if err != nil {
+			return fmt.Errorf("row %d: %w", i, err)
+		}
+		if err := checkStagedRow(values, schema); err != nil {
+			return fmt.Errorf("row %d: %w", i, err)
+		}
+		if err := encoder.Encode(values); err != nil {
+			return fmt.Errorf("row %d: failed to encode: %w", i, err)
+		}
+	}
+
+	source := bigquery.NewReaderSource(&buf)
+	source.SourceFormat = bigquery.JSON
+	source.Schema = schema
+	source.AutoDetect = false
+	job, err := staging.LoaderFrom(source).Run(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to start staging load: %w", err)
+	}
+	status, err := job.Wait(ctx)
+	if err == nil {
+		err = status.Err()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to stage rows in %s: %w", staging.TableID, err)
+	}
+	return nil
+}
+
+// checkStagedRow reports the columns of values missing from schema, which a load job would reject or, with
+// autodetection, add with a guessed type, and the required columns of schema without a value
+func checkStagedRow(values map[string]bigquery.Value, schema bigquery.Schema) error {
+	for column := range values {
+		if !hasColumn(schema, column) {
+			return fmt.Errorf("column %s is not part of the schema", column)
+		}
+	}
+	for _, field := range schema {
+		if field.Required && values[field.Name] == nil {
+			return fmt.Errorf("required column %s has no value", field.Name)
+		}
+	}
+	return nil
+}
+
+// resolveColumns maps the requested columns onto the schema's field names, ignoring case
+func resolveColumns(table string, schema bigquery.Schema, columns []string) ([]string, error) {
+	resolved := make([]string, 0, len(columns))
+	for _, column := range columns {
+		found := ""
+		for _, field := range schema {
+			if strings.EqualFold(field.Name, column) {
+				found = field.Name
+				break
+			}
+		}
+		if found == "" {
+			return nil, fmt.Errorf("upsert key column %s does not exist in table %s", column, table)
+		}
+		resolved = append(resolved, found)
+	}
+	return resolved, nil
+}
+
+// mergeStatement updates the rows of target matching staging on the key columns and inserts the rest
+func mergeStatement(target, staging *bigquery.Table, schema bigquery.Schema, key []string) string {
+	isKey := map[string]bool{}
+	var on []string
+	for _, column := range key {
+		isKey[column] = true
+		on = append(on, fmt.Sprintf("T.`%s` = S.`%s`", column, column))
+	}
+	var set, columns, values []string
+	for _, field := range schema {
+		columns = append(columns, fmt.Sprintf("`%s`", field.Name))
+		values = append(values, fmt.Sprintf("S.`%s`", field.Name))
+		if !isKey[field.Name] {
+			set = append(set, fmt.Sprintf("`%s` = S.`%s`", field.Name, field.Name))
+		}
+	}
+
+	var sql strings.Builder
+	fmt.Fprintf(&sql, "MERGE `%s.%s.%s` T\nUSING `%s.%s.%s` S\nON %s\n",
+		target.ProjectID, target.DatasetID, target.TableID, staging.ProjectID, staging.DatasetID, staging.TableID, strings.Join(on, " AND "))
+	if len(set) > 0 {
+		fmt.Fprintf(&sql, "WHEN MATCHED THEN UPDATE SET %s\n", strings.Join(set, ", "))
+	}
+	fmt.Fprintf(&sql, "WHEN NOT MATCHED THEN INSERT (%s) VALUES (%s)", strings.Join(columns, ", "), strings.Join(values, ", "))
+	return sql.String()
+}
@@ -0,0 +1,113 @@
+package metrics
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Diagnostic codes reported by ValidateInput
+const (
+	DiagnosticMissingSource      = "missing_source"
+	DiagnosticConflictingSources = "conflicting_sources"
+	DiagnosticInvalidGCSPath     = "invalid_gcs_path"
+	DiagnosticInvalidGCSScheme   = "invalid_gcs_scheme"
+	DiagnosticMissingBucket      = "missing_bucket"
+	DiagnosticMissingObject      = "missing_object"
+	DiagnosticMissingProject     = "missing_project"
+	DiagnosticMissingDataset     = "missing_dataset"
+	DiagnosticInvalidValue       = "invalid_value"
+)
+
+// Diagnostic is a single machine-readable validation problem. Field names the offending
+// input using the CLI flag name so that text and JSON reports refer to the same thing.
+type Diagnostic struct {
+	Code    string `json:"code"`
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (d Diagnostic) Error() string {
+	return d.Message
+}
+
+// InputOptions describes where metrics are read from and, when loading, where they are written to
+type InputOptions struct {
+	GCSPath string
+	// URL is an http(s) URL fetched with a plain GET, such as a signed GCS URL
+	URL       string
+	FilePath  string
+	ExportDir string
+	// PathsFile lists gs:// paths that are loaded one after the other
+	PathsFile string
+	ProjectID string
+	DatasetID string
+	// DatasetMetadataKey names a GCS object metadata key that selects the dataset, making DatasetID a fallback
+	DatasetMetadataKey string
+	// RequireDestination is set when the metrics will be loaded into BigQuery
+	RequireDestination bool
+}
+
+// ValidateInput checks the source and destination settings and returns every problem found
+func ValidateInput(opts InputOptions) []Diagnostic {
+	var diagnostics []Diagnostic
+
+	sources := 0
+	for _, source := range []string{opts.GCSPath, opts.URL, opts.FilePath, opts.ExportDir, opts.PathsFile} {
+		if source != "" {
+			sources++
+		}
+	}
+	switch {
+	case sources == 0:
+		diagnostics = append(diagnostics, Diagnostic{Code: DiagnosticMissingSource, Field: "gcs-path", Message: "--gcs-path, --url, --file, --from-export-dir or --paths-file is required"})
+	case sources > 1:
+		diagnostics = append(diagnostics, Diagnostic{Code: DiagnosticConflictingSources, Field: "file", Message: "--gcs-path, --url, --file, --from-export-dir and --paths-file are mutually exclusive"})
+	case opts.URL != "" && !IsHTTPSource(opts.URL):
+		diagnostics = append(diagnostics, Diagnostic{Code: DiagnosticInvalidValue, Field: "url", Message: "--url must be an http:// or https:// URL"})
+	case opts.GCSPath != "":
+		if _, _, err := ParseGCSPath(opts.GCSPath); err != nil {
+			var diagnostic Diagnostic
+			if errors.As(err, &diagnostic) {
+				diagnostics = append(diagnostics, diagnostic)
+			}
+		}
+	}
+
+	if opts.RequireDestination {
+		if opts.ProjectID == "" {
+			diagnostics = append(diagnostics, Diagnostic{Code: DiagnosticMissingProject, Field: "google-project-id", Message: "--google-project-id is required"})
+		}
+		if opts.DatasetID == "" && opts.DatasetMetadataKey == "" {
+			diagnostics = append(diagnostics, Diagnostic{Code: DiagnosticMissingDataset, Field: "bigquery-dataset", Message: "--bigquery-dataset is required"})
+		}
+	}
+	if opts.DatasetMetadataKey != "" && opts.GCSPath == "" {
+		diagnostics = append(diagnostics, Diagnostic{Code: DiagnosticInvalidValue, Field: "dataset-from-metadata", Message: "--dataset-from-metadata requires --gcs-path"})
+	}
+	return diagnostics
+}
+
+// ParseGCSPath splits a gs://bucket/object path. Errors are Diagnostic values.
+func ParseGCSPath(gcsPath string) (bucket, object string, err error) {
+	u, err := url.Parse(gcsPath)
+	if err != nil {
+		return "", "", Diagnostic{Code: DiagnosticInvalidGCSPath, Field: "gcs-path", Message: fmt.Sprintf("invalid GCS path: %v", err)}
+	}
+	if IsHTTPSource(gcsPath) {
+		return "", "", Diagnostic{Code: DiagnosticInvalidGCSScheme, Field: "gcs-path", Message: "path must use gs:// scheme; load http(s) URLs such as signed URLs with --url"}
+	}
+	if u.Scheme != "gs" {
+		return "", "", Diagnostic{Code: DiagnosticInvalidGCSScheme, Field: "gcs-path", Message: fmt.Sprintf("path must use gs:// scheme, got %s://", u.Scheme)}
+	}
+	if u.Host == "" {
+		return "", "", Diagnostic{Code: DiagnosticMissingBucket, Field: "gcs-path", Message: "bucket name is required"}
+	}
+	bucket = u.Host
+	object = strings.TrimPrefix(u.Path, "/")
+	if object == "" {
+		return "", "", Diagnostic{Code: DiagnosticMissingObject, Field: "gcs-path", Message: "object path is required"}
+	}
+	return bucket, object, nil
+}
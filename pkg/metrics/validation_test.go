@@ -0,0 +1,116 @@
+package metrics
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseGCSPath(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		path     string
+		bucket   string
+		object   string
+		expected *Diagnostic
+	}{
+		{name: "valid", path: "gs://bucket/path/to/ci-operator-metrics.json", bucket: "bucket", object: "path/to/ci-operator-metrics.json"},
+		{name: "unparsable", path: "gs://bucket/%zz", expected: &Diagnostic{Code: DiagnosticInvalidGCSPath, Field: "gcs-path", Message: `invalid GCS path: parse "gs://bucket/%zz": invalid URL escape "%zz"`}},
+		{name: "https", path: "https://storage.googleapis.com/bucket/object", expected: &Diagnostic{Code: DiagnosticInvalidGCSScheme, Field: "gcs-path", Message: "path must use gs:// scheme; load http(s) URLs such as signed URLs with --url"}},
+		{name: "other scheme", path: "s3://bucket/object", expected: &Diagnostic{Code: DiagnosticInvalidGCSScheme, Field: "gcs-path", Message: "path must use gs:// scheme, got s3://"}},
+		{name: "no scheme", path: "bucket/object", expected: &Diagnostic{Code: DiagnosticInvalidGCSScheme, Field: "gcs-path", Message: "path must use gs:// scheme, got ://"}},
+		{name: "no bucket", path: "gs:///object", expected: &Diagnostic{Code: DiagnosticMissingBucket, Field: "gcs-path", Message: "bucket name is required"}},
+		{name: "no object", path: "gs://bucket/", expected: &Diagnostic{Code: DiagnosticMissingObject, Field: "gcs-path", Message: "object path is required"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			bucket, object, err := ParseGCSPath(tc.path)
+			if tc.expected == nil {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if bucket != tc.bucket || object != tc.object {
+					t.Errorf("expected %s and %s, got %s and %s", tc.bucket, tc.object, bucket, object)
+				}
+				return
+			}
+			var diagnostic Diagnostic
+			if !errors.As(err, &diagnostic) {
+				t.Fatalf("expected a Diagnostic, got %v", err)
+			}
+			if diagnostic != *tc.expected {
+				t.Errorf("expected %+v, got %+v", *tc.expected, diagnostic)
+			}
+		})
+	}
+}
+
+func TestValidateInput(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		opts     InputOptions
+		expected []Diagnostic
+	}{
+		{
+			name: "valid load",
+			opts: InputOptions{GCSPath: "gs://bucket/object", ProjectID: "project", DatasetID: "dataset", RequireDestination: true},
+		},
+		{
+			name:     "no source",
+			opts:     InputOptions{},
+			expected: []Diagnostic{{Code: DiagnosticMissingSource, Field: "gcs-path", Message: "--gcs-path, --url, --file, --from-export-dir or --paths-file is required"}},
+		},
+		{
+			name:     "two sources",
+			opts:     InputOptions{GCSPath: "gs://bucket/object", FilePath: "metrics.json"},
+			expected: []Diagnostic{{Code: DiagnosticConflictingSources, Field: "file", Message: "--gcs-path, --url, --file, --from-export-dir and --paths-file are mutually exclusive"}},
+		},
+		{
+			name:     "url scheme",
+			opts:     InputOptions{URL: "ftp://host/metrics.json"},
+			expected: []Diagnostic{{Code: DiagnosticInvalidValue, Field: "url", Message: "--url must be an http:// or https:// URL"}},
+		},
+		{
+			name:     "invalid gcs path",
+			opts:     InputOptions{GCSPath: "gs://bucket"},
+			expected: []Diagnostic{{Code: DiagnosticMissingObject, Field: "gcs-path", Message: "object path is required"}},
+		},
+		{
+			name: "every problem is reported",
+			opts: InputOptions{GCSPath: "s3://bucket/object", RequireDestination: true},
+			expected: []Diagnostic{
+				{Code: DiagnosticInvalidGCSScheme, Field: "gcs-path", Message: "path must use gs:// scheme, got s3://"},
+				{Code: DiagnosticMissingProject, Field: "google-project-id", Message: "--google-project-id is required"},
+				{Code: DiagnosticMissingDataset, Field: "bigquery-dataset", Message: "--bigquery-dataset is required"},
+			},
+		},
+		{
+			name: "dataset from metadata",
+			opts: InputOptions{GCSPath: "gs://bucket/object", ProjectID: "project", DatasetMetadataKey: "target-dataset", RequireDestination: true},
+		},
+		{
+			name:     "dataset from metadata without gcs",
+			opts:     InputOptions{FilePath: "metrics.json", ProjectID: "project", DatasetID: "dataset", DatasetMetadataKey: "target-dataset", RequireDestination: true},
+			expected: []Diagnostic{{Code: DiagnosticInvalidValue, Field: "dataset-from-metadata", Message: "--dataset-from-metadata requires --gcs-path"}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if actual := ValidateInput(tc.opts); !reflect.DeepEqual(actual, tc.expected) {
+				t.Errorf("expected %+v, got %+v", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestDiagnosticJSON(t *testing.T) {
+	// --output json reports the diagnostics under a "diagnostics" key
+	diagnostics := ValidateInput(InputOptions{GCSPath: "gs:///object"})
+	encoded, err := json.Marshal(map[string][]Diagnostic{"diagnostics": diagnostics})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"diagnostics":[{"code":"missing_bucket","field":"gcs-path","message":"bucket name is required"}]}`
+	if string(encoded) != expected {
+		t.Errorf("expected %s, got %s", expected, encoded)
+	}
+}
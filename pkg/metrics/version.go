@@ -0,0 +1,14 @@
+package metrics
+
+// LoaderVersionColumn is the column WithLoaderVersion adds to every table
+const LoaderVersionColumn = "loader_version"
+
+// WithLoaderVersion adds a loader_version column holding version to every row of every table, so that rows can be
+// traced back to the release of the loader that wrote them
+func WithLoaderVersion(version string) Option {
+	return func(b *BigQueryLoader) {
+		if version != "" {
+			b.transforms = append(b.transforms, constantColumn{name: LoaderVersionColumn, value: version})
+		}
+	}
+}
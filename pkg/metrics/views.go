@@ -0,0 +1,65 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"cloud.google.com/go/bigquery"
+)
+
+// viewNamePattern matches the names BigQuery allows for tables and views
+var viewNamePattern = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// View is a BigQuery view defined by a standard SQL query
+type View struct {
+	Name  string
+	Query string
+}
+
+// ReadViewDir reads every <view>.sql file of dir as the query of the view named after the file, in name order.
+// Other files are ignored.
+func ReadViewDir(dir string) ([]View, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read view directory: %w", err)
+	}
+	var views []View
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), ".sql")
+		if entry.IsDir() || !ok {
+			continue
+		}
+		if !viewNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("%s: view names may only contain letters, digits and underscores", entry.Name())
+		}
+		query, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", entry.Name(), err)
+		}
+		if strings.TrimSpace(string(query)) == "" {
+			return nil, fmt.Errorf("%s is empty", entry.Name())
+		}
+		views = append(views, View{Name: name, Query: string(query)})
+	}
+	return views, nil
+}
+
+// CreateViews creates every view in the dataset, replacing the query of views that already exist
+func CreateViews(ctx context.Context, bqClient *bigquery.Client, datasetID string, views []View) error {
+	dataset := bqClient.Dataset(datasetID)
+	for _, view := range views {
+		table := dataset.Table(view.Name)
+		err := table.Create(ctx, &bigquery.TableMetadata{ViewQuery: view.Query})
+		if isAlreadyExistsError(err) {
+			_, err = table.Update(ctx, bigquery.TableMetadataToUpdate{ViewQuery: view.Query}, "")
+		}
+		if err != nil {
+			return fmt.Errorf("failed to create view %s: %w", view.Name, err)
+		}
+	}
+	return nil
+}
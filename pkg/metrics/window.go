@@ -0,0 +1,71 @@
+package metrics
+
+import (
+	"reflect"
+	"time"
+)
+
+// timestampWindow is the range of timestamps considered plausible, relative to the load time
+type timestampWindow struct {
+	min       time.Time
+	maxFuture time.Duration
+	strict    bool
+}
+
+// WithTimestampWindow flags rows holding any timestamp, including nested ones, before min or more than maxFuture
+// after the load time. Zero timestamps are treated as unset and a zero min disables the lower bound. Flagged rows are
+// counted per table and loaded anyway unless strict is set, in which case they are dropped.
+func WithTimestampWindow(min time.Time, maxFuture time.Duration, strict bool) Option {
+	return func(b *BigQueryLoader) {
+		b.timestampWindow = &timestampWindow{min: min, maxFuture: maxFuture, strict: strict}
+	}
+}
+
+// filter returns the rows to load and how many rows had a timestamp outside the window
+func (w *timestampWindow) filter(rows []any, now time.Time) ([]any, int) {
+	maxTime := now.Add(w.maxFuture)
+	kept := rows[:0:0]
+	flagged := 0
+	for _, row := range rows {
+		if w.outOfRange(reflect.ValueOf(row), maxTime) {
+			flagged++
+			if w.strict {
+				continue
+			}
+		}
+		kept = append(kept, row)
+	}
+	return kept, flagged
+}
+
+// outOfRange walks v and reports whether any non-zero time in it lies outside [w.min, maxTime]
+func (w *timestampWindow) outOfRange(v reflect.Value, maxTime time.Time) bool {
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		return !v.IsNil() && w.outOfRange(v.Elem(), maxTime)
+	case reflect.Struct:
+		if v.Type() == timeType {
+			t := v.Interface().(time.Time)
+			return !t.IsZero() && (t.Before(w.min) || t.After(maxTime))
+		}
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).IsExported() && w.outOfRange(v.Field(i), maxTime) {
+				return true
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if w.outOfRange(v.Index(i), maxTime) {
+				return true
+			}
+		}
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			if w.outOfRange(iter.Value(), maxTime) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,321 @@
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"reflect"
+	"slices"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/bigquery/storage/managedwriter"
+	"cloud.google.com/go/bigquery/storage/managedwriter/adapt"
+
+	"google.golang.org/api/option"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// writeAPIBatchSize is the number of rows sent per AppendRows request, keeping requests well below the 10MB limit
+const writeAPIBatchSize = 500
+
+// writeAPIClient appends rows to the default stream of tables through the BigQuery Storage Write API
+type writeAPIClient struct {
+	client    *managedwriter.Client
+	projectID string
+}
+
+// WithWriteAPIClientOptions creates the Storage Write API clients of the loader with clientOpts, such as the gRPC
+// dial options that send them through a proxy. The BigQuery client options cannot be reused as they are, as the
+// Storage Write API talks gRPC rather than HTTP.
+func WithWriteAPIClientOptions(clientOpts ...option.ClientOption) Option {
+	return func(b *BigQueryLoader) {
+		b.writeAPIClientOpts = clientOpts
+	}
+}
+
+func newWriteAPIClient(ctx context.Context, projectID string, clientOpts ...option.ClientOption) (*writeAPIClient, error) {
+	client, err := managedwriter.NewClient(ctx, projectID, clientOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Storage Write API client: %w", err)
+	}
+	return &writeAPIClient{client: client, projectID: projectID}, nil
+}
+
+func (w *writeAPIClient) close() error {
+	return w.client.Close()
+}
+
+// writeAPIStream is a managed stream bound to a single table together with the message descriptor of its rows
+type writeAPIStream struct {
+	stream     *managedwriter.ManagedStream
+	descriptor protoreflect.MessageDescriptor
+	schema     bigquery.Schema
+	// observe, when set, is called with the size of every append and the time until it was acknowledged
+	observe func(rows int, elapsed time.Duration)
+	// clock times the appends passed to observe
+	clock func() time.Time
+}
+
+// newStream opens the default stream of tableID in datasetID, or the stream opts ask for
+func (w *writeAPIClient) newStream(ctx context.Context, datasetID, tableID string, schema bigquery.Schema, opts ...managedwriter.WriterOption) (*writeAPIStream, error) {
+	storageSchema, err := adapt.BQSchemaToStorageTableSchema(schema)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert schema: %w", err)
+	}
+	descriptor, err := adapt.StorageSchemaToProto2Descriptor(storageSchema, "root")
+	if err != nil {
+		return nil, fmt.Errorf("failed to build proto descriptor: %w", err)
+	}
+	messageDescriptor, ok := descriptor.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("schema did not convert to a message descriptor")
+	}
+	descriptorProto, err := adapt.NormalizeDescriptor(messageDescriptor)
+	if err != nil {
+		return nil, fmt.Errorf("failed to normalize proto descriptor: %w", err)
+	}
+
+	stream, err := w.client.NewManagedStream(ctx, append([]managedwriter.WriterOption{
+		managedwriter.WithDestinationTable(managedwriter.TableParentFromParts(w.projectID, datasetID, tableID)),
+		managedwriter.WithType(managedwriter.DefaultStream),
+		managedwriter.WithSchemaDescriptor(descriptorProto),
+	}, opts...)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create managed stream: %w", err)
+	}
+	return &writeAPIStream{stream: stream, descriptor: messageDescriptor, schema: schema, clock: time.Now}, nil
+}
+
+func (s *writeAPIStream) close() error {
+	return s.stream.Close()
+}
+
+// appendRows serializes the rows into proto messages and appends them in batches, waiting for every batch to be acknowledged.
+// Rows rejected by BigQuery are reported as a bigquery.PutMultiError indexed into rows, like streaming inserts report them.
+// Every batch commits on its own: a batch holding a rejected row writes none of its rows, while the other batches
+// are written (see uncommittedRows). A batch failing for any other reason fails the whole append.
+func (s *writeAPIStream) appendRows(ctx context.Context, rows []any) error {
+	var results []*managedwriter.AppendResult
+	var starts []int
+	var sent []time.Time
+	for start := 0; start < len(rows); start += writeAPIBatchSize {
+		end := min(start+writeAPIBatchSize, len(rows))
+		batch := make([][]byte, 0, end-start)
+		for _, row := range rows[start:end] {
+			encoded, err := s.encodeRow(row)
+			if err != nil {
+				return err
+			}
+			batch = append(batch, encoded)
+		}
+		sent = append(sent, s.clock())
+		result, err := s.stream.AppendRows(ctx, batch)
+		if err != nil {
+			return fmt.Errorf("failed to append rows: %w", err)
+		}
+		results = append(results, result)
+		starts = append(starts, start)
+	}
+
+	var rowErrors bigquery.PutMultiError
+	var appendErr error
+	for i, result := range results {
+		response, err := result.FullResponse(ctx)
+		if s.observe != nil && err == nil {
+			s.observe(min(writeAPIBatchSize, len(rows)-starts[i]), s.clock().Sub(sent[i]))
+		}
+		batchErrors := response.GetRowErrors()
+		for _, rowError := range batchErrors {
+			rowErrors = append(rowErrors, bigquery.RowInsertionError{
+				RowIndex: starts[i] + int(rowError.GetIndex()),
+				Errors:   bigquery.MultiError{errors.New(rowError.GetMessage())},
+			})
+		}
+		// a batch with row errors also fails with an error, which the row errors explain
+		if err != nil && len(batchErrors) == 0 && appendErr == nil {
+			appendErr = fmt.Errorf("append failed: %w", err)
+		}
+	}
+	if appendErr != nil {
+		return appendErr
+	}
+	if len(rowErrors) > 0 {
+		return rowErrors
+	}
+	return nil
+}
+
+// uncommittedRows returns the valid rows of the batches appendRows sent to the default stream that BigQuery rejected
+// for the rows of rowErrors. The other batches were written, so appending these rows writes the valid rows of rows
+// exactly once.
+func uncommittedRows(rows []any, rowErrors bigquery.PutMultiError) []any {
+	invalid := map[int]bool{}
+	rejected := map[int]bool{}
+	for _, rowError := range rowErrors {
+		invalid[rowError.RowIndex] = true
+		rejected[rowError.RowIndex/writeAPIBatchSize] = true
+	}
+	var valid []any
+	for i, row := range rows {
+		if rejected[i/writeAPIBatchSize] && !invalid[i] {
+			valid = append(valid, row)
+		}
+	}
+	return valid
+}
+
+func (s *writeAPIStream) encodeRow(row any) ([]byte, error) {
+	values, _, err := saveRow(row, s.schema)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert row: %w", err)
+	}
+	message := dynamicpb.NewMessage(s.descriptor)
+	if err := setMessageFields(message, s.schema, values); err != nil {
+		return nil, err
+	}
+	encoded, err := proto.Marshal(message)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal row: %w", err)
+	}
+	return encoded, nil
+}
+
+// setMessageFields copies the values of a saved row into a dynamic message built from the same schema
+func setMessageFields(message *dynamicpb.Message, schema bigquery.Schema, values map[string]bigquery.Value) error {
+	fields := message.Descriptor().Fields()
+	for _, fieldSchema := range schema {
+		value, ok := values[fieldSchema.Name]
+		if !ok || isNil(value) {
+			continue
+		}
+		field := fields.ByName(protoreflect.Name(fieldSchema.Name))
+		if field == nil {
+			return fmt.Errorf("field %s is missing from the proto descriptor", fieldSchema.Name)
+		}
+
+		if fieldSchema.Repeated {
+			items := reflect.ValueOf(value)
+			if items.Kind() != reflect.Slice && items.Kind() != reflect.Array {
+				return fmt.Errorf("repeated field %s has non-slice value %T", fieldSchema.Name, value)
+			}
+			list := message.Mutable(field).List()
+			for i := 0; i < items.Len(); i++ {
+				item, err := toProtoValue(field, fieldSchema, items.Index(i).Interface())
+				if err != nil {
+					return err
+				}
+				list.Append(item)
+			}
+			continue
+		}
+
+		item, err := toProtoValue(field, fieldSchema, value)
+		if err != nil {
+			return err
+		}
+		message.Set(field, item)
+	}
+	return nil
+}
+
+func toProtoValue(field protoreflect.FieldDescriptor, fieldSchema *bigquery.FieldSchema, value any) (protoreflect.Value, error) {
+	v := reflect.ValueOf(value)
+	switch fieldSchema.Type {
+	case bigquery.RecordFieldType:
+		nested, ok := value.(map[string]bigquery.Value)
+		if !ok {
+			return protoreflect.Value{}, fmt.Errorf("record field %s has unexpected value %T", fieldSchema.Name, value)
+		}
+		message := dynamicpb.NewMessage(field.Message())
+		if err := setMessageFields(message, fieldSchema.Schema, nested); err != nil {
+			return protoreflect.Value{}, err
+		}
+		return protoreflect.ValueOfMessage(message), nil
+	case bigquery.TimestampFieldType:
+		t, ok := value.(time.Time)
+		if !ok {
+			return protoreflect.Value{}, fmt.Errorf("timestamp field %s has unexpected value %T", fieldSchema.Name, value)
+		}
+		return protoreflect.ValueOfInt64(t.UnixMicro()), nil
+	case bigquery.JSONFieldType:
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return protoreflect.Value{}, fmt.Errorf("failed to encode JSON field %s: %w", fieldSchema.Name, err)
+		}
+		return protoreflect.ValueOfString(string(encoded)), nil
+	case bigquery.NumericFieldType, bigquery.BigNumericFieldType:
+		if v.Kind() == reflect.String {
+			rat, ok := new(big.Rat).SetString(v.String())
+			if !ok {
+				return protoreflect.Value{}, fmt.Errorf("%s field %s has invalid value %q", fieldSchema.Type, fieldSchema.Name, v.String())
+			}
+			scale := numericScale
+			if fieldSchema.Type == bigquery.BigNumericFieldType {
+				scale = bigNumericScale
+			}
+			return protoreflect.ValueOfBytes(encodeDecimal(rat, scale)), nil
+		}
+	case bigquery.BytesFieldType:
+		if bytes, ok := value.([]byte); ok {
+			return protoreflect.ValueOfBytes(bytes), nil
+		}
+	case bigquery.StringFieldType:
+		if v.Kind() == reflect.String {
+			return protoreflect.ValueOfString(v.String()), nil
+		}
+	case bigquery.IntegerFieldType:
+		switch v.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return protoreflect.ValueOfInt64(v.Int()), nil
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+			return protoreflect.ValueOfInt64(int64(v.Uint())), nil
+		}
+	case bigquery.FloatFieldType:
+		if v.Kind() == reflect.Float32 || v.Kind() == reflect.Float64 {
+			return protoreflect.ValueOfFloat64(v.Float()), nil
+		}
+	case bigquery.BooleanFieldType:
+		if v.Kind() == reflect.Bool {
+			return protoreflect.ValueOfBool(v.Bool()), nil
+		}
+	}
+	return protoreflect.Value{}, fmt.Errorf("field %s of type %s has unsupported value %T", fieldSchema.Name, fieldSchema.Type, value)
+}
+
+// Digits after the decimal point of the NUMERIC and BIGNUMERIC types
+const (
+	numericScale    = 9
+	bigNumericScale = 38
+)
+
+// encodeDecimal encodes r, rounded to scale decimal digits, the way the Storage Write API expects NUMERIC and
+// BIGNUMERIC values: the scaled integer as little-endian two's complement bytes
+func encodeDecimal(r *big.Rat, scale int) []byte {
+	scaled := new(big.Rat).Mul(r, new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(scale)), nil)))
+	n, _ := new(big.Int).SetString(scaled.FloatString(0), 10)
+	size := (n.BitLen() + 8) / 8
+	if n.Sign() < 0 {
+		n.Add(n, new(big.Int).Lsh(big.NewInt(1), uint(size*8)))
+	}
+	encoded := n.FillBytes(make([]byte, size))
+	slices.Reverse(encoded)
+	return encoded
+}
+
+func isNil(value any) bool {
+	if value == nil {
+		return true
+	}
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Map, reflect.Slice, reflect.Pointer, reflect.Interface:
+		return v.IsNil()
+	}
+	return false
+}
@@ -0,0 +1,189 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"testing"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/bigquery/storage/apiv1/storagepb"
+	"google.golang.org/api/option"
+	statuspb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// fakeWriteServer is a Storage Write API server committing every AppendRows request that holds no rejected row,
+// and rejecting whole the requests that hold one, as BigQuery does
+type fakeWriteServer struct {
+	storagepb.UnimplementedBigQueryWriteServer
+	// reject reports whether BigQuery rejects a serialized row
+	reject func(row []byte) bool
+
+	lock      sync.Mutex
+	committed map[string]int
+	requests  int
+	// streams counts the streams created, offsets holds the offset of every AppendRows request
+	streams int
+	offsets []int64
+	// onAppend, when set, is called for every AppendRows request
+	onAppend func()
+}
+
+func (s *fakeWriteServer) CreateWriteStream(_ context.Context, req *storagepb.CreateWriteStreamRequest) (*storagepb.WriteStream, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.streams++
+	return &storagepb.WriteStream{Name: fmt.Sprintf("%s/streams/%d", req.GetParent(), s.streams), Type: req.GetWriteStream().GetType(), Location: "us"}, nil
+}
+
+func (s *fakeWriteServer) GetWriteStream(_ context.Context, req *storagepb.GetWriteStreamRequest) (*storagepb.WriteStream, error) {
+	return &storagepb.WriteStream{Name: req.GetName(), Type: storagepb.WriteStream_COMMITTED, Location: "us"}, nil
+}
+
+func (s *fakeWriteServer) AppendRows(stream storagepb.BigQueryWrite_AppendRowsServer) error {
+	for {
+		req, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		rows := req.GetProtoRows().GetRows().GetSerializedRows()
+		response := &storagepb.AppendRowsResponse{}
+		for i, row := range rows {
+			if s.reject != nil && s.reject(row) {
+				response.RowErrors = append(response.RowErrors, &storagepb.RowError{Index: int64(i), Code: storagepb.RowError_FIELDS_ERROR, Message: "invalid row"})
+			}
+		}
+		s.lock.Lock()
+		s.requests++
+		if s.onAppend != nil {
+			s.onAppend()
+		}
+		if offset := req.GetOffset(); offset != nil {
+			s.offsets = append(s.offsets, offset.GetValue())
+		}
+		if len(response.RowErrors) > 0 {
+			response.Response = &storagepb.AppendRowsResponse_Error{Error: &statuspb.Status{Code: int32(codes.InvalidArgument), Message: "rows were rejected"}}
+		} else {
+			response.Response = &storagepb.AppendRowsResponse_AppendResult_{AppendResult: &storagepb.AppendRowsResponse_AppendResult{}}
+			for _, row := range rows {
+				s.committed[string(row)]++
+			}
+		}
+		s.lock.Unlock()
+		if err := stream.Send(response); err != nil {
+			return err
+		}
+	}
+}
+
+// newFakeWriteAPIClient starts server and returns a Storage Write API client talking to it
+func newFakeWriteAPIClient(t *testing.T, server *fakeWriteServer) *writeAPIClient {
+	t.Helper()
+	writer, err := newWriteAPIClient(context.Background(), "project", startFakeWriteServer(t, server)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { writer.close() })
+	return writer
+}
+
+// startFakeWriteServer starts server and returns the options of the Storage Write API clients talking to it
+func startFakeWriteServer(t *testing.T, server *fakeWriteServer) []option.ClientOption {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	grpcServer := grpc.NewServer()
+	storagepb.RegisterBigQueryWriteServer(grpcServer, server)
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+	return []option.ClientOption{
+		option.WithEndpoint(listener.Addr().String()),
+		option.WithoutAuthentication(),
+		option.WithGRPCDialOption(grpc.WithTransportCredentials(insecure.NewCredentials())),
+	}
+}
+
+type writeAPITestRow struct {
+	ID string `bigquery:"id"`
+}
+
+func TestInsertRowsWriteAPISkipInvalidRows(t *testing.T) {
+	server := &fakeWriteServer{
+		reject:    func(row []byte) bool { return bytes.Contains(row, []byte("bad")) },
+		committed: map[string]int{},
+	}
+	writer := newFakeWriteAPIClient(t, server)
+
+	var rows []any
+	for i := range 3*writeAPIBatchSize - 100 {
+		id := fmt.Sprintf("row-%d", i)
+		if i == writeAPIBatchSize+200 {
+			id = "bad"
+		}
+		rows = append(rows, &writeAPITestRow{ID: id})
+	}
+	schema, err := bigquery.InferSchema(writeAPITestRow{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bqClient, err := bigquery.NewClient(context.Background(), "project", option.WithoutAuthentication(), option.WithEndpoint("http://127.0.0.1:0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bqClient.Close()
+
+	loader := NewBigQueryLoader(context.Background(), bqClient, "project", "dataset", WithSkipInvalidRows(true))
+	skipped, err := loader.insertRows(context.Background(), bqClient.Dataset("dataset").Table("table"), schema, rows, writer)
+	if err != nil {
+		t.Fatalf("insertRows failed: %v", err)
+	}
+	if len(skipped) != 1 || skipped[0].RowIndex != writeAPIBatchSize+200 {
+		t.Errorf("expected only row %d to be skipped, got %v", writeAPIBatchSize+200, skipped)
+	}
+
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	if len(server.committed) != len(rows)-1 {
+		t.Errorf("expected %d distinct rows to be written, got %d", len(rows)-1, len(server.committed))
+	}
+	for row, count := range server.committed {
+		if count != 1 {
+			t.Errorf("row %q was written %d times", row, count)
+		}
+	}
+	// the three batches, then the valid rows of the rejected second batch
+	if server.requests != 4 {
+		t.Errorf("expected 4 append requests, got %d", server.requests)
+	}
+}
+
+func TestUncommittedRows(t *testing.T) {
+	rows := make([]any, 2*writeAPIBatchSize+10)
+	for i := range rows {
+		rows[i] = i
+	}
+	rowErrors := bigquery.PutMultiError{{RowIndex: writeAPIBatchSize + 3}, {RowIndex: writeAPIBatchSize + 7}}
+
+	valid := uncommittedRows(rows, rowErrors)
+	if len(valid) != writeAPIBatchSize-2 {
+		t.Fatalf("expected %d rows, got %d", writeAPIBatchSize-2, len(valid))
+	}
+	for _, row := range valid {
+		i := row.(int)
+		if i < writeAPIBatchSize || i >= 2*writeAPIBatchSize || i == writeAPIBatchSize+3 || i == writeAPIBatchSize+7 {
+			t.Errorf("row %d should not be appended again", i)
+		}
+	}
+}
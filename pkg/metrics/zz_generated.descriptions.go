@@ -0,0 +1,40 @@
+// Code generated by gen-field-descriptions. DO NOT EDIT.
+
+package metrics
+
+// fieldDescriptions holds the comments of struct fields, keyed by the import path and name of the struct
+// type and then by field name
+var fieldDescriptions = map[string]map[string]string{
+	"github.com/droslean/ci-metrics-bigquery/pkg/metrics.ImageEventUnion": {
+		"AdditionalContext":  "Free-form producer context",
+		"CompletionTime":     "When the import or image stream operation finished",
+		"DurationSeconds":    "Time between StartTime and CompletionTime",
+		"Error":              "Error of a failed operation",
+		"FullName":           "Namespaced name of the image stream",
+		"FullTagName":        "Image stream tag imported, as name:tag",
+		"ImageStreamDetails": "Status of the image stream, as reported by the API server",
+		"ImageStreamName":    "Name of the image stream",
+		"Namespace":          "Namespace of the image stream",
+		"RetryCount":         "Number of retries before the operation finished",
+		"SourceImage":        "Pull spec the tag was imported from",
+		"SourceImageKind":    "Kind of the import source, such as DockerImage",
+		"StartTime":          "When the import or image stream operation started",
+		"Success":            "Whether the operation succeeded",
+		"TagName":            "Tag imported, on tag import events",
+		"Timestamp":          "When the event was recorded",
+	},
+	"github.com/droslean/ci-metrics-bigquery/pkg/metrics.LeaseEventUnion": {
+		"AcquisitionDurationSeconds":   "Time spent waiting to acquire the lease",
+		"Error":                        "Error acquiring or releasing the lease, if any",
+		"LeaseName":                    "Lease name parsed from RawLeaseName",
+		"LeasesAvailableAtRelease":     "Free leases of the pool when the lease was released",
+		"LeasesRemainingAtAcquisition": "Free leases of the pool when the lease was acquired",
+		"LeasesTotal":                  "Total leases of the pool",
+		"RawLeaseName":                 "Lease name as reported by the lease server",
+		"Region":                       "Region parsed from RawLeaseName",
+		"ReleaseDurationSeconds":       "Time spent releasing the lease",
+		"Released":                     "Set on release events, unset on acquisition events",
+		"Slice":                        "Quota slice parsed from RawLeaseName",
+		"Timestamp":                    "When the event was recorded",
+	},
+}